@@ -8,6 +8,10 @@ const (
 	ContextKeyEstimatedTokens ContextKey = "estimated_tokens"
 
 	ContextKeyOriginalModel    ContextKey = "original_model"
+	ContextKeyRequestedModel   ContextKey = "requested_model"
+	ContextKeyVirtualModel     ContextKey = "virtual_model"
+	ContextKeySpeechPipeline   ContextKey = "speech_pipeline"
+	ContextKeySpeechLeg        ContextKey = "speech_pipeline_leg"
 	ContextKeyRequestStartTime ContextKey = "request_start_time"
 
 	/* token related keys */
@@ -37,6 +41,8 @@ const (
 	ContextKeyChannelIsMultiKey        ContextKey = "channel_is_multi_key"
 	ContextKeyChannelMultiKeyIndex     ContextKey = "channel_multi_key_index"
 	ContextKeyChannelKey               ContextKey = "channel_key"
+	ContextKeyChannelPoolId            ContextKey = "channel_pool_id"
+	ContextKeyChannelSelectStrategy    ContextKey = "channel_select_strategy"
 
 	ContextKeyAutoGroup           ContextKey = "auto_group"
 	ContextKeyAutoGroupIndex      ContextKey = "auto_group_index"
@@ -55,4 +61,10 @@ const (
 	ContextKeyLocalCountTokens ContextKey = "local_count_tokens"
 
 	ContextKeySystemPromptOverride ContextKey = "system_prompt_override"
+
+	// 请求中引用了 Anthropic Files API 上传的文件，需要附加对应的 anthropic-beta
+	ContextKeyClaudeFilesApiUsed ContextKey = "claude_files_api_used"
+
+	// Responses API 上游返回的完整 response 对象（流式请求取自 response.completed 事件），用于网关侧存储
+	ContextKeyResponsesResult ContextKey = "responses_result"
 )
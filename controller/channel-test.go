@@ -28,7 +28,6 @@ import (
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
-	"github.com/bytedance/gopkg/util/gopool"
 	"github.com/samber/lo"
 
 	"github.com/gin-gonic/gin"
@@ -40,7 +39,25 @@ type testResult struct {
 	newAPIError *types.NewAPIError
 }
 
+// channelTestOptions 单次渠道测试的参数
+type channelTestOptions struct {
+	Model        string
+	EndpointType string
+	KeyIndex     int // 多 Key 渠道测试的 key 索引，-1 表示按渠道的 key 选择策略选取
+	Stream       bool
+}
+
 func testChannel(channel *model.Channel, testModel string, endpointType string) testResult {
+	return testChannelWithOptions(channel, channelTestOptions{
+		Model:        testModel,
+		EndpointType: endpointType,
+		KeyIndex:     -1,
+	})
+}
+
+func testChannelWithOptions(channel *model.Channel, opts channelTestOptions) testResult {
+	testModel := opts.Model
+	endpointType := opts.EndpointType
 	tik := time.Now()
 	var unsupportedTestChannelTypes = []int{
 		constant.ChannelTypeMidjourney,
@@ -127,7 +144,7 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 	group, _ := model.GetUserGroup(1, false)
 	c.Set("group", group)
 
-	newAPIError := middleware.SetupContextForSelectedChannel(c, channel, testModel)
+	newAPIError := middleware.SetupContextForSelectedChannelKey(c, channel, testModel, opts.KeyIndex)
 	if newAPIError != nil {
 		return testResult{
 			context:     c,
@@ -182,6 +199,15 @@ func testChannel(channel *model.Channel, testModel string, endpointType string)
 	}
 
 	request := buildTestRequest(testModel, endpointType, channel)
+	if opts.Stream {
+		switch r := request.(type) {
+		case *dto.GeneralOpenAIRequest:
+			r.Stream = true
+			r.StreamOptions = &dto.StreamOptions{IncludeUsage: true}
+		case *dto.OpenAIResponsesRequest:
+			r.Stream = true
+		}
+	}
 
 	info, err := relaycommon.GenRelayInfo(c, relayFormat, request, nil)
 
@@ -548,6 +574,9 @@ func TestChannel(c *gin.Context) {
 	endpointType := c.Query("endpoint_type")
 	tik := time.Now()
 	result := testChannel(channel, testModel, endpointType)
+	if result.context != nil {
+		service.RecordChannelTestResult(channel.Id, result.context.GetString("original_model"), result.localErr == nil && result.newAPIError == nil, time.Since(tik))
+	}
 	if result.localErr != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -579,72 +608,14 @@ var testAllChannelsLock sync.Mutex
 var testAllChannelsRunning bool = false
 
 func testAllChannels(notify bool) error {
-
-	testAllChannelsLock.Lock()
-	if testAllChannelsRunning {
-		testAllChannelsLock.Unlock()
-		return errors.New("测试已在运行中")
-	}
-	testAllChannelsRunning = true
-	testAllChannelsLock.Unlock()
-	channels, getChannelErr := model.GetAllChannels(0, 0, true, false)
-	if getChannelErr != nil {
-		return getChannelErr
-	}
-	var disableThreshold = int64(common.ChannelDisableThreshold * 1000)
-	if disableThreshold == 0 {
-		disableThreshold = 10000000 // a impossible value
-	}
-	gopool.Go(func() {
-		// 使用 defer 确保无论如何都会重置运行状态，防止死锁
-		defer func() {
-			testAllChannelsLock.Lock()
-			testAllChannelsRunning = false
-			testAllChannelsLock.Unlock()
-		}()
-
-		for _, channel := range channels {
-			isChannelEnabled := channel.Status == common.ChannelStatusEnabled
-			tik := time.Now()
-			result := testChannel(channel, "", "")
-			tok := time.Now()
-			milliseconds := tok.Sub(tik).Milliseconds()
-
-			shouldBanChannel := false
-			newAPIError := result.newAPIError
-			// request error disables the channel
-			if newAPIError != nil {
-				shouldBanChannel = service.ShouldDisableChannel(channel.Type, result.newAPIError)
-			}
-
-			// 当错误检查通过，才检查响应时间
-			if common.AutomaticDisableChannelEnabled && !shouldBanChannel {
-				if milliseconds > disableThreshold {
-					err := fmt.Errorf("响应时间 %.2fs 超过阈值 %.2fs", float64(milliseconds)/1000.0, float64(disableThreshold)/1000.0)
-					newAPIError = types.NewOpenAIError(err, types.ErrorCodeChannelResponseTimeExceeded, http.StatusRequestTimeout)
-					shouldBanChannel = true
-				}
-			}
-
-			// disable channel
-			if isChannelEnabled && shouldBanChannel && channel.GetAutoBan() {
-				processChannelError(result.context, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(result.context, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
-			}
-
-			// enable channel
-			if !isChannelEnabled && service.ShouldEnableChannel(newAPIError, channel.Status) {
-				service.EnableChannel(channel.Id, common.GetContextKeyString(result.context, constant.ContextKeyChannelKey), channel.Name)
-			}
-
-			channel.UpdateResponseTime(milliseconds)
-			time.Sleep(common.RequestInterval)
-		}
-
-		if notify {
-			service.NotifyRootUser(dto.NotifyTypeChannelTest, "通道测试完成", "所有通道测试已完成")
-		}
-	})
-	return nil
+	setting := operation_setting.GetMonitorSetting()
+	return runChannelTestMatrix(&ChannelTestMatrixRequest{
+		AllModels:     setting.TestAllModels,
+		AllKeys:       setting.TestAllKeys,
+		Stream:        setting.TestStream,
+		EndpointTypes: setting.TestEndpointTypes,
+		Concurrency:   setting.TestConcurrency,
+	}, notify)
 }
 
 func TestAllChannels(c *gin.Context) {
@@ -15,6 +15,7 @@ import (
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/relay/channel/gemini"
 	"github.com/QuantumNous/new-api/relay/channel/ollama"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/service"
 
 	"github.com/gin-gonic/gin"
@@ -61,6 +62,7 @@ func parseStatusFilter(statusParam string) int {
 }
 
 func clearChannelInfo(channel *model.Channel) {
+	channel.MaskSecretSettings()
 	if channel.ChannelInfo.IsMultiKey {
 		channel.ChannelInfo.MultiKeyDisabledReason = nil
 		channel.ChannelInfo.MultiKeyDisabledTime = nil
@@ -576,6 +578,23 @@ func validateChannel(channel *model.Channel, isAdd bool) error {
 		return fmt.Errorf("渠道额外设置[channel setting] 格式错误：%s", err.Error())
 	}
 
+	// 校验转换脚本能否编译
+	if channel.OtherSettings != "" {
+		var otherSettings dto.ChannelOtherSettings
+		if err := common.UnmarshalJsonStr(channel.OtherSettings, &otherSettings); err == nil && strings.TrimSpace(otherSettings.TransformScript) != "" {
+			if _, err := relaycommon.NewScriptRunner(otherSettings.TransformScript, nil); err != nil {
+				return fmt.Errorf("转换脚本错误：%s", err.Error())
+			}
+		}
+	}
+
+	// 校验参数覆盖，避免未知操作等拼写错误影响线上请求
+	if channel.ParamOverride != nil {
+		if _, _, err := relaycommon.ParseParamOverride(*channel.ParamOverride); err != nil {
+			return err
+		}
+	}
+
 	// 如果是添加操作，检查 channel 和 key 是否为空
 	if isAdd {
 		if channel == nil || channel.Key == "" {
@@ -927,11 +946,24 @@ func EditTagChannels(c *gin.Context) {
 		}
 		channelTag.HeaderOverride = common.GetPointer[string](trimmed)
 	}
+	configChanged := channelTag.ModelMapping != nil || channelTag.ParamOverride != nil || channelTag.HeaderOverride != nil
+	var tagChannelIds []int
+	if configChanged {
+		if channels, err := model.GetChannelsByTag(channelTag.Tag, false, false); err == nil {
+			for _, channel := range channels {
+				tagChannelIds = append(tagChannelIds, channel.Id)
+				if err = model.EnsureChannelConfigBaseline(channel); err != nil {
+					common.SysLog(fmt.Sprintf("failed to record channel config baseline: channel_id=%d, error=%v", channel.Id, err))
+				}
+			}
+		}
+	}
 	err = model.EditChannelByTag(channelTag.Tag, channelTag.NewTag, channelTag.ModelMapping, channelTag.Models, channelTag.Groups, channelTag.Priority, channelTag.Weight, channelTag.ParamOverride, channelTag.HeaderOverride)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	recordChannelConfigVersions(c, tagChannelIds, "tag edit")
 	model.InitChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1003,6 +1035,7 @@ func UpdateChannel(c *gin.Context) {
 
 	// Always copy the original ChannelInfo so that fields like IsMultiKey and MultiKeySize are retained.
 	channel.ChannelInfo = originChannel.ChannelInfo
+	channel.RestoreMaskedSettings(originChannel)
 
 	// If the request explicitly specifies a new MultiKeyMode, apply it on top of the original info.
 	if channel.MultiKeyMode != nil && *channel.MultiKeyMode != "" {
@@ -1089,11 +1122,15 @@ func UpdateChannel(c *gin.Context) {
 			// 覆盖模式：直接使用新密钥（默认行为，不需要特殊处理）
 		}
 	}
+	if err = model.EnsureChannelConfigBaseline(originChannel); err != nil {
+		common.SysLog(fmt.Sprintf("failed to record channel config baseline: channel_id=%d, error=%v", channel.Id, err))
+	}
 	err = channel.Update()
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	recordChannelConfigVersions(c, []int{channel.Id}, "update")
 	model.InitChannelCache()
 	service.ResetProxyClientCache()
 	channel.Key = ""
@@ -1343,12 +1380,31 @@ func CopyChannel(c *gin.Context) {
 
 // MultiKeyManageRequest represents the request for multi-key management operations
 type MultiKeyManageRequest struct {
-	ChannelId int    `json:"channel_id"`
-	Action    string `json:"action"`              // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status"
-	KeyIndex  *int   `json:"key_index,omitempty"` // for disable_key, enable_key, and delete_key actions
-	Page      int    `json:"page,omitempty"`      // for get_key_status pagination
-	PageSize  int    `json:"page_size,omitempty"` // for get_key_status pagination
-	Status    *int   `json:"status,omitempty"`    // for get_key_status filtering: 1=enabled, 2=manual_disabled, 3=auto_disabled, nil=all
+	ChannelId  int                 `json:"channel_id"`
+	Action     string              `json:"action"`                // "disable_key", "enable_key", "delete_key", "delete_disabled_keys", "get_key_status", "import_keys", "export_keys"
+	KeyIndex   *int                `json:"key_index,omitempty"`   // for disable_key, enable_key, and delete_key actions
+	Page       int                 `json:"page,omitempty"`        // for get_key_status pagination
+	PageSize   int                 `json:"page_size,omitempty"`   // for get_key_status pagination
+	Status     *int                `json:"status,omitempty"`      // for get_key_status filtering: 1=enabled, 2=manual_disabled, 3=auto_disabled, nil=all
+	Keys       []MultiKeyImportKey `json:"keys,omitempty"`        // for import_keys
+	ImportMode string              `json:"import_mode,omitempty"` // for import_keys: "append" (default) or "replace"
+}
+
+// MultiKeyImportKey 导入的 key 及其状态，状态为空时视为启用
+type MultiKeyImportKey struct {
+	Key    string `json:"key"`
+	Status int    `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// MultiKeyExportKey 导出的 key、状态与用量
+type MultiKeyExportKey struct {
+	Index        int                    `json:"index"`
+	Key          string                 `json:"key"`
+	Status       int                    `json:"status"`
+	DisabledTime int64                  `json:"disabled_time,omitempty"`
+	Reason       string                 `json:"reason,omitempty"`
+	Usage        *model.ChannelKeyUsage `json:"usage,omitempty"`
 }
 
 // MultiKeyStatusResponse represents the response for key status query
@@ -1365,11 +1421,14 @@ type MultiKeyStatusResponse struct {
 }
 
 type KeyStatus struct {
-	Index        int    `json:"index"`
-	Status       int    `json:"status"` // 1: enabled, 2: disabled
-	DisabledTime int64  `json:"disabled_time,omitempty"`
-	Reason       string `json:"reason,omitempty"`
-	KeyPreview   string `json:"key_preview"` // first 10 chars of key for identification
+	Index          int                    `json:"index"`
+	Status         int                    `json:"status"` // 1: enabled, 2: disabled
+	DisabledTime   int64                  `json:"disabled_time,omitempty"`
+	Reason         string                 `json:"reason,omitempty"`
+	KeyPreview     string                 `json:"key_preview"`               // first 10 chars of key for identification
+	CooldownUntil  int64                  `json:"cooldown_until,omitempty"`  // 冷却结束时间，仅反映当前节点
+	CooldownReason string                 `json:"cooldown_reason,omitempty"` // 冷却原因
+	Usage          *model.ChannelKeyUsage `json:"usage,omitempty"`           // 累计用量
 }
 
 // ManageMultiKeys handles multi-key management operations
@@ -1419,6 +1478,12 @@ func ManageMultiKeys(c *gin.Context) {
 		// Statistics for all keys (unchanged by filtering)
 		var enabledCount, manualDisabledCount, autoDisabledCount int
 
+		usages, err := model.GetChannelKeyUsages(channel.Id)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+
 		// Build all key status data first
 		var allKeyStatusList []KeyStatus
 		for i, key := range keys {
@@ -1457,12 +1522,16 @@ func ManageMultiKeys(c *gin.Context) {
 				keyPreview = key[:10] + "..."
 			}
 
+			cooldownUntil, cooldownReason := model.GetChannelKeyCooldown(channel.Id, i)
 			allKeyStatusList = append(allKeyStatusList, KeyStatus{
-				Index:        i,
-				Status:       status,
-				DisabledTime: disabledTime,
-				Reason:       reason,
-				KeyPreview:   keyPreview,
+				Index:          i,
+				Status:         status,
+				DisabledTime:   disabledTime,
+				Reason:         reason,
+				KeyPreview:     keyPreview,
+				CooldownUntil:  cooldownUntil,
+				CooldownReason: cooldownReason,
+				Usage:          usages[i],
 			})
 		}
 
@@ -1696,6 +1765,7 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var indexMapping = make(map[int]int)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1705,6 +1775,7 @@ func ManageMultiKeys(c *gin.Context) {
 			}
 
 			remainingKeys = append(remainingKeys, key)
+			indexMapping[i] = newIndex
 
 			// 保留其他密钥的状态信息，重新索引
 			if channel.ChannelInfo.MultiKeyStatusList != nil {
@@ -1745,6 +1816,9 @@ func ManageMultiKeys(c *gin.Context) {
 			common.ApiError(c, err)
 			return
 		}
+		if err = model.RemapChannelKeys(channel.Id, indexMapping); err != nil {
+			common.SysLog(fmt.Sprintf("failed to remap channel key usages: channel_id=%d, error=%v", channel.Id, err))
+		}
 
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
@@ -1760,6 +1834,7 @@ func ManageMultiKeys(c *gin.Context) {
 		var newStatusList = make(map[int]int)
 		var newDisabledTime = make(map[int]int64)
 		var newDisabledReason = make(map[int]string)
+		var indexMapping = make(map[int]int)
 
 		newIndex := 0
 		for i, key := range keys {
@@ -1775,6 +1850,7 @@ func ManageMultiKeys(c *gin.Context) {
 				deletedCount++
 			} else {
 				remainingKeys = append(remainingKeys, key)
+				indexMapping[i] = newIndex
 				// 保留非自动禁用密钥的状态信息，重新索引
 				if status != 1 {
 					newStatusList[newIndex] = status
@@ -1813,6 +1889,9 @@ func ManageMultiKeys(c *gin.Context) {
 			common.ApiError(c, err)
 			return
 		}
+		if err = model.RemapChannelKeys(channel.Id, indexMapping); err != nil {
+			common.SysLog(fmt.Sprintf("failed to remap channel key usages: channel_id=%d, error=%v", channel.Id, err))
+		}
 
 		model.InitChannelCache()
 		c.JSON(http.StatusOK, gin.H{
@@ -1822,6 +1901,39 @@ func ManageMultiKeys(c *gin.Context) {
 		})
 		return
 
+	case "export_keys":
+		// 导出完整密钥，要求与查看渠道密钥相同的权限与安全验证
+		if c.GetInt("role") < common.RoleRootUser || !c.GetBool("secure_verified") {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "导出密钥需要超级管理员权限并通过安全验证",
+				"code":    "VERIFICATION_REQUIRED",
+			})
+			return
+		}
+		exported, err := exportMultiKeys(channel)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		common.ApiSuccess(c, exported)
+		return
+
+	case "import_keys":
+		importedCount, err := importMultiKeys(channel, request.Keys, request.ImportMode)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+
+		model.InitChannelCache()
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": fmt.Sprintf("已导入 %d 个密钥", importedCount),
+			"data":    importedCount,
+		})
+		return
+
 	default:
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -1831,6 +1943,134 @@ func ManageMultiKeys(c *gin.Context) {
 	}
 }
 
+// exportMultiKeys 导出多 Key 渠道的全部密钥及其状态与用量
+func exportMultiKeys(channel *model.Channel) ([]MultiKeyExportKey, error) {
+	usages, err := model.GetChannelKeyUsages(channel.Id)
+	if err != nil {
+		return nil, err
+	}
+	keys := channel.GetKeys()
+	exported := make([]MultiKeyExportKey, 0, len(keys))
+	for i, key := range keys {
+		item := MultiKeyExportKey{
+			Index:  i,
+			Key:    key,
+			Status: common.ChannelStatusEnabled,
+			Usage:  usages[i],
+		}
+		if status, ok := channel.ChannelInfo.MultiKeyStatusList[i]; ok {
+			item.Status = status
+			item.DisabledTime = channel.ChannelInfo.MultiKeyDisabledTime[i]
+			item.Reason = channel.ChannelInfo.MultiKeyDisabledReason[i]
+		}
+		exported = append(exported, item)
+	}
+	return exported, nil
+}
+
+// importMultiKeys 批量导入密钥及其状态，返回导入的数量。
+// append 模式追加渠道中尚不存在的密钥；replace 模式以导入列表替换全部密钥，未指定状态的已有密钥沿用原状态与用量统计
+func importMultiKeys(channel *model.Channel, items []MultiKeyImportKey, mode string) (int, error) {
+	if mode == "" {
+		mode = "append"
+	}
+	if mode != "append" && mode != "replace" {
+		return 0, fmt.Errorf("不支持的导入模式：%s", mode)
+	}
+	if len(items) == 0 {
+		return 0, fmt.Errorf("没有要导入的密钥")
+	}
+	jsonKeys := strings.HasPrefix(strings.TrimSpace(channel.Key), "[")
+
+	info := &channel.ChannelInfo
+	oldKeys := channel.GetKeys()
+	oldIndex := make(map[string]int, len(oldKeys))
+	for i, key := range oldKeys {
+		if _, ok := oldIndex[key]; !ok {
+			oldIndex[key] = i
+		}
+	}
+
+	var keys []string
+	statusList := make(map[int]int)
+	disabledTime := make(map[int]int64)
+	disabledReason := make(map[int]string)
+	indexMapping := make(map[int]int)
+	seen := make(map[string]bool)
+	if mode == "append" {
+		keys = append(keys, oldKeys...)
+		for i, key := range oldKeys {
+			seen[key] = true
+			if status, ok := info.MultiKeyStatusList[i]; ok {
+				statusList[i] = status
+				disabledTime[i] = info.MultiKeyDisabledTime[i]
+				disabledReason[i] = info.MultiKeyDisabledReason[i]
+			}
+		}
+	}
+
+	imported := 0
+	for _, item := range items {
+		key := strings.TrimSpace(item.Key)
+		if key == "" || seen[key] {
+			continue
+		}
+		if !jsonKeys && strings.Contains(key, "\n") {
+			return 0, fmt.Errorf("密钥不能包含换行")
+		}
+		switch item.Status {
+		case 0, common.ChannelStatusEnabled, common.ChannelStatusManuallyDisabled, common.ChannelStatusAutoDisabled:
+		default:
+			return 0, fmt.Errorf("无效的密钥状态：%d", item.Status)
+		}
+		seen[key] = true
+		index := len(keys)
+		keys = append(keys, key)
+		imported++
+
+		old, existed := oldIndex[key]
+		if existed {
+			indexMapping[old] = index
+		}
+		if item.Status == 0 && existed {
+			if status, ok := info.MultiKeyStatusList[old]; ok {
+				statusList[index] = status
+				disabledTime[index] = info.MultiKeyDisabledTime[old]
+				disabledReason[index] = info.MultiKeyDisabledReason[old]
+			}
+		} else if item.Status == common.ChannelStatusManuallyDisabled || item.Status == common.ChannelStatusAutoDisabled {
+			statusList[index] = item.Status
+			disabledTime[index] = common.GetTimestamp()
+			disabledReason[index] = item.Reason
+		}
+	}
+	if mode == "append" && imported == 0 {
+		return 0, nil
+	}
+	if len(keys) == 0 {
+		return 0, fmt.Errorf("没有有效的密钥")
+	}
+
+	if jsonKeys {
+		channel.Key = "[" + strings.Join(keys, ",") + "]"
+	} else {
+		channel.Key = strings.Join(keys, "\n")
+	}
+	info.MultiKeySize = len(keys)
+	info.MultiKeyStatusList = statusList
+	info.MultiKeyDisabledTime = disabledTime
+	info.MultiKeyDisabledReason = disabledReason
+	if err := channel.Update(); err != nil {
+		return 0, err
+	}
+	if mode == "replace" {
+		if err := model.RemapChannelKeys(channel.Id, indexMapping); err != nil {
+			common.SysLog(fmt.Sprintf("failed to remap channel key usages: channel_id=%d, error=%v", channel.Id, err))
+		}
+	}
+	return imported, nil
+}
+
 // OllamaPullModel 拉取 Ollama 模型
 func OllamaPullModel(c *gin.Context) {
 	var req struct {
@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordChannelConfigVersions 渠道保存后记录配置版本，失败只记录日志，不影响保存结果
+func recordChannelConfigVersions(c *gin.Context, channelIds []int, note string) {
+	for _, id := range channelIds {
+		channel, err := model.GetChannelById(id, false)
+		if err == nil {
+			_, err = model.RecordChannelConfigVersion(channel, c.GetInt("id"), note)
+		}
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to record channel config version: channel_id=%d, error=%v", id, err))
+		}
+	}
+}
+
+// TestChannelOverride 使用示例请求测试模型映射、参数覆盖与请求头覆盖，返回改写后的请求体与每个操作的执行记录
+func TestChannelOverride(c *gin.Context) {
+	var req service.OverridePreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	result, err := service.PreviewOverride(&req)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, result)
+}
+
+func GetChannelConfigVersions(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	versions, err := model.GetChannelConfigVersions(channelId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, versions)
+}
+
+// DiffChannelConfigVersions 比较两个版本，to 为空时与渠道当前配置比较
+func DiffChannelConfigVersions(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	fromVersion, err := strconv.Atoi(c.Query("from"))
+	if err != nil {
+		common.ApiError(c, errors.New("from 必须是版本号"))
+		return
+	}
+	from, err := model.GetChannelConfigVersion(channelId, fromVersion)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var to *model.ChannelConfigVersion
+	if toQuery := c.Query("to"); toQuery != "" {
+		toVersion, err := strconv.Atoi(toQuery)
+		if err != nil {
+			common.ApiError(c, errors.New("to 必须是版本号"))
+			return
+		}
+		if to, err = model.GetChannelConfigVersion(channelId, toVersion); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	} else {
+		channel, err := model.GetChannelById(channelId, false)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		to = &model.ChannelConfigVersion{
+			ChannelId:      channelId,
+			ModelMapping:   channel.ModelMapping,
+			ParamOverride:  channel.ParamOverride,
+			HeaderOverride: channel.HeaderOverride,
+		}
+	}
+	common.ApiSuccess(c, gin.H{
+		"from":  from.Version,
+		"to":    to.Version,
+		"diffs": service.DiffChannelConfigVersions(from, to),
+	})
+}
+
+// RollbackChannelConfigVersion 将渠道的模型映射、参数覆盖与请求头覆盖恢复为指定版本
+func RollbackChannelConfigVersion(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	newVersion, err := model.RollbackChannelConfig(channelId, version, c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.InitChannelCache()
+	common.ApiSuccess(c, newVersion)
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetChannelPools 获取渠道池列表
+func GetChannelPools(c *gin.Context) {
+	pools, err := model.GetAllChannelPools()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, pools)
+}
+
+// GetChannelPool 获取渠道池及其成员渠道（含按标签匹配的渠道）
+func GetChannelPool(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pool, err := model.GetChannelPoolById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	members, err := model.GetChannelPoolMembers(pool)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	for _, member := range members {
+		clearChannelInfo(member)
+	}
+	common.ApiSuccess(c, gin.H{
+		"pool":     pool,
+		"channels": members,
+	})
+}
+
+func validateChannelPoolRequest(c *gin.Context, pool *model.ChannelPool) bool {
+	if err := model.ValidateChannelPool(pool); err != nil {
+		common.ApiError(c, err)
+		return false
+	}
+	if dup, err := model.IsChannelPoolNameDuplicated(pool.Id, pool.Name); err != nil {
+		common.ApiError(c, err)
+		return false
+	} else if dup {
+		common.ApiErrorMsg(c, "渠道池名称已存在")
+		return false
+	}
+	return true
+}
+
+// CreateChannelPool 创建渠道池，并重新生成成员渠道的 abilities
+func CreateChannelPool(c *gin.Context) {
+	var pool model.ChannelPool
+	if err := c.ShouldBindJSON(&pool); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pool.Id = 0
+	if !validateChannelPoolRequest(c, &pool) {
+		return
+	}
+	if err := pool.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, &pool)
+}
+
+// UpdateChannelPool 更新渠道池，并重新生成修改前后成员渠道的 abilities
+func UpdateChannelPool(c *gin.Context) {
+	var pool model.ChannelPool
+	if err := c.ShouldBindJSON(&pool); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if pool.Id == 0 {
+		common.ApiErrorMsg(c, "缺少渠道池 ID")
+		return
+	}
+	if !validateChannelPoolRequest(c, &pool) {
+		return
+	}
+	if err := pool.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, &pool)
+}
+
+// DeleteChannelPool 删除渠道池，成员渠道恢复按自身的分组生成 abilities
+func DeleteChannelPool(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pool, err := model.GetChannelPoolById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err = pool.Delete(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+type channelScriptTestRequest struct {
+	ChannelId int                    `json:"channel_id"` // script 为空时使用该渠道已保存的脚本
+	Script    string                 `json:"script"`
+	Hook      string                 `json:"hook"`    // onRequest / onResponse / onStreamChunk
+	Payload   json.RawMessage        `json:"payload"` // 请求体、响应体或一个流式事件的 JSON
+	Context   map[string]interface{} `json:"context"` // 模拟的 ctx，如 model、group、user_id
+}
+
+// TestChannelScript 使用示例数据执行渠道转换脚本，返回脚本的输出，不会发送任何请求
+func TestChannelScript(c *gin.Context) {
+	var req channelScriptTestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Script == "" && req.ChannelId > 0 {
+		channel, err := model.GetChannelById(req.ChannelId, true)
+		if err != nil {
+			common.ApiError(c, err)
+			return
+		}
+		req.Script = channel.GetOtherSettings().TransformScript
+	}
+	if req.Script == "" {
+		common.ApiError(c, errors.New("脚本不能为空"))
+		return
+	}
+	if req.Hook == "" {
+		req.Hook = relaycommon.ScriptHookRequest
+	}
+	if len(req.Payload) == 0 {
+		req.Payload = json.RawMessage("{}")
+	}
+	if req.Context == nil {
+		req.Context = map[string]interface{}{}
+	}
+
+	start := time.Now()
+	runner, err := relaycommon.NewScriptRunner(req.Script, req.Context)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	hooks := make([]string, 0, 3)
+	for _, hook := range []string{relaycommon.ScriptHookRequest, relaycommon.ScriptHookResponse, relaycommon.ScriptHookStreamChunk} {
+		if runner.HasHook(hook) {
+			hooks = append(hooks, hook)
+		}
+	}
+	result := gin.H{"hooks": hooks}
+	if !runner.HasHook(req.Hook) {
+		common.ApiErrorMsg(c, "脚本未定义 "+req.Hook)
+		return
+	}
+	output, dropped, err := runner.Run(req.Hook, req.Payload)
+	result["duration_ms"] = time.Since(start).Milliseconds()
+	if err != nil {
+		result["error"] = err.Error()
+		common.ApiSuccess(c, result)
+		return
+	}
+	result["dropped"] = dropped
+	if json.Valid(output) {
+		result["output"] = json.RawMessage(output)
+	} else {
+		result["output"] = string(output)
+	}
+	common.ApiSuccess(c, result)
+}
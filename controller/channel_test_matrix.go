@@ -0,0 +1,372 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+// ChannelTestMatrixRequest 批量测试的测试矩阵：渠道 × 模型 × key × 端点类型 × 是否流式
+type ChannelTestMatrixRequest struct {
+	ChannelIds    []int    `json:"channel_ids"`    // 为空表示全部渠道
+	Models        []string `json:"models"`         // 只测试渠道中包含的这些模型
+	AllModels     bool     `json:"all_models"`     // 测试渠道的全部模型，否则仅测试测试模型（未设置时为第一个模型）
+	AllKeys       bool     `json:"all_keys"`       // 多 Key 渠道逐个测试每个 key
+	Stream        bool     `json:"stream"`         // 额外测试流式请求
+	EndpointTypes []string `json:"endpoint_types"` // 为空时按模型自动检测
+	Concurrency   int      `json:"concurrency"`    // 同时进行的测试请求数，0 使用系统设置
+}
+
+// 不支持流式的端点类型不生成流式测试点
+var nonStreamEndpointTypes = []string{
+	string(constant.EndpointTypeEmbeddings),
+	string(constant.EndpointTypeImageGeneration),
+	string(constant.EndpointTypeJinaRerank),
+}
+
+// buildChannelTestPoints 生成渠道的全部测试点
+func buildChannelTestPoints(channel *model.Channel, req *ChannelTestMatrixRequest) []channelTestOptions {
+	channelModels := lo.Uniq(lo.FilterMap(channel.GetModels(), func(m string, _ int) (string, bool) {
+		m = strings.TrimSpace(m)
+		return m, m != ""
+	}))
+	models := []string{""}
+	if len(req.Models) > 0 {
+		models = lo.Filter(req.Models, func(m string, _ int) bool {
+			return lo.Contains(channelModels, m)
+		})
+	} else if req.AllModels && len(channelModels) > 0 {
+		models = channelModels
+	}
+	keyIndexes := []int{-1}
+	if req.AllKeys && channel.ChannelInfo.IsMultiKey {
+		keyIndexes = lo.Range(len(channel.GetKeys()))
+	}
+	endpointTypes := req.EndpointTypes
+	if len(endpointTypes) == 0 {
+		endpointTypes = []string{""}
+	}
+	streams := []bool{false}
+	if req.Stream {
+		streams = append(streams, true)
+	}
+
+	points := make([]channelTestOptions, 0, len(models)*len(keyIndexes)*len(endpointTypes)*len(streams))
+	for _, m := range models {
+		for _, keyIndex := range keyIndexes {
+			for _, endpointType := range endpointTypes {
+				for _, stream := range streams {
+					if stream && lo.Contains(nonStreamEndpointTypes, endpointType) {
+						continue
+					}
+					points = append(points, channelTestOptions{
+						Model:        m,
+						EndpointType: endpointType,
+						KeyIndex:     keyIndex,
+						Stream:       stream,
+					})
+				}
+			}
+		}
+	}
+	return points
+}
+
+// channelTestPointResult 单个测试点的结果
+type channelTestPointResult struct {
+	options      channelTestOptions
+	result       testResult
+	model        string
+	keyIndex     int // 实际使用的 key 索引，单 Key 渠道为 0，无法确定时为 -1
+	milliseconds int64
+}
+
+func (r *channelTestPointResult) success() bool {
+	return r.result.localErr == nil && r.result.newAPIError == nil
+}
+
+// runChannelTestMatrix 在后台按测试矩阵测试渠道，同一时间只允许一个批量测试
+func runChannelTestMatrix(req *ChannelTestMatrixRequest, notify bool) error {
+	testAllChannelsLock.Lock()
+	if testAllChannelsRunning {
+		testAllChannelsLock.Unlock()
+		return errors.New("测试已在运行中")
+	}
+	testAllChannelsRunning = true
+	testAllChannelsLock.Unlock()
+
+	var channels []*model.Channel
+	var err error
+	if len(req.ChannelIds) > 0 {
+		channels, err = model.GetChannelsByIds(req.ChannelIds)
+	} else {
+		channels, err = model.GetAllChannels(0, 0, true, false)
+	}
+	if err != nil {
+		testAllChannelsLock.Lock()
+		testAllChannelsRunning = false
+		testAllChannelsLock.Unlock()
+		return err
+	}
+	concurrency := req.Concurrency
+	if concurrency <= 0 {
+		concurrency = operation_setting.GetMonitorSetting().TestConcurrency
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	gopool.Go(func() {
+		// 使用 defer 确保无论如何都会重置运行状态，防止死锁
+		defer func() {
+			testAllChannelsLock.Lock()
+			testAllChannelsRunning = false
+			testAllChannelsLock.Unlock()
+		}()
+
+		tik := time.Now()
+		runs := make([]*channelMatrixRun, 0, len(channels))
+		for _, channel := range channels {
+			if points := buildChannelTestPoints(channel, req); len(points) > 0 {
+				runs = append(runs, newChannelMatrixRun(channel, points))
+			}
+		}
+		runChannelMatrixPool(runs, concurrency, common.RequestInterval, testChannelPoint, finishChannelMatrix)
+		common.SysLog(fmt.Sprintf("channel test matrix finished: %d channels, %.2fs", len(channels), time.Since(tik).Seconds()))
+
+		if notify {
+			service.NotifyRootUser(dto.NotifyTypeChannelTest, "通道测试完成", "所有通道测试已完成")
+		}
+	})
+	return nil
+}
+
+// channelMatrixRun 单个渠道的批量测试进度，全部测试点完成后由最后完成的 worker 汇总
+type channelMatrixRun struct {
+	channel   *model.Channel
+	points    []channelTestOptions
+	results   []*channelTestPointResult
+	remaining atomic.Int32
+}
+
+func newChannelMatrixRun(channel *model.Channel, points []channelTestOptions) *channelMatrixRun {
+	run := &channelMatrixRun{
+		channel: channel,
+		points:  points,
+		results: make([]*channelTestPointResult, len(points)),
+	}
+	run.remaining.Store(int32(len(points)))
+	return run
+}
+
+type channelMatrixJob struct {
+	run   *channelMatrixRun
+	index int
+}
+
+// runChannelMatrixPool 使用固定数量的 worker 执行全部渠道的测试点。
+// 测试点按间隔 interval 依次派发，等待间隔期间不占用 worker
+func runChannelMatrixPool(runs []*channelMatrixRun, concurrency int, interval time.Duration,
+	testPoint func(*model.Channel, channelTestOptions) *channelTestPointResult, finish func(*channelMatrixRun)) {
+	jobs := make(chan channelMatrixJob)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				run := job.run
+				run.results[job.index] = testPoint(run.channel, run.points[job.index])
+				if run.remaining.Add(-1) == 0 {
+					finish(run)
+				}
+			}
+		}()
+	}
+
+	first := true
+	for _, run := range runs {
+		for i := range run.points {
+			if !first && interval > 0 {
+				time.Sleep(interval)
+			}
+			first = false
+			jobs <- channelMatrixJob{run: run, index: i}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// testChannelPoint 执行单个测试点，记录实际测试的模型与 key
+func testChannelPoint(channel *model.Channel, point channelTestOptions) *channelTestPointResult {
+	tik := time.Now()
+	result := testChannelWithOptions(channel, point)
+	r := &channelTestPointResult{
+		options:      point,
+		result:       result,
+		model:        point.Model,
+		keyIndex:     point.KeyIndex,
+		milliseconds: time.Since(tik).Milliseconds(),
+	}
+	if result.context != nil {
+		if testModel := result.context.GetString("original_model"); testModel != "" {
+			r.model = testModel
+		}
+	}
+	if !channel.ChannelInfo.IsMultiKey {
+		r.keyIndex = 0
+	} else if point.KeyIndex < 0 && result.context != nil && common.GetContextKeyBool(result.context, constant.ContextKeyChannelIsMultiKey) {
+		r.keyIndex = common.GetContextKeyInt(result.context, constant.ContextKeyChannelMultiKeyIndex)
+	}
+	return r
+}
+
+// finishChannelMatrix 保存渠道全部测试点的结果，并按 key 粒度决定禁用或启用
+func finishChannelMatrix(run *channelMatrixRun) {
+	var totalMilliseconds int64
+	for _, r := range run.results {
+		totalMilliseconds += r.milliseconds
+		saveChannelTestPointResult(run.channel, r)
+	}
+	run.channel.UpdateResponseTime(totalMilliseconds / int64(len(run.results)))
+
+	applyChannelTestResults(run.channel, run.results)
+}
+
+func saveChannelTestPointResult(channel *model.Channel, r *channelTestPointResult) {
+	record := &model.ChannelTestResult{
+		ChannelId:    channel.Id,
+		Model:        r.model,
+		KeyIndex:     max(r.keyIndex, 0),
+		EndpointType: r.options.EndpointType,
+		Stream:       r.options.Stream,
+		Success:      r.success(),
+		ResponseTime: r.milliseconds,
+		TestedAt:     common.GetTimestamp(),
+	}
+	if r.result.newAPIError != nil {
+		record.StatusCode = r.result.newAPIError.StatusCode
+		record.Message = r.result.newAPIError.MaskSensitiveError()
+	} else if r.result.localErr != nil {
+		record.Message = r.result.localErr.Error()
+	}
+	service.RecordChannelTestResult(channel.Id, r.model, record.Success, time.Duration(r.milliseconds)*time.Millisecond)
+	if err := model.SaveChannelTestResult(record); err != nil {
+		common.SysLog(fmt.Sprintf("failed to save channel test result: channel_id=%d, error=%v", channel.Id, err))
+	}
+}
+
+// applyChannelTestResults 按 key 汇总测试结果：任一测试点出现应禁用的错误（或响应超时）时禁用该 key，
+// 全部测试点成功时启用被自动禁用的 key。单 Key 渠道以渠道为单位处理
+func applyChannelTestResults(channel *model.Channel, results []*channelTestPointResult) {
+	var disableThreshold = int64(common.ChannelDisableThreshold * 1000)
+	if disableThreshold == 0 {
+		disableThreshold = 10000000 // a impossible value
+	}
+
+	keyResults := lo.GroupBy(results, func(r *channelTestPointResult) int {
+		return r.keyIndex
+	})
+	for keyIndex, group := range keyResults {
+		if keyIndex < 0 {
+			continue
+		}
+		var banResult *channelTestPointResult
+		var banError *types.NewAPIError
+		allSuccess := true
+		for _, r := range group {
+			if !r.success() {
+				allSuccess = false
+			}
+			if banError != nil {
+				continue
+			}
+			if service.ShouldCoolDownChannel(channel.ChannelInfo.IsMultiKey, r.result.newAPIError) {
+				cooldownKeyIndex := -1
+				if channel.ChannelInfo.IsMultiKey {
+					cooldownKeyIndex = keyIndex
+				}
+				service.CoolDownChannel(channel.Id, cooldownKeyIndex, r.result.newAPIError)
+				continue
+			}
+			if r.result.newAPIError != nil && service.ShouldDisableChannel(channel.Type, r.result.newAPIError) {
+				banResult, banError = r, r.result.newAPIError
+			} else if common.AutomaticDisableChannelEnabled && r.milliseconds > disableThreshold {
+				// 当错误检查通过，才检查响应时间
+				err := fmt.Errorf("响应时间 %.2fs 超过阈值 %.2fs", float64(r.milliseconds)/1000.0, float64(disableThreshold)/1000.0)
+				banResult, banError = r, types.NewOpenAIError(err, types.ErrorCodeChannelResponseTimeExceeded, http.StatusRequestTimeout)
+			}
+		}
+
+		if channel.ChannelInfo.IsMultiKey {
+			keyStatus, ok := channel.ChannelInfo.MultiKeyStatusList[keyIndex]
+			if !ok {
+				keyStatus = common.ChannelStatusEnabled
+			}
+			if banError != nil && keyStatus == common.ChannelStatusEnabled && channel.GetAutoBan() {
+				channelError := types.NewChannelError(channel.Id, channel.Type, channel.Name, true, "", channel.GetAutoBan())
+				service.DisableChannelKey(*channelError, keyIndex, banError.ErrorWithStatusCode())
+			}
+			if allSuccess && keyStatus == common.ChannelStatusAutoDisabled && common.AutomaticEnableChannelEnabled {
+				service.EnableChannelKey(channel.Id, keyIndex, channel.Name)
+			}
+			continue
+		}
+
+		// disable channel
+		if banError != nil && channel.Status == common.ChannelStatusEnabled && channel.GetAutoBan() && banResult.result.context != nil {
+			processChannelError(banResult.result.context, *types.NewChannelError(channel.Id, channel.Type, channel.Name, false, common.GetContextKeyString(banResult.result.context, constant.ContextKeyChannelKey), channel.GetAutoBan()), banError)
+		}
+		// enable channel
+		if allSuccess && service.ShouldEnableChannel(nil, channel.Status) {
+			service.EnableChannel(channel.Id, "", channel.Name)
+		}
+	}
+}
+
+// TestChannelMatrix 按请求的测试矩阵在后台批量测试渠道，结果通过 GetChannelTestResults 查询
+func TestChannelMatrix(c *gin.Context) {
+	var req ChannelTestMatrixRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := runChannelTestMatrix(&req, false); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+// GetChannelTestResults 查询渠道各测试点最近一次的测试结果
+func GetChannelTestResults(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	results, err := model.GetChannelTestResults(channelId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, results)
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildChannelTestPoints(t *testing.T) {
+	channel := &model.Channel{Models: "m1, m2,m1"}
+	channel.ChannelInfo.IsMultiKey = true
+	channel.Key = "k0\nk1"
+
+	points := buildChannelTestPoints(channel, &ChannelTestMatrixRequest{AllModels: true, AllKeys: true, Stream: true,
+		EndpointTypes: []string{"openai", "embeddings"}})
+	// 2 模型 × 2 key × (openai 流式/非流式 + embeddings 非流式)
+	require.Len(t, points, 2*2*3)
+	for _, p := range points {
+		require.False(t, p.Stream && p.EndpointType == "embeddings")
+	}
+
+	// 指定的模型只保留渠道中存在的
+	points = buildChannelTestPoints(channel, &ChannelTestMatrixRequest{Models: []string{"m2", "m3"}})
+	require.Len(t, points, 1)
+	require.Equal(t, "m2", points[0].Model)
+	require.Equal(t, -1, points[0].KeyIndex)
+}
+
+func TestRunChannelMatrixPool_BoundsConcurrency(t *testing.T) {
+	const concurrency = 3
+	runs := make([]*channelMatrixRun, 0, 10)
+	total := 0
+	for i := 1; i <= 10; i++ {
+		points := make([]channelTestOptions, i)
+		total += i
+		runs = append(runs, newChannelMatrixRun(&model.Channel{Id: i}, points))
+	}
+
+	var running, maxRunning, tested atomic.Int32
+	var mu sync.Mutex
+	finished := make(map[int]int)
+	testPoint := func(channel *model.Channel, point channelTestOptions) *channelTestPointResult {
+		n := running.Add(1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		tested.Add(1)
+		return &channelTestPointResult{options: point}
+	}
+	finish := func(run *channelMatrixRun) {
+		// 汇总时该渠道的全部测试点都已完成
+		for _, r := range run.results {
+			require.NotNil(t, r)
+		}
+		mu.Lock()
+		finished[run.channel.Id]++
+		mu.Unlock()
+	}
+
+	runChannelMatrixPool(runs, concurrency, 0, testPoint, finish)
+	require.Equal(t, int32(total), tested.Load())
+	require.LessOrEqual(t, maxRunning.Load(), int32(concurrency))
+	require.Greater(t, maxRunning.Load(), int32(1))
+	require.Len(t, finished, len(runs))
+	for id, count := range finished {
+		require.Equal(t, 1, count, "channel %d finished more than once", id)
+	}
+}
+
+func TestRunChannelMatrixPool_PacesOutsideWorkers(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	run := newChannelMatrixRun(&model.Channel{Id: 1}, make([]channelTestOptions, 4))
+
+	var mu sync.Mutex
+	var starts []time.Time
+	var busy time.Duration
+	testPoint := func(channel *model.Channel, point channelTestOptions) *channelTestPointResult {
+		tik := time.Now()
+		mu.Lock()
+		starts = append(starts, tik)
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		busy += time.Since(tik)
+		mu.Unlock()
+		return &channelTestPointResult{options: point}
+	}
+
+	runChannelMatrixPool([]*channelMatrixRun{run}, 1, interval, testPoint, func(*channelMatrixRun) {})
+	require.Len(t, starts, 4)
+	// 相邻测试点按间隔派发，而 worker 只在测试期间被占用
+	for i := 1; i < len(starts); i++ {
+		require.GreaterOrEqual(t, starts[i].Sub(starts[i-1]), interval)
+	}
+	require.Less(t, busy, 3*interval)
+}
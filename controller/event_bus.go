@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetEventBusStatus 查看各 sink 的投递积压与失败情况
+func GetEventBusStatus(c *gin.Context) {
+	stats, err := model.GetEventSinkStats()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	busSetting := operation_setting.GetEventBusSetting()
+	sinks := make([]gin.H, 0, len(busSetting.Sinks))
+	for _, sink := range busSetting.Sinks {
+		sinks = append(sinks, gin.H{
+			"name":    sink.Name,
+			"type":    sink.Type,
+			"enabled": sink.Enabled,
+			"events":  sink.Events,
+		})
+	}
+	common.ApiSuccess(c, gin.H{
+		"enabled": busSetting.Enabled,
+		"sinks":   sinks,
+		"stats":   stats,
+	})
+}
+
+// TestEventBus 发布一条测试事件，用于验证 sink 配置
+func TestEventBus(c *gin.Context) {
+	if !operation_setting.GetEventBusSetting().Enabled {
+		common.ApiErrorMsg(c, "事件总线未启用")
+		return
+	}
+	model.PublishEvent(model.EventTypeSystemTest, c.GetInt("id"), gin.H{
+		"message": "event bus test",
+		"time":    time.Now().Unix(),
+	})
+	common.ApiSuccess(c, nil)
+}
+
+// RetryEventSinkDeliveries 重新投递某个 sink 的失败事件
+func RetryEventSinkDeliveries(c *gin.Context) {
+	affected, err := model.RetryFailedEventDeliveries(c.Param("sink"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{"count": affected})
+}
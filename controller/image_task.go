@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// 异步图片任务：请求携带 Prefer: respond-async、X-Async: true 或 async: true 时，预扣费后立即返回任务 ID，
+// 由当前节点在后台按普通图片请求的流程（含重试）执行，结果通过统一任务查询接口获取，失败时返还预扣费。
+// 执行期间节点持续续期任务租约；节点宕机导致租约过期后，轮询调度器将任务判定失败并退款。
+
+// imageTaskBodyFields 仅用于网关的请求字段，透传请求体时需要移除
+var imageTaskBodyFields = []string{"async", "callback_url"}
+
+// parseAsyncImageRequest 判断图片请求是否以异步任务方式执行，并返回回调地址
+func parseAsyncImageRequest(c *gin.Context, info *relaycommon.RelayInfo) (bool, string) {
+	if info.RelayMode != relayconstant.RelayModeImagesGenerations && info.RelayMode != relayconstant.RelayModeImagesEdits {
+		return false, ""
+	}
+	async := strings.Contains(strings.ToLower(c.GetHeader("Prefer")), "respond-async")
+	if v, err := strconv.ParseBool(c.GetHeader("X-Async")); err == nil && v {
+		async = true
+	}
+	var callbackUrl string
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		if form, err := common.ParseMultipartFormReusable(c); err == nil {
+			if values := form.Value["async"]; len(values) > 0 {
+				if v, err := strconv.ParseBool(values[0]); err == nil && v {
+					async = true
+				}
+			}
+			if values := form.Value["callback_url"]; len(values) > 0 {
+				callbackUrl = values[0]
+			}
+		}
+	} else if request, ok := info.Request.(*dto.ImageRequest); ok {
+		var v bool
+		if raw, ok := request.Extra["async"]; ok && common.Unmarshal(raw, &v) == nil && v {
+			async = true
+		}
+		if raw, ok := request.Extra["callback_url"]; ok {
+			_ = common.Unmarshal(raw, &callbackUrl)
+		}
+	}
+	return async, callbackUrl
+}
+
+// submitImageTask 创建异步图片任务并在后台执行，调用前需已完成预扣费；返回 nil 后预扣费由后台任务负责结算或返还
+func submitImageTask(c *gin.Context, info *relaycommon.RelayInfo, meta *types.TokenCountMeta, callbackUrl string) *types.NewAPIError {
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return types.NewErrorWithStatusCode(err, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+	}
+	body = stripImageTaskBodyFields(c, body)
+
+	now := time.Now().Unix()
+	task := model.InitTask(constant.TaskPlatformImage, info)
+	task.TaskID = "imgtask_" + common.GetUUID()
+	task.ChannelId = c.GetInt("channel_id")
+	task.Action = constant.TaskActionGenerate
+	if info.RelayMode == relayconstant.RelayModeImagesEdits {
+		task.Action = constant.TaskActionImageEdit
+	}
+	task.Status = model.TaskStatusQueued
+	task.Quota = info.FinalPreConsumedQuota
+	task.Properties.OriginModelName = info.OriginModelName
+	if request, ok := info.Request.(*dto.ImageRequest); ok {
+		task.Properties.Input = request.Prompt
+	}
+	if callbackUrl != "" {
+		task.CallBackUrl = callbackUrl
+		task.CallBackStatus = service.CallbackStatusPending
+	}
+	task.LeaseOwner = model.GetPollOwner()
+	task.LeaseUntil = now + int64(operation_setting.GetTaskPollSetting().GetLeaseSeconds())
+	task.NextPollAt = now
+	if err := task.Insert(); err != nil {
+		return types.NewError(fmt.Errorf("insert image task failed: %w", err), types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+	}
+	info.AsyncTaskId = task.TaskID
+
+	// 请求结束后 gin.Context 会被回收，后台执行使用上下文副本，并与客户端连接的生命周期解耦
+	lc := c.Copy()
+	lc.Request = c.Request.Clone(context.Background())
+	lc.Writer = newSubRequestWriter(nil)
+	lc.Set(common.KeyRequestBody, body)
+	gopool.Go(func() {
+		runImageTask(lc, info, meta, task)
+	})
+
+	c.JSON(http.StatusAccepted, dto.UnifiedTaskCreateResponse{
+		Code:    http.StatusAccepted,
+		Message: "success",
+		Data: &dto.UnifiedTaskResponseData{
+			TaskId: task.TaskID,
+			Model:  info.OriginModelName,
+			State:  "queued",
+		},
+	})
+	return nil
+}
+
+// stripImageTaskBodyFields 移除 JSON 请求体中仅用于网关的字段，避免透传到上游
+func stripImageTaskBodyFields(c *gin.Context, body []byte) []byte {
+	if !strings.Contains(c.ContentType(), "json") {
+		return body
+	}
+	var fields map[string]any
+	if err := common.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	changed := false
+	for _, field := range imageTaskBodyFields {
+		if _, ok := fields[field]; ok {
+			delete(fields, field)
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	stripped, err := common.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return stripped
+}
+
+// runImageTask 在后台执行图片请求，与同步请求共用 relayWithRetry 的渠道选择、重试与模型回退
+func runImageTask(c *gin.Context, info *relaycommon.RelayInfo, meta *types.TokenCountMeta, task *model.Task) {
+	owner := model.GetPollOwner()
+	stop := make(chan struct{})
+	defer close(stop)
+	go keepImageTaskLease(task.ID, owner, stop)
+
+	task.Status = model.TaskStatusInProgress
+	task.StartTime = time.Now().Unix()
+	task.Progress = "10%"
+	_ = model.DB.Model(&model.Task{}).Where("id = ?", task.ID).Updates(map[string]any{
+		"status":     task.Status,
+		"start_time": task.StartTime,
+		"progress":   task.Progress,
+	}).Error
+
+	writer := c.Writer.(*subRequestWriter)
+	newAPIError := relayWithRetry(c, info, meta, func() *types.NewAPIError {
+		writer.body.Reset()
+		return relayHandler(c, info)
+	})
+
+	if newAPIError != nil {
+		logger.LogError(c, fmt.Sprintf("image task %s failed: %s", task.TaskID, newAPIError.Error()))
+		task.Status = model.TaskStatusFailure
+		task.FailReason = newAPIError.Error()
+		changed, err := model.FailUnfinishedTask(task.ID, task.FailReason)
+		if err != nil {
+			logger.LogError(c, fmt.Sprintf("update image task %s failed: %s", task.TaskID, err.Error()))
+		}
+		// 任务已被调度器判定失败时额度已退还、回调已触发
+		if !changed {
+			return
+		}
+		if info.FinalPreConsumedQuota != 0 {
+			service.ReturnPreConsumedQuota(c, info)
+			model.PublishRefundEvent(task.UserId, info.FinalPreConsumedQuota, "task_failed", task.TaskID)
+		}
+	} else {
+		task.Status = model.TaskStatusSuccess
+		task.Progress = "100%"
+		task.FinishTime = time.Now().Unix()
+		task.ChannelId = info.ChannelId
+		task.Properties.UpstreamModelName = info.UpstreamModelName
+		task.Data = writer.body.Bytes()
+		changed, err := model.CompleteUnfinishedTask(task.ID, map[string]any{
+			"status":      task.Status,
+			"progress":    task.Progress,
+			"finish_time": task.FinishTime,
+			"channel_id":  task.ChannelId,
+			"properties":  task.Properties,
+			"data":        task.Data,
+		})
+		if err != nil {
+			logger.LogError(c, fmt.Sprintf("update image task %s failed: %s", task.TaskID, err.Error()))
+		}
+		if !changed {
+			logger.LogWarn(c, fmt.Sprintf("image task %s already finished, result discarded", task.TaskID))
+			return
+		}
+	}
+
+	if task.CallBackUrl != "" {
+		service.TriggerTaskCallback(task)
+	}
+}
+
+// keepImageTaskLease 定期续期任务租约，防止执行中的任务被调度器判定为失联
+func keepImageTaskLease(id int64, owner string, stop <-chan struct{}) {
+	leaseSeconds := operation_setting.GetTaskPollSetting().GetLeaseSeconds()
+	ticker := time.NewTicker(time.Duration(leaseSeconds) * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := model.RenewTaskLease(id, owner, time.Now().Unix()+int64(leaseSeconds)); err != nil {
+				common.SysLog(fmt.Sprintf("renew image task %d lease failed: %s", id, err.Error()))
+			}
+		}
+	}
+}
+
+// failOrphanedImageTasks 调度器领取到图片任务说明执行节点的租约已过期，任务无法继续，判定失败并退款
+func failOrphanedImageTasks(ctx context.Context, taskM map[string]*model.Task) {
+	for _, task := range taskM {
+		failUnfinishedTask(ctx, task, "任务执行中断（执行节点已失联）", "异步图片任务中断", "task_failed")
+	}
+}
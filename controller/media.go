@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetMediaObject 通过签名链接下载持久化的媒体
+func GetMediaObject(c *gin.Context) {
+	key := c.Param("key")
+	if !service.VerifyMediaSignature(key, c.Query("expires"), c.Query("signature")) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": gin.H{
+				"message": "invalid or expired media signature",
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	obj, err := model.GetMediaObjectByKey(key)
+	if err != nil || (obj.ExpiresAt > 0 && obj.ExpiresAt < time.Now().Unix()) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"message": "media not found",
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+	service.ServeMediaObject(c, obj)
+}
+
+type mediaObjectItem struct {
+	*model.MediaObject
+	Url string `json:"url"`
+}
+
+func getMediaObjectList(c *gin.Context, userId int) {
+	pageInfo := common.GetPageQuery(c)
+	objects, total, err := model.GetMediaObjects(model.MediaObjectQueryParams{
+		UserId:   userId,
+		Source:   c.Query("source"),
+		SourceId: c.Query("source_id"),
+	}, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	items := make([]mediaObjectItem, 0, len(objects))
+	for _, obj := range objects {
+		items = append(items, mediaObjectItem{MediaObject: obj, Url: service.SignMediaURL(service.MediaObjectURL(obj))})
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(items)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetAllMediaObjects(c *gin.Context) {
+	userId := -1
+	if c.Query("user_id") != "" {
+		userId, _ = strconv.Atoi(c.Query("user_id"))
+	}
+	getMediaObjectList(c, userId)
+}
+
+func GetSelfMediaObjects(c *gin.Context) {
+	getMediaObjectList(c, c.GetInt("id"))
+}
+
+// GetSelfMediaUsage 返回当前用户的媒体存储占用与配额
+func GetSelfMediaUsage(c *gin.Context) {
+	userId := c.GetInt("id")
+	used, err := model.GetUserMediaUsage(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	group, err := model.GetUserGroup(userId, false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	setting := operation_setting.GetMediaStorageSetting()
+	common.ApiSuccess(c, gin.H{
+		"used":           used,
+		"quota":          setting.GetQuotaBytes(group),
+		"retention_days": setting.GetRetentionDays(group),
+	})
+}
+
+func deleteMediaObject(c *gin.Context, userId int) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	obj, err := model.GetMediaObjectById(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.ApiErrorMsg(c, "媒体不存在")
+			return
+		}
+		common.ApiError(c, err)
+		return
+	}
+	if userId >= 0 && obj.UserId != userId {
+		common.ApiErrorMsg(c, "媒体不存在")
+		return
+	}
+	if err := service.DeleteMediaObject(c.Request.Context(), obj); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func DeleteMediaObject(c *gin.Context) {
+	deleteMediaObject(c, -1)
+}
+
+func DeleteSelfMediaObject(c *gin.Context) {
+	deleteMediaObject(c, c.GetInt("id"))
+}
+
+// CleanupMediaObjects 立即清理过期媒体
+func CleanupMediaObjects(c *gin.Context) {
+	deleted := service.CleanupExpiredMedia()
+	common.ApiSuccess(c, gin.H{
+		"deleted": deleted,
+	})
+}
+
+// persistTaskMediaURL 在任务完成时持久化结果链接，失败时保留原链接
+func persistTaskMediaURL(task *model.Task, source string, rawURL string) string {
+	if !operation_setting.GetMediaStorageSetting().ShouldPersist(source) || rawURL == "" || service.IsMediaURL(rawURL) {
+		return rawURL
+	}
+	if !strings.HasPrefix(rawURL, "http") && !strings.HasPrefix(rawURL, "data:") {
+		return rawURL
+	}
+	mediaURL, err := service.PersistMediaFromURL(context.Background(), task.UserId, source, task.TaskID, rawURL)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to persist media for task %s: %s", task.TaskID, err.Error()))
+		return rawURL
+	}
+	return mediaURL
+}
+
+// sunoMediaFields Suno 结果中需要持久化的链接字段
+var sunoMediaFields = []string{"audio_url", "video_url", "image_url", "image_large_url"}
+
+// persistSunoTaskMedia 持久化 Suno 任务结果中的音频、视频与封面
+func persistSunoTaskMedia(task *model.Task) {
+	var clips []map[string]any
+	if err := common.Unmarshal(task.Data, &clips); err != nil || len(clips) == 0 {
+		return
+	}
+	changed := false
+	for _, clip := range clips {
+		for _, field := range sunoMediaFields {
+			rawURL, _ := clip[field].(string)
+			if mediaURL := persistTaskMediaURL(task, operation_setting.MediaSourceSuno, rawURL); mediaURL != rawURL {
+				clip[field] = mediaURL
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return
+	}
+	data, err := common.Marshal(clips)
+	if err != nil {
+		return
+	}
+	task.Data = data
+	if err := task.Update(); err != nil {
+		common.SysLog(fmt.Sprintf("failed to update media urls for task %s: %s", task.TaskID, err.Error()))
+	}
+}
+
+// persistMidjourneyMedia 持久化 Midjourney 任务的图片与视频
+func persistMidjourneyMedia(task *model.Task) {
+	persist := func(rawURL string) string {
+		if rawURL == "" || service.IsMediaURL(rawURL) {
+			return rawURL
+		}
+		mediaURL, err := service.PersistMediaFromURL(context.Background(), task.UserId, operation_setting.MediaSourceMidjourney, task.TaskID, rawURL)
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to persist media for midjourney task %s: %s", task.TaskID, err.Error()))
+			return rawURL
+		}
+		return mediaURL
+	}
+	data := task.GetMidjourneyData()
+	imageURL := persist(data.ImageUrl)
+	videoURL := persist(data.VideoUrl)
+	if imageURL == data.ImageUrl && videoURL == data.VideoUrl {
+		return
+	}
+	data.ImageUrl = imageURL
+	data.VideoUrl = videoURL
+	task.SetMidjourneyData(data)
+	if err := task.Update(); err != nil {
+		common.SysLog(fmt.Sprintf("failed to update media urls for midjourney task %s: %s", task.TaskID, err.Error()))
+	}
+}
+
+// signTaskMediaURLs 为任务列表中的媒体地址附加签名
+func signTaskMediaURLs(tasks []*model.Task) {
+	for _, task := range tasks {
+		task.FailReason = service.SignMediaURL(task.FailReason)
+		task.Data = service.SignMediaURLsInBody(task.Data)
+	}
+}
@@ -133,7 +133,7 @@ func ListModels(c *gin.Context, modelType int) {
 			tokenModelLimit = map[string]bool{}
 		}
 		for allowModel, _ := range tokenModelLimit {
-			if !acceptUnsetRatioModel {
+			if !acceptUnsetRatioModel && model.CacheGetVirtualModel(allowModel) == nil {
 				_, _, exist := ratio_setting.GetModelRatioOrPrice(allowModel)
 				if !exist {
 					continue
@@ -180,8 +180,20 @@ func ListModels(c *gin.Context, modelType int) {
 		} else {
 			models = model.GetGroupEnabledModels(group)
 		}
+		// 虚拟模型：只要有一个目标模型在分组中可用即展示
+		for _, vm := range model.CacheGetAllVirtualModels() {
+			if common.StringsContains(models, vm.Name) {
+				continue
+			}
+			for _, target := range vm.GetTargetsForGroup(group) {
+				if common.StringsContains(models, target.Model) {
+					models = append(models, vm.Name)
+					break
+				}
+			}
+		}
 		for _, modelName := range models {
-			if !acceptUnsetRatioModel {
+			if !acceptUnsetRatioModel && model.CacheGetVirtualModel(modelName) == nil {
 				_, _, exist := ratio_setting.GetModelRatioOrPrice(modelName)
 				if !exist {
 					continue
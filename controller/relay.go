@@ -7,7 +7,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
@@ -21,6 +23,7 @@ import (
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/model_setting"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
@@ -82,12 +85,20 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 			return
 		}
 		defer ws.Close()
+		// 语音链路模型由网关依次调用 STT、对话、TTS 模型完成
+		if pipelineName := common.GetContextKeyString(c, constant.ContextKeySpeechPipeline); pipelineName != "" {
+			relaySpeechPipelineRealtime(c, ws, pipelineName)
+			return
+		}
 	}
 
 	defer func() {
 		if newAPIError != nil {
 			logger.LogError(c, fmt.Sprintf("relay error: %s", newAPIError.Error()))
 			newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), requestId))
+			if newAPIError.RetryAfter > 0 {
+				c.Header("Retry-After", strconv.FormatInt(newAPIError.RetryAfter, 10))
+			}
 			switch relayFormat {
 			case types.RelayFormatOpenAIRealtime:
 				helper.WssError(c, ws, newAPIError.ToOpenAIError())
@@ -172,61 +183,157 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 	}()
 
-	retryParam := &service.RetryParam{
-		Ctx:        c,
-		TokenGroup: relayInfo.TokenGroup,
-		ModelName:  relayInfo.OriginModelName,
-		Retry:      common.GetPointer(0),
+	if async, callbackUrl := parseAsyncImageRequest(c, relayInfo); async {
+		newAPIError = submitImageTask(c, relayInfo, meta, callbackUrl)
+		return
 	}
 
-	for ; retryParam.GetRetry() <= common.RetryTimes; retryParam.IncreaseRetry() {
-		channel, channelErr := getChannel(c, relayInfo, retryParam)
-		if channelErr != nil {
-			logger.LogError(c, channelErr.Error())
-			newAPIError = channelErr
-			break
-		}
-
-		addUsedChannel(c, channel.Id)
-		requestBody, bodyErr := common.GetRequestBody(c)
-		if bodyErr != nil {
-			// Ensure consistent 413 for oversized bodies even when error occurs later (e.g., retry path)
-			if common.IsRequestBodyTooLargeError(bodyErr) || errors.Is(bodyErr, common.ErrRequestBodyTooLarge) {
-				newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusRequestEntityTooLarge, types.ErrOptionWithSkipRetry())
-			} else {
-				newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
-			}
-			break
-		}
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-
+	newAPIError = relayWithRetry(c, relayInfo, meta, func() *types.NewAPIError {
 		switch relayFormat {
 		case types.RelayFormatOpenAIRealtime:
-			newAPIError = relay.WssHelper(c, relayInfo)
+			return relay.WssHelper(c, relayInfo)
 		case types.RelayFormatClaude:
-			newAPIError = relay.ClaudeHelper(c, relayInfo)
+			return relay.ClaudeHelper(c, relayInfo)
 		case types.RelayFormatGemini:
-			newAPIError = geminiRelayHandler(c, relayInfo)
+			return geminiRelayHandler(c, relayInfo)
 		default:
-			newAPIError = relayHandler(c, relayInfo)
+			return relayHandler(c, relayInfo)
 		}
+	})
+}
 
-		if newAPIError == nil {
-			return
+// relayWithRetry 选择渠道并执行 attempt，失败时按重试次数更换渠道，当前模型的渠道耗尽后切换到回退链中的下一个模型。
+// 同步请求与异步图片任务共用，调用前需已完成预扣费；realtime 会话不做模型回退，也不计入渠道统计
+func relayWithRetry(c *gin.Context, relayInfo *relaycommon.RelayInfo, meta *types.TokenCountMeta, attempt func() *types.NewAPIError) (newAPIError *types.NewAPIError) {
+	realtime := relayInfo.RelayFormat == types.RelayFormatOpenAIRealtime
+	triedModels := []string{relayInfo.OriginModelName}
+	if relayInfo.IsModelFallback() {
+		triedModels = append([]string{relayInfo.RequestedModelName}, triedModels...)
+		setModelFallbackHeader(c, relayInfo)
+	}
+	defer func() {
+		useChannel := c.GetStringSlice("use_channel")
+		if len(useChannel) > 1 {
+			retryLogStr := fmt.Sprintf("重试：%s", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(useChannel)), "->"), "[]"))
+			logger.LogInfo(c, retryLogStr)
+		}
+		if len(triedModels) > 1 {
+			logger.LogInfo(c, fmt.Sprintf("模型回退：%s", strings.Join(triedModels, "->")))
 		}
+	}()
 
-		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+	for {
+		retryParam := &service.RetryParam{
+			Ctx:        c,
+			TokenGroup: relayInfo.TokenGroup,
+			ModelName:  relayInfo.OriginModelName,
+			Retry:      common.GetPointer(0),
+		}
 
-		if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
-			break
+		for ; retryParam.GetRetry() <= common.RetryTimes; retryParam.IncreaseRetry() {
+			channel, channelErr := getChannel(c, relayInfo, retryParam)
+			if channelErr != nil {
+				logger.LogError(c, channelErr.Error())
+				newAPIError = channelErr
+				// 选中的多 Key 渠道的 key 全部在冷却中，该渠道已进入冷却，换一个渠道重试
+				if channelErr.GetErrorCode() == types.ErrorCodeChannelNoAvailableKey && retryParam.GetRetry() < common.RetryTimes {
+					continue
+				}
+				break
+			}
+
+			addUsedChannel(c, channel.Id)
+			requestBody, bodyErr := common.GetRequestBody(c)
+			if bodyErr != nil {
+				// Ensure consistent 413 for oversized bodies even when error occurs later (e.g., retry path)
+				if common.IsRequestBodyTooLargeError(bodyErr) || errors.Is(bodyErr, common.ErrRequestBodyTooLarge) {
+					newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusRequestEntityTooLarge, types.ErrOptionWithSkipRetry())
+				} else {
+					newAPIError = types.NewErrorWithStatusCode(bodyErr, types.ErrorCodeReadRequestBodyFailed, http.StatusBadRequest, types.ErrOptionWithSkipRetry())
+				}
+				break
+			}
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+
+			attemptStart := time.Now()
+			newAPIError = func() *types.NewAPIError {
+				// 记录渠道进行中的请求数，只有 least_in_flight 选择策略需要
+				if model.IsInFlightTrackedStrategy(common.GetContextKeyString(c, constant.ContextKeyChannelSelectStrategy)) {
+					defer model.AcquireChannelInFlight(channel.Id)()
+				}
+				return attempt()
+			}()
+			// realtime 会话时长不代表渠道响应时延，不计入统计
+			if !realtime {
+				service.RecordChannelRelayResult(channel.Id, relayInfo.OriginModelName, newAPIError, time.Since(attemptStart))
+			}
+
+			if newAPIError == nil {
+				return nil
+			}
+
+			processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+
+			if model_setting.IsModelFallbackTrigger(newAPIError.StatusCode, string(newAPIError.GetErrorCode())) {
+				break
+			}
+			if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
+				break
+			}
+		}
+
+		// 当前模型的渠道已耗尽，尝试切换到回退链中的下一个模型
+		if realtime {
+			return newAPIError
+		}
+		exhausted := shouldRetry(c, newAPIError, 1)
+		if !service.ShouldFallbackModel(c, newAPIError, exhausted) {
+			return newAPIError
+		}
+		nextModel := service.GetNextFallbackModel(c, relayInfo.TokenGroup, relayInfo.RequestedModelName, triedModels)
+		if nextModel == "" {
+			return newAPIError
+		}
+		triedModels = append(triedModels, nextModel)
+		if switchErr := switchFallbackModel(c, relayInfo, nextModel, meta); switchErr != nil {
+			logger.LogError(c, fmt.Sprintf("switch to fallback model %s failed: %s", nextModel, switchErr.Error()))
+			return newAPIError
 		}
 	}
+}
 
-	useChannel := c.GetStringSlice("use_channel")
-	if len(useChannel) > 1 {
-		retryLogStr := fmt.Sprintf("重试：%s", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(useChannel)), "->"), "[]"))
-		logger.LogInfo(c, retryLogStr)
+// switchFallbackModel 切换到回退模型：返还原模型的预扣费，按新模型重新计算价格并预扣费
+func switchFallbackModel(c *gin.Context, relayInfo *relaycommon.RelayInfo, nextModel string, meta *types.TokenCountMeta) *types.NewAPIError {
+	logger.LogInfo(c, fmt.Sprintf("模型 %s 渠道已耗尽，回退到模型 %s", relayInfo.OriginModelName, nextModel))
+
+	if relayInfo.FinalPreConsumedQuota != 0 {
+		service.ReturnPreConsumedQuota(c, relayInfo)
+		relayInfo.FinalPreConsumedQuota = 0
 	}
+
+	relayInfo.OriginModelName = nextModel
+	common.SetContextKey(c, constant.ContextKeyRequestedModel, relayInfo.RequestedModelName)
+	common.SetContextKey(c, constant.ContextKeyAutoGroupIndex, 0)
+	if meta == nil {
+		meta = &types.TokenCountMeta{}
+	}
+
+	priceData, err := helper.ModelPriceHelper(c, relayInfo, relayInfo.GetEstimatePromptTokens(), meta)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeModelPriceError, types.ErrOptionWithSkipRetry())
+	}
+	if !priceData.FreeModel {
+		if newAPIError := service.PreConsumeQuota(c, priceData.QuotaToPreConsume, relayInfo); newAPIError != nil {
+			return newAPIError
+		}
+	}
+	setModelFallbackHeader(c, relayInfo)
+	return nil
+}
+
+func setModelFallbackHeader(c *gin.Context, relayInfo *relaycommon.RelayInfo) {
+	c.Header("X-New-Api-Requested-Model", relayInfo.RequestedModelName)
+	c.Header("X-New-Api-Fallback-Model", relayInfo.OriginModelName)
 }
 
 var upgrader = websocket.Upgrader{
@@ -287,6 +394,11 @@ func getChannel(c *gin.Context, info *relaycommon.RelayInfo, retryParam *service
 
 	info.PriceData.GroupRatioInfo = helper.HandleGroupRatio(c, info)
 
+	var coolingErr *model.ChannelCoolingDownError
+	if errors.As(err, &coolingErr) {
+		return nil, types.NewErrorWithStatusCode(fmt.Errorf("分组 %s 下模型 %s 的可用渠道均在冷却中，请 %d 秒后重试", selectGroup, info.OriginModelName, coolingErr.RetryAfter), types.ErrorCodeGetChannelFailed, http.StatusTooManyRequests,
+			types.ErrOptionWithSkipRetry(), types.ErrOptionWithRetryAfter(coolingErr.RetryAfter))
+	}
 	if err != nil {
 		return nil, types.NewError(fmt.Errorf("获取分组 %s 下模型 %s 的可用渠道失败（retry）: %s", selectGroup, info.OriginModelName, err.Error()), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 	}
@@ -331,7 +443,13 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 	logger.LogError(c, fmt.Sprintf("channel error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
-	if service.ShouldDisableChannel(channelError.ChannelType, err) && channelError.AutoBan {
+	if service.ShouldCoolDownChannel(channelError.IsMultiKey, err) {
+		keyIndex := -1
+		if channelError.IsMultiKey {
+			keyIndex = common.GetContextKeyInt(c, constant.ContextKeyChannelMultiKeyIndex)
+		}
+		service.CoolDownChannel(channelError.ChannelId, keyIndex, err)
+	} else if service.ShouldDisableChannel(channelError.ChannelType, err) && channelError.AutoBan {
 		gopool.Go(func() {
 			service.DisableChannel(channelError, err.ErrorWithStatusCode())
 		})
@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExplainRouting 路由诊断：返回按令牌或用户请求某个模型时的鉴权检查、分组链、各优先级的候选渠道、模型映射及计费倍率，
+// 不会向上游发送请求
+func ExplainRouting(c *gin.Context) {
+	var req service.RoutingExplainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	result, err := service.ExplainRouting(&req)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, result)
+}
@@ -0,0 +1,690 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/middleware"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// 语音链路：把 STT、对话、TTS 三个模型组合成一个虚拟语音模型，通过 /v1/audio/chat 与 /v1/realtime 提供。
+// 每个环节都以内部子请求的方式走标准中继流程，按环节模型单独选择渠道、预扣费并记录消费日志，
+// 日志中通过 speech_pipeline / speech_pipeline_leg 区分。
+
+const (
+	speechLegStt  = "stt"
+	speechLegChat = "chat"
+	speechLegTts  = "tts"
+)
+
+// runSpeechPipelineLeg 在独立的上下文副本中执行一个环节，渠道选择、预扣费、重试与计费与普通请求一致
+func runSpeechPipelineLeg(c *gin.Context, pipelineName string, leg string, modelName string, path string, relayFormat types.RelayFormat,
+	contentType string, body []byte, writer *subRequestWriter) (newAPIError *types.NewAPIError) {
+	lc := c.Copy()
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	req.Header.Set("Content-Type", contentType)
+	lc.Request = req
+	lc.Writer = writer
+	lc.Set(common.KeyRequestBody, body)
+	lc.Set("relay_mode", relayconstant.Path2RelayMode(path))
+	common.SetContextKey(lc, constant.ContextKeySpeechPipeline, pipelineName)
+	common.SetContextKey(lc, constant.ContextKeySpeechLeg, leg)
+	common.SetContextKey(lc, constant.ContextKeyRequestedModel, "")
+	common.SetContextKey(lc, constant.ContextKeyVirtualModel, "")
+
+	retryParam := &service.RetryParam{
+		Ctx:        lc,
+		TokenGroup: common.GetContextKeyString(lc, constant.ContextKeyUsingGroup),
+		ModelName:  modelName,
+		Retry:      common.GetPointer(0),
+	}
+	channel, _, err := service.CacheGetRandomSatisfiedChannel(retryParam)
+	if err != nil || channel == nil {
+		if err == nil {
+			err = errors.New("no available channel")
+		}
+		return types.NewError(fmt.Errorf("语音链路 %s 的 %s 环节获取模型 %s 的可用渠道失败: %w", pipelineName, leg, modelName, err), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
+	}
+	if newAPIError = middleware.SetupContextForSelectedChannel(lc, channel, modelName); newAPIError != nil {
+		return newAPIError
+	}
+
+	request, err := helper.GetAndValidateRequest(lc, relayFormat)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	relayInfo, err := relaycommon.GenRelayInfo(lc, relayFormat, request, nil)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeGenRelayInfoFailed)
+	}
+	meta := request.GetTokenCountMeta()
+	tokens, err := service.EstimateRequestToken(lc, meta, relayInfo)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeCountTokenFailed)
+	}
+	relayInfo.SetEstimatePromptTokens(tokens)
+	priceData, err := helper.ModelPriceHelper(lc, relayInfo, tokens, meta)
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeModelPriceError)
+	}
+	if !priceData.FreeModel {
+		if newAPIError = service.PreConsumeQuota(lc, priceData.QuotaToPreConsume, relayInfo); newAPIError != nil {
+			return newAPIError
+		}
+	}
+	defer func() {
+		if newAPIError != nil && relayInfo.FinalPreConsumedQuota != 0 {
+			service.ReturnPreConsumedQuota(lc, relayInfo)
+		}
+	}()
+
+	for ; retryParam.GetRetry() <= common.RetryTimes; retryParam.IncreaseRetry() {
+		if retryParam.GetRetry() > 0 {
+			channel, newAPIError = getChannel(lc, relayInfo, retryParam)
+			if newAPIError != nil {
+				return newAPIError
+			}
+		}
+		lc.Request.Body = io.NopCloser(bytes.NewReader(body))
+		newAPIError = relayHandler(lc, relayInfo)
+		if newAPIError == nil {
+			return nil
+		}
+		processChannelError(lc, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(lc, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+		// 已经向下游输出了部分内容时不能重试
+		if writer.Written() || !shouldRetry(lc, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
+			break
+		}
+	}
+	return newAPIError
+}
+
+// speechPipelineTranscribe STT 环节，返回识别文本
+func speechPipelineTranscribe(c *gin.Context, name string, pipeline operation_setting.SpeechPipeline, audio []byte, filename string) (string, *types.NewAPIError) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("model", pipeline.SttModel)
+	_ = mw.WriteField("response_format", "json")
+	if pipeline.Language != "" {
+		_ = mw.WriteField("language", pipeline.Language)
+	}
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return "", types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	if _, err := part.Write(audio); err != nil {
+		return "", types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	_ = mw.Close()
+
+	writer := newSubRequestWriter(nil)
+	if newAPIError := runSpeechPipelineLeg(c, name, speechLegStt, pipeline.SttModel, "/v1/audio/transcriptions", types.RelayFormatOpenAIAudio,
+		mw.FormDataContentType(), body.Bytes(), writer); newAPIError != nil {
+		return "", newAPIError
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := common.Unmarshal(writer.body.Bytes(), &result); err != nil {
+		return strings.TrimSpace(writer.body.String()), nil
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// speechPipelineChat 对话环节，以流式方式请求并把增量文本回调给 onDelta
+func speechPipelineChat(c *gin.Context, name string, pipeline operation_setting.SpeechPipeline, messages []map[string]string, onDelta func(string)) *types.NewAPIError {
+	body, err := common.Marshal(map[string]any{
+		"model":          pipeline.ChatModel,
+		"messages":       messages,
+		"stream":         true,
+		"stream_options": map[string]any{"include_usage": true},
+	})
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	parser := &speechChatStreamParser{onDelta: onDelta}
+	return runSpeechPipelineLeg(c, name, speechLegChat, pipeline.ChatModel, "/v1/chat/completions", types.RelayFormatOpenAI,
+		"application/json", body, newSubRequestWriter(parser.Write))
+}
+
+// speechPipelineSynthesize TTS 环节，合成的音频按上游返回的顺序回调给 onAudio
+func speechPipelineSynthesize(c *gin.Context, name string, pipeline operation_setting.SpeechPipeline, text string, voice string, format string, onAudio func([]byte) error) *types.NewAPIError {
+	body, err := common.Marshal(dto.AudioRequest{
+		Model:          pipeline.TtsModel,
+		Input:          text,
+		Voice:          voice,
+		ResponseFormat: format,
+	})
+	if err != nil {
+		return types.NewError(err, types.ErrorCodeInvalidRequest, types.ErrOptionWithSkipRetry())
+	}
+	return runSpeechPipelineLeg(c, name, speechLegTts, pipeline.TtsModel, "/v1/audio/speech", types.RelayFormatOpenAIAudio,
+		"application/json", body, newSubRequestWriter(onAudio))
+}
+
+// runSpeechPipelineReply 执行对话与 TTS 环节。streamable 为 true 时对话输出按句切分后依次送入 TTS，边生成边合成；
+// 否则（如 wav/flac 等不能直接拼接的格式）在对话结束后整段合成。onAudio 为空时只生成文本。
+func runSpeechPipelineReply(c *gin.Context, name string, pipeline operation_setting.SpeechPipeline, messages []map[string]string,
+	voice string, format string, streamable bool, onText func(string) error, onAudio func([]byte) error) (string, *types.NewAPIError) {
+	segments := make(chan string, 16)
+	ttsDone := make(chan *types.NewAPIError, 1)
+	gopool.Go(func() {
+		var ttsErr *types.NewAPIError
+		for segment := range segments {
+			if ttsErr == nil && onAudio != nil {
+				ttsErr = speechPipelineSynthesize(c, name, pipeline, segment, voice, format, onAudio)
+			}
+		}
+		ttsDone <- ttsErr
+	})
+
+	segmenter := &speechSegmenter{minChars: operation_setting.GetSpeechPipelineSetting().MinSegmentChars}
+	var reply strings.Builder
+	var textErr error
+	chatErr := speechPipelineChat(c, name, pipeline, messages, func(delta string) {
+		reply.WriteString(delta)
+		if onText != nil && textErr == nil {
+			textErr = onText(delta)
+		}
+		if streamable {
+			for _, segment := range segmenter.Push(delta) {
+				segments <- segment
+			}
+		}
+	})
+	if chatErr == nil {
+		if streamable {
+			if rest := segmenter.Flush(); rest != "" {
+				segments <- rest
+			}
+		} else if strings.TrimSpace(reply.String()) != "" {
+			segments <- reply.String()
+		}
+	}
+	close(segments)
+	ttsErr := <-ttsDone
+
+	if chatErr != nil {
+		return reply.String(), chatErr
+	}
+	if textErr != nil {
+		return reply.String(), types.NewError(textErr, types.ErrorCodeBadResponse, types.ErrOptionWithSkipRetry())
+	}
+	return reply.String(), ttsErr
+}
+
+// speechChatStreamParser 从对话环节的 SSE 输出中解析增量文本
+type speechChatStreamParser struct {
+	buf     []byte
+	onDelta func(string)
+}
+
+func (p *speechChatStreamParser) Write(data []byte) error {
+	p.buf = append(p.buf, data...)
+	for {
+		idx := bytes.IndexByte(p.buf, '\n')
+		if idx < 0 {
+			return nil
+		}
+		line := strings.TrimSpace(string(p.buf[:idx]))
+		p.buf = p.buf[idx+1:]
+		payload, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		payload = strings.TrimSpace(payload)
+		if payload == "" || payload == "[DONE]" {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := common.UnmarshalJsonStr(payload, &chunk); err != nil {
+			continue
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				p.onDelta(choice.Delta.Content)
+			}
+		}
+	}
+}
+
+// speechSegmenter 按句切分流式文本，每段至少 minChars 个字符，避免过于频繁地调用 TTS
+type speechSegmenter struct {
+	minChars int
+	buf      strings.Builder
+}
+
+func (s *speechSegmenter) Push(delta string) []string {
+	var segments []string
+	for _, r := range delta {
+		s.buf.WriteRune(r)
+		if strings.ContainsRune("。！？；.!?;\n", r) && utf8.RuneCountInString(strings.TrimSpace(s.buf.String())) >= s.minChars {
+			if segment := strings.TrimSpace(s.buf.String()); segment != "" {
+				segments = append(segments, segment)
+			}
+			s.buf.Reset()
+		}
+	}
+	return segments
+}
+
+func (s *speechSegmenter) Flush() string {
+	segment := strings.TrimSpace(s.buf.String())
+	s.buf.Reset()
+	return segment
+}
+
+func buildSpeechPipelineMessages(instructions string, history []map[string]string) []map[string]string {
+	messages := make([]map[string]string, 0, len(history)+1)
+	if instructions != "" {
+		messages = append(messages, map[string]string{"role": "system", "content": instructions})
+	}
+	return append(messages, history...)
+}
+
+// speechPipelineStreamable 可以直接拼接的音频格式才能分段合成
+func speechPipelineStreamable(format string) bool {
+	switch format {
+	case "mp3", "opus", "aac", "pcm":
+		return true
+	}
+	return false
+}
+
+func speechPipelineContentType(format string) string {
+	switch format {
+	case "opus":
+		return "audio/opus"
+	case "aac":
+		return "audio/aac"
+	case "flac":
+		return "audio/flac"
+	case "wav":
+		return "audio/wav"
+	case "pcm":
+		return "audio/pcm"
+	default:
+		return "audio/mpeg"
+	}
+}
+
+type speechPipelineRequest struct {
+	Model          string `json:"model"`
+	Audio          string `json:"audio"`        // base64 编码的输入音频
+	AudioFormat    string `json:"audio_format"` // 输入音频格式，默认 wav
+	Voice          string `json:"voice"`
+	ResponseFormat string `json:"response_format"`
+	Instructions   string `json:"instructions"`
+}
+
+// parseSpeechPipelineRequest 支持 multipart（file 字段）与 JSON（base64 audio 字段）两种请求
+func parseSpeechPipelineRequest(c *gin.Context) (*speechPipelineRequest, []byte, string, error) {
+	req := &speechPipelineRequest{}
+	if strings.Contains(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
+		form, err := common.ParseMultipartFormReusable(c)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		value := func(key string) string {
+			if values := form.Value[key]; len(values) > 0 {
+				return values[0]
+			}
+			return ""
+		}
+		req.Model = value("model")
+		req.Voice = value("voice")
+		req.ResponseFormat = value("response_format")
+		req.Instructions = value("instructions")
+		files := form.File["file"]
+		if len(files) == 0 {
+			return nil, nil, "", errors.New("file is required")
+		}
+		file, err := files[0].Open()
+		if err != nil {
+			return nil, nil, "", err
+		}
+		defer file.Close()
+		audio, err := io.ReadAll(file)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return req, audio, files[0].Filename, nil
+	}
+	if err := common.UnmarshalBodyReusable(c, req); err != nil {
+		return nil, nil, "", err
+	}
+	if req.Audio == "" {
+		return nil, nil, "", errors.New("audio is required")
+	}
+	audio, err := base64.StdEncoding.DecodeString(req.Audio)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("audio must be base64 encoded: %w", err)
+	}
+	return req, audio, "audio." + common.GetStringIfEmpty(req.AudioFormat, "wav"), nil
+}
+
+func abortSpeechPipeline(c *gin.Context, newAPIError *types.NewAPIError) {
+	logger.LogError(c, fmt.Sprintf("speech pipeline error: %s", newAPIError.Error()))
+	newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), c.GetString(common.RequestIdKey)))
+	c.JSON(newAPIError.StatusCode, gin.H{
+		"error": newAPIError.ToOpenAIError(),
+	})
+}
+
+// RelaySpeechPipeline 语音对话：上传一段语音，返回合成后的回复音频，识别文本通过 X-Speech-Transcript 头返回
+func RelaySpeechPipeline(c *gin.Context) {
+	req, audio, filename, err := parseSpeechPipelineRequest(c)
+	if err != nil {
+		abortSpeechPipeline(c, types.NewErrorWithStatusCode(err, types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry()))
+		return
+	}
+	pipeline, ok := operation_setting.GetSpeechPipeline(req.Model)
+	if !ok {
+		abortSpeechPipeline(c, types.NewErrorWithStatusCode(fmt.Errorf("model %s is not a speech pipeline", req.Model), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry()))
+		return
+	}
+
+	transcript, newAPIError := speechPipelineTranscribe(c, req.Model, pipeline, audio, filename)
+	if newAPIError != nil {
+		abortSpeechPipeline(c, newAPIError)
+		return
+	}
+	if transcript == "" {
+		abortSpeechPipeline(c, types.NewErrorWithStatusCode(errors.New("no speech recognized in the input audio"), types.ErrorCodeInvalidRequest, http.StatusBadRequest, types.ErrOptionWithSkipRetry()))
+		return
+	}
+
+	format := common.GetStringIfEmpty(req.ResponseFormat, "mp3")
+	messages := buildSpeechPipelineMessages(common.GetStringIfEmpty(req.Instructions, pipeline.Instructions),
+		[]map[string]string{{"role": "user", "content": transcript}})
+	c.Header("X-Speech-Transcript", url.QueryEscape(transcript))
+	started := false
+	onAudio := func(data []byte) error {
+		if !started {
+			started = true
+			c.Header("Content-Type", speechPipelineContentType(format))
+			c.Status(http.StatusOK)
+		}
+		if _, err := c.Writer.Write(data); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+	_, newAPIError = runSpeechPipelineReply(c, req.Model, pipeline, messages, common.GetStringIfEmpty(req.Voice, pipeline.Voice),
+		format, speechPipelineStreamable(format), nil, onAudio)
+	if newAPIError != nil {
+		if !started {
+			abortSpeechPipeline(c, newAPIError)
+			return
+		}
+		logger.LogError(c, fmt.Sprintf("speech pipeline error after streaming started: %s", newAPIError.Error()))
+	}
+}
+
+// speechPipelineRealtime 以 OpenAI Realtime 协议提供语音链路。网关不做语音活动检测：
+// input_audio_buffer.commit 结束一轮用户语音并执行 STT，turn_detection 未关闭时随后自动生成回复，否则等待 response.create。
+type speechPipelineRealtime struct {
+	c        *gin.Context
+	ws       *websocket.Conn
+	wsMu     sync.Mutex
+	name     string
+	pipeline operation_setting.SpeechPipeline
+	session  dto.RealtimeSession
+	autoTurn bool
+	audio    bytes.Buffer
+	history  []map[string]string
+}
+
+func relaySpeechPipelineRealtime(c *gin.Context, ws *websocket.Conn, name string) {
+	pipeline, ok := operation_setting.GetSpeechPipeline(name)
+	if !ok {
+		helper.WssError(c, ws, types.NewError(fmt.Errorf("model %s is not a speech pipeline", name), types.ErrorCodeInvalidRequest).ToOpenAIError())
+		return
+	}
+	s := &speechPipelineRealtime{
+		c:        c,
+		ws:       ws,
+		name:     name,
+		pipeline: pipeline,
+		session: dto.RealtimeSession{
+			Modalities:        []string{"text", "audio"},
+			Instructions:      pipeline.Instructions,
+			Voice:             pipeline.Voice,
+			InputAudioFormat:  "pcm16",
+			OutputAudioFormat: "pcm16",
+			TurnDetection:     map[string]any{"type": "server_vad"},
+		},
+		autoTurn: true,
+	}
+	if err := s.send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionCreated, Session: &s.session}); err != nil {
+		return
+	}
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.LogError(c, "speech pipeline realtime read error: "+err.Error())
+			}
+			return
+		}
+		if err := s.handle(message); err != nil {
+			logger.LogError(c, "speech pipeline realtime error: "+err.Error())
+			return
+		}
+	}
+}
+
+func (s *speechPipelineRealtime) send(event *dto.RealtimeEvent) error {
+	if event.EventId == "" {
+		event.EventId = "evt_" + common.GetRandomString(16)
+	}
+	data, err := common.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	return s.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+func (s *speechPipelineRealtime) sendError(newAPIError *types.NewAPIError) error {
+	openaiError := newAPIError.ToOpenAIError()
+	return s.send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeError, Error: &openaiError})
+}
+
+func (s *speechPipelineRealtime) handle(message []byte) error {
+	event := &dto.RealtimeEvent{}
+	if err := common.Unmarshal(message, event); err != nil {
+		return s.sendError(types.NewError(err, types.ErrorCodeInvalidRequest))
+	}
+	switch event.Type {
+	case dto.RealtimeEventTypeSessionUpdate:
+		if event.Session != nil {
+			s.updateSession(message, event.Session)
+		}
+		return s.send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdated, Session: &s.session})
+	case dto.RealtimeEventInputAudioBufferAppend:
+		data, err := base64.StdEncoding.DecodeString(event.Audio)
+		if err != nil {
+			return s.sendError(types.NewError(errors.New("input audio must be base64 encoded"), types.ErrorCodeInvalidRequest))
+		}
+		s.audio.Write(service.DecodeRealtimeAudio(data, s.session.InputAudioFormat))
+		return nil
+	case dto.RealtimeEventInputAudioBufferClear:
+		s.audio.Reset()
+		return s.send(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferCleared})
+	case dto.RealtimeEventInputAudioBufferCommit:
+		return s.commitAudio()
+	case dto.RealtimeEventTypeConversationCreate:
+		if event.Item == nil || event.Item.Type != "message" {
+			return nil
+		}
+		var text strings.Builder
+		for _, content := range event.Item.Content {
+			if content.Type == "input_text" || content.Type == "text" {
+				text.WriteString(content.Text)
+			}
+		}
+		role := "user"
+		if event.Item.Role == "assistant" {
+			role = "assistant"
+		}
+		s.appendHistory(role, text.String())
+		if event.Item.Id == "" {
+			event.Item.Id = "item_" + common.GetRandomString(16)
+		}
+		return s.send(&dto.RealtimeEvent{Type: dto.RealtimeEventConversationItemCreated, Item: event.Item})
+	case dto.RealtimeEventTypeResponseCreate:
+		return s.respond()
+	default:
+		return nil
+	}
+}
+
+func (s *speechPipelineRealtime) updateSession(message []byte, update *dto.RealtimeSession) {
+	if len(update.Modalities) > 0 {
+		s.session.Modalities = update.Modalities
+	}
+	s.session.Instructions = common.GetStringIfEmpty(update.Instructions, s.session.Instructions)
+	s.session.Voice = common.GetStringIfEmpty(update.Voice, s.session.Voice)
+	switch update.InputAudioFormat {
+	case "pcm16", "g711_ulaw", "g711_alaw":
+		if s.audio.Len() == 0 {
+			s.session.InputAudioFormat = update.InputAudioFormat
+		}
+	}
+	switch update.OutputAudioFormat {
+	case "pcm16", "g711_ulaw", "g711_alaw":
+		s.session.OutputAudioFormat = update.OutputAudioFormat
+	}
+	// turn_detection 显式为 null 表示由客户端通过 response.create 触发回复
+	var raw struct {
+		Session map[string]json.RawMessage `json:"session"`
+	}
+	_ = common.Unmarshal(message, &raw)
+	if turnDetection, ok := raw.Session["turn_detection"]; ok {
+		s.session.TurnDetection = update.TurnDetection
+		s.autoTurn = string(turnDetection) != "null"
+	}
+}
+
+func (s *speechPipelineRealtime) appendHistory(role string, content string) {
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	s.history = append(s.history, map[string]string{"role": role, "content": content})
+	if limit := operation_setting.GetSpeechPipelineSetting().MaxHistoryMessages; limit > 0 && len(s.history) > limit {
+		s.history = s.history[len(s.history)-limit:]
+	}
+}
+
+func (s *speechPipelineRealtime) commitAudio() error {
+	if s.audio.Len() == 0 {
+		return s.sendError(types.NewError(errors.New("input audio buffer is empty"), types.ErrorCodeInvalidRequest))
+	}
+	wav := service.PCM16ToWav(s.audio.Bytes(), service.AudioSampleRate(s.session.InputAudioFormat))
+	s.audio.Reset()
+	itemId := "item_" + common.GetRandomString(16)
+	if err := s.send(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferCommitted, ItemId: itemId}); err != nil {
+		return err
+	}
+	transcript, newAPIError := speechPipelineTranscribe(s.c, s.name, s.pipeline, wav, "audio.wav")
+	if newAPIError != nil {
+		return s.sendError(newAPIError)
+	}
+	if err := s.send(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioTranscriptionCompleted, ItemId: itemId, Transcript: transcript}); err != nil {
+		return err
+	}
+	s.appendHistory("user", transcript)
+	if s.autoTurn && transcript != "" {
+		return s.respond()
+	}
+	return nil
+}
+
+func (s *speechPipelineRealtime) respond() error {
+	responseId := "resp_" + common.GetRandomString(16)
+	itemId := "item_" + common.GetRandomString(16)
+	if err := s.send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeResponseCreated, Response: &dto.RealtimeResponse{Id: responseId, Status: "in_progress"}}); err != nil {
+		return err
+	}
+
+	audioOutput := common.StringsContains(s.session.Modalities, "audio")
+	textEvent := dto.RealtimeEventResponseTextDelta
+	var onAudio func([]byte) error
+	if audioOutput {
+		textEvent = dto.RealtimeEventResponseAudioTranscriptionDelta
+		outputFormat := s.session.OutputAudioFormat
+		var pending []byte
+		// TTS 以 24kHz pcm 输出，写入的分片可能不是完整的采样，需要保留余下的奇数字节
+		onAudio = func(data []byte) error {
+			pending = append(pending, data...)
+			n := len(pending) &^ 1
+			if n == 0 {
+				return nil
+			}
+			pcm := service.ResamplePCM16(pending[:n], 24000, service.AudioSampleRate(outputFormat))
+			pending = append([]byte(nil), pending[n:]...)
+			return s.send(&dto.RealtimeEvent{
+				Type:       dto.RealtimeEventResponseAudioDelta,
+				ResponseId: responseId,
+				ItemId:     itemId,
+				Delta:      base64.StdEncoding.EncodeToString(service.EncodeRealtimeAudio(pcm, outputFormat)),
+			})
+		}
+	}
+	onText := func(delta string) error {
+		return s.send(&dto.RealtimeEvent{Type: textEvent, ResponseId: responseId, ItemId: itemId, Delta: delta})
+	}
+
+	messages := buildSpeechPipelineMessages(s.session.Instructions, s.history)
+	reply, newAPIError := runSpeechPipelineReply(s.c, s.name, s.pipeline, messages, s.session.Voice, "pcm", true, onText, onAudio)
+	s.appendHistory("assistant", reply)
+	status := "completed"
+	if newAPIError != nil {
+		status = "failed"
+		if err := s.sendError(newAPIError); err != nil {
+			return err
+		}
+	}
+	if audioOutput {
+		if err := s.send(&dto.RealtimeEvent{Type: dto.RealtimeEventResponseAudioTranscriptDone, ResponseId: responseId, ItemId: itemId, Transcript: reply}); err != nil {
+			return err
+		}
+	}
+	return s.send(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeResponseDone, Response: &dto.RealtimeResponse{Id: responseId, Status: status}})
+}
@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	speechTestPipeline = "voice-test"
+	speechTestStt      = "stt-test"
+	speechTestChat     = "chat-test"
+	speechTestTts      = "tts-test"
+	speechTestReply    = "It is noon."
+	speechTestAudio    = "MP3-AUDIO"
+	speechTestQuota    = 1000000
+)
+
+// 上一轮计费的异步缓存更新可能仍在读取这些开关，只在首次设置
+var speechTestInitOnce sync.Once
+
+// speechTestUpstream 模拟 OpenAI 兼容上游，按路径分别返回 STT、流式对话与 TTS 结果，ttsStatus 非 200 时 TTS 失败
+type speechTestUpstream struct {
+	t         *testing.T
+	ttsStatus int
+
+	mu       sync.Mutex
+	calls    []string
+	chatBody map[string]any
+	ttsBody  map[string]any
+}
+
+func (u *speechTestUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	u.calls = append(u.calls, r.URL.Path)
+	u.mu.Unlock()
+	switch r.URL.Path {
+	case "/v1/audio/transcriptions":
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		audio, _ := io.ReadAll(file)
+		if r.FormValue("model") != speechTestStt || string(audio) != "WAV-INPUT" {
+			http.Error(w, "unexpected transcription request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"text":"what time is it","usage":{"type":"tokens","input_tokens":8,"output_tokens":4,"total_tokens":12}}`))
+	case "/v1/chat/completions":
+		body := map[string]any{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		u.mu.Lock()
+		u.chatBody = body
+		u.mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range []string{"It is ", "noon."} {
+			_, _ = fmt.Fprintf(w, "data: {\"id\":\"c1\",\"object\":\"chat.completion.chunk\",\"model\":\"%s\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", speechTestChat, delta)
+		}
+		_, _ = fmt.Fprintf(w, "data: {\"id\":\"c1\",\"object\":\"chat.completion.chunk\",\"model\":\"%s\",\"choices\":[],\"usage\":{\"prompt_tokens\":10,\"completion_tokens\":5,\"total_tokens\":15}}\n\n", speechTestChat)
+		_, _ = w.Write([]byte("data: [DONE]\n\n"))
+	case "/v1/audio/speech":
+		body := map[string]any{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		u.mu.Lock()
+		u.ttsBody = body
+		u.mu.Unlock()
+		if u.ttsStatus != http.StatusOK {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(u.ttsStatus)
+			_, _ = w.Write([]byte(`{"error":{"message":"tts upstream failed","type":"server_error"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		_, _ = w.Write([]byte(speechTestAudio))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// setupSpeechPipelineTest 初始化数据库、渠道、价格与语音链路配置，返回用户、令牌与请求入口
+func setupSpeechPipelineTest(t *testing.T, upstream *speechTestUpstream) (*model.User, *model.Token, *gin.Engine) {
+	speechTestInitOnce.Do(func() {
+		gin.SetMode(gin.TestMode)
+		common.RedisEnabled = false
+		common.MemoryCacheEnabled = false
+		common.BatchUpdateEnabled = false
+		common.LogConsumeEnabled = true
+		common.IsMasterNode = true
+		constant.StreamingTimeout = 30
+		service.InitHttpClient()
+	})
+	common.SQLitePath = filepath.Join(t.TempDir(), "speech-pipeline.db")
+	require.NoError(t, model.InitDB())
+	model.LOG_DB = model.DB
+
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	// STT 与 TTS 按次计费，对话按倍率计费
+	require.NoError(t, ratio_setting.UpdateModelPriceByJSONString(fmt.Sprintf(`{"%s":0.002,"%s":0.004}`, speechTestStt, speechTestTts)))
+	require.NoError(t, ratio_setting.UpdateModelRatioByJSONString(fmt.Sprintf(`{"%s":1}`, speechTestChat)))
+	require.NoError(t, ratio_setting.UpdateCompletionRatioByJSONString(fmt.Sprintf(`{"%s":2}`, speechTestChat)))
+
+	setting := operation_setting.GetSpeechPipelineSetting()
+	origin := *setting
+	t.Cleanup(func() { *setting = origin })
+	setting.Pipelines = map[string]operation_setting.SpeechPipeline{
+		speechTestPipeline: {SttModel: speechTestStt, ChatModel: speechTestChat, TtsModel: speechTestTts, Voice: "alloy", Instructions: "answer briefly"},
+	}
+
+	user := &model.User{Username: "speech", Password: "password123", Group: "default", Quota: speechTestQuota, Status: common.UserStatusEnabled}
+	require.NoError(t, model.DB.Create(user).Error)
+	token := &model.Token{UserId: user.Id, Key: common.GetRandomString(48), Name: "speech", Status: common.TokenStatusEnabled,
+		RemainQuota: speechTestQuota, ExpiredTime: -1}
+	require.NoError(t, model.DB.Create(token).Error)
+	channel := &model.Channel{Type: constant.ChannelTypeOpenAI, Name: "speech-upstream", Key: "sk-upstream", Status: common.ChannelStatusEnabled,
+		BaseURL: common.GetPointer(server.URL), Models: strings.Join([]string{speechTestStt, speechTestChat, speechTestTts}, ","), Group: "default"}
+	require.NoError(t, channel.Insert())
+
+	engine := gin.New()
+	engine.POST("/v1/audio/chat", middleware.TokenAuth(), middleware.Distribute(), RelaySpeechPipeline)
+	return user, token, engine
+}
+
+func doSpeechPipelineRequest(engine *gin.Engine, token *model.Token) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]any{
+		"model":           speechTestPipeline,
+		"audio":           "V0FWLUlOUFVU", // base64("WAV-INPUT")
+		"response_format": "mp3",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/chat", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer sk-"+token.Key)
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// speechLegQuotas 按环节汇总消费日志中的额度
+func speechLegQuotas(t *testing.T, userId int) map[string]int {
+	var logs []*model.Log
+	require.NoError(t, model.LOG_DB.Where("user_id = ? AND type = ?", userId, model.LogTypeConsume).Find(&logs).Error)
+	quotas := make(map[string]int)
+	for _, log := range logs {
+		other := map[string]any{}
+		require.NoError(t, common.UnmarshalJsonStr(log.Other, &other))
+		require.Equal(t, speechTestPipeline, other["speech_pipeline"])
+		leg, _ := other["speech_pipeline_leg"].(string)
+		quotas[leg] += log.Quota
+	}
+	return quotas
+}
+
+func requireSpeechBilling(t *testing.T, user *model.User, token *model.Token, expected int) {
+	quota, err := model.GetUserQuota(user.Id, true)
+	require.NoError(t, err)
+	require.Equal(t, speechTestQuota-expected, quota)
+	updatedToken, err := model.GetTokenById(token.Id)
+	require.NoError(t, err)
+	require.Equal(t, speechTestQuota-expected, updatedToken.RemainQuota)
+}
+
+func TestRelaySpeechPipeline_ChainsLegsAndBillsEachLeg(t *testing.T) {
+	upstream := &speechTestUpstream{t: t, ttsStatus: http.StatusOK}
+	user, token, engine := setupSpeechPipelineTest(t, upstream)
+
+	recorder := doSpeechPipelineRequest(engine, token)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	require.Equal(t, "audio/mpeg", recorder.Header().Get("Content-Type"))
+	require.Equal(t, speechTestAudio, recorder.Body.String())
+	transcript, err := url.QueryUnescape(recorder.Header().Get("X-Speech-Transcript"))
+	require.NoError(t, err)
+	require.Equal(t, "what time is it", transcript)
+
+	// 环节按 STT -> 对话 -> TTS 依次执行，上一环节的输出作为下一环节的输入
+	require.Equal(t, []string{"/v1/audio/transcriptions", "/v1/chat/completions", "/v1/audio/speech"}, upstream.calls)
+	require.Equal(t, speechTestChat, upstream.chatBody["model"])
+	messages := upstream.chatBody["messages"].([]any)
+	require.Equal(t, map[string]any{"role": "system", "content": "answer briefly"}, messages[0])
+	require.Equal(t, map[string]any{"role": "user", "content": "what time is it"}, messages[1])
+	require.Equal(t, speechTestTts, upstream.ttsBody["model"])
+	require.Equal(t, speechTestReply, upstream.ttsBody["input"])
+	require.Equal(t, "alloy", upstream.ttsBody["voice"])
+
+	// 每个环节按各自模型单独计费：STT 0.002 * 500000，对话 (10 + 5 * 2) * 1，TTS 0.004 * 500000
+	legs := speechLegQuotas(t, user.Id)
+	require.Equal(t, map[string]int{speechLegStt: 1000, speechLegChat: 20, speechLegTts: 2000}, legs)
+	requireSpeechBilling(t, user, token, 1000+20+2000)
+}
+
+func TestRelaySpeechPipeline_FailedLegIsRefunded(t *testing.T) {
+	upstream := &speechTestUpstream{t: t, ttsStatus: http.StatusInternalServerError}
+	user, token, engine := setupSpeechPipelineTest(t, upstream)
+
+	recorder := doSpeechPipelineRequest(engine, token)
+	require.Equal(t, http.StatusInternalServerError, recorder.Code, recorder.Body.String())
+	require.Contains(t, recorder.Body.String(), "tts upstream failed")
+
+	// 已完成的 STT 与对话环节正常计费，失败的 TTS 环节返还预扣额度且不记录消费日志
+	legs := speechLegQuotas(t, user.Id)
+	require.Equal(t, map[string]int{speechLegStt: 1000, speechLegChat: 20}, legs)
+	requireSpeechBilling(t, user, token, 1000+20)
+}
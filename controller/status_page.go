@@ -0,0 +1,49 @@
+package controller
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetPublicModelStatus 公开状态页：按模型展示可用率、时延与故障，period 可选 24h / 7d / 90d
+func GetPublicModelStatus(c *gin.Context) {
+	if !operation_setting.GetStatusPageSetting().Enabled {
+		common.ApiErrorMsg(c, "状态页未启用")
+		return
+	}
+	report, err := service.GetPublicStatus(c.DefaultQuery("period", "24h"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, report)
+}
+
+// GetChannelStatus 管理端渠道状态：各模型的可用率时序、故障记录及各测试点最近一次的测试结果
+func GetChannelStatus(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	report, err := service.GetChannelStatus(channelId, c.DefaultQuery("period", "24h"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	testResults, err := model.GetChannelTestResults(channelId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"status":       report,
+		"test_results": testResults,
+	})
+}
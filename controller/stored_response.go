@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 网关侧保存的 Responses API 响应，仅读取本地存储，不访问上游；用户只能访问自己创建的响应
+
+func storedResponseError(c *gin.Context, statusCode int, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": types.OpenAIError{
+			Message: message,
+			Type:    "invalid_request_error",
+		},
+	})
+}
+
+func getUserStoredResponse(c *gin.Context) (*model.StoredResponse, bool) {
+	responseId := c.Param("id")
+	record, err := model.GetUserStoredResponse(c.GetInt("id"), responseId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			storedResponseError(c, http.StatusNotFound, "No response found with id '"+responseId+"'.")
+		} else {
+			storedResponseError(c, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	return record, true
+}
+
+// GetStoredResponse GET /v1/responses/:id
+func GetStoredResponse(c *gin.Context) {
+	record, ok := getUserStoredResponse(c)
+	if !ok {
+		return
+	}
+	c.Data(http.StatusOK, "application/json", record.Response)
+}
+
+// GetStoredResponseInputItems GET /v1/responses/:id/input_items，返回该轮请求的 input items
+func GetStoredResponseInputItems(c *gin.Context) {
+	record, ok := getUserStoredResponse(c)
+	if !ok {
+		return
+	}
+	items := make([]json.RawMessage, 0)
+	if len(record.Input) > 0 {
+		if err := common.Unmarshal(record.Input, &items); err != nil {
+			storedResponseError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     items,
+		"has_more": false,
+	})
+}
+
+// DeleteStoredResponse DELETE /v1/responses/:id
+func DeleteStoredResponse(c *gin.Context) {
+	responseId := c.Param("id")
+	deleted, err := model.DeleteUserStoredResponse(c.GetInt("id"), responseId)
+	if err != nil {
+		storedResponseError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !deleted {
+		storedResponseError(c, http.StatusNotFound, "No response found with id '"+responseId+"'.")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      responseId,
+		"object":  "response.deleted",
+		"deleted": true,
+	})
+}
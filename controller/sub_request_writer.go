@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// subRequestWriter 接收内部子请求（语音链路环节、异步图片任务等）的响应；onWrite 为空时缓存响应体，否则按写入顺序回调
+type subRequestWriter struct {
+	header  http.Header
+	status  int
+	size    int
+	body    bytes.Buffer
+	onWrite func([]byte) error
+}
+
+func newSubRequestWriter(onWrite func([]byte) error) *subRequestWriter {
+	return &subRequestWriter{header: http.Header{}, onWrite: onWrite}
+}
+
+func (w *subRequestWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *subRequestWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *subRequestWriter) WriteHeaderNow() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *subRequestWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.onWrite != nil {
+		if err := w.onWrite(data); err != nil {
+			return 0, err
+		}
+	} else {
+		w.body.Write(data)
+	}
+	w.size += len(data)
+	return len(data), nil
+}
+
+func (w *subRequestWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *subRequestWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *subRequestWriter) Size() int {
+	return w.size
+}
+
+func (w *subRequestWriter) Written() bool {
+	return w.size > 0
+}
+
+func (w *subRequestWriter) Flush() {}
+
+func (w *subRequestWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("hijack not supported")
+}
+
+func (w *subRequestWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *subRequestWriter) Pusher() http.Pusher {
+	return nil
+}
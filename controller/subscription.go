@@ -0,0 +1,456 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/checkout/session"
+	"github.com/stripe/stripe-go/v81/subscription"
+	"github.com/thanhpk/randstr"
+)
+
+// GetSubscriptionPlans 管理员获取全部订阅套餐
+func GetSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetAllSubscriptionPlans(false)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+// GetEnabledSubscriptionPlans 用户获取可订阅的套餐
+func GetEnabledSubscriptionPlans(c *gin.Context) {
+	plans, err := model.GetAllSubscriptionPlans(true)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, plans)
+}
+
+func CreateSubscriptionPlan(c *gin.Context) {
+	var plan model.SubscriptionPlan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if msg := validateSubscriptionPlan(&plan); msg != "" {
+		common.ApiErrorMsg(c, msg)
+		return
+	}
+	if err := plan.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, &plan)
+}
+
+func UpdateSubscriptionPlan(c *gin.Context) {
+	var plan model.SubscriptionPlan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if plan.Id == 0 {
+		common.ApiErrorMsg(c, "缺少套餐 ID")
+		return
+	}
+	if msg := validateSubscriptionPlan(&plan); msg != "" {
+		common.ApiErrorMsg(c, msg)
+		return
+	}
+	origin, err := model.GetSubscriptionPlanById(plan.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan.CreatedTime = origin.CreatedTime
+	if err := plan.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, &plan)
+}
+
+func DeleteSubscriptionPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.DeleteSubscriptionPlanById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func validateSubscriptionPlan(plan *model.SubscriptionPlan) string {
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" {
+		return "套餐名称不能为空"
+	}
+	if plan.Period == "" {
+		plan.Period = model.SubscriptionPeriodMonth
+	}
+	if plan.Period != model.SubscriptionPeriodMonth && plan.Period != model.SubscriptionPeriodYear {
+		return "订阅周期只能为 month 或 year"
+	}
+	if plan.OveragePolicy == "" {
+		plan.OveragePolicy = model.SubscriptionOverageBlock
+	}
+	if plan.OveragePolicy != model.SubscriptionOverageBlock && plan.OveragePolicy != model.SubscriptionOverageBalance {
+		return "超额策略只能为 block 或 balance"
+	}
+	if plan.IncludedQuota < 0 || plan.Price < 0 {
+		return "价格和包含额度不能为负数"
+	}
+	return ""
+}
+
+// GetAllUserSubscriptions 管理员分页获取用户订阅
+func GetAllUserSubscriptions(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	subs, total, err := model.GetAllUserSubscriptions(pageInfo, c.Query("status"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(subs)
+	common.ApiSuccess(c, pageInfo)
+}
+
+type GrantSubscriptionRequest struct {
+	UserId int `json:"user_id"`
+	PlanId int `json:"plan_id"`
+}
+
+// GrantUserSubscription 管理员直接为用户开通订阅（不经过支付）
+func GrantUserSubscription(c *gin.Context) {
+	var req GrantSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil {
+		common.ApiErrorMsg(c, "套餐不存在")
+		return
+	}
+	if _, err := model.GetUserById(req.UserId, false); err != nil {
+		common.ApiErrorMsg(c, "用户不存在")
+		return
+	}
+	if old, err := model.GetActiveUserSubscription(req.UserId); err == nil && old != nil {
+		if err := model.EndUserSubscription(old, model.UserSubscriptionStatusCanceled); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	sub := &model.UserSubscription{
+		UserId: req.UserId,
+		PlanId: plan.Id,
+		Status: model.UserSubscriptionStatusPending,
+	}
+	if err := sub.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.ActivateUserSubscription(sub, plan); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(req.UserId, model.LogTypeManage, fmt.Sprintf("管理员开通订阅套餐 %s", plan.Name))
+	model.PublishTopupEvent(req.UserId, "subscription_grant", strconv.Itoa(sub.Id), plan.IncludedQuota, 0)
+	common.ApiSuccess(c, sub)
+}
+
+// AdminCancelUserSubscription 管理员立即取消订阅
+func AdminCancelUserSubscription(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	sub, err := model.GetUserSubscriptionById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if sub.StripeSubscriptionId != "" {
+		if err := cancelStripeSubscription(sub.StripeSubscriptionId, false); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	if err := model.EndUserSubscription(sub, model.UserSubscriptionStatusCanceled); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, sub)
+}
+
+// GetSelfSubscription 获取当前用户的生效订阅与历史订阅
+func GetSelfSubscription(c *gin.Context) {
+	userId := c.GetInt("id")
+	active, err := model.GetActiveUserSubscription(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	history, err := model.GetUserSubscriptions(userId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"active":  active,
+		"history": history,
+	})
+}
+
+// CancelSelfSubscription 用户取消订阅，当前周期结束后失效
+func CancelSelfSubscription(c *gin.Context) {
+	sub, err := model.GetActiveUserSubscription(c.GetInt("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if sub == nil {
+		common.ApiErrorMsg(c, "当前没有生效的订阅")
+		return
+	}
+	if sub.StripeSubscriptionId != "" {
+		if err := cancelStripeSubscription(sub.StripeSubscriptionId, true); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	sub.CancelAtPeriodEnd = true
+	if err := sub.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, sub)
+}
+
+type SubscriptionPayRequest struct {
+	PlanId int `json:"plan_id"`
+}
+
+// RequestSubscriptionStripePay 创建 Stripe 订阅支付链接
+func RequestSubscriptionStripePay(c *gin.Context) {
+	var req SubscriptionPayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "参数错误"})
+		return
+	}
+	plan, err := model.GetSubscriptionPlanById(req.PlanId)
+	if err != nil || plan.Status != 1 || plan.DeletedAt.Valid {
+		c.JSON(200, gin.H{"message": "error", "data": "套餐不存在"})
+		return
+	}
+	if plan.StripePriceId == "" {
+		c.JSON(200, gin.H{"message": "error", "data": "该套餐未配置 Stripe 价格"})
+		return
+	}
+
+	id := c.GetInt("id")
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "用户不存在"})
+		return
+	}
+
+	reference := fmt.Sprintf("new-api-sub-%d-%d-%s", user.Id, time.Now().UnixMilli(), randstr.String(4))
+	referenceId := "sub_" + common.Sha1([]byte(reference))
+
+	payLink, err := genStripeSubscriptionLink(referenceId, user.StripeCustomer, user.Email, plan)
+	if err != nil {
+		log.Println("获取Stripe订阅支付链接失败", err)
+		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
+		return
+	}
+
+	sub := &model.UserSubscription{
+		UserId:  id,
+		PlanId:  plan.Id,
+		Status:  model.UserSubscriptionStatusPending,
+		TradeNo: referenceId,
+	}
+	if err := sub.Insert(); err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"message": "success",
+		"data": gin.H{
+			"pay_link": payLink,
+		},
+	})
+}
+
+// TestSubscriptionWebhook 本地测试 Stripe 订阅回调：请求体为 Stripe 事件 JSON，跳过签名校验直接处理
+func TestSubscriptionWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var event stripe.Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		common.ApiErrorMsg(c, "无效的 Stripe 事件: "+err.Error())
+		return
+	}
+	if event.Data == nil || event.Data.Object == nil {
+		common.ApiErrorMsg(c, "Stripe 事件缺少 data.object")
+		return
+	}
+	handleStripeEvent(event)
+	common.ApiSuccess(c, nil)
+}
+
+func subscriptionSessionCompleted(event stripe.Event) {
+	referenceId := stripeObjectString(event, "client_reference_id")
+	status := stripeObjectString(event, "status")
+	if "complete" != status {
+		log.Println("错误的Stripe订阅Checkout完成状态:", status, ",", referenceId)
+		return
+	}
+	stripeSubscriptionId := stripeObjectString(event, "subscription")
+	customerId := stripeObjectString(event, "customer")
+	if err := model.CompleteSubscriptionCheckout(referenceId, stripeSubscriptionId, customerId); err != nil {
+		log.Println("激活订阅失败", referenceId, err.Error())
+		return
+	}
+	log.Printf("订阅已激活：%s, %s", referenceId, stripeSubscriptionId)
+}
+
+func subscriptionSessionExpired(event stripe.Event) {
+	referenceId := stripeObjectString(event, "client_reference_id")
+	sub, err := model.GetUserSubscriptionByTradeNo(referenceId)
+	if err != nil {
+		log.Println("订阅订单不存在", referenceId)
+		return
+	}
+	if sub.Status != model.UserSubscriptionStatusPending {
+		return
+	}
+	if err := model.EndUserSubscription(sub, model.UserSubscriptionStatusExpired); err != nil {
+		log.Println("过期订阅订单失败", referenceId, ", err:", err.Error())
+	}
+}
+
+func subscriptionInvoicePaid(event stripe.Event) {
+	stripeSubscriptionId := stripeObjectString(event, "subscription")
+	if stripeSubscriptionId == "" {
+		stripeSubscriptionId = stripeObjectString(event, "parent", "subscription_details", "subscription")
+	}
+	if stripeSubscriptionId == "" {
+		return
+	}
+	if stripeObjectString(event, "billing_reason") == "subscription_create" {
+		// 首期账单由 checkout.session.completed 激活
+		return
+	}
+	if err := model.RenewStripeUserSubscription(stripeSubscriptionId); err != nil {
+		log.Println("订阅续费失败", stripeSubscriptionId, err.Error())
+	}
+}
+
+func subscriptionUpdated(event stripe.Event) {
+	sub, err := model.GetUserSubscriptionByStripeId(stripeObjectString(event, "id"))
+	if err != nil {
+		return
+	}
+	cancelAtPeriodEnd := stripeObjectString(event, "cancel_at_period_end") == "true"
+	if sub.CancelAtPeriodEnd != cancelAtPeriodEnd {
+		sub.CancelAtPeriodEnd = cancelAtPeriodEnd
+		if err := sub.Update(); err != nil {
+			log.Println("更新订阅失败", sub.Id, err.Error())
+		}
+	}
+}
+
+func subscriptionDeleted(event stripe.Event) {
+	sub, err := model.GetUserSubscriptionByStripeId(stripeObjectString(event, "id"))
+	if err != nil {
+		return
+	}
+	if sub.Status == model.UserSubscriptionStatusCanceled || sub.Status == model.UserSubscriptionStatusExpired {
+		return
+	}
+	if err := model.EndUserSubscription(sub, model.UserSubscriptionStatusCanceled); err != nil {
+		log.Println("取消订阅失败", sub.Id, err.Error())
+	}
+}
+
+func genStripeSubscriptionLink(referenceId string, customerId string, email string, plan *model.SubscriptionPlan) (string, error) {
+	if !strings.HasPrefix(setting.StripeApiSecret, "sk_") && !strings.HasPrefix(setting.StripeApiSecret, "rk_") {
+		return "", fmt.Errorf("无效的Stripe API密钥")
+	}
+
+	stripe.Key = setting.StripeApiSecret
+
+	params := &stripe.CheckoutSessionParams{
+		ClientReferenceID: stripe.String(referenceId),
+		SuccessURL:        stripe.String(system_setting.ServerAddress + "/console/topup"),
+		CancelURL:         stripe.String(system_setting.ServerAddress + "/console/topup"),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(plan.StripePriceId),
+				Quantity: stripe.Int64(1),
+			},
+		},
+		Mode:                stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		AllowPromotionCodes: stripe.Bool(setting.StripePromotionCodesEnabled),
+		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
+			Metadata: map[string]string{
+				"plan_id":  strconv.Itoa(plan.Id),
+				"trade_no": referenceId,
+			},
+		},
+	}
+
+	if "" == customerId {
+		if "" != email {
+			params.CustomerEmail = stripe.String(email)
+		}
+	} else {
+		params.Customer = stripe.String(customerId)
+	}
+
+	result, err := session.New(params)
+	if err != nil {
+		return "", err
+	}
+
+	return result.URL, nil
+}
+
+// cancelStripeSubscription atPeriodEnd 为 true 时在当前周期结束后取消，否则立即取消
+func cancelStripeSubscription(stripeSubscriptionId string, atPeriodEnd bool) error {
+	stripe.Key = setting.StripeApiSecret
+	if atPeriodEnd {
+		_, err := subscription.Update(stripeSubscriptionId, &stripe.SubscriptionParams{
+			CancelAtPeriodEnd: stripe.Bool(true),
+		})
+		return err
+	}
+	_, err := subscription.Cancel(stripeSubscriptionId, nil)
+	return err
+}
@@ -18,66 +18,149 @@ import (
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/relay"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
+	"github.com/bytedance/gopkg/util/gopool"
 	"github.com/gin-gonic/gin"
 	"github.com/samber/lo"
 )
 
+// UpdateTaskBulk 异步任务轮询调度器，所有节点均可运行，通过租约领取到期任务
 func UpdateTaskBulk() {
-	//revocer
-	//imageModel := "midjourney"
 	for {
-		time.Sleep(time.Duration(15) * time.Second)
-		common.SysLog("任务进度轮询开始")
-		ctx := context.TODO()
-		allTasks := model.GetAllUnFinishSyncTasks(constant.TaskQueryLimit)
-		platformTask := make(map[constant.TaskPlatform][]*model.Task)
-		for _, t := range allTasks {
-			platformTask[t.Platform] = append(platformTask[t.Platform], t)
+		pollSetting := operation_setting.GetTaskPollSetting()
+		time.Sleep(pollSetting.GetTickInterval())
+		pollDueTasks(pollSetting)
+	}
+}
+
+func pollDueTasks(pollSetting *operation_setting.TaskPollSetting) {
+	ctx := context.TODO()
+	owner := model.GetPollOwner()
+	now := time.Now()
+	limit := pollSetting.BatchSize
+	if limit <= 0 {
+		limit = constant.TaskQueryLimit
+	}
+	allTasks, err := model.AcquireDueTasks(owner, now.Unix(), pollSetting.GetLeaseSeconds(), limit)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Acquire due tasks error: %v", err))
+	}
+	if len(allTasks) == 0 {
+		return
+	}
+	common.SysLog(fmt.Sprintf("任务进度轮询开始，领取任务数: %d", len(allTasks)))
+
+	// 记录轮询前的状态，任务有进展时重置退避
+	type pollSnapshot struct {
+		status   model.TaskStatus
+		progress string
+	}
+	snapshots := make(map[int64]pollSnapshot)
+	platformTask := make(map[constant.TaskPlatform][]*model.Task)
+	for _, t := range allTasks {
+		platformPoll := pollSetting.GetPlatformPollSetting(string(t.Platform))
+		if platformPoll.IsTimeout(t.SubmitTime, now) {
+			failTimedOutTask(ctx, t, platformPoll.TimeoutSeconds)
+			continue
 		}
-		for platform, tasks := range platformTask {
-			if len(tasks) == 0 {
+		snapshots[t.ID] = pollSnapshot{status: t.Status, progress: t.Progress}
+		platformTask[t.Platform] = append(platformTask[t.Platform], t)
+	}
+	for platform, tasks := range platformTask {
+		if len(tasks) == 0 {
+			continue
+		}
+		taskChannelM := make(map[int][]string)
+		taskM := make(map[string]*model.Task)
+		nullTaskIds := make([]int64, 0)
+		for _, task := range tasks {
+			if task.TaskID == "" {
+				// 统计失败的未完成任务
+				nullTaskIds = append(nullTaskIds, task.ID)
 				continue
 			}
-			taskChannelM := make(map[int][]string)
-			taskM := make(map[string]*model.Task)
-			nullTaskIds := make([]int64, 0)
-			for _, task := range tasks {
-				if task.TaskID == "" {
-					// 统计失败的未完成任务
-					nullTaskIds = append(nullTaskIds, task.ID)
-					continue
-				}
-				taskM[task.TaskID] = task
-				taskChannelM[task.ChannelId] = append(taskChannelM[task.ChannelId], task.TaskID)
-			}
-			if len(nullTaskIds) > 0 {
-				err := model.TaskBulkUpdateByID(nullTaskIds, map[string]any{
-					"status":   "FAILURE",
-					"progress": "100%",
-				})
-				if err != nil {
-					logger.LogError(ctx, fmt.Sprintf("Fix null task_id task error: %v", err))
-				} else {
-					logger.LogInfo(ctx, fmt.Sprintf("Fix null task_id task success: %v", nullTaskIds))
-				}
-			}
-			if len(taskChannelM) == 0 {
-				continue
+			taskM[task.TaskID] = task
+			taskChannelM[task.ChannelId] = append(taskChannelM[task.ChannelId], task.TaskID)
+		}
+		if len(nullTaskIds) > 0 {
+			err := model.TaskBulkUpdateByID(nullTaskIds, map[string]any{
+				"status":   "FAILURE",
+				"progress": "100%",
+			})
+			if err != nil {
+				logger.LogError(ctx, fmt.Sprintf("Fix null task_id task error: %v", err))
+			} else {
+				logger.LogInfo(ctx, fmt.Sprintf("Fix null task_id task success: %v", nullTaskIds))
 			}
+		}
+		if len(taskChannelM) == 0 {
+			continue
+		}
+
+		UpdateTaskByPlatform(platform, taskChannelM, taskM)
+	}
 
-			UpdateTaskByPlatform(platform, taskChannelM, taskM)
+	for _, t := range allTasks {
+		snapshot, ok := snapshots[t.ID]
+		if !ok {
+			continue
+		}
+		attempts := t.PollAttempts + 1
+		if t.Status != snapshot.status || t.Progress != snapshot.progress {
+			attempts = 0
+		}
+		delay := pollSetting.GetPlatformPollSetting(string(t.Platform)).NextPollDelay(attempts)
+		if err := model.ScheduleTaskNextPoll(t.ID, owner, time.Now().Add(delay).Unix(), attempts); err != nil {
+			logger.LogError(ctx, fmt.Sprintf("Schedule task %d next poll error: %v", t.ID, err))
 		}
-		common.SysLog("任务进度轮询完成")
+	}
+	common.SysLog("任务进度轮询完成")
+}
+
+// failTimedOutTask 超时任务判定失败并退还额度
+func failTimedOutTask(ctx context.Context, task *model.Task, timeoutSeconds int) {
+	failUnfinishedTask(ctx, task, fmt.Sprintf("任务超时（超过 %d 秒未完成）", timeoutSeconds), "异步任务超时", "task_timeout")
+}
+
+// failUnfinishedTask 将未完成的任务判定失败，退还额度并触发回调；状态已被其他流程变更时不做任何处理
+func failUnfinishedTask(ctx context.Context, task *model.Task, reason string, logTitle string, refundReason string) {
+	changed, err := model.FailUnfinishedTask(task.ID, reason)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Fail task %d error: %v", task.ID, err))
+		return
+	}
+	if !changed {
+		return
+	}
+	logger.LogInfo(ctx, fmt.Sprintf("Task %s failed: %s", task.TaskID, reason))
+	if task.Quota != 0 {
+		if err := service.RefundTaskQuota(task, task.Quota); err != nil {
+			logger.LogError(ctx, "fail to increase user quota: "+err.Error())
+		} else {
+			logContent := fmt.Sprintf("%s %s，补偿 %s", logTitle, task.TaskID, logger.LogQuota(task.Quota))
+			model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
+			model.PublishRefundEvent(task.UserId, task.Quota, refundReason, task.TaskID)
+		}
+	}
+	task.Status = model.TaskStatusFailure
+	task.Progress = "100%"
+	task.FailReason = reason
+	if task.CallBackUrl != "" && task.CallBackStatus != service.CallbackStatusSuccess {
+		common.RelayCtxGo(ctx, func() {
+			service.TriggerTaskCallback(task)
+		})
 	}
 }
 
 func UpdateTaskByPlatform(platform constant.TaskPlatform, taskChannelM map[int][]string, taskM map[string]*model.Task) {
 	switch platform {
 	case constant.TaskPlatformMidjourney:
-		//_ = UpdateMidjourneyTaskAll(context.Background(), tasks)
+		_ = UpdateMidjourneyTaskAll(context.Background(), taskChannelM, taskM)
 	case constant.TaskPlatformSuno:
 		_ = UpdateSunoTaskAll(context.Background(), taskChannelM, taskM)
+	case constant.TaskPlatformImage:
+		failOrphanedImageTasks(context.Background(), taskM)
 	default:
 		if err := UpdateVideoTaskAll(context.Background(), platform, taskChannelM, taskM); err != nil {
 			common.SysLog(fmt.Sprintf("UpdateVideoTaskAll fail: %s", err))
@@ -166,12 +249,13 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 			} else {
 				quota := task.Quota
 				if quota != 0 {
-					err = model.IncreaseUserQuota(task.UserId, quota, false)
+					err = service.RefundTaskQuota(task, quota)
 					if err != nil {
 						logger.LogError(ctx, "fail to increase user quota: "+err.Error())
 					}
 					logContent := fmt.Sprintf("异步任务执行失败 %s，补偿 %s", task.TaskID, logger.LogQuota(quota))
 					model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
+					model.PublishRefundEvent(task.UserId, quota, "task_failed", task.TaskID)
 				}
 			}
 		}
@@ -187,6 +271,11 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		if err != nil {
 			common.SysLog("UpdateMidjourneyTask task error: " + err.Error())
 		}
+		if task.Status == model.TaskStatusSuccess && operation_setting.GetMediaStorageSetting().ShouldPersist(operation_setting.MediaSourceSuno) {
+			gopool.Go(func() {
+				persistSunoTaskMedia(task)
+			})
+		}
 
 		// 任务状态变更为成功或失败时触发回调
 		if (task.Status == model.TaskStatusSuccess || task.Status == model.TaskStatusFailure) && preStatus != task.Status {
@@ -259,6 +348,7 @@ func GetAllTask(c *gin.Context) {
 	}
 
 	items := model.TaskGetAllTasks(pageInfo.GetStartIdx(), pageInfo.GetPageSize(), queryParams)
+	signTaskMediaURLs(items)
 	total := model.TaskCountAllTasks(queryParams)
 	pageInfo.SetTotal(int(total))
 	pageInfo.SetItems(items)
@@ -283,8 +373,19 @@ func GetUserTask(c *gin.Context) {
 	}
 
 	items := model.TaskGetAllUserTask(userId, pageInfo.GetStartIdx(), pageInfo.GetPageSize(), queryParams)
+	signTaskMediaURLs(items)
 	total := model.TaskCountAllUserTask(userId, queryParams)
 	pageInfo.SetTotal(int(total))
 	pageInfo.SetItems(items)
 	common.ApiSuccess(c, pageInfo)
 }
+
+// GetTaskQueueStats 查看异步任务队列深度
+func GetTaskQueueStats(c *gin.Context) {
+	stats, err := model.GetTaskQueueStats()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, stats)
+}
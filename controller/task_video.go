@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
@@ -16,7 +17,10 @@ import (
 	"github.com/QuantumNous/new-api/relay/channel"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
 )
 
 func UpdateVideoTaskAll(ctx context.Context, platform constant.TaskPlatform, taskChannelM map[int][]string, taskM map[string]*model.Task) error {
@@ -195,7 +199,7 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 									logger.LogQuota(preConsumedQuota),
 									taskResult.TotalTokens,
 								))
-								if err := model.DecreaseUserQuota(task.UserId, quotaDelta); err != nil {
+								if err := service.AdjustTaskQuota(task, quotaDelta); err != nil {
 									logger.LogError(ctx, fmt.Sprintf("补扣费失败: %s", err.Error()))
 								} else {
 									model.UpdateUserUsedQuotaAndRequestCount(task.UserId, quotaDelta)
@@ -218,7 +222,7 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 									logger.LogQuota(preConsumedQuota),
 									taskResult.TotalTokens,
 								))
-								if err := model.IncreaseUserQuota(task.UserId, refundQuota, false); err != nil {
+								if err := service.RefundTaskQuota(task, refundQuota); err != nil {
 									logger.LogError(ctx, fmt.Sprintf("退还预扣费失败: %s", err.Error()))
 								} else {
 									task.Quota = actualQuota // 更新任务记录的实际扣费额度
@@ -268,6 +272,12 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 		shouldRefund = false
 	}
 
+	if task.Status == model.TaskStatusSuccess && preStatus != task.Status && operation_setting.GetMediaStorageSetting().ShouldPersist(operation_setting.MediaSourceVideo) {
+		gopool.Go(func() {
+			persistTaskVideo(task)
+		})
+	}
+
 	// 任务状态变更为成功或失败时触发回调
 	if (task.Status == model.TaskStatusSuccess || task.Status == model.TaskStatusFailure) && preStatus != task.Status {
 		if task.CallBackUrl != "" && task.CallBackStatus != service.CallbackStatusSuccess {
@@ -279,11 +289,12 @@ func updateVideoSingleTask(ctx context.Context, adaptor channel.TaskAdaptor, cha
 
 	if shouldRefund {
 		// 任务失败且之前状态不是失败才退还额度，防止重复退还
-		if err := model.IncreaseUserQuota(task.UserId, quota, false); err != nil {
+		if err := service.RefundTaskQuota(task, quota); err != nil {
 			logger.LogWarn(ctx, "Failed to increase user quota: "+err.Error())
 		}
 		logContent := fmt.Sprintf("Video async task failed %s, refund %s", task.TaskID, logger.LogQuota(quota))
 		model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
+		model.PublishRefundEvent(task.UserId, quota, "task_failed", task.TaskID)
 	}
 
 	return nil
@@ -322,3 +333,26 @@ func truncateBase64(s string) string {
 	}
 	return s[:maxKeep] + "..."
 }
+
+// persistTaskVideo 将完成的视频写入媒体存储，之后 /v1/videos/:id/content 直接从存储读取
+func persistTaskVideo(task *model.Task) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	resp, proxyErr := openTaskVideo(ctx, task)
+	if proxyErr != nil {
+		common.SysLog(fmt.Sprintf("failed to persist video for task %s: %s", task.TaskID, proxyErr.message))
+		return
+	}
+	obj, err := service.PersistMediaFromResponse(ctx, task.UserId, operation_setting.MediaSourceVideo, task.TaskID, resp)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to persist video for task %s: %s", task.TaskID, err.Error()))
+		return
+	}
+	// 部分平台将视频链接保存在 FailReason 中，替换为网关媒体地址
+	if strings.HasPrefix(task.FailReason, "http") {
+		task.FailReason = service.MediaObjectURL(obj)
+		if err := task.Update(); err != nil {
+			common.SysLog(fmt.Sprintf("failed to update video url for task %s: %s", task.TaskID, err.Error()))
+		}
+	}
+}
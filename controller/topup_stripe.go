@@ -145,16 +145,54 @@ func StripeWebhook(c *gin.Context) {
 		return
 	}
 
+	handleStripeEvent(event)
+
+	c.Status(http.StatusOK)
+}
+
+func handleStripeEvent(event stripe.Event) {
+	isSubscriptionCheckout := stripeObjectString(event, "mode") == string(stripe.CheckoutSessionModeSubscription)
 	switch event.Type {
 	case stripe.EventTypeCheckoutSessionCompleted:
-		sessionCompleted(event)
+		if isSubscriptionCheckout {
+			subscriptionSessionCompleted(event)
+		} else {
+			sessionCompleted(event)
+		}
 	case stripe.EventTypeCheckoutSessionExpired:
-		sessionExpired(event)
+		if isSubscriptionCheckout {
+			subscriptionSessionExpired(event)
+		} else {
+			sessionExpired(event)
+		}
+	case stripe.EventTypeInvoicePaid:
+		subscriptionInvoicePaid(event)
+	case stripe.EventTypeCustomerSubscriptionUpdated:
+		subscriptionUpdated(event)
+	case stripe.EventTypeCustomerSubscriptionDeleted:
+		subscriptionDeleted(event)
 	default:
 		log.Printf("不支持的Stripe Webhook事件类型: %s\n", event.Type)
 	}
+}
 
-	c.Status(http.StatusOK)
+// stripeObjectString 安全地读取事件对象中的字段，字段不存在时返回空字符串
+func stripeObjectString(event stripe.Event, keys ...string) string {
+	var node interface{} = event.Data.Object
+	for _, key := range keys {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		node = m[key]
+	}
+	if node == nil {
+		return ""
+	}
+	if s, ok := node.(string); ok {
+		return s
+	}
+	return fmt.Sprint(node)
 }
 
 func sessionCompleted(event stripe.Event) {
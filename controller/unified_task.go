@@ -231,14 +231,14 @@ func convertTaskToUnifiedFormat(task *model.Task) *dto.UnifiedTaskDetailData {
 		data.CompletedAt = task.FinishTime
 		// 解析result
 		var result map[string]interface{}
-		if err := common.Unmarshal(task.Data, &result); err == nil && len(result) > 0 {
+		if err := common.Unmarshal(service.SignMediaURLsInBody(task.Data), &result); err == nil && len(result) > 0 {
 			data.Result = result
 		}
 		// 如果Data为空但有FailReason（某些平台将URL存在FailReason中）
 		if (data.Result == nil || len(data.Result) == 0) && task.FailReason != "" {
 			if strings.HasPrefix(task.FailReason, "http") {
 				data.Result = map[string]interface{}{
-					"resultUrls": []string{task.FailReason},
+					"resultUrls": []string{service.SignMediaURL(task.FailReason)},
 				}
 			}
 		}
@@ -51,3 +51,29 @@ func GetUserQuotaDates(c *gin.Context) {
 	})
 	return
 }
+
+// GetChannelCostReport 渠道毛利报表，group_by 可选 channel/model/group/day
+func GetChannelCostReport(c *gin.Context) {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	channelId, _ := strconv.Atoi(c.Query("channel"))
+	groupBy := c.DefaultQuery("group_by", model.ChannelCostGroupByChannel)
+	switch groupBy {
+	case model.ChannelCostGroupByChannel, model.ChannelCostGroupByModel, model.ChannelCostGroupByGroup, model.ChannelCostGroupByDay:
+	default:
+		common.ApiErrorMsg(c, "group_by 只能为 channel、model、group 或 day")
+		return
+	}
+	items, err := model.GetChannelCostReport(model.ChannelCostReportFilter{
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ChannelId:      channelId,
+		ModelName:      c.Query("model_name"),
+		Group:          c.Query("group"),
+	}, groupBy)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, items)
+}
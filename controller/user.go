@@ -1159,6 +1159,13 @@ func UpdateUserSetting(c *gin.Context) {
 			})
 			return
 		}
+		if len(req.WebhookUrl) > model.MaxWebhookURLLength {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("Webhook地址长度不能超过%d个字符", model.MaxWebhookURLLength),
+			})
+			return
+		}
 	}
 
 	// 如果是邮件类型，验证邮箱地址
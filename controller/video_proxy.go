@@ -12,6 +12,7 @@ import (
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/gin-gonic/gin"
 )
@@ -60,17 +61,53 @@ func VideoProxy(c *gin.Context) {
 		return
 	}
 
-	channel, err := model.CacheGetChannel(task.ChannelId)
-	if err != nil {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to get task %s: not found", taskID))
-		c.JSON(http.StatusInternalServerError, gin.H{
+	// 已持久化的视频直接从媒体存储读取
+	if obj, err := model.GetLatestMediaObjectBySource(operation_setting.MediaSourceVideo, task.TaskID); err == nil {
+		service.ServeMediaObject(c, obj)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+	resp, proxyErr := openTaskVideo(ctx, task)
+	if proxyErr != nil {
+		c.JSON(proxyErr.status, gin.H{
 			"error": gin.H{
-				"message": "Failed to retrieve channel information",
+				"message": proxyErr.message,
 				"type":    "server_error",
 			},
 		})
 		return
 	}
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		for _, value := range values {
+			c.Writer.Header().Add(key, value)
+		}
+	}
+
+	c.Writer.Header().Set("Cache-Control", "public, max-age=86400") // Cache for 24 hours
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(c.Writer, resp.Body)
+	if err != nil {
+		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to stream video content: %s", err.Error()))
+	}
+}
+
+type videoProxyError struct {
+	status  int
+	message string
+}
+
+// openTaskVideo 请求上游的视频内容，成功时返回状态码为 200 的响应
+func openTaskVideo(ctx context.Context, task *model.Task) (*http.Response, *videoProxyError) {
+	taskID := task.TaskID
+	channel, err := model.CacheGetChannel(task.ChannelId)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Failed to get task %s: not found", taskID))
+		return nil, &videoProxyError{status: http.StatusInternalServerError, message: "Failed to retrieve channel information"}
+	}
 	baseURL := channel.GetBaseURL()
 	if baseURL == "" {
 		baseURL = "https://api.openai.com"
@@ -80,54 +117,28 @@ func VideoProxy(c *gin.Context) {
 	proxy := channel.GetSetting().Proxy
 	client, err := service.GetHttpClientWithProxy(proxy)
 	if err != nil {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to create proxy client for task %s: %s", taskID, err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "Failed to create proxy client",
-				"type":    "server_error",
-			},
-		})
-		return
+		logger.LogError(ctx, fmt.Sprintf("Failed to create proxy client for task %s: %s", taskID, err.Error()))
+		return nil, &videoProxyError{status: http.StatusInternalServerError, message: "Failed to create proxy client"}
 	}
 
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
-	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "", nil)
 	if err != nil {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to create request: %s", err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "Failed to create proxy request",
-				"type":    "server_error",
-			},
-		})
-		return
+		logger.LogError(ctx, fmt.Sprintf("Failed to create request: %s", err.Error()))
+		return nil, &videoProxyError{status: http.StatusInternalServerError, message: "Failed to create proxy request"}
 	}
 
 	switch channel.Type {
 	case constant.ChannelTypeGemini:
 		apiKey := task.PrivateData.Key
 		if apiKey == "" {
-			logger.LogError(c.Request.Context(), fmt.Sprintf("Missing stored API key for Gemini task %s", taskID))
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": gin.H{
-					"message": "API key not stored for task",
-					"type":    "server_error",
-				},
-			})
-			return
+			logger.LogError(ctx, fmt.Sprintf("Missing stored API key for Gemini task %s", taskID))
+			return nil, &videoProxyError{status: http.StatusInternalServerError, message: "API key not stored for task"}
 		}
 
 		videoURL, err = getGeminiVideoURL(channel, task, apiKey)
 		if err != nil {
-			logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to resolve Gemini video URL for task %s: %s", taskID, err.Error()))
-			c.JSON(http.StatusBadGateway, gin.H{
-				"error": gin.H{
-					"message": "Failed to resolve Gemini video URL",
-					"type":    "server_error",
-				},
-			})
-			return
+			logger.LogError(ctx, fmt.Sprintf("Failed to resolve Gemini video URL for task %s: %s", taskID, err.Error()))
+			return nil, &videoProxyError{status: http.StatusBadGateway, message: "Failed to resolve Gemini video URL"}
 		}
 		req.Header.Set("x-goog-api-key", apiKey)
 	case constant.ChannelTypeOpenAI, constant.ChannelTypeSora:
@@ -140,50 +151,20 @@ func VideoProxy(c *gin.Context) {
 
 	req.URL, err = url.Parse(videoURL)
 	if err != nil {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to parse URL %s: %s", videoURL, err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "Failed to create proxy request",
-				"type":    "server_error",
-			},
-		})
-		return
+		logger.LogError(ctx, fmt.Sprintf("Failed to parse URL %s: %s", videoURL, err.Error()))
+		return nil, &videoProxyError{status: http.StatusInternalServerError, message: "Failed to create proxy request"}
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to fetch video from %s: %s", videoURL, err.Error()))
-		c.JSON(http.StatusBadGateway, gin.H{
-			"error": gin.H{
-				"message": "Failed to fetch video content",
-				"type":    "server_error",
-			},
-		})
-		return
+		logger.LogError(ctx, fmt.Sprintf("Failed to fetch video from %s: %s", videoURL, err.Error()))
+		return nil, &videoProxyError{status: http.StatusBadGateway, message: "Failed to fetch video content"}
 	}
-	defer resp.Body.Close()
-
 	if resp.StatusCode != http.StatusOK {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Upstream returned status %d for %s", resp.StatusCode, videoURL))
-		c.JSON(http.StatusBadGateway, gin.H{
-			"error": gin.H{
-				"message": fmt.Sprintf("Upstream service returned status %d", resp.StatusCode),
-				"type":    "server_error",
-			},
-		})
-		return
-	}
-
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Writer.Header().Add(key, value)
-		}
+		resp.Body.Close()
+		logger.LogError(ctx, fmt.Sprintf("Upstream returned status %d for %s", resp.StatusCode, videoURL))
+		return nil, &videoProxyError{status: http.StatusBadGateway, message: fmt.Sprintf("Upstream service returned status %d", resp.StatusCode)}
 	}
 
-	c.Writer.Header().Set("Cache-Control", "public, max-age=86400") // Cache for 24 hours
-	c.Writer.WriteHeader(resp.StatusCode)
-	_, err = io.Copy(c.Writer, resp.Body)
-	if err != nil {
-		logger.LogError(c.Request.Context(), fmt.Sprintf("Failed to stream video content: %s", err.Error()))
-	}
+	return resp, nil
 }
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetVirtualModels 获取虚拟模型列表
+func GetVirtualModels(c *gin.Context) {
+	virtualModels, err := model.GetAllVirtualModels()
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, virtualModels)
+}
+
+// CreateVirtualModel 创建虚拟模型
+func CreateVirtualModel(c *gin.Context) {
+	var vm model.VirtualModel
+	if err := c.ShouldBindJSON(&vm); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if msg := validateVirtualModel(&vm); msg != "" {
+		common.ApiErrorMsg(c, msg)
+		return
+	}
+	if dup, err := model.IsVirtualModelNameDuplicated(0, vm.Name); err != nil {
+		common.ApiError(c, err)
+		return
+	} else if dup {
+		common.ApiErrorMsg(c, "虚拟模型名称已存在")
+		return
+	}
+
+	if err := vm.Insert(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RefreshPricing()
+	common.ApiSuccess(c, &vm)
+}
+
+// UpdateVirtualModel 更新虚拟模型
+func UpdateVirtualModel(c *gin.Context) {
+	var vm model.VirtualModel
+	if err := c.ShouldBindJSON(&vm); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if vm.Id == 0 {
+		common.ApiErrorMsg(c, "缺少虚拟模型 ID")
+		return
+	}
+	if msg := validateVirtualModel(&vm); msg != "" {
+		common.ApiErrorMsg(c, msg)
+		return
+	}
+	if dup, err := model.IsVirtualModelNameDuplicated(vm.Id, vm.Name); err != nil {
+		common.ApiError(c, err)
+		return
+	} else if dup {
+		common.ApiErrorMsg(c, "虚拟模型名称已存在")
+		return
+	}
+	origin, err := model.GetVirtualModelById(vm.Id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	vm.CreatedTime = origin.CreatedTime
+
+	if err := vm.Update(); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RefreshPricing()
+	common.ApiSuccess(c, &vm)
+}
+
+// DeleteVirtualModel 删除虚拟模型
+func DeleteVirtualModel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err := model.DeleteVirtualModelById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RefreshPricing()
+	common.ApiSuccess(c, nil)
+}
+
+// validateVirtualModel 校验虚拟模型配置，返回错误信息，合法时返回空字符串
+func validateVirtualModel(vm *model.VirtualModel) string {
+	vm.Name = strings.TrimSpace(vm.Name)
+	if vm.Name == "" {
+		return "虚拟模型名称不能为空"
+	}
+	switch vm.StickyBy {
+	case model.VirtualModelStickyNone, model.VirtualModelStickyUser, model.VirtualModelStickyToken:
+	default:
+		return "sticky_by 只能为空、user 或 token"
+	}
+	var targets []model.VirtualModelTarget
+	if err := common.UnmarshalJsonStr(vm.Targets, &targets); err != nil {
+		return "目标模型格式错误: " + err.Error()
+	}
+	if len(targets) == 0 {
+		return "至少需要一个目标模型"
+	}
+	if msg := validateVirtualModelTargets(vm.Name, targets); msg != "" {
+		return msg
+	}
+	if strings.TrimSpace(vm.GroupTargets) != "" {
+		var groupTargets map[string][]model.VirtualModelTarget
+		if err := common.UnmarshalJsonStr(vm.GroupTargets, &groupTargets); err != nil {
+			return "分组目标模型格式错误: " + err.Error()
+		}
+		for group, targets := range groupTargets {
+			if msg := validateVirtualModelTargets(vm.Name, targets); msg != "" {
+				return fmt.Sprintf("分组 %s: %s", group, msg)
+			}
+		}
+	}
+	return ""
+}
+
+func validateVirtualModelTargets(name string, targets []model.VirtualModelTarget) string {
+	totalWeight := 0
+	for _, t := range targets {
+		if strings.TrimSpace(t.Model) == "" {
+			return "目标模型名称不能为空"
+		}
+		if t.Model == name {
+			return "目标模型不能是虚拟模型自身"
+		}
+		if t.Weight < 0 {
+			return "目标模型权重不能为负数"
+		}
+		totalWeight += t.Weight
+	}
+	if len(targets) > 0 && totalWeight == 0 {
+		return "目标模型权重之和必须大于 0"
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getWebhookDeliveryQueryParams(c *gin.Context, userId int) model.WebhookDeliveryQueryParams {
+	endpointId, _ := strconv.Atoi(c.Query("endpoint_id"))
+	return model.WebhookDeliveryQueryParams{
+		UserId:     userId,
+		EndpointId: endpointId,
+		EventType:  c.Query("event_type"),
+		Status:     c.Query("status"),
+		SourceId:   c.Query("source_id"),
+	}
+}
+
+func GetAllWebhookEndpoints(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId := -1
+	if c.Query("user_id") != "" {
+		userId, _ = strconv.Atoi(c.Query("user_id"))
+	}
+	endpoints, total, err := model.GetWebhookEndpoints(userId, pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(endpoints)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetSelfWebhookEndpoints(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	endpoints, total, err := model.GetWebhookEndpoints(c.GetInt("id"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(endpoints)
+	common.ApiSuccess(c, pageInfo)
+}
+
+type webhookEndpointStatusRequest struct {
+	Status int `json:"status"`
+}
+
+func updateWebhookEndpointStatus(c *gin.Context, userId int) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req webhookEndpointStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if req.Status != model.WebhookEndpointStatusEnabled && req.Status != model.WebhookEndpointStatusDisabled {
+		common.ApiErrorMsg(c, "无效的状态")
+		return
+	}
+	endpoint, err := model.GetWebhookEndpointById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if userId >= 0 && endpoint.UserId != userId {
+		common.ApiErrorMsg(c, "webhook 地址不存在")
+		return
+	}
+	if err := model.UpdateWebhookEndpointStatus(id, req.Status); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, nil)
+}
+
+func UpdateWebhookEndpointStatus(c *gin.Context) {
+	updateWebhookEndpointStatus(c, -1)
+}
+
+func UpdateSelfWebhookEndpointStatus(c *gin.Context) {
+	updateWebhookEndpointStatus(c, c.GetInt("id"))
+}
+
+func GetAllWebhookDeliveries(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	userId := -1
+	if c.Query("user_id") != "" {
+		userId, _ = strconv.Atoi(c.Query("user_id"))
+	}
+	deliveries, total, err := model.GetWebhookDeliveries(getWebhookDeliveryQueryParams(c, userId), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(deliveries)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func GetSelfWebhookDeliveries(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	deliveries, total, err := model.GetWebhookDeliveries(getWebhookDeliveryQueryParams(c, c.GetInt("id")), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(deliveries)
+	common.ApiSuccess(c, pageInfo)
+}
+
+func replayWebhookDelivery(c *gin.Context, userId int) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if userId >= 0 {
+		delivery, err := model.GetWebhookDeliveryById(id)
+		if err != nil || delivery.UserId != userId {
+			common.ApiError(c, errors.New("投递记录不存在"))
+			return
+		}
+	}
+	delivery, err := service.ReplayWebhookDelivery(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, delivery)
+}
+
+func ReplayWebhookDelivery(c *gin.Context) {
+	replayWebhookDelivery(c, -1)
+}
+
+func ReplaySelfWebhookDelivery(c *gin.Context) {
+	replayWebhookDelivery(c, c.GetInt("id"))
+}
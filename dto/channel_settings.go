@@ -31,6 +31,23 @@ type ChannelOtherSettings struct {
 	DisableStore          bool          `json:"disable_store,omitempty"`           // 是否禁用 store 透传（默认允许透传，禁用后可能导致 Codex 无法使用）
 	AllowSafetyIdentifier bool          `json:"allow_safety_identifier,omitempty"` // 是否允许 safety_identifier 透传（默认过滤以保护用户隐私）
 	AwsKeyType            AwsKeyType    `json:"aws_key_type,omitempty"`
+	KeyRPMLimit           int           `json:"key_rpm_limit,omitempty"` // 多 Key 渠道中每个 key 每分钟的最大请求数，0 表示不限制
+	// 余额监控
+	MinBalance      float64 `json:"min_balance,omitempty"`       // 余额下限，低于该值时降低优先级，0 表示使用全局配置
+	BalanceAdminKey string  `json:"balance_admin_key,omitempty"` // OpenAI / Anthropic 的 Admin Key，用于查询组织用量
+	BalanceBudget   float64 `json:"balance_budget,omitempty"`    // 每月预算（美元），通过 Admin Key 查询时余额为预算减去本月用量
+	// 上游成本，用于统计渠道毛利；ModelCosts 优先于 CostRatio
+	CostRatio  float64                     `json:"cost_ratio,omitempty"`  // 上游成本相对模型价格（不含分组倍率）的倍率，0 表示未配置
+	ModelCosts map[string]ChannelModelCost `json:"model_costs,omitempty"` // 按模型配置的上游实际价格
+	// 请求/响应转换脚本（JavaScript），见 relay/common/script.go
+	TransformScript string `json:"transform_script,omitempty"`
+}
+
+// ChannelModelCost 上游实际价格，单位为美元
+type ChannelModelCost struct {
+	InputPrice  float64 `json:"input_price"`  // 每百万输入 tokens
+	OutputPrice float64 `json:"output_price"` // 每百万输出 tokens
+	CallPrice   float64 `json:"call_price"`   // 每次调用，大于 0 时忽略 tokens 价格
 }
 
 func (s *ChannelOtherSettings) IsOpenRouterEnterprise() bool {
@@ -564,3 +564,99 @@ type GeminiBatchEmbeddingResponse struct {
 type ContentEmbedding struct {
 	Values []float64 `json:"values"`
 }
+
+// Gemini Live (BidiGenerateContent) websocket messages
+// https://ai.google.dev/api/live
+
+type GeminiLiveClientMessage struct {
+	Setup         *GeminiLiveSetup         `json:"setup,omitempty"`
+	ClientContent *GeminiLiveClientContent `json:"clientContent,omitempty"`
+	RealtimeInput *GeminiLiveRealtimeInput `json:"realtimeInput,omitempty"`
+	ToolResponse  *GeminiLiveToolResponse  `json:"toolResponse,omitempty"`
+}
+
+type GeminiLiveSetup struct {
+	Model                    string                      `json:"model"`
+	GenerationConfig         *GeminiChatGenerationConfig `json:"generationConfig,omitempty"`
+	SystemInstruction        *GeminiChatContent          `json:"systemInstruction,omitempty"`
+	Tools                    []GeminiChatTool            `json:"tools,omitempty"`
+	RealtimeInputConfig      *GeminiLiveRealtimeConfig   `json:"realtimeInputConfig,omitempty"`
+	InputAudioTranscription  *struct{}                   `json:"inputAudioTranscription,omitempty"`
+	OutputAudioTranscription *struct{}                   `json:"outputAudioTranscription,omitempty"`
+}
+
+type GeminiLiveRealtimeConfig struct {
+	AutomaticActivityDetection *GeminiLiveActivityDetection `json:"automaticActivityDetection,omitempty"`
+}
+
+type GeminiLiveActivityDetection struct {
+	Disabled bool `json:"disabled"`
+}
+
+type GeminiLiveClientContent struct {
+	Turns        []GeminiChatContent `json:"turns,omitempty"`
+	TurnComplete bool                `json:"turnComplete"`
+}
+
+type GeminiLiveRealtimeInput struct {
+	Audio          *GeminiInlineData `json:"audio,omitempty"`
+	Text           string            `json:"text,omitempty"`
+	ActivityStart  *struct{}         `json:"activityStart,omitempty"`
+	ActivityEnd    *struct{}         `json:"activityEnd,omitempty"`
+	AudioStreamEnd bool              `json:"audioStreamEnd,omitempty"`
+}
+
+type GeminiLiveToolResponse struct {
+	FunctionResponses []GeminiLiveFunctionResponse `json:"functionResponses"`
+}
+
+type GeminiLiveFunctionResponse struct {
+	Id       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type GeminiLiveServerMessage struct {
+	SetupComplete *struct{}                `json:"setupComplete,omitempty"`
+	ServerContent *GeminiLiveServerContent `json:"serverContent,omitempty"`
+	ToolCall      *GeminiLiveToolCall      `json:"toolCall,omitempty"`
+	UsageMetadata *GeminiLiveUsageMetadata `json:"usageMetadata,omitempty"`
+	GoAway        *GeminiLiveGoAway        `json:"goAway,omitempty"`
+}
+
+type GeminiLiveServerContent struct {
+	ModelTurn           *GeminiChatContent       `json:"modelTurn,omitempty"`
+	TurnComplete        bool                     `json:"turnComplete,omitempty"`
+	Interrupted         bool                     `json:"interrupted,omitempty"`
+	GenerationComplete  bool                     `json:"generationComplete,omitempty"`
+	InputTranscription  *GeminiLiveTranscription `json:"inputTranscription,omitempty"`
+	OutputTranscription *GeminiLiveTranscription `json:"outputTranscription,omitempty"`
+}
+
+type GeminiLiveTranscription struct {
+	Text string `json:"text"`
+}
+
+type GeminiLiveToolCall struct {
+	FunctionCalls []GeminiLiveFunctionCall `json:"functionCalls"`
+}
+
+type GeminiLiveFunctionCall struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Args any    `json:"args"`
+}
+
+type GeminiLiveGoAway struct {
+	TimeLeft string `json:"timeLeft"`
+}
+
+type GeminiLiveUsageMetadata struct {
+	PromptTokenCount        int                         `json:"promptTokenCount"`
+	CachedContentTokenCount int                         `json:"cachedContentTokenCount"`
+	ResponseTokenCount      int                         `json:"responseTokenCount"`
+	ThoughtsTokenCount      int                         `json:"thoughtsTokenCount"`
+	TotalTokenCount         int                         `json:"totalTokenCount"`
+	PromptTokensDetails     []GeminiPromptTokensDetails `json:"promptTokensDetails"`
+	ResponseTokensDetails   []GeminiPromptTokensDetails `json:"responseTokensDetails"`
+}
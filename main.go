@@ -92,6 +92,35 @@ func main() {
 	// 数据看板
 	go model.UpdateQuotaData()
 
+	// webhook 投递重试
+	go service.StartWebhookDeliveryTask()
+
+	// 事件总线投递
+	go service.StartEventBusDispatcher()
+
+	// 过期媒体清理
+	go service.StartMediaStorageGC()
+
+	// 输入文件缓存清理
+	go service.StartFileCacheGC()
+
+	// 网关侧 Responses 存储清理
+	go service.StartResponseStoreCleanup()
+
+	// 渠道统计写入、压缩与故障状态校正
+	go service.StartChannelStatsTask()
+
+	// 多 Key 渠道 key 用量写入与自动恢复
+	go service.StartChannelKeyPoolTask()
+
+	// 渠道余额监控与告警
+	go service.StartChannelBalanceMonitorTask()
+
+	// 订阅到期处理
+	if common.IsMasterNode {
+		go service.UpdateUserSubscriptionsTask()
+	}
+
 	if os.Getenv("CHANNEL_UPDATE_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_UPDATE_FREQUENCY"))
 		if err != nil {
@@ -105,10 +134,8 @@ func main() {
 	// Codex credential auto-refresh check every 10 minutes, refresh when expires within 1 day
 	service.StartCodexCredentialAutoRefreshTask()
 
-	if common.IsMasterNode && constant.UpdateTask {
-		gopool.Go(func() {
-			controller.UpdateMidjourneyTaskBulk()
-		})
+	// 异步任务轮询，各节点通过租约分摊任务
+	if constant.UpdateTask {
 		gopool.Go(func() {
 			controller.UpdateTaskBulk()
 		})
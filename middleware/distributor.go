@@ -15,6 +15,7 @@ import (
 	"github.com/QuantumNous/new-api/model"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/types"
 
@@ -97,17 +98,46 @@ func Distribute() func(c *gin.Context) {
 						common.SetContextKey(c, constant.ContextKeyUsingGroup, usingGroup)
 					}
 				}
+				// 语音链路模型没有对应渠道，由控制器按环节分别选择渠道
+				if _, ok := operation_setting.GetSpeechPipeline(modelRequest.Model); ok &&
+					(strings.HasPrefix(c.Request.URL.Path, "/v1/realtime") || strings.HasPrefix(c.Request.URL.Path, "/v1/audio/chat")) {
+					common.SetContextKey(c, constant.ContextKeySpeechPipeline, modelRequest.Model)
+					common.SetContextKey(c, constant.ContextKeyRequestStartTime, time.Now())
+					c.Next()
+					return
+				}
+				// 虚拟模型：按权重解析为真实模型后再选择渠道
+				if targetModel, isVirtual := service.ResolveVirtualModel(c, modelRequest.Model, usingGroup); isVirtual {
+					common.SetContextKey(c, constant.ContextKeyVirtualModel, modelRequest.Model)
+					modelRequest.Model = targetModel
+				}
 				channel, selectGroup, err = service.CacheGetRandomSatisfiedChannel(&service.RetryParam{
 					Ctx:        c,
 					ModelName:  modelRequest.Model,
 					TokenGroup: usingGroup,
 					Retry:      common.GetPointer(0),
 				})
+				if err != nil || channel == nil {
+					// 当前模型无可用渠道时，尝试模型回退链
+					fallbackChannel, fallbackGroup, fallbackModel := service.CacheGetFallbackChannel(c, usingGroup, modelRequest.Model)
+					if fallbackChannel != nil {
+						common.SetContextKey(c, constant.ContextKeyRequestedModel, modelRequest.Model)
+						channel, selectGroup, err = fallbackChannel, fallbackGroup, nil
+						modelRequest.Model = fallbackModel
+					}
+				}
 				if err != nil {
 					showGroup := usingGroup
 					if usingGroup == "auto" {
 						showGroup = fmt.Sprintf("auto(%s)", selectGroup)
 					}
+					var coolingErr *model.ChannelCoolingDownError
+					if errors.As(err, &coolingErr) {
+						// 候选渠道全部在冷却中，告知客户端最早可重试的时间
+						c.Header("Retry-After", strconv.FormatInt(coolingErr.RetryAfter, 10))
+						abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("分组 %s 下模型 %s 的可用渠道均在冷却中，请 %d 秒后重试", showGroup, modelRequest.Model, coolingErr.RetryAfter), types.ErrorCodeGetChannelFailed)
+						return
+					}
 					message := fmt.Sprintf("获取分组 %s 下模型 %s 的可用渠道失败（distributor）: %s", showGroup, modelRequest.Model, err.Error())
 					// 如果错误，但是渠道不为空，说明是数据库一致性问题
 					//if channel != nil {
@@ -287,6 +317,10 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 			}
 			modelRequest.Model = common.GetStringIfEmpty(modelRequest.Model, "whisper-1")
 			relayMode = relayconstant.RelayModeAudioTranscription
+		} else if strings.HasPrefix(c.Request.URL.Path, "/v1/audio/chat") {
+			if req, err := getModelFromRequest(c); err == nil && req.Model != "" {
+				modelRequest.Model = req.Model
+			}
 		}
 		c.Set("relay_mode", relayMode)
 	}
@@ -304,6 +338,16 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 }
 
 func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) *types.NewAPIError {
+	return setupContextForChannel(c, channel, modelName, -1, false)
+}
+
+// SetupContextForSelectedChannelKey 与 SetupContextForSelectedChannel 相同，但多 Key 渠道固定使用指定索引的 key（不论其启用状态），用于渠道测试。
+// keyIndex 小于 0 时按渠道的 key 选择策略选取，测试请求不计入 key 的用量与每分钟请求数
+func SetupContextForSelectedChannelKey(c *gin.Context, channel *model.Channel, modelName string, keyIndex int) *types.NewAPIError {
+	return setupContextForChannel(c, channel, modelName, keyIndex, true)
+}
+
+func setupContextForChannel(c *gin.Context, channel *model.Channel, modelName string, keyIndex int, isChannelTest bool) *types.NewAPIError {
 	c.Set("original_model", modelName) // for retry
 	if channel == nil {
 		return types.NewError(errors.New("channel is nil"), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
@@ -314,18 +358,45 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	common.SetContextKey(c, constant.ContextKeyChannelCreateTime, channel.CreatedTime)
 	common.SetContextKey(c, constant.ContextKeyChannelSetting, channel.GetSetting())
 	common.SetContextKey(c, constant.ContextKeyChannelOtherSetting, channel.GetOtherSettings())
-	common.SetContextKey(c, constant.ContextKeyChannelParamOverride, channel.GetParamOverride())
+	paramOverride := channel.GetParamOverride()
+	modelMapping := channel.GetModelMapping()
+	// 经由渠道池路由时，渠道池的模型映射与参数覆盖先于渠道自身的配置生效
+	pool := model.GetChannelRoutePool(getRoutingGroup(c), modelName, channel.Id)
+	if pool != nil {
+		paramOverride = pool.MergeParamOverride(paramOverride)
+		modelMapping = pool.MergeModelMapping(modelMapping)
+		common.SetContextKey(c, constant.ContextKeyChannelPoolId, pool.Id)
+	} else {
+		common.SetContextKey(c, constant.ContextKeyChannelPoolId, 0)
+	}
+	common.SetContextKey(c, constant.ContextKeyChannelSelectStrategy, model.GetChannelSelectStrategy(getRoutingGroup(c), pool))
+	common.SetContextKey(c, constant.ContextKeyChannelParamOverride, paramOverride)
 	common.SetContextKey(c, constant.ContextKeyChannelHeaderOverride, channel.GetHeaderOverride())
 	if nil != channel.OpenAIOrganization && *channel.OpenAIOrganization != "" {
 		common.SetContextKey(c, constant.ContextKeyChannelOrganization, *channel.OpenAIOrganization)
 	}
 	common.SetContextKey(c, constant.ContextKeyChannelAutoBan, channel.GetAutoBan())
-	common.SetContextKey(c, constant.ContextKeyChannelModelMapping, channel.GetModelMapping())
+	common.SetContextKey(c, constant.ContextKeyChannelModelMapping, modelMapping)
 	common.SetContextKey(c, constant.ContextKeyChannelStatusCodeMapping, channel.GetStatusCodeMapping())
 
-	key, index, newAPIError := channel.GetNextEnabledKey()
-	if newAPIError != nil {
-		return newAPIError
+	var key string
+	var index int
+	if keyIndex >= 0 && channel.ChannelInfo.IsMultiKey {
+		keys := channel.GetKeys()
+		if keyIndex >= len(keys) {
+			return types.NewError(fmt.Errorf("key index %d out of range", keyIndex), types.ErrorCodeChannelNoAvailableKey, types.ErrOptionWithSkipRetry())
+		}
+		key, index = keys[keyIndex], keyIndex
+	} else {
+		var newAPIError *types.NewAPIError
+		if isChannelTest {
+			key, index, newAPIError = channel.GetNextEnabledKeyForChannelTest()
+		} else {
+			key, index, newAPIError = channel.GetNextEnabledKey()
+		}
+		if newAPIError != nil {
+			return newAPIError
+		}
 	}
 	if channel.ChannelInfo.IsMultiKey {
 		common.SetContextKey(c, constant.ContextKeyChannelIsMultiKey, true)
@@ -362,6 +433,15 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	return nil
 }
 
+// getRoutingGroup 选择渠道时使用的分组，auto 分组取实际命中的分组
+func getRoutingGroup(c *gin.Context) string {
+	group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+	if autoGroup := common.GetContextKeyString(c, constant.ContextKeyAutoGroup); group == "auto" && autoGroup != "" {
+		return autoGroup
+	}
+	return group
+}
+
 // extractModelNameFromGeminiPath 从 Gemini API URL 路径中提取模型名
 // 输入格式: /v1beta/models/gemini-2.0-flash:generateContent
 // 输出: gemini-2.0-flash
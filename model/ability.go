@@ -3,10 +3,11 @@ package model
 import (
 	"errors"
 	"fmt"
-	"strings"
 	"sync"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/pkg/balancer"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/samber/lo"
 	"gorm.io/gorm"
@@ -21,6 +22,7 @@ type Ability struct {
 	Priority  *int64  `json:"priority" gorm:"bigint;default:0;index"`
 	Weight    uint    `json:"weight" gorm:"default:0;index"`
 	Tag       *string `json:"tag" gorm:"index"`
+	PoolId    int     `json:"pool_id" gorm:"default:0;index"` // 生成该 ability 的渠道池，0 表示按渠道自身的分组生成
 }
 
 type AbilityWithChannel struct {
@@ -103,7 +105,7 @@ func getChannelQuery(group string, model string, retry int) (*gorm.DB, error) {
 	return channelQuery, nil
 }
 
-func GetChannel(group string, model string, retry int) (*Channel, error) {
+func GetChannel(group string, model string, retry int, hashKey string) (*Channel, error) {
 	var abilities []Ability
 
 	var err error = nil
@@ -119,8 +121,50 @@ func GetChannel(group string, model string, retry int) (*Channel, error) {
 	if err != nil {
 		return nil, err
 	}
-	channel := Channel{}
+	// 跳过冷却中的渠道
 	if len(abilities) > 0 {
+		channelIds := make([]int, 0, len(abilities))
+		for _, ability_ := range abilities {
+			channelIds = append(channelIds, ability_.ChannelId)
+		}
+		available, coolingUntil := filterCoolingChannels(channelIds)
+		if len(available) == 0 {
+			return nil, newChannelCoolingDownError(coolingUntil)
+		}
+		// 余额不足的渠道仅在没有其他渠道时使用
+		if operation_setting.GetBalanceMonitorSetting().Enabled {
+			lowBalance, err := getCachedLowBalance(available)
+			if err != nil {
+				return nil, err
+			}
+			available = deprioritizeLowBalanceChannels(available, func(id int) bool {
+				return lowBalance[id]
+			})
+		}
+		abilities = lo.Filter(abilities, func(ability_ Ability, _ int) bool {
+			return lo.Contains(available, ability_.ChannelId)
+		})
+	}
+	channel := Channel{}
+	strategy := getSelectStrategy(group, lo.Map(abilities, func(ability_ Ability, _ int) int {
+		return ability_.PoolId
+	}), func(id int) *ChannelPool {
+		pool, err := GetChannelPoolById(id)
+		if err != nil {
+			return nil
+		}
+		return pool
+	})
+	if len(abilities) > 0 && strategy != balancer.StrategyRandom {
+		var priority int64
+		if abilities[0].Priority != nil {
+			priority = *abilities[0].Priority
+		}
+		candidates := lo.Map(abilities, func(ability_ Ability, _ int) balancer.Candidate {
+			return balancer.Candidate{Id: ability_.ChannelId, Weight: int(ability_.Weight)}
+		})
+		channel.Id = abilities[selectCandidate(strategy, group, model, priority, hashKey, candidates)].ChannelId
+	} else if len(abilities) > 0 {
 		// Randomly choose one
 		weightSum := uint(0)
 		for _, ability_ := range abilities {
@@ -144,37 +188,19 @@ func GetChannel(group string, model string, retry int) (*Channel, error) {
 }
 
 func (channel *Channel) AddAbilities(tx *gorm.DB) error {
-	models_ := strings.Split(channel.Models, ",")
-	groups_ := strings.Split(channel.Group, ",")
-	abilitySet := make(map[string]struct{})
-	abilities := make([]Ability, 0, len(models_))
-	for _, model := range models_ {
-		for _, group := range groups_ {
-			key := group + "|" + model
-			if _, exists := abilitySet[key]; exists {
-				continue
-			}
-			abilitySet[key] = struct{}{}
-			ability := Ability{
-				Group:     group,
-				Model:     model,
-				ChannelId: channel.Id,
-				Enabled:   channel.Status == common.ChannelStatusEnabled,
-				Priority:  channel.Priority,
-				Weight:    uint(channel.GetWeight()),
-				Tag:       channel.Tag,
-			}
-			abilities = append(abilities, ability)
-		}
-	}
-	if len(abilities) == 0 {
-		return nil
-	}
 	// choose DB or provided tx
 	useDB := DB
 	if tx != nil {
 		useDB = tx
 	}
+	pools, err := getEnabledChannelPools(useDB)
+	if err != nil {
+		return err
+	}
+	abilities := channel.buildAbilities(pools)
+	if len(abilities) == 0 {
+		return nil
+	}
 	for _, chunk := range lo.Chunk(abilities, 50) {
 		err := useDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&chunk).Error
 		if err != nil {
@@ -184,6 +210,15 @@ func (channel *Channel) AddAbilities(tx *gorm.DB) error {
 	return nil
 }
 
+func createAbilities(abilities []Ability) error {
+	for _, chunk := range lo.Chunk(abilities, 50) {
+		if err := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&chunk).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (channel *Channel) DeleteAbilities() error {
 	return DB.Where("channel_id = ?", channel.Id).Delete(&Ability{}).Error
 }
@@ -216,30 +251,14 @@ func (channel *Channel) UpdateAbilities(tx *gorm.DB) error {
 	}
 
 	// Then add new abilities
-	models_ := strings.Split(channel.Models, ",")
-	groups_ := strings.Split(channel.Group, ",")
-	abilitySet := make(map[string]struct{})
-	abilities := make([]Ability, 0, len(models_))
-	for _, model := range models_ {
-		for _, group := range groups_ {
-			key := group + "|" + model
-			if _, exists := abilitySet[key]; exists {
-				continue
-			}
-			abilitySet[key] = struct{}{}
-			ability := Ability{
-				Group:     group,
-				Model:     model,
-				ChannelId: channel.Id,
-				Enabled:   channel.Status == common.ChannelStatusEnabled,
-				Priority:  channel.Priority,
-				Weight:    uint(channel.GetWeight()),
-				Tag:       channel.Tag,
-			}
-			abilities = append(abilities, ability)
+	pools, err := getEnabledChannelPools(tx)
+	if err != nil {
+		if isNewTx {
+			tx.Rollback()
 		}
+		return err
 	}
-
+	abilities := channel.buildAbilities(pools)
 	if len(abilities) > 0 {
 		for _, chunk := range lo.Chunk(abilities, 50) {
 			err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&chunk).Error
@@ -314,6 +333,10 @@ func FixAbility() (int, int, error) {
 	if len(channels) == 0 {
 		return 0, 0, nil
 	}
+	pools, err := getEnabledChannelPools(DB)
+	if err != nil {
+		return 0, 0, err
+	}
 	successCount := 0
 	failCount := 0
 	for _, chunk := range lo.Chunk(channels, 50) {
@@ -327,7 +350,7 @@ func FixAbility() (int, int, error) {
 		}
 		// Then add new abilities
 		for _, channel := range chunk {
-			err = channel.AddAbilities(nil)
+			err = createAbilities(channel.buildAbilities(pools))
 			if err != nil {
 				common.SysLog(fmt.Sprintf("Add abilities for channel %d failed: %s", channel.Id, err.Error()))
 				failCount++
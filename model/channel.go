@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"strings"
 	"sync"
 
@@ -103,6 +104,15 @@ func (channel *Channel) GetKeys() []string {
 }
 
 func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
+	return channel.getNextEnabledKey(true)
+}
+
+// GetNextEnabledKeyForChannelTest 与 GetNextEnabledKey 的选择规则相同，但不计入 key 的用量与每分钟请求数，用于渠道测试
+func (channel *Channel) GetNextEnabledKeyForChannelTest() (string, int, *types.NewAPIError) {
+	return channel.getNextEnabledKey(false)
+}
+
+func (channel *Channel) getNextEnabledKey(markUsed bool) (string, int, *types.NewAPIError) {
 	// If not in multi-key mode, return the original key string directly.
 	if !channel.ChannelInfo.IsMultiKey {
 		return channel.Key, 0, nil
@@ -144,11 +154,36 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 	if len(enabledIdx) == 0 {
 		return "", 0, types.NewError(errors.New("no enabled keys"), types.ErrorCodeChannelNoAvailableKey)
 	}
+	// 跳过冷却中或达到每分钟请求数限制的 key
+	rpmLimit := channel.GetOtherSettings().KeyRPMLimit
+	availableIdx := filterAvailableChannelKeys(channel.Id, enabledIdx, rpmLimit)
+	if len(availableIdx) == 0 {
+		// 整个渠道冷却到最早恢复的 key 可用为止，后续选择渠道时跳过
+		availableAt := channelKeysAvailableAt(channel.Id, enabledIdx, rpmLimit)
+		CoolDownChannel(channel.Id, availableAt)
+		return "", 0, types.NewErrorWithStatusCode(errors.New("all enabled keys are cooling down or rate limited"), types.ErrorCodeChannelNoAvailableKey, http.StatusTooManyRequests,
+			types.ErrOptionWithRetryAfter(newChannelCoolingDownError(availableAt).RetryAfter))
+	}
+	enabledIdx = availableIdx
+	markKeyUsed := func(idx int) {
+		if markUsed {
+			markChannelKeyUsed(channel.Id, idx, rpmLimit)
+		}
+	}
+	available := make(map[int]bool, len(enabledIdx))
+	for _, idx := range enabledIdx {
+		available[idx] = true
+	}
 
 	switch channel.ChannelInfo.MultiKeyMode {
 	case constant.MultiKeyModeRandom:
 		// Randomly pick one enabled key
 		selectedIdx := enabledIdx[rand.Intn(len(enabledIdx))]
+		markKeyUsed(selectedIdx)
+		return keys[selectedIdx], selectedIdx, nil
+	case constant.MultiKeyModeLeastUsed:
+		selectedIdx := leastUsedChannelKey(channel.Id, enabledIdx)
+		markKeyUsed(selectedIdx)
 		return keys[selectedIdx], selectedIdx, nil
 	case constant.MultiKeyModePolling:
 		// Use channel-specific lock to ensure thread-safe polling
@@ -175,16 +210,19 @@ func (channel *Channel) GetNextEnabledKey() (string, int, *types.NewAPIError) {
 		}
 		for i := 0; i < len(keys); i++ {
 			idx := (start + i) % len(keys)
-			if getStatus(idx) == common.ChannelStatusEnabled {
+			if available[idx] {
 				// update polling index for next call (point to the next position)
 				channel.ChannelInfo.MultiKeyPollingIndex = (idx + 1) % len(keys)
+				markKeyUsed(idx)
 				return keys[idx], idx, nil
 			}
 		}
 		// Fallback – should not happen, but return first enabled key
+		markKeyUsed(enabledIdx[0])
 		return keys[enabledIdx[0]], enabledIdx[0], nil
 	default:
 		// Unknown mode, default to first enabled key (or original key string)
+		markKeyUsed(enabledIdx[0])
 		return keys[enabledIdx[0]], enabledIdx[0], nil
 	}
 }
@@ -402,8 +440,23 @@ func BatchDeleteChannels(ids []int) error {
 			tx.Rollback()
 			return err
 		}
+		if err := tx.Where("channel_id in (?)", chunk).Delete(&ChannelTestResult{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Where("channel_id in (?)", chunk).Delete(&ChannelBalanceRecord{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Where("channel_id in (?)", chunk).Delete(&ChannelConfigVersion{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
-	return tx.Commit().Error
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	return DeleteChannelKeyUsages(ids...)
 }
 
 func (channel *Channel) GetPriority() int64 {
@@ -500,6 +553,8 @@ func (channel *Channel) Update() error {
 		return err
 	}
 	DB.Model(channel).First(channel, "id = ?", channel.Id)
+	// 渠道余额下限可能已修改
+	invalidateChannelLowBalance(channel.Id)
 	err = channel.UpdateAbilities(nil)
 	return err
 }
@@ -522,6 +577,7 @@ func (channel *Channel) UpdateBalance(balance float64) {
 	if err != nil {
 		common.SysLog(fmt.Sprintf("failed to update balance: channel_id=%d, error=%v", channel.Id, err))
 	}
+	invalidateChannelLowBalance(channel.Id)
 }
 
 func (channel *Channel) Delete() error {
@@ -531,7 +587,19 @@ func (channel *Channel) Delete() error {
 		return err
 	}
 	err = channel.DeleteAbilities()
-	return err
+	if err != nil {
+		return err
+	}
+	if err = DeleteChannelTestResults(channel.Id); err != nil {
+		return err
+	}
+	if err = DB.Where("channel_id = ?", channel.Id).Delete(&ChannelBalanceRecord{}).Error; err != nil {
+		return err
+	}
+	if err = DeleteChannelConfigVersions(channel.Id); err != nil {
+		return err
+	}
+	return DeleteChannelKeyUsages(channel.Id)
 }
 
 var channelStatusLock sync.Mutex
@@ -679,6 +747,89 @@ func UpdateChannelStatus(channelId int, usingKey string, status int, reason stri
 	return true
 }
 
+// UpdateChannelKeyStatus 按索引更新多 Key 渠道中单个 key 的状态，返回是否发生变化。
+// 所有 key 被禁用时渠道随之自动禁用；因此被自动禁用的渠道在有 key 恢复后重新启用
+func UpdateChannelKeyStatus(channelId int, keyIndex int, status int, reason string) bool {
+	apply := func(channel *Channel) bool {
+		info := &channel.ChannelInfo
+		if keyIndex < 0 || keyIndex >= info.MultiKeySize {
+			return false
+		}
+		current, disabled := info.MultiKeyStatusList[keyIndex]
+		if status == common.ChannelStatusEnabled {
+			if !disabled {
+				return false
+			}
+			delete(info.MultiKeyStatusList, keyIndex)
+			delete(info.MultiKeyDisabledReason, keyIndex)
+			delete(info.MultiKeyDisabledTime, keyIndex)
+			if channel.Status == common.ChannelStatusAutoDisabled {
+				channel.Status = common.ChannelStatusEnabled
+			}
+			return true
+		}
+		if disabled && current == status {
+			return false
+		}
+		if info.MultiKeyStatusList == nil {
+			info.MultiKeyStatusList = make(map[int]int)
+		}
+		if info.MultiKeyDisabledReason == nil {
+			info.MultiKeyDisabledReason = make(map[int]string)
+		}
+		if info.MultiKeyDisabledTime == nil {
+			info.MultiKeyDisabledTime = make(map[int]int64)
+		}
+		info.MultiKeyStatusList[keyIndex] = status
+		info.MultiKeyDisabledReason[keyIndex] = reason
+		info.MultiKeyDisabledTime[keyIndex] = common.GetTimestamp()
+		if len(info.MultiKeyStatusList) >= info.MultiKeySize && channel.Status == common.ChannelStatusEnabled {
+			channel.Status = common.ChannelStatusAutoDisabled
+			otherInfo := channel.GetOtherInfo()
+			otherInfo["status_reason"] = "All keys are disabled"
+			otherInfo["status_time"] = common.GetTimestamp()
+			channel.SetOtherInfo(otherInfo)
+		}
+		return true
+	}
+
+	channel, err := GetChannelById(channelId, true)
+	if err != nil || !channel.ChannelInfo.IsMultiKey {
+		return false
+	}
+	pollingLock := GetChannelPollingLock(channelId)
+	pollingLock.Lock()
+	beforeStatus := channel.Status
+	changed := apply(channel)
+	pollingLock.Unlock()
+	if !changed {
+		return false
+	}
+	if err := channel.SaveWithoutKey(); err != nil {
+		common.SysLog(fmt.Sprintf("failed to update channel key status: channel_id=%d, key_index=%d, status=%d, error=%v", channelId, keyIndex, status, err))
+		return false
+	}
+	if beforeStatus != channel.Status {
+		if err := UpdateAbilityStatus(channelId, channel.Status == common.ChannelStatusEnabled); err != nil {
+			common.SysLog(fmt.Sprintf("failed to update ability status: channel_id=%d, error=%v", channelId, err))
+		}
+	}
+
+	if common.MemoryCacheEnabled {
+		channelStatusLock.Lock()
+		defer channelStatusLock.Unlock()
+		if channelCache, _ := CacheGetChannel(channelId); channelCache != nil {
+			pollingLock.Lock()
+			apply(channelCache)
+			pollingLock.Unlock()
+			if beforeStatus != channel.Status {
+				CacheUpdateChannelStatus(channelId, channel.Status)
+			}
+		}
+	}
+	return true
+}
+
 func EnableChannelByTag(tag string) error {
 	err := DB.Model(&Channel{}).Where("tag = ?", tag).Update("status", common.ChannelStatusEnabled).Error
 	if err != nil {
@@ -734,6 +885,14 @@ func EditChannelByTag(tag string, newTag *string, modelMapping *string, models *
 	if err != nil {
 		return err
 	}
+	// 渠道池可能按标签选择成员并覆盖优先级与权重，存在渠道池时总是重新生成 abilities
+	if !shouldReCreateAbilities {
+		var poolCount int64
+		if err = DB.Model(&ChannelPool{}).Where("status = ?", common.ChannelStatusEnabled).Count(&poolCount).Error; err != nil {
+			return err
+		}
+		shouldReCreateAbilities = poolCount > 0
+	}
 	if shouldReCreateAbilities {
 		channels, err := GetChannelsByTag(updatedTag, false, false)
 		if err == nil {
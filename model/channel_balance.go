@@ -0,0 +1,149 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// BalanceAdminKeyMask 返回给前端的 Admin Key 占位符，保存时原样提交表示不修改
+const BalanceAdminKeyMask = "******"
+
+const balanceAdminKeyField = "balance_admin_key"
+
+// ChannelBalanceRecord 渠道余额历史，每次查询余额时记录一条
+type ChannelBalanceRecord struct {
+	Id          int64    `json:"id"`
+	ChannelId   int      `json:"channel_id" gorm:"index:idx_channel_balance_time,priority:1"`
+	Balance     *float64 `json:"balance"`      // 余额，只有用量窗口的渠道（如 Codex）为 null
+	UsedPercent *float64 `json:"used_percent"` // 用量窗口中已用比例最高的一个（0-100）
+	ResetAt     int64    `json:"reset_at,omitempty" gorm:"bigint"`
+	CreatedAt   int64    `json:"created_at" gorm:"bigint;index:idx_channel_balance_time,priority:2;index"`
+}
+
+func RecordChannelBalance(record *ChannelBalanceRecord) error {
+	return DB.Create(record).Error
+}
+
+// GetChannelBalanceRecords 按时间顺序返回渠道 since 之后的余额历史
+func GetChannelBalanceRecords(channelId int, since int64) ([]*ChannelBalanceRecord, error) {
+	var records []*ChannelBalanceRecord
+	err := DB.Where("channel_id = ? AND created_at >= ?", channelId, since).Order("created_at").Find(&records).Error
+	return records, err
+}
+
+func DeleteChannelBalanceRecordsBefore(before int64) (int64, error) {
+	result := DB.Where("created_at < ?", before).Delete(&ChannelBalanceRecord{})
+	return result.RowsAffected, result.Error
+}
+
+// GetMinBalance 渠道的余额下限，渠道未单独配置时使用全局配置
+func (channel *Channel) GetMinBalance() float64 {
+	if minBalance := channel.GetOtherSettings().MinBalance; minBalance > 0 {
+		return minBalance
+	}
+	return operation_setting.GetBalanceMonitorSetting().MinBalance
+}
+
+// IsBalanceLow 开启余额监控且已查询到的余额低于下限
+func (channel *Channel) IsBalanceLow() bool {
+	if !operation_setting.GetBalanceMonitorSetting().Enabled || channel.BalanceUpdatedTime == 0 {
+		return false
+	}
+	minBalance := channel.GetMinBalance()
+	return minBalance > 0 && channel.Balance < minBalance
+}
+
+// deprioritizeLowBalanceChannels 过滤掉余额不足的渠道，全部余额不足时原样返回，作为兜底继续使用
+func deprioritizeLowBalanceChannels(channelIds []int, isBalanceLow func(id int) bool) []int {
+	if !operation_setting.GetBalanceMonitorSetting().Enabled {
+		return channelIds
+	}
+	available := make([]int, 0, len(channelIds))
+	for _, id := range channelIds {
+		if !isBalanceLow(id) {
+			available = append(available, id)
+		}
+	}
+	if len(available) == 0 {
+		return channelIds
+	}
+	return available
+}
+
+// 未开启内存缓存时渠道选择使用的余额状态缓存：渠道 id -> 余额是否不足。
+// 每隔 SyncFrequency 整体失效，本节点更新余额或渠道设置时立即失效对应渠道
+var (
+	channelLowBalanceLock     sync.RWMutex
+	channelLowBalance         = make(map[int]bool)
+	channelLowBalanceLoadedAt int64
+)
+
+// getCachedLowBalance 返回渠道余额是否不足，缓存中缺失的渠道一次性从数据库加载
+func getCachedLowBalance(channelIds []int) (map[int]bool, error) {
+	now := time.Now().Unix()
+	result := make(map[int]bool, len(channelIds))
+	missing := make([]int, 0)
+	channelLowBalanceLock.RLock()
+	expired := now-channelLowBalanceLoadedAt >= int64(common.SyncFrequency)
+	for _, id := range channelIds {
+		if low, ok := channelLowBalance[id]; ok && !expired {
+			result[id] = low
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	channelLowBalanceLock.RUnlock()
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	var channels []*Channel
+	if err := DB.Select("id", "balance", "balance_updated_time", "settings").Where("id in (?)", missing).Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	channelLowBalanceLock.Lock()
+	defer channelLowBalanceLock.Unlock()
+	if now-channelLowBalanceLoadedAt >= int64(common.SyncFrequency) {
+		channelLowBalance = make(map[int]bool)
+		channelLowBalanceLoadedAt = now
+	}
+	for _, channel := range channels {
+		result[channel.Id] = channel.IsBalanceLow()
+		channelLowBalance[channel.Id] = result[channel.Id]
+	}
+	return result, nil
+}
+
+// invalidateChannelLowBalance 渠道余额或设置变更后清除缓存的余额状态
+func invalidateChannelLowBalance(channelId int) {
+	channelLowBalanceLock.Lock()
+	defer channelLowBalanceLock.Unlock()
+	delete(channelLowBalance, channelId)
+}
+
+// MaskSecretSettings 隐藏渠道设置中的 Admin Key，渠道列表与详情不返回明文
+func (channel *Channel) MaskSecretSettings() {
+	if channel.OtherSettings == "" || gjson.Get(channel.OtherSettings, balanceAdminKeyField).String() == "" {
+		return
+	}
+	if masked, err := sjson.Set(channel.OtherSettings, balanceAdminKeyField, BalanceAdminKeyMask); err == nil {
+		channel.OtherSettings = masked
+	}
+}
+
+// RestoreMaskedSettings 提交的 Admin Key 为占位符时恢复为 origin 中保存的值
+func (channel *Channel) RestoreMaskedSettings(origin *Channel) {
+	if channel.OtherSettings == "" || gjson.Get(channel.OtherSettings, balanceAdminKeyField).String() != BalanceAdminKeyMask {
+		return
+	}
+	restored, err := sjson.Set(channel.OtherSettings, balanceAdminKeyField, gjson.Get(origin.OtherSettings, balanceAdminKeyField).String())
+	if err == nil {
+		channel.OtherSettings = restored
+	}
+}
@@ -3,19 +3,30 @@ package model
 import (
 	"errors"
 	"fmt"
-	"math/rand"
+	"slices"
 	"sort"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/pkg/balancer"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/samber/lo"
 )
 
-var group2model2channels map[string]map[string][]int // enabled channel
-var channelsIDM map[int]*Channel                     // all channels include disabled
+// channelRoute 渠道在某个分组 + 模型下的路由信息，来自 abilities
+type channelRoute struct {
+	channelId int
+	priority  int64
+	weight    int
+	poolId    int
+}
+
+var group2model2channels map[string]map[string][]channelRoute // enabled channel
+var channelsIDM map[int]*Channel                              // all channels include disabled
+var channelPoolsIDM map[int]*ChannelPool
 var channelSyncLock sync.RWMutex
 
 func InitChannelCache() {
@@ -28,39 +39,42 @@ func InitChannelCache() {
 	for _, channel := range channels {
 		newChannelId2channel[channel.Id] = channel
 	}
+	newChannelPoolsIDM := make(map[int]*ChannelPool)
+	var pools []*ChannelPool
+	DB.Find(&pools)
+	for _, pool := range pools {
+		newChannelPoolsIDM[pool.Id] = pool
+	}
+	// 分组 + 模型到渠道的路由由 abilities 生成，abilities 中已包含渠道池覆盖后的优先级与权重
 	var abilities []*Ability
 	DB.Find(&abilities)
-	groups := make(map[string]bool)
+	newGroup2model2channels := make(map[string]map[string][]channelRoute)
 	for _, ability := range abilities {
-		groups[ability.Group] = true
-	}
-	newGroup2model2channels := make(map[string]map[string][]int)
-	for group := range groups {
-		newGroup2model2channels[group] = make(map[string][]int)
-	}
-	for _, channel := range channels {
-		if channel.Status != common.ChannelStatusEnabled {
+		channel, ok := newChannelId2channel[ability.ChannelId]
+		if !ok || channel.Status != common.ChannelStatusEnabled {
 			continue // skip disabled channels
 		}
-		groups := strings.Split(channel.Group, ",")
-		for _, group := range groups {
-			models := strings.Split(channel.Models, ",")
-			for _, model := range models {
-				if _, ok := newGroup2model2channels[group][model]; !ok {
-					newGroup2model2channels[group][model] = make([]int, 0)
-				}
-				newGroup2model2channels[group][model] = append(newGroup2model2channels[group][model], channel.Id)
-			}
+		if _, ok := newGroup2model2channels[ability.Group]; !ok {
+			newGroup2model2channels[ability.Group] = make(map[string][]channelRoute)
+		}
+		route := channelRoute{
+			channelId: ability.ChannelId,
+			weight:    int(ability.Weight),
+			poolId:    ability.PoolId,
 		}
+		if ability.Priority != nil {
+			route.priority = *ability.Priority
+		}
+		newGroup2model2channels[ability.Group][ability.Model] = append(newGroup2model2channels[ability.Group][ability.Model], route)
 	}
 
 	// sort by priority
 	for group, model2channels := range newGroup2model2channels {
-		for model, channels := range model2channels {
-			sort.Slice(channels, func(i, j int) bool {
-				return newChannelId2channel[channels[i]].GetPriority() > newChannelId2channel[channels[j]].GetPriority()
+		for model, routes := range model2channels {
+			sort.SliceStable(routes, func(i, j int) bool {
+				return routes[i].priority > routes[j].priority
 			})
-			newGroup2model2channels[group][model] = channels
+			newGroup2model2channels[group][model] = routes
 		}
 	}
 
@@ -81,6 +95,7 @@ func InitChannelCache() {
 		}
 	}
 	channelsIDM = newChannelId2channel
+	channelPoolsIDM = newChannelPoolsIDM
 	channelSyncLock.Unlock()
 	common.SysLog("channels synced from database")
 }
@@ -93,101 +108,122 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+// GetRandomSatisfiedChannel 按重试次数选择优先级，再按分组或渠道池配置的策略在该优先级内选择渠道，
+// hashKey 用于一致性哈希策略，通常为用户 id
+func GetRandomSatisfiedChannel(group string, model string, retry int, hashKey string) (*Channel, error) {
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetChannel(group, model, retry)
+		return GetChannel(group, model, retry, hashKey)
 	}
 
 	channelSyncLock.RLock()
 	defer channelSyncLock.RUnlock()
 
-	// First, try to find channels with the exact model name.
-	channels := group2model2channels[group][model]
-
-	// If no channels found, try to find channels with the normalized model name.
-	if len(channels) == 0 {
-		normalizedModel := ratio_setting.FormatMatchingModelName(model)
-		channels = group2model2channels[group][normalizedModel]
+	routes := getCachedChannelRoutes(group, model)
+	if len(routes) == 0 {
+		return nil, nil
+	}
+	channels := make([]int, 0, len(routes))
+	for _, route := range routes {
+		channels = append(channels, route.channelId)
 	}
 
+	// 跳过冷却中的渠道
+	channels, coolingUntil := filterCoolingChannels(channels)
 	if len(channels) == 0 {
-		return nil, nil
+		return nil, newChannelCoolingDownError(coolingUntil)
 	}
+	// 余额不足的渠道仅在没有其他渠道时使用
+	channels = deprioritizeLowBalanceChannels(channels, func(id int) bool {
+		channel, ok := channelsIDM[id]
+		return ok && channel.IsBalanceLow()
+	})
+	routes = lo.Filter(routes, func(route channelRoute, _ int) bool {
+		return slices.Contains(channels, route.channelId)
+	})
 
-	if len(channels) == 1 {
-		if channel, ok := channelsIDM[channels[0]]; ok {
+	if len(routes) == 1 {
+		if channel, ok := channelsIDM[routes[0].channelId]; ok {
 			return channel, nil
 		}
-		return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channels[0])
+		return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", routes[0].channelId)
 	}
 
-	uniquePriorities := make(map[int]bool)
-	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
-			uniquePriorities[int(channel.GetPriority())] = true
-		} else {
-			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
+	uniquePriorities := make(map[int64]bool)
+	for _, route := range routes {
+		if _, ok := channelsIDM[route.channelId]; !ok {
+			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", route.channelId)
 		}
+		uniquePriorities[route.priority] = true
 	}
-	var sortedUniquePriorities []int
-	for priority := range uniquePriorities {
-		sortedUniquePriorities = append(sortedUniquePriorities, priority)
-	}
-	sort.Sort(sort.Reverse(sort.IntSlice(sortedUniquePriorities)))
+	sortedUniquePriorities := lo.Keys(uniquePriorities)
+	sort.Slice(sortedUniquePriorities, func(i, j int) bool {
+		return sortedUniquePriorities[i] > sortedUniquePriorities[j]
+	})
 
 	if retry >= len(uniquePriorities) {
 		retry = len(uniquePriorities) - 1
 	}
-	targetPriority := int64(sortedUniquePriorities[retry])
+	targetPriority := sortedUniquePriorities[retry]
 
 	// get the priority for the given retry number
-	var sumWeight = 0
-	var targetChannels []*Channel
-	for _, channelId := range channels {
-		if channel, ok := channelsIDM[channelId]; ok {
-			if channel.GetPriority() == targetPriority {
-				sumWeight += channel.GetWeight()
-				targetChannels = append(targetChannels, channel)
-			}
-		} else {
-			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
+	var targetRoutes []channelRoute
+	for _, route := range routes {
+		if route.priority == targetPriority {
+			targetRoutes = append(targetRoutes, route)
 		}
 	}
 
-	if len(targetChannels) == 0 {
+	if len(targetRoutes) == 0 {
 		return nil, errors.New(fmt.Sprintf("no channel found, group: %s, model: %s, priority: %d", group, model, targetPriority))
 	}
 
-	// smoothing factor and adjustment
-	smoothingFactor := 1
-	smoothingAdjustment := 0
-
-	if sumWeight == 0 {
-		// when all channels have weight 0, set sumWeight to the number of channels and set smoothing adjustment to 100
-		// each channel's effective weight = 100
-		sumWeight = len(targetChannels) * 100
-		smoothingAdjustment = 100
-	} else if sumWeight/len(targetChannels) < 10 {
-		// when the average weight is less than 10, set smoothing factor to 100
-		smoothingFactor = 100
+	strategy := getSelectStrategy(group, lo.Map(targetRoutes, func(route channelRoute, _ int) int {
+		return route.poolId
+	}), func(id int) *ChannelPool {
+		return channelPoolsIDM[id]
+	})
+	candidates := lo.Map(targetRoutes, func(route channelRoute, _ int) balancer.Candidate {
+		return balancer.Candidate{Id: route.channelId, Weight: route.weight}
+	})
+	selected := selectCandidate(strategy, group, model, targetPriority, hashKey, candidates)
+	if selected < 0 {
+		return nil, errors.New("channel not found")
 	}
+	return channelsIDM[targetRoutes[selected].channelId], nil
+}
 
-	// Calculate the total weight of all channels up to endIdx
-	totalWeight := sumWeight * smoothingFactor
-
-	// Generate a random value in the range [0, totalWeight)
-	randomWeight := rand.Intn(totalWeight)
+// getCachedChannelRoutes 先按模型名精确匹配，没有时按标准化后的模型名匹配，调用方需持有 channelSyncLock
+func getCachedChannelRoutes(group string, model string) []channelRoute {
+	routes := group2model2channels[group][model]
+	if len(routes) == 0 {
+		routes = group2model2channels[group][ratio_setting.FormatMatchingModelName(model)]
+	}
+	return routes
+}
 
-	// Find a channel based on its weight
-	for _, channel := range targetChannels {
-		randomWeight -= channel.GetWeight()*smoothingFactor + smoothingAdjustment
-		if randomWeight < 0 {
-			return channel, nil
+// GetChannelRoutePool 返回渠道在分组 + 模型下所属的渠道池，不是由渠道池生成的路由时返回 nil
+func GetChannelRoutePool(group string, model string, channelId int) *ChannelPool {
+	if !common.MemoryCacheEnabled {
+		var ability Ability
+		err := DB.Where(commonGroupCol+" = ? and model = ? and channel_id = ?", group, model, channelId).First(&ability).Error
+		if err != nil || ability.PoolId == 0 {
+			return nil
+		}
+		pool, err := GetChannelPoolById(ability.PoolId)
+		if err != nil {
+			return nil
+		}
+		return pool
+	}
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	for _, route := range getCachedChannelRoutes(group, model) {
+		if route.channelId == channelId && route.poolId > 0 {
+			return channelPoolsIDM[route.poolId]
 		}
 	}
-	// return null if no channel is not found
-	return nil, errors.New("channel not found")
+	return nil
 }
 
 func CacheGetChannel(id int) (*Channel, error) {
@@ -234,11 +270,11 @@ func CacheUpdateChannelStatus(id int, status int) {
 	if status != common.ChannelStatusEnabled {
 		// delete the channel from group2model2channels
 		for group, model2channels := range group2model2channels {
-			for model, channels := range model2channels {
-				for i, channelId := range channels {
-					if channelId == id {
+			for model, routes := range model2channels {
+				for i, route := range routes {
+					if route.channelId == id {
 						// remove the channel from the slice
-						group2model2channels[group][model] = append(channels[:i], channels[i+1:]...)
+						group2model2channels[group][model] = append(routes[:i], routes[i+1:]...)
 						break
 					}
 				}
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 每个渠道最多保留的配置版本数，超出后删除最旧的版本
+const channelConfigVersionLimit = 50
+
+// 并发记录版本时版本号冲突的最大重试次数
+const channelConfigVersionRetries = 5
+
+// ChannelConfigVersion 渠道请求改写相关配置（模型映射、参数覆盖、请求头覆盖）的历史版本
+type ChannelConfigVersion struct {
+	Id             int     `json:"id"`
+	ChannelId      int     `json:"channel_id" gorm:"uniqueIndex:idx_channel_config_version,priority:1"`
+	Version        int     `json:"version" gorm:"uniqueIndex:idx_channel_config_version,priority:2"`
+	ModelMapping   *string `json:"model_mapping" gorm:"type:text"`
+	ParamOverride  *string `json:"param_override" gorm:"type:text"`
+	HeaderOverride *string `json:"header_override" gorm:"type:text"`
+	OperatorId     int     `json:"operator_id"`
+	Note           string  `json:"note" gorm:"type:varchar(255)"`
+	CreatedTime    int64   `json:"created_time" gorm:"bigint"`
+}
+
+func stringPtrValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// SameConfig 判断两个版本的配置内容是否一致，nil 与空字符串视为相同
+func (v *ChannelConfigVersion) SameConfig(other *ChannelConfigVersion) bool {
+	return stringPtrValue(v.ModelMapping) == stringPtrValue(other.ModelMapping) &&
+		stringPtrValue(v.ParamOverride) == stringPtrValue(other.ParamOverride) &&
+		stringPtrValue(v.HeaderOverride) == stringPtrValue(other.HeaderOverride)
+}
+
+func newChannelConfigVersion(channel *Channel) *ChannelConfigVersion {
+	return &ChannelConfigVersion{
+		ChannelId:      channel.Id,
+		ModelMapping:   channel.ModelMapping,
+		ParamOverride:  channel.ParamOverride,
+		HeaderOverride: channel.HeaderOverride,
+	}
+}
+
+// GetLatestChannelConfigVersion 返回渠道最新的配置版本，没有历史时返回 nil
+func GetLatestChannelConfigVersion(channelId int) (*ChannelConfigVersion, error) {
+	var versions []*ChannelConfigVersion
+	err := DB.Where("channel_id = ?", channelId).Order("version desc").Limit(1).Find(&versions).Error
+	if err != nil || len(versions) == 0 {
+		return nil, err
+	}
+	return versions[0], nil
+}
+
+// RecordChannelConfigVersion 记录渠道当前的配置，与最新版本相同时不会新增版本。
+// 返回 nil 表示配置没有变化。(channel_id, version) 唯一，并发记录时版本号冲突的一方重新读取最新版本后重试
+func RecordChannelConfigVersion(channel *Channel, operatorId int, note string) (*ChannelConfigVersion, error) {
+	for attempt := 1; ; attempt++ {
+		version, conflict, err := recordChannelConfigVersion(channel, operatorId, note)
+		if !conflict || attempt >= channelConfigVersionRetries {
+			return version, err
+		}
+	}
+}
+
+// recordChannelConfigVersion 按最新版本号加一写入新版本，写入失败且该版本号已被占用时返回 conflict
+func recordChannelConfigVersion(channel *Channel, operatorId int, note string) (*ChannelConfigVersion, bool, error) {
+	latest, err := GetLatestChannelConfigVersion(channel.Id)
+	if err != nil {
+		return nil, false, err
+	}
+	version := newChannelConfigVersion(channel)
+	if latest != nil && latest.SameConfig(version) {
+		return nil, false, nil
+	}
+	version.Version = 1
+	if latest != nil {
+		version.Version = latest.Version + 1
+	}
+	version.OperatorId = operatorId
+	version.Note = note
+	version.CreatedTime = common.GetTimestamp()
+	if err = DB.Create(version).Error; err != nil {
+		var count int64
+		if countErr := DB.Model(&ChannelConfigVersion{}).Where("channel_id = ? AND version = ?", channel.Id, version.Version).
+			Count(&count).Error; countErr == nil && count > 0 {
+			return nil, true, err
+		}
+		return nil, false, err
+	}
+	if version.Version > channelConfigVersionLimit {
+		err = DB.Where("channel_id = ? AND version <= ?", channel.Id, version.Version-channelConfigVersionLimit).
+			Delete(&ChannelConfigVersion{}).Error
+		if err != nil {
+			common.SysLog("failed to prune channel config versions: " + err.Error())
+		}
+	}
+	return version, false, nil
+}
+
+// EnsureChannelConfigBaseline 修改配置前调用，没有历史版本时先记录修改前的配置，保证可以回滚到最初状态
+func EnsureChannelConfigBaseline(channel *Channel) error {
+	latest, err := GetLatestChannelConfigVersion(channel.Id)
+	if err != nil || latest != nil {
+		return err
+	}
+	_, err = RecordChannelConfigVersion(channel, 0, "baseline")
+	return err
+}
+
+// GetChannelConfigVersions 按版本号倒序返回渠道的配置历史
+func GetChannelConfigVersions(channelId int) ([]*ChannelConfigVersion, error) {
+	var versions []*ChannelConfigVersion
+	err := DB.Where("channel_id = ?", channelId).Order("version desc").Find(&versions).Error
+	return versions, err
+}
+
+func GetChannelConfigVersion(channelId int, version int) (*ChannelConfigVersion, error) {
+	var v ChannelConfigVersion
+	err := DB.Where("channel_id = ? AND version = ?", channelId, version).First(&v).Error
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// RollbackChannelConfig 将渠道的配置恢复为指定版本，并记录为一个新版本
+func RollbackChannelConfig(channelId int, version int, operatorId int) (*ChannelConfigVersion, error) {
+	target, err := GetChannelConfigVersion(channelId, version)
+	if err != nil {
+		return nil, err
+	}
+	channel, err := GetChannelById(channelId, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = EnsureChannelConfigBaseline(channel); err != nil {
+		return nil, err
+	}
+	err = DB.Model(&Channel{}).Where("id = ?", channelId).
+		Select("model_mapping", "param_override", "header_override").
+		Updates(&Channel{
+			ModelMapping:   target.ModelMapping,
+			ParamOverride:  target.ParamOverride,
+			HeaderOverride: target.HeaderOverride,
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+	channel.ModelMapping = target.ModelMapping
+	channel.ParamOverride = target.ParamOverride
+	channel.HeaderOverride = target.HeaderOverride
+	return RecordChannelConfigVersion(channel, operatorId, "rollback to v"+strconv.Itoa(version))
+}
+
+func DeleteChannelConfigVersions(channelIds ...int) error {
+	if len(channelIds) == 0 {
+		return nil
+	}
+	return DB.Where("channel_id in (?)", channelIds).Delete(&ChannelConfigVersion{}).Error
+}
@@ -0,0 +1,54 @@
+package model
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestRecordChannelConfigVersion_RetriesOnVersionConflict(t *testing.T) {
+	common.RedisEnabled = false
+	common.IsMasterNode = true
+	common.SQLitePath = filepath.Join(t.TempDir(), "channel-config-version.db")
+	require.NoError(t, InitDB())
+
+	const channelId = 1
+	first := `{"gpt-4":"a"}`
+	_, err := RecordChannelConfigVersion(&Channel{Id: channelId, ModelMapping: &first}, 1, "")
+	require.NoError(t, err)
+
+	// 读取最新版本之后、写入之前，另一个请求抢先写入了同一版本号
+	competing := `{"gpt-4":"b"}`
+	injected := false
+	require.NoError(t, DB.Callback().Create().Before("gorm:begin_transaction").Register("test:competing_version", func(tx *gorm.DB) {
+		if injected {
+			return
+		}
+		if _, ok := tx.Statement.Dest.(*ChannelConfigVersion); !ok {
+			return
+		}
+		injected = true
+		require.NoError(t, DB.Create(&ChannelConfigVersion{ChannelId: channelId, Version: 2, ModelMapping: &competing}).Error)
+	}))
+	t.Cleanup(func() { _ = DB.Callback().Create().Remove("test:competing_version") })
+
+	mine := `{"gpt-4":"c"}`
+	version, err := RecordChannelConfigVersion(&Channel{Id: channelId, ModelMapping: &mine}, 1, "")
+	require.NoError(t, err)
+	require.True(t, injected)
+	require.Equal(t, 3, version.Version)
+
+	versions, err := GetChannelConfigVersions(channelId)
+	require.NoError(t, err)
+	require.Len(t, versions, 3)
+	require.Equal(t, mine, *versions[0].ModelMapping)
+	require.Equal(t, competing, *versions[1].ModelMapping)
+
+	// 同一版本号无法重复写入
+	err = DB.Create(&ChannelConfigVersion{ChannelId: channelId, Version: 1, ModelMapping: &first}).Error
+	require.Error(t, err)
+}
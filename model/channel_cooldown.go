@@ -0,0 +1,150 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ChannelCoolingDownError 候选渠道全部处于冷却中
+type ChannelCoolingDownError struct {
+	RetryAfter int64 // 最早恢复的渠道还需等待的秒数
+}
+
+func (e *ChannelCoolingDownError) Error() string {
+	return fmt.Sprintf("all channels are cooling down, retry after %d seconds", e.RetryAfter)
+}
+
+const channelCooldownKeyPrefix = "channel_cooldown:"
+
+// 启用 Redis 时渠道冷却状态在各节点间共享：key 的值为冷却结束时间，过期时间即剩余冷却时长。
+// 未启用 Redis 时仅保存在当前节点内存中：渠道 id -> 冷却结束时间（秒级时间戳）
+var (
+	channelCooldownLock sync.RWMutex
+	channelCooldowns    = make(map[int]int64)
+)
+
+// coolDownChannelScript 仅当新的结束时间晚于当前值时写入，并按剩余冷却时长设置过期时间
+var coolDownChannelScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+if tonumber(ARGV[1]) > current then
+	redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+end
+return 0
+`)
+
+// CoolDownChannel 让渠道在 until 之前不参与选择，已在冷却中时取较晚的结束时间
+func CoolDownChannel(channelId int, until int64) {
+	if common.RedisEnabled {
+		ttl := until - time.Now().Unix()
+		if ttl <= 0 {
+			return
+		}
+		key := channelCooldownKeyPrefix + strconv.Itoa(channelId)
+		if err := coolDownChannelScript.Run(context.Background(), common.RDB, []string{key}, until, ttl).Err(); err != nil {
+			common.SysLog(fmt.Sprintf("failed to set channel cooldown: channel_id=%d, error=%v", channelId, err))
+		}
+		return
+	}
+	channelCooldownLock.Lock()
+	defer channelCooldownLock.Unlock()
+	if until > channelCooldowns[channelId] {
+		channelCooldowns[channelId] = until
+	}
+}
+
+// getChannelCooldowns 返回渠道的冷却结束时间，未在冷却中的渠道不在结果中
+func getChannelCooldowns(channelIds []int) map[int]int64 {
+	now := time.Now().Unix()
+	cooldowns := make(map[int]int64)
+	if !common.RedisEnabled {
+		channelCooldownLock.RLock()
+		defer channelCooldownLock.RUnlock()
+		for _, id := range channelIds {
+			if until := channelCooldowns[id]; until > now {
+				cooldowns[id] = until
+			}
+		}
+		return cooldowns
+	}
+	if len(channelIds) == 0 {
+		return cooldowns
+	}
+	keys := make([]string, len(channelIds))
+	for i, id := range channelIds {
+		keys[i] = channelCooldownKeyPrefix + strconv.Itoa(id)
+	}
+	values, err := common.RDB.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		// 读取失败时不跳过任何渠道
+		common.SysLog("failed to get channel cooldowns: " + err.Error())
+		return cooldowns
+	}
+	for i, value := range values {
+		if v, ok := value.(string); ok {
+			if until, _ := strconv.ParseInt(v, 10, 64); until > now {
+				cooldowns[channelIds[i]] = until
+			}
+		}
+	}
+	return cooldowns
+}
+
+// GetChannelCooldown 返回渠道的冷却结束时间，未在冷却中时返回 0
+func GetChannelCooldown(channelId int) int64 {
+	return getChannelCooldowns([]int{channelId})[channelId]
+}
+
+// filterCoolingChannels 过滤掉冷却中的渠道；全部处于冷却中时返回最早的冷却结束时间
+func filterCoolingChannels(channelIds []int) ([]int, int64) {
+	cooldowns := getChannelCooldowns(channelIds)
+	available := make([]int, 0, len(channelIds))
+	var earliest int64
+	for _, id := range channelIds {
+		until, cooling := cooldowns[id]
+		if !cooling {
+			available = append(available, id)
+			continue
+		}
+		if earliest == 0 || until < earliest {
+			earliest = until
+		}
+	}
+	if len(available) > 0 {
+		return available, 0
+	}
+	return available, earliest
+}
+
+func newChannelCoolingDownError(until int64) *ChannelCoolingDownError {
+	return &ChannelCoolingDownError{RetryAfter: max(until-time.Now().Unix(), 1)}
+}
+
+// RefreshChannelKeyCooldown 多 Key 渠道的已启用 key 全部处于冷却（或达到请求数限制）时，让整个渠道冷却到最早恢复的 key 可用为止
+func RefreshChannelKeyCooldown(channelId int) {
+	channel, err := CacheGetChannel(channelId)
+	if err != nil || !channel.ChannelInfo.IsMultiKey {
+		return
+	}
+	pollingLock := GetChannelPollingLock(channelId)
+	pollingLock.Lock()
+	enabledIdx := make([]int, 0, channel.ChannelInfo.MultiKeySize)
+	for i := range channel.GetKeys() {
+		if _, disabled := channel.ChannelInfo.MultiKeyStatusList[i]; !disabled {
+			enabledIdx = append(enabledIdx, i)
+		}
+	}
+	pollingLock.Unlock()
+	if len(enabledIdx) == 0 {
+		return
+	}
+	if availableAt := channelKeysAvailableAt(channelId, enabledIdx, channel.GetOtherSettings().KeyRPMLimit); availableAt > time.Now().Unix() {
+		CoolDownChannel(channelId, availableAt)
+	}
+}
@@ -0,0 +1,214 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"gorm.io/gorm"
+)
+
+// ChannelCostData 渠道收入与上游成本的按小时聚合数据，用于毛利统计
+type ChannelCostData struct {
+	Id        int    `json:"id"`
+	ChannelId int    `json:"channel_id" gorm:"index:idx_ccd_channel_created,priority:1"`
+	ModelName string `json:"model_name" gorm:"size:64;default:''"`
+	Group     string `json:"group" gorm:"size:64;default:''"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index:idx_ccd_channel_created,priority:2;index"`
+	TokenUsed int    `json:"token_used" gorm:"default:0"`
+	Count     int    `json:"count" gorm:"default:0"`
+	Quota     int    `json:"quota" gorm:"default:0"` // 向用户收取的额度
+	Cost      int    `json:"cost" gorm:"default:0"`  // 上游成本（额度单位）
+}
+
+// CalculateChannelCost 根据渠道配置的上游价格计算一次请求的成本（额度单位）
+// groupRatio 为本次请求的分组倍率，用于将收取的额度还原为模型价格；未配置成本时返回 false
+func CalculateChannelCost(channelId int, modelName string, promptTokens int, completionTokens int, quota int, groupRatio float64) (int, bool) {
+	channel, err := CacheGetChannel(channelId)
+	if err != nil || channel == nil {
+		return 0, false
+	}
+	settings := channel.GetOtherSettings()
+	if len(settings.ModelCosts) > 0 {
+		modelCost, ok := settings.ModelCosts[modelName]
+		if !ok {
+			modelCost, ok = settings.ModelCosts[ratio_setting.FormatMatchingModelName(modelName)]
+		}
+		if ok {
+			var usd float64
+			if modelCost.CallPrice > 0 {
+				usd = modelCost.CallPrice
+			} else {
+				usd = (float64(promptTokens)*modelCost.InputPrice + float64(completionTokens)*modelCost.OutputPrice) / 1000000
+			}
+			return int(math.Round(usd * common.QuotaPerUnit)), true
+		}
+	}
+	if settings.CostRatio > 0 {
+		if groupRatio <= 0 {
+			// 分组倍率为 0 时无法还原模型价格
+			return 0, true
+		}
+		return int(math.Round(float64(quota) / groupRatio * settings.CostRatio)), true
+	}
+	return 0, false
+}
+
+var cacheChannelCostData = make(map[string]*ChannelCostData)
+var cacheChannelCostDataLock = sync.Mutex{}
+
+func LogChannelCostData(channelId int, modelName string, group string, quota int, cost int, createdAt int64, tokenUsed int) {
+	// 只精确到小时
+	createdAt = createdAt - (createdAt % 3600)
+
+	cacheChannelCostDataLock.Lock()
+	defer cacheChannelCostDataLock.Unlock()
+	key := fmt.Sprintf("%d-%s-%s-%d", channelId, modelName, group, createdAt)
+	data, ok := cacheChannelCostData[key]
+	if ok {
+		data.Count += 1
+		data.Quota += quota
+		data.Cost += cost
+		data.TokenUsed += tokenUsed
+	} else {
+		data = &ChannelCostData{
+			ChannelId: channelId,
+			ModelName: modelName,
+			Group:     group,
+			CreatedAt: createdAt,
+			Count:     1,
+			Quota:     quota,
+			Cost:      cost,
+			TokenUsed: tokenUsed,
+		}
+	}
+	cacheChannelCostData[key] = data
+}
+
+func SaveChannelCostDataCache() {
+	cacheChannelCostDataLock.Lock()
+	defer cacheChannelCostDataLock.Unlock()
+	for _, data := range cacheChannelCostData {
+		query := DB.Model(&ChannelCostData{}).Where("channel_id = ? and model_name = ? and "+commonGroupCol+" = ? and created_at = ?",
+			data.ChannelId, data.ModelName, data.Group, data.CreatedAt)
+		result := query.Updates(map[string]interface{}{
+			"count":      gorm.Expr("count + ?", data.Count),
+			"quota":      gorm.Expr("quota + ?", data.Quota),
+			"cost":       gorm.Expr("cost + ?", data.Cost),
+			"token_used": gorm.Expr("token_used + ?", data.TokenUsed),
+		})
+		if result.Error != nil {
+			common.SysLog(fmt.Sprintf("SaveChannelCostDataCache error: %s", result.Error))
+			continue
+		}
+		if result.RowsAffected == 0 {
+			if err := DB.Create(data).Error; err != nil {
+				common.SysLog(fmt.Sprintf("SaveChannelCostDataCache error: %s", err))
+			}
+		}
+	}
+	cacheChannelCostData = make(map[string]*ChannelCostData)
+}
+
+const (
+	ChannelCostGroupByChannel = "channel"
+	ChannelCostGroupByModel   = "model"
+	ChannelCostGroupByGroup   = "group"
+	ChannelCostGroupByDay     = "day"
+)
+
+// ChannelCostReportItem 毛利报表的一行
+type ChannelCostReportItem struct {
+	ChannelId   int     `json:"channel_id,omitempty"`
+	ChannelName string  `json:"channel_name,omitempty"`
+	ModelName   string  `json:"model_name,omitempty"`
+	Group       string  `json:"group,omitempty"`
+	Day         string  `json:"day,omitempty"`
+	Count       int     `json:"count"`
+	TokenUsed   int     `json:"token_used"`
+	Revenue     int     `json:"revenue"`
+	Cost        int     `json:"cost"`
+	Margin      int     `json:"margin"`
+	MarginRate  float64 `json:"margin_rate"`
+}
+
+type ChannelCostReportFilter struct {
+	StartTimestamp int64
+	EndTimestamp   int64
+	ChannelId      int
+	ModelName      string
+	Group          string
+}
+
+// GetChannelCostReport 按渠道/模型/分组/天汇总收入、成本与毛利
+func GetChannelCostReport(filter ChannelCostReportFilter, groupBy string) ([]*ChannelCostReportItem, error) {
+	tx := DB.Model(&ChannelCostData{}).Where("created_at >= ? and created_at <= ?", filter.StartTimestamp, filter.EndTimestamp)
+	if filter.ChannelId != 0 {
+		tx = tx.Where("channel_id = ?", filter.ChannelId)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name = ?", filter.ModelName)
+	}
+	if filter.Group != "" {
+		tx = tx.Where(commonGroupCol+" = ?", filter.Group)
+	}
+	var rows []*ChannelCostData
+	if err := tx.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]*ChannelCostReportItem)
+	for _, row := range rows {
+		var key string
+		item := &ChannelCostReportItem{}
+		switch groupBy {
+		case ChannelCostGroupByModel:
+			key = row.ModelName
+			item.ModelName = row.ModelName
+		case ChannelCostGroupByGroup:
+			key = row.Group
+			item.Group = row.Group
+		case ChannelCostGroupByDay:
+			key = time.Unix(row.CreatedAt, 0).Format("2006-01-02")
+			item.Day = key
+		default:
+			key = fmt.Sprintf("%d", row.ChannelId)
+			item.ChannelId = row.ChannelId
+		}
+		if existing, ok := items[key]; ok {
+			item = existing
+		} else {
+			items[key] = item
+		}
+		item.Count += row.Count
+		item.TokenUsed += row.TokenUsed
+		item.Revenue += row.Quota
+		item.Cost += row.Cost
+	}
+
+	result := make([]*ChannelCostReportItem, 0, len(items))
+	for _, item := range items {
+		item.Margin = item.Revenue - item.Cost
+		if item.Revenue > 0 {
+			item.MarginRate = float64(item.Margin) / float64(item.Revenue)
+		}
+		if item.ChannelId != 0 {
+			if channel, err := CacheGetChannel(item.ChannelId); err == nil && channel != nil {
+				item.ChannelName = channel.Name
+			}
+		}
+		result = append(result, item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if groupBy == ChannelCostGroupByDay {
+			return result[i].Day < result[j].Day
+		}
+		return result[i].Revenue > result[j].Revenue
+	})
+	return result, nil
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+)
+
+// ChannelIncident 渠道故障记录，由自动禁用产生，渠道（或 key）恢复启用后结束
+type ChannelIncident struct {
+	Id         int    `json:"id"`
+	ChannelId  int    `json:"channel_id" gorm:"index"`
+	KeyIndex   int    `json:"key_index"` // -1 表示整个渠道不可用，否则为多 Key 渠道中被禁用的 key
+	Reason     string `json:"reason" gorm:"type:text"`
+	StartedAt  int64  `json:"started_at" gorm:"bigint;index"`
+	ResolvedAt int64  `json:"resolved_at" gorm:"bigint;index"` // 0 表示尚未恢复
+}
+
+// OpenChannelIncident 记录故障，同一渠道（key）已有未结束的故障时不重复记录
+func OpenChannelIncident(channelId int, keyIndex int, reason string) error {
+	var count int64
+	err := DB.Model(&ChannelIncident{}).Where("channel_id = ? AND key_index = ? AND resolved_at = 0", channelId, keyIndex).Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	return DB.Create(&ChannelIncident{
+		ChannelId: channelId,
+		KeyIndex:  keyIndex,
+		Reason:    reason,
+		StartedAt: common.GetTimestamp(),
+	}).Error
+}
+
+// ResolveChannelIncidents 结束渠道指定 key 的故障，includeChannel 为 true 时同时结束渠道级故障
+func ResolveChannelIncidents(channelId int, keyIndex int, includeChannel bool) error {
+	query := DB.Model(&ChannelIncident{}).Where("channel_id = ? AND resolved_at = 0", channelId)
+	if includeChannel {
+		query = query.Where("key_index = ? OR key_index = -1", keyIndex)
+	} else {
+		query = query.Where("key_index = ?", keyIndex)
+	}
+	return query.Update("resolved_at", common.GetTimestamp()).Error
+}
+
+func GetOpenChannelIncidents() ([]*ChannelIncident, error) {
+	var incidents []*ChannelIncident
+	err := DB.Where("resolved_at = 0").Find(&incidents).Error
+	return incidents, err
+}
+
+func ResolveChannelIncidentById(id int) error {
+	return DB.Model(&ChannelIncident{}).Where("id = ? AND resolved_at = 0", id).Update("resolved_at", common.GetTimestamp()).Error
+}
+
+// GetChannelIncidentsSince 查询 since 之后仍在持续或已结束的故障，channelId 为 0 时查询所有渠道，
+// channelOnly 为 true 时只查询渠道级故障
+func GetChannelIncidentsSince(channelId int, since int64, channelOnly bool) ([]*ChannelIncident, error) {
+	var incidents []*ChannelIncident
+	query := DB.Where("resolved_at = 0 OR resolved_at >= ?", since)
+	if channelId > 0 {
+		query = query.Where("channel_id = ?", channelId)
+	}
+	if channelOnly {
+		query = query.Where("key_index = -1")
+	}
+	err := query.Order("started_at desc").Find(&incidents).Error
+	return incidents, err
+}
+
+func DeleteChannelIncidentsBefore(before int64) error {
+	return DB.Where("resolved_at > 0 AND resolved_at < ?", before).Delete(&ChannelIncident{}).Error
+}
@@ -0,0 +1,518 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ChannelKeyUsage 多 Key 渠道中单个 key 的累计用量
+type ChannelKeyUsage struct {
+	Id           int   `json:"-"`
+	ChannelId    int   `json:"channel_id" gorm:"uniqueIndex:idx_channel_key_usage,priority:1"`
+	KeyIndex     int   `json:"key_index" gorm:"uniqueIndex:idx_channel_key_usage,priority:2"`
+	RequestCount int64 `json:"request_count"`
+	TokenCount   int64 `json:"token_count"`
+	Quota        int64 `json:"quota"`
+	LastUsedAt   int64 `json:"last_used_at" gorm:"bigint"`
+}
+
+type channelKeyRef struct {
+	channelId int
+	keyIndex  int
+}
+
+// channelKeyState key 的运行时状态，未启用 Redis 时使用，仅保存在当前节点内存中
+type channelKeyState struct {
+	cooldownUntil  int64
+	cooldownReason string
+	recent         []int64 // 最近一分钟内的请求时间，用于 RPM 限制
+}
+
+// key 的用量先在内存中累加，定期以增量方式写入数据库；requestTotals 为数据库中累计请求数的快照，用于 least_used 选择
+var (
+	channelKeyPoolLock      sync.Mutex
+	channelKeyStates        = make(map[channelKeyRef]*channelKeyState)
+	channelKeyUsagePending  = make(map[channelKeyRef]*ChannelKeyUsage)
+	channelKeyRequestTotals = make(map[channelKeyRef]int64)
+)
+
+// 启用 Redis 时 key 的冷却与每分钟请求数在各节点间共享：
+// 每个渠道一个冷却 hash（key index -> "结束时间:原因"），过期时间为其中最晚的结束时间；
+// 每个渠道每个自然分钟一个计数 hash（key index -> 请求数），两分钟后过期
+const (
+	channelKeyCooldownKeyPrefix = "channel_key_cooldown:"
+	channelKeyRPMKeyPrefix      = "channel_key_rpm:"
+	channelKeyRPMKeyTTL         = 2 * time.Minute
+)
+
+func channelKeyCooldownKey(channelId int) string {
+	return channelKeyCooldownKeyPrefix + strconv.Itoa(channelId)
+}
+
+func channelKeyRPMKey(channelId int, minute int64) string {
+	return fmt.Sprintf("%s%d:%d", channelKeyRPMKeyPrefix, channelId, minute)
+}
+
+// coolDownChannelKeyScript 仅当新的结束时间晚于当前值时写入，并保证 hash 的过期时间不早于该结束时间
+var coolDownChannelKeyScript = redis.NewScript(`
+local current = redis.call("HGET", KEYS[1], ARGV[1])
+if current then
+	local until = tonumber(string.match(current, "^(%d+)"))
+	if until and until >= tonumber(ARGV[2]) then
+		return 0
+	end
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2] .. ":" .. ARGV[3])
+if redis.call("TTL", KEYS[1]) < tonumber(ARGV[4]) then
+	redis.call("EXPIRE", KEYS[1], ARGV[4])
+end
+return 1
+`)
+
+func parseChannelKeyCooldown(value string) (int64, string) {
+	untilStr, reason, _ := strings.Cut(value, ":")
+	until, _ := strconv.ParseInt(untilStr, 10, 64)
+	return until, reason
+}
+
+func getChannelKeyState(ref channelKeyRef) *channelKeyState {
+	state, ok := channelKeyStates[ref]
+	if !ok {
+		state = &channelKeyState{}
+		channelKeyStates[ref] = state
+	}
+	return state
+}
+
+func getPendingChannelKeyUsage(ref channelKeyRef) *ChannelKeyUsage {
+	usage, ok := channelKeyUsagePending[ref]
+	if !ok {
+		usage = &ChannelKeyUsage{ChannelId: ref.channelId, KeyIndex: ref.keyIndex}
+		channelKeyUsagePending[ref] = usage
+	}
+	return usage
+}
+
+// CoolDownChannelKey 让 key 在 until（秒级时间戳）之前不参与选择，已在冷却中时取较晚的结束时间
+func CoolDownChannelKey(channelId int, keyIndex int, until int64, reason string) {
+	if common.RedisEnabled {
+		ttl := until - time.Now().Unix()
+		if ttl <= 0 {
+			return
+		}
+		err := coolDownChannelKeyScript.Run(context.Background(), common.RDB, []string{channelKeyCooldownKey(channelId)}, keyIndex, until, reason, ttl).Err()
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to set channel key cooldown: channel_id=%d, key_index=%d, error=%v", channelId, keyIndex, err))
+		}
+		return
+	}
+	channelKeyPoolLock.Lock()
+	defer channelKeyPoolLock.Unlock()
+	state := getChannelKeyState(channelKeyRef{channelId, keyIndex})
+	if until > state.cooldownUntil {
+		state.cooldownUntil = until
+		state.cooldownReason = reason
+	}
+}
+
+// GetChannelKeyCooldown 返回 key 的冷却结束时间与原因，未在冷却中时返回 0
+func GetChannelKeyCooldown(channelId int, keyIndex int) (int64, string) {
+	now := time.Now().Unix()
+	if common.RedisEnabled {
+		value, err := common.RDB.HGet(context.Background(), channelKeyCooldownKey(channelId), strconv.Itoa(keyIndex)).Result()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) {
+				common.SysLog(fmt.Sprintf("failed to get channel key cooldown: channel_id=%d, key_index=%d, error=%v", channelId, keyIndex, err))
+			}
+			return 0, ""
+		}
+		if until, reason := parseChannelKeyCooldown(value); until > now {
+			return until, reason
+		}
+		return 0, ""
+	}
+	channelKeyPoolLock.Lock()
+	defer channelKeyPoolLock.Unlock()
+	state, ok := channelKeyStates[channelKeyRef{channelId, keyIndex}]
+	if !ok || state.cooldownUntil <= now {
+		return 0, ""
+	}
+	return state.cooldownUntil, state.cooldownReason
+}
+
+// getChannelKeysAvailableAt 返回候选 key 各自可用的时间（冷却结束或每分钟请求数恢复），当前可用的 key 为 now
+func getChannelKeysAvailableAt(channelId int, candidates []int, rpmLimit int, now int64) map[int]int64 {
+	availableAt := make(map[int]int64, len(candidates))
+	for _, idx := range candidates {
+		availableAt[idx] = now
+	}
+	if common.RedisEnabled {
+		fields := make([]string, len(candidates))
+		for i, idx := range candidates {
+			fields[i] = strconv.Itoa(idx)
+		}
+		ctx := context.Background()
+		minute := now / 60
+		pipe := common.RDB.Pipeline()
+		cooldownCmd := pipe.HMGet(ctx, channelKeyCooldownKey(channelId), fields...)
+		var rpmCmd *redis.SliceCmd
+		if rpmLimit > 0 {
+			rpmCmd = pipe.HMGet(ctx, channelKeyRPMKey(channelId, minute), fields...)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			// 读取失败时不跳过任何 key
+			common.SysLog(fmt.Sprintf("failed to get channel key states: channel_id=%d, error=%v", channelId, err))
+			return availableAt
+		}
+		for i, value := range cooldownCmd.Val() {
+			if v, ok := value.(string); ok {
+				until, _ := parseChannelKeyCooldown(v)
+				availableAt[candidates[i]] = max(availableAt[candidates[i]], until)
+			}
+		}
+		if rpmCmd != nil {
+			for i, value := range rpmCmd.Val() {
+				if v, ok := value.(string); ok {
+					if count, _ := strconv.Atoi(v); count >= rpmLimit {
+						availableAt[candidates[i]] = max(availableAt[candidates[i]], (minute+1)*60)
+					}
+				}
+			}
+		}
+		return availableAt
+	}
+	channelKeyPoolLock.Lock()
+	defer channelKeyPoolLock.Unlock()
+	for _, idx := range candidates {
+		state, ok := channelKeyStates[channelKeyRef{channelId, idx}]
+		if !ok {
+			continue
+		}
+		availableAt[idx] = max(availableAt[idx], state.cooldownUntil)
+		if rpmLimit > 0 {
+			state.recent = pruneRecentRequests(state.recent, now)
+			if len(state.recent) >= rpmLimit {
+				availableAt[idx] = max(availableAt[idx], state.recent[len(state.recent)-rpmLimit]+60)
+			}
+		}
+	}
+	return availableAt
+}
+
+// filterAvailableChannelKeys 过滤掉冷却中或已达到每分钟请求数限制的 key
+func filterAvailableChannelKeys(channelId int, candidates []int, rpmLimit int) []int {
+	now := time.Now().Unix()
+	availableAt := getChannelKeysAvailableAt(channelId, candidates, rpmLimit, now)
+	available := make([]int, 0, len(candidates))
+	for _, idx := range candidates {
+		if availableAt[idx] <= now {
+			available = append(available, idx)
+		}
+	}
+	return available
+}
+
+// channelKeysAvailableAt 返回候选 key 中最早可用的时间，有 key 当前可用时返回当前时间
+func channelKeysAvailableAt(channelId int, candidates []int, rpmLimit int) int64 {
+	availableAt := getChannelKeysAvailableAt(channelId, candidates, rpmLimit, time.Now().Unix())
+	var earliest int64
+	for _, idx := range candidates {
+		if earliest == 0 || availableAt[idx] < earliest {
+			earliest = availableAt[idx]
+		}
+	}
+	return earliest
+}
+
+func pruneRecentRequests(recent []int64, now int64) []int64 {
+	i := 0
+	for i < len(recent) && recent[i] <= now-60 {
+		i++
+	}
+	return recent[i:]
+}
+
+// leastUsedChannelKey 选择累计请求数（数据库快照加本节点未写入的部分）最少的 key
+func leastUsedChannelKey(channelId int, candidates []int) int {
+	channelKeyPoolLock.Lock()
+	defer channelKeyPoolLock.Unlock()
+	selected := candidates[0]
+	var selectedCount int64 = -1
+	for _, idx := range candidates {
+		ref := channelKeyRef{channelId, idx}
+		count := channelKeyRequestTotals[ref]
+		if pending, ok := channelKeyUsagePending[ref]; ok {
+			count += pending.RequestCount
+		}
+		if selectedCount < 0 || count < selectedCount {
+			selected, selectedCount = idx, count
+		}
+	}
+	return selected
+}
+
+// markChannelKeyUsed 记录一次 key 被选中，rpmLimit 大于 0 时同时记录请求时间
+func markChannelKeyUsed(channelId int, keyIndex int, rpmLimit int) {
+	now := time.Now().Unix()
+	if rpmLimit > 0 && common.RedisEnabled {
+		ctx := context.Background()
+		key := channelKeyRPMKey(channelId, now/60)
+		pipe := common.RDB.TxPipeline()
+		pipe.HIncrBy(ctx, key, strconv.Itoa(keyIndex), 1)
+		pipe.Expire(ctx, key, channelKeyRPMKeyTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysLog(fmt.Sprintf("failed to increase channel key rpm: channel_id=%d, key_index=%d, error=%v", channelId, keyIndex, err))
+		}
+	}
+	channelKeyPoolLock.Lock()
+	defer channelKeyPoolLock.Unlock()
+	ref := channelKeyRef{channelId, keyIndex}
+	usage := getPendingChannelKeyUsage(ref)
+	usage.RequestCount++
+	usage.LastUsedAt = now
+	if rpmLimit > 0 && !common.RedisEnabled {
+		state := getChannelKeyState(ref)
+		state.recent = append(pruneRecentRequests(state.recent, now), now)
+	}
+}
+
+// RecordChannelKeyConsumption 记录 key 消耗的 tokens 与额度
+func RecordChannelKeyConsumption(channelId int, keyIndex int, tokens int, quota int) {
+	channelKeyPoolLock.Lock()
+	defer channelKeyPoolLock.Unlock()
+	usage := getPendingChannelKeyUsage(channelKeyRef{channelId, keyIndex})
+	usage.TokenCount += int64(tokens)
+	usage.Quota += int64(quota)
+}
+
+func increaseChannelKeyUsage(tx *gorm.DB, usage *ChannelKeyUsage) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "channel_id"}, {Name: "key_index"}},
+		// 列名需带表名，否则 PostgreSQL 会报 column reference is ambiguous
+		DoUpdates: clause.Assignments(map[string]any{
+			"request_count": gorm.Expr("channel_key_usages.request_count + ?", usage.RequestCount),
+			"token_count":   gorm.Expr("channel_key_usages.token_count + ?", usage.TokenCount),
+			"quota":         gorm.Expr("channel_key_usages.quota + ?", usage.Quota),
+			"last_used_at":  gorm.Expr("CASE WHEN channel_key_usages.last_used_at > ? THEN channel_key_usages.last_used_at ELSE ? END", usage.LastUsedAt, usage.LastUsedAt),
+		}),
+	}).Create(usage).Error
+}
+
+// FlushChannelKeyUsages 将内存中的 key 用量写入数据库并刷新累计请求数快照，写入失败的部分保留到下次重试
+func FlushChannelKeyUsages() {
+	channelKeyPoolLock.Lock()
+	pending := channelKeyUsagePending
+	channelKeyUsagePending = make(map[channelKeyRef]*ChannelKeyUsage)
+	channelKeyPoolLock.Unlock()
+
+	for ref, usage := range pending {
+		if err := increaseChannelKeyUsage(DB, usage); err != nil {
+			common.SysLog(fmt.Sprintf("failed to save channel key usage: channel_id=%d, key_index=%d, error=%v", ref.channelId, ref.keyIndex, err))
+			channelKeyPoolLock.Lock()
+			current := getPendingChannelKeyUsage(ref)
+			current.RequestCount += usage.RequestCount
+			current.TokenCount += usage.TokenCount
+			current.Quota += usage.Quota
+			current.LastUsedAt = max(current.LastUsedAt, usage.LastUsedAt)
+			channelKeyPoolLock.Unlock()
+		}
+	}
+
+	var usages []*ChannelKeyUsage
+	if err := DB.Select("channel_id, key_index, request_count").Find(&usages).Error; err != nil {
+		common.SysLog("failed to load channel key usages: " + err.Error())
+		return
+	}
+	totals := make(map[channelKeyRef]int64, len(usages))
+	for _, usage := range usages {
+		totals[channelKeyRef{usage.ChannelId, usage.KeyIndex}] = usage.RequestCount
+	}
+	channelKeyPoolLock.Lock()
+	channelKeyRequestTotals = totals
+	channelKeyPoolLock.Unlock()
+}
+
+// GetChannelKeyUsages 查询渠道各 key 的累计用量（含本节点尚未写入的部分），key index -> usage
+func GetChannelKeyUsages(channelId int) (map[int]*ChannelKeyUsage, error) {
+	var usages []*ChannelKeyUsage
+	if err := DB.Where("channel_id = ?", channelId).Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[int]*ChannelKeyUsage, len(usages))
+	for _, usage := range usages {
+		result[usage.KeyIndex] = usage
+	}
+	channelKeyPoolLock.Lock()
+	defer channelKeyPoolLock.Unlock()
+	for ref, pending := range channelKeyUsagePending {
+		if ref.channelId != channelId {
+			continue
+		}
+		usage, ok := result[ref.keyIndex]
+		if !ok {
+			usage = &ChannelKeyUsage{ChannelId: channelId, KeyIndex: ref.keyIndex}
+			result[ref.keyIndex] = usage
+		}
+		usage.RequestCount += pending.RequestCount
+		usage.TokenCount += pending.TokenCount
+		usage.Quota += pending.Quota
+		usage.LastUsedAt = max(usage.LastUsedAt, pending.LastUsedAt)
+	}
+	return result, nil
+}
+
+// RemapChannelKeys 删除或重排 key 后同步迁移用量与运行时状态，mapping 为旧索引 -> 新索引，不在 mapping 中的 key 视为已删除
+func RemapChannelKeys(channelId int, mapping map[int]int) error {
+	FlushChannelKeyUsages()
+
+	var usages []*ChannelKeyUsage
+	if err := DB.Where("channel_id = ?", channelId).Find(&usages).Error; err != nil {
+		return err
+	}
+	kept := make([]*ChannelKeyUsage, 0, len(usages))
+	for _, usage := range usages {
+		if newIndex, ok := mapping[usage.KeyIndex]; ok {
+			usage.Id = 0
+			usage.KeyIndex = newIndex
+			kept = append(kept, usage)
+		}
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("channel_id = ?", channelId).Delete(&ChannelKeyUsage{}).Error; err != nil {
+			return err
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		return tx.Create(&kept).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if common.RedisEnabled {
+		keys := []string{channelKeyCooldownKey(channelId), channelKeyRPMKey(channelId, time.Now().Unix()/60)}
+		for _, key := range keys {
+			if err := remapRedisChannelKeyHash(key, mapping); err != nil {
+				common.SysLog(fmt.Sprintf("failed to remap channel key states: key=%s, error=%v", key, err))
+			}
+		}
+	}
+	channelKeyPoolLock.Lock()
+	defer channelKeyPoolLock.Unlock()
+	remap := func(states map[channelKeyRef]*channelKeyState) map[channelKeyRef]*channelKeyState {
+		result := make(map[channelKeyRef]*channelKeyState, len(states))
+		for ref, state := range states {
+			if ref.channelId == channelId {
+				newIndex, ok := mapping[ref.keyIndex]
+				if !ok {
+					continue
+				}
+				ref.keyIndex = newIndex
+			}
+			result[ref] = state
+		}
+		return result
+	}
+	channelKeyStates = remap(channelKeyStates)
+	for ref := range channelKeyRequestTotals {
+		if ref.channelId == channelId {
+			delete(channelKeyRequestTotals, ref)
+		}
+	}
+	for _, usage := range kept {
+		channelKeyRequestTotals[channelKeyRef{channelId, usage.KeyIndex}] = usage.RequestCount
+	}
+	return nil
+}
+
+// remapRedisChannelKeyHash 按 mapping 迁移以 key index 为字段的 hash，保留剩余的过期时间
+func remapRedisChannelKeyHash(key string, mapping map[int]int) error {
+	ctx := context.Background()
+	values, err := common.RDB.HGetAll(ctx, key).Result()
+	if err != nil || len(values) == 0 {
+		return err
+	}
+	ttl, err := common.RDB.TTL(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	remapped := make(map[string]any, len(values))
+	for field, value := range values {
+		idx, err := strconv.Atoi(field)
+		if err != nil {
+			continue
+		}
+		if newIndex, ok := mapping[idx]; ok {
+			remapped[strconv.Itoa(newIndex)] = value
+		}
+	}
+	pipe := common.RDB.TxPipeline()
+	pipe.Del(ctx, key)
+	if len(remapped) > 0 && ttl > 0 {
+		pipe.HSet(ctx, key, remapped)
+		pipe.Expire(ctx, key, ttl)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// DeleteChannelKeyUsages 删除渠道的 key 用量与运行时状态
+func DeleteChannelKeyUsages(channelIds ...int) error {
+	if len(channelIds) == 0 {
+		return nil
+	}
+	channelKeyPoolLock.Lock()
+	deleted := make(map[int]bool, len(channelIds))
+	for _, id := range channelIds {
+		deleted[id] = true
+	}
+	for ref := range channelKeyStates {
+		if deleted[ref.channelId] {
+			delete(channelKeyStates, ref)
+		}
+	}
+	for ref := range channelKeyUsagePending {
+		if deleted[ref.channelId] {
+			delete(channelKeyUsagePending, ref)
+		}
+	}
+	channelKeyPoolLock.Unlock()
+	if common.RedisEnabled {
+		minute := time.Now().Unix() / 60
+		keys := make([]string, 0, len(channelIds)*2)
+		for _, id := range channelIds {
+			keys = append(keys, channelKeyCooldownKey(id), channelKeyRPMKey(id, minute))
+		}
+		if err := common.RDB.Del(context.Background(), keys...).Err(); err != nil {
+			common.SysLog("failed to delete channel key states: " + err.Error())
+		}
+	}
+	return DB.Where("channel_id in (?)", channelIds).Delete(&ChannelKeyUsage{}).Error
+}
+
+// GetMultiKeyChannels 查询所有多 Key 渠道（不含 key）
+func GetMultiKeyChannels() ([]*Channel, error) {
+	var channels []*Channel
+	if err := DB.Select("id, name, status, channel_info").Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	multiKeyChannels := make([]*Channel, 0)
+	for _, channel := range channels {
+		if channel.ChannelInfo.IsMultiKey {
+			multiKeyChannels = append(multiKeyChannels, channel)
+		}
+	}
+	return multiKeyChannels, nil
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newKeyPoolTestChannel(id int, keys string, otherSettings string) *Channel {
+	channel := &Channel{Id: id, Key: keys, OtherSettings: otherSettings}
+	channel.ChannelInfo.IsMultiKey = true
+	channel.ChannelInfo.MultiKeyMode = constant.MultiKeyModeLeastUsed
+	return channel
+}
+
+func resetChannelKeyPoolState(channelId int) {
+	channelKeyPoolLock.Lock()
+	defer channelKeyPoolLock.Unlock()
+	for ref := range channelKeyStates {
+		if ref.channelId == channelId {
+			delete(channelKeyStates, ref)
+		}
+	}
+	for ref := range channelKeyUsagePending {
+		if ref.channelId == channelId {
+			delete(channelKeyUsagePending, ref)
+		}
+	}
+}
+
+// expireChannelKeyState 模拟时间流逝：冷却结束时间与最近请求时间整体提前 seconds 秒
+func expireChannelKeyState(channelId int, keyIndex int, seconds int64) {
+	channelKeyPoolLock.Lock()
+	defer channelKeyPoolLock.Unlock()
+	state := getChannelKeyState(channelKeyRef{channelId, keyIndex})
+	state.cooldownUntil -= seconds
+	for i := range state.recent {
+		state.recent[i] -= seconds
+	}
+}
+
+func TestGetNextEnabledKey_SkipsCoolingAndRateLimitedKeys(t *testing.T) {
+	common.RedisEnabled = false
+	const channelId = 910001
+	t.Cleanup(func() { resetChannelKeyPoolState(channelId) })
+	channel := newKeyPoolTestChannel(channelId, "k0\nk1\nk2", `{"key_rpm_limit":1}`)
+	channel.ChannelInfo.MultiKeyStatusList = map[int]int{1: common.ChannelStatusManuallyDisabled}
+
+	now := time.Now().Unix()
+	CoolDownChannelKey(channelId, 0, now+60, "status_code=429")
+	// 冷却只会延长不会缩短
+	CoolDownChannelKey(channelId, 0, now+30, "ignored")
+	until, reason := GetChannelKeyCooldown(channelId, 0)
+	require.Equal(t, now+60, until)
+	require.Equal(t, "status_code=429", reason)
+
+	// key 0 冷却中、key 1 已禁用，只能选中 key 2
+	key, idx, apiErr := channel.GetNextEnabledKey()
+	require.Nil(t, apiErr)
+	require.Equal(t, "k2", key)
+	require.Equal(t, 2, idx)
+
+	// key 2 达到每分钟请求数限制后没有可用 key，整个渠道冷却到最早恢复的 key 可用为止
+	_, _, apiErr = channel.GetNextEnabledKey()
+	require.NotNil(t, apiErr)
+	require.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	require.Greater(t, apiErr.RetryAfter, int64(0))
+	require.InDelta(t, now+60, GetChannelCooldown(channelId), 1)
+
+	// 冷却结束后 key 0 恢复可用
+	expireChannelKeyState(channelId, 0, 61)
+	until, _ = GetChannelKeyCooldown(channelId, 0)
+	require.Zero(t, until)
+	key, idx, apiErr = channel.GetNextEnabledKey()
+	require.Nil(t, apiErr)
+	require.Equal(t, "k0", key)
+	require.Equal(t, 0, idx)
+
+	// 一分钟后 key 2 的请求数限制恢复，刚被选中的 key 0 仍受限制
+	expireChannelKeyState(channelId, 2, 61)
+	require.Equal(t, []int{2}, filterAvailableChannelKeys(channelId, []int{0, 2}, 1))
+	key, _, apiErr = channel.GetNextEnabledKey()
+	require.Nil(t, apiErr)
+	require.Equal(t, "k2", key)
+}
+
+func TestGetNextEnabledKeyForChannelTest_DoesNotCountUsage(t *testing.T) {
+	common.RedisEnabled = false
+	const channelId = 910002
+	t.Cleanup(func() { resetChannelKeyPoolState(channelId) })
+	channel := newKeyPoolTestChannel(channelId, "k0", `{"key_rpm_limit":1}`)
+
+	// 渠道测试不消耗每分钟请求数，也不计入 key 用量
+	for i := 0; i < 3; i++ {
+		key, _, apiErr := channel.GetNextEnabledKeyForChannelTest()
+		require.Nil(t, apiErr)
+		require.Equal(t, "k0", key)
+	}
+	channelKeyPoolLock.Lock()
+	_, counted := channelKeyUsagePending[channelKeyRef{channelId, 0}]
+	channelKeyPoolLock.Unlock()
+	require.False(t, counted)
+
+	_, _, apiErr := channel.GetNextEnabledKey()
+	require.Nil(t, apiErr)
+	_, _, apiErr = channel.GetNextEnabledKey()
+	require.NotNil(t, apiErr)
+	require.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+}
@@ -0,0 +1,434 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/pkg/balancer"
+
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ChannelPoolOverflowNone = "none" // 渠道池内没有可用渠道时直接失败
+	ChannelPoolOverflowPool = "pool" // 渠道池内没有可用渠道时使用溢出池的渠道
+)
+
+// 溢出渠道的优先级在其自身优先级的基础上减去该偏移量，保证只有本池渠道都不可用时才会被选中
+const channelPoolOverflowPriorityOffset int64 = 1 << 32
+
+// ChannelPool 渠道池：一组渠道及其路由配置。渠道池分配给用户分组后，成员渠道的 abilities 由渠道池生成，
+// 属于任一已启用渠道池的渠道不再按自身的分组生成 abilities
+type ChannelPool struct {
+	Id             int     `json:"id"`
+	Name           string  `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description    string  `json:"description" gorm:"type:varchar(255)"`
+	Status         int     `json:"status" gorm:"default:1"`
+	Groups         string  `json:"groups" gorm:"type:varchar(255)"` // 分配到的用户分组，逗号分隔
+	ChannelIds     string  `json:"channel_ids" gorm:"type:text"`    // 成员渠道 id，逗号分隔
+	Tags           string  `json:"tags" gorm:"type:varchar(255)"`   // 带有这些标签的渠道自动成为成员，逗号分隔
+	Priority       *int64  `json:"priority" gorm:"bigint"`          // 覆盖成员渠道的优先级，为空时使用渠道自身的优先级
+	Weight         *uint   `json:"weight"`                          // 覆盖成员渠道的权重，为空时使用渠道自身的权重
+	ModelMapping   *string `json:"model_mapping" gorm:"type:text"`  // 先于渠道自身的模型映射生效
+	ParamOverride  *string `json:"param_override" gorm:"type:text"` // 先于渠道自身的参数覆盖生效
+	OverflowPolicy string  `json:"overflow_policy" gorm:"type:varchar(16);default:'none'"`
+	OverflowPoolId int     `json:"overflow_pool_id"`
+	SelectStrategy string  `json:"select_strategy" gorm:"type:varchar(32)"` // 同一优先级内的渠道选择策略，为空时使用分组的策略
+	CreatedTime    int64   `json:"created_time" gorm:"bigint"`
+	UpdatedTime    int64   `json:"updated_time" gorm:"bigint"`
+}
+
+func splitCommaList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (pool *ChannelPool) GetGroups() []string {
+	return splitCommaList(pool.Groups)
+}
+
+func (pool *ChannelPool) GetTags() []string {
+	return splitCommaList(pool.Tags)
+}
+
+func (pool *ChannelPool) GetChannelIds() []int {
+	ids := make([]int, 0)
+	for _, item := range splitCommaList(pool.ChannelIds) {
+		if id, err := strconv.Atoi(item); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// HasChannel 渠道在成员列表中，或带有渠道池的标签
+func (pool *ChannelPool) HasChannel(channel *Channel) bool {
+	if slices.Contains(pool.GetChannelIds(), channel.Id) {
+		return true
+	}
+	tag := channel.GetTag()
+	return tag != "" && slices.Contains(pool.GetTags(), tag)
+}
+
+func (pool *ChannelPool) GetModelMapping() map[string]string {
+	mapping := make(map[string]string)
+	if pool.ModelMapping != nil && *pool.ModelMapping != "" {
+		if err := common.UnmarshalJsonStr(*pool.ModelMapping, &mapping); err != nil {
+			common.SysLog(fmt.Sprintf("failed to unmarshal model mapping: channel_pool_id=%d, error=%v", pool.Id, err))
+		}
+	}
+	return mapping
+}
+
+func (pool *ChannelPool) GetParamOverride() map[string]interface{} {
+	paramOverride := make(map[string]interface{})
+	if pool.ParamOverride != nil && *pool.ParamOverride != "" {
+		if err := common.UnmarshalJsonStr(*pool.ParamOverride, &paramOverride); err != nil {
+			common.SysLog(fmt.Sprintf("failed to unmarshal param override: channel_pool_id=%d, error=%v", pool.Id, err))
+		}
+	}
+	return paramOverride
+}
+
+// MergeModelMapping 将渠道池的模型映射合并到渠道的模型映射中，同名模型以渠道池为准；
+// 由于模型映射支持链式重定向，请求的模型会先按渠道池映射，再按渠道自身的映射转换为上游模型
+func (pool *ChannelPool) MergeModelMapping(channelMapping string) string {
+	poolMapping := pool.GetModelMapping()
+	if len(poolMapping) == 0 {
+		return channelMapping
+	}
+	merged := make(map[string]string)
+	if channelMapping != "" {
+		if err := common.UnmarshalJsonStr(channelMapping, &merged); err != nil {
+			return channelMapping
+		}
+	}
+	for from, to := range poolMapping {
+		merged[from] = to
+	}
+	data, err := common.Marshal(merged)
+	if err != nil {
+		return channelMapping
+	}
+	return string(data)
+}
+
+// MergeParamOverride 合并渠道池与渠道的参数覆盖，渠道的配置后生效：
+// 两者都是键值格式时按键合并，否则都转换为 operations 格式后按先渠道池、后渠道的顺序执行
+func (pool *ChannelPool) MergeParamOverride(channelOverride map[string]interface{}) map[string]interface{} {
+	poolOverride := pool.GetParamOverride()
+	if len(poolOverride) == 0 {
+		return channelOverride
+	}
+	if len(channelOverride) == 0 {
+		return poolOverride
+	}
+	_, poolIsOps := poolOverride["operations"]
+	_, channelIsOps := channelOverride["operations"]
+	if !poolIsOps && !channelIsOps {
+		merged := make(map[string]interface{}, len(poolOverride)+len(channelOverride))
+		for k, v := range poolOverride {
+			merged[k] = v
+		}
+		for k, v := range channelOverride {
+			merged[k] = v
+		}
+		return merged
+	}
+	operations := append(paramOverrideOperations(poolOverride), paramOverrideOperations(channelOverride)...)
+	return map[string]interface{}{"operations": operations}
+}
+
+func paramOverrideOperations(paramOverride map[string]interface{}) []interface{} {
+	if ops, ok := paramOverride["operations"].([]interface{}); ok {
+		return ops
+	}
+	keys := lo.Keys(paramOverride)
+	slices.Sort(keys)
+	ops := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		ops = append(ops, map[string]interface{}{"path": key, "mode": "set", "value": paramOverride[key]})
+	}
+	return ops
+}
+
+// effectiveRoute 成员渠道在该渠道池中的优先级与权重
+func (pool *ChannelPool) effectiveRoute(channel *Channel) (int64, uint) {
+	priority := channel.GetPriority()
+	if pool.Priority != nil {
+		priority = *pool.Priority
+	}
+	weight := uint(channel.GetWeight())
+	if pool.Weight != nil {
+		weight = *pool.Weight
+	}
+	return priority, weight
+}
+
+// poolModels 成员渠道在该渠道池中提供的模型：渠道自身的模型，以及渠道池模型映射中映射到这些模型的别名
+func (pool *ChannelPool) poolModels(channel *Channel) []string {
+	models := strings.Split(channel.Models, ",")
+	for from, to := range pool.GetModelMapping() {
+		if slices.Contains(models, to) && !slices.Contains(models, from) {
+			models = append(models, from)
+		}
+	}
+	return models
+}
+
+func getEnabledChannelPools(db *gorm.DB) ([]*ChannelPool, error) {
+	var pools []*ChannelPool
+	err := db.Where("status = ?", common.ChannelStatusEnabled).Order("id").Find(&pools).Error
+	return pools, err
+}
+
+// buildAbilities 生成渠道的 abilities：属于已启用渠道池的渠道由渠道池生成，其他渠道按自身的分组生成。
+// 同一分组 + 模型只保留先生成的一条，渠道池直接生成的优先于溢出生成的
+func (channel *Channel) buildAbilities(pools []*ChannelPool) []Ability {
+	abilitySet := make(map[string]struct{})
+	abilities := make([]Ability, 0)
+	add := func(group string, model string, priority int64, weight uint, poolId int) {
+		key := group + "|" + model
+		if _, exists := abilitySet[key]; exists {
+			return
+		}
+		abilitySet[key] = struct{}{}
+		abilities = append(abilities, Ability{
+			Group:     group,
+			Model:     model,
+			ChannelId: channel.Id,
+			Enabled:   channel.Status == common.ChannelStatusEnabled,
+			Priority:  common.GetPointer(priority),
+			Weight:    weight,
+			Tag:       channel.Tag,
+			PoolId:    poolId,
+		})
+	}
+
+	memberPools := lo.Filter(pools, func(pool *ChannelPool, _ int) bool {
+		return pool.HasChannel(channel)
+	})
+	if len(memberPools) == 0 {
+		for _, model := range strings.Split(channel.Models, ",") {
+			for _, group := range strings.Split(channel.Group, ",") {
+				add(group, model, channel.GetPriority(), uint(channel.GetWeight()), 0)
+			}
+		}
+		return abilities
+	}
+	for _, pool := range memberPools {
+		priority, weight := pool.effectiveRoute(channel)
+		for _, model := range pool.poolModels(channel) {
+			for _, group := range pool.GetGroups() {
+				add(group, model, priority, weight, pool.Id)
+			}
+		}
+	}
+	// 作为其他渠道池的溢出池时，以更低的优先级加入这些渠道池的分组
+	for _, pool := range memberPools {
+		priority, weight := pool.effectiveRoute(channel)
+		for _, source := range pools {
+			if source.Id == pool.Id || source.OverflowPolicy != ChannelPoolOverflowPool || source.OverflowPoolId != pool.Id {
+				continue
+			}
+			for _, model := range pool.poolModels(channel) {
+				for _, group := range source.GetGroups() {
+					add(group, model, priority-channelPoolOverflowPriorityOffset, weight, pool.Id)
+				}
+			}
+		}
+	}
+	return abilities
+}
+
+func GetAllChannelPools() ([]*ChannelPool, error) {
+	var pools []*ChannelPool
+	err := DB.Order("id").Find(&pools).Error
+	return pools, err
+}
+
+func GetChannelPoolById(id int) (*ChannelPool, error) {
+	pool := &ChannelPool{}
+	err := DB.First(pool, "id = ?", id).Error
+	return pool, err
+}
+
+// GetChannelPoolMembers 渠道池的成员渠道（不含 key）
+func GetChannelPoolMembers(pool *ChannelPool) ([]*Channel, error) {
+	var channels []*Channel
+	query := DB.Omit("key")
+	ids, tags := pool.GetChannelIds(), pool.GetTags()
+	switch {
+	case len(ids) > 0 && len(tags) > 0:
+		query = query.Where("id in (?) or tag in (?)", ids, tags)
+	case len(ids) > 0:
+		query = query.Where("id in (?)", ids)
+	case len(tags) > 0:
+		query = query.Where("tag in (?)", tags)
+	default:
+		return channels, nil
+	}
+	err := query.Order("id").Find(&channels).Error
+	return channels, err
+}
+
+func (pool *ChannelPool) Insert() error {
+	pool.CreatedTime = common.GetTimestamp()
+	pool.UpdatedTime = pool.CreatedTime
+	if err := DB.Create(pool).Error; err != nil {
+		return err
+	}
+	return RefreshChannelPoolAbilities(pool)
+}
+
+// Update 保存渠道池并重新生成受影响渠道的 abilities
+func (pool *ChannelPool) Update() error {
+	old, err := GetChannelPoolById(pool.Id)
+	if err != nil {
+		return err
+	}
+	pool.CreatedTime = old.CreatedTime
+	pool.UpdatedTime = common.GetTimestamp()
+	if err = DB.Select("*").Omit("created_time").Updates(pool).Error; err != nil {
+		return err
+	}
+	return RefreshChannelPoolAbilities(old, pool)
+}
+
+func (pool *ChannelPool) Delete() error {
+	var referenced []string
+	err := DB.Model(&ChannelPool{}).Where("overflow_pool_id = ? and id <> ?", pool.Id, pool.Id).Pluck("name", &referenced).Error
+	if err != nil {
+		return err
+	}
+	if len(referenced) > 0 {
+		return fmt.Errorf("渠道池正被用作以下渠道池的溢出池：%s", strings.Join(referenced, ", "))
+	}
+	if err = DB.Delete(pool).Error; err != nil {
+		return err
+	}
+	return RefreshChannelPoolAbilities(pool)
+}
+
+// RefreshChannelPoolAbilities 重新生成渠道池（及其溢出池）成员渠道的 abilities 并刷新缓存，传入修改前后的渠道池
+func RefreshChannelPoolAbilities(pools ...*ChannelPool) error {
+	channelIds := make([]int, 0)
+	related := make([]*ChannelPool, 0, len(pools)*2)
+	for _, pool := range pools {
+		related = append(related, pool)
+		if pool.OverflowPoolId > 0 {
+			if overflowPool, err := GetChannelPoolById(pool.OverflowPoolId); err == nil {
+				related = append(related, overflowPool)
+			}
+		}
+	}
+	for _, pool := range related {
+		members, err := GetChannelPoolMembers(pool)
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			channelIds = append(channelIds, member.Id)
+		}
+	}
+	slices.Sort(channelIds)
+	if err := rebuildChannelAbilities(slices.Compact(channelIds)); err != nil {
+		return err
+	}
+	InitChannelCache()
+	return nil
+}
+
+// rebuildChannelAbilities 在一个事务中重新生成指定渠道的 abilities
+func rebuildChannelAbilities(channelIds []int) error {
+	if len(channelIds) == 0 {
+		return nil
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		pools, err := getEnabledChannelPools(tx)
+		if err != nil {
+			return err
+		}
+		for _, chunk := range lo.Chunk(channelIds, 50) {
+			var channels []*Channel
+			if err = tx.Where("id in (?)", chunk).Find(&channels).Error; err != nil {
+				return err
+			}
+			if err = tx.Where("channel_id in (?)", chunk).Delete(&Ability{}).Error; err != nil {
+				return err
+			}
+			for _, channel := range channels {
+				abilities := channel.buildAbilities(pools)
+				for _, abilityChunk := range lo.Chunk(abilities, 50) {
+					if err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&abilityChunk).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// ValidateChannelPool 检查渠道池配置
+func ValidateChannelPool(pool *ChannelPool) error {
+	pool.Name = strings.TrimSpace(pool.Name)
+	if pool.Name == "" {
+		return errors.New("渠道池名称不能为空")
+	}
+	if pool.Status == 0 {
+		pool.Status = common.ChannelStatusEnabled
+	}
+	pool.Groups = strings.Join(pool.GetGroups(), ",")
+	pool.Tags = strings.Join(pool.GetTags(), ",")
+	pool.ChannelIds = strings.Join(lo.Map(pool.GetChannelIds(), func(id int, _ int) string {
+		return strconv.Itoa(id)
+	}), ",")
+	if pool.ModelMapping != nil && *pool.ModelMapping != "" {
+		mapping := make(map[string]string)
+		if err := common.UnmarshalJsonStr(*pool.ModelMapping, &mapping); err != nil {
+			return fmt.Errorf("模型映射格式错误：%w", err)
+		}
+	}
+	if pool.ParamOverride != nil && *pool.ParamOverride != "" {
+		paramOverride := make(map[string]interface{})
+		if err := common.UnmarshalJsonStr(*pool.ParamOverride, &paramOverride); err != nil {
+			return fmt.Errorf("参数覆盖格式错误：%w", err)
+		}
+	}
+	if !balancer.IsValid(pool.SelectStrategy) {
+		return fmt.Errorf("不支持的渠道选择策略：%s", pool.SelectStrategy)
+	}
+	switch pool.OverflowPolicy {
+	case "", ChannelPoolOverflowNone:
+		pool.OverflowPolicy = ChannelPoolOverflowNone
+		pool.OverflowPoolId = 0
+	case ChannelPoolOverflowPool:
+		if pool.OverflowPoolId == 0 || pool.OverflowPoolId == pool.Id {
+			return errors.New("请选择其他渠道池作为溢出池")
+		}
+		if _, err := GetChannelPoolById(pool.OverflowPoolId); err != nil {
+			return fmt.Errorf("溢出池 #%d 不存在", pool.OverflowPoolId)
+		}
+	default:
+		return fmt.Errorf("不支持的溢出策略：%s", pool.OverflowPolicy)
+	}
+	return nil
+}
+
+func IsChannelPoolNameDuplicated(id int, name string) (bool, error) {
+	var count int64
+	err := DB.Model(&ChannelPool{}).Where("name = ? AND id <> ?", name, id).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,262 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/pkg/balancer"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+
+	"github.com/samber/lo"
+)
+
+const channelInFlightKeyPrefix = "channel_in_flight:"
+
+// channelInFlightCounter 渠道进行中的请求数，启用 Redis 时在各节点间共享，否则只统计本节点
+type channelInFlightCounter struct {
+	memory balancer.InFlightCounter
+}
+
+var inFlightCounter = &channelInFlightCounter{memory: balancer.NewMemoryInFlightCounter()}
+
+func init() {
+	balancer.Register(balancer.StrategyLeastInFlight, balancer.NewLeastInFlight(inFlightCounter))
+}
+
+func (c *channelInFlightCounter) Acquire(id int) {
+	if !common.RedisEnabled {
+		c.memory.Acquire(id)
+		return
+	}
+	ctx := context.Background()
+	key := channelInFlightKeyPrefix + strconv.Itoa(id)
+	ttl := time.Duration(max(operation_setting.GetChannelSelectSetting().InFlightTTL, 60)) * time.Second
+	pipe := common.RDB.TxPipeline()
+	pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		common.SysLog(fmt.Sprintf("failed to increase in-flight count: channel_id=%d, error=%v", id, err))
+	}
+}
+
+func (c *channelInFlightCounter) Release(id int) {
+	if !common.RedisEnabled {
+		c.memory.Release(id)
+		return
+	}
+	ctx := context.Background()
+	key := channelInFlightKeyPrefix + strconv.Itoa(id)
+	count, err := common.RDB.Decr(ctx, key).Result()
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to decrease in-flight count: channel_id=%d, error=%v", id, err))
+		return
+	}
+	if count <= 0 {
+		// 计数过期后再释放会变为负数
+		_ = common.RDB.Del(ctx, key).Err()
+	}
+}
+
+func (c *channelInFlightCounter) Counts(ids []int) map[int]int64 {
+	if !common.RedisEnabled {
+		return c.memory.Counts(ids)
+	}
+	counts := make(map[int]int64, len(ids))
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = channelInFlightKeyPrefix + strconv.Itoa(id)
+	}
+	values, err := common.RDB.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		common.SysLog("failed to get in-flight counts: " + err.Error())
+		return counts
+	}
+	for i, value := range values {
+		if s, ok := value.(string); ok {
+			count, _ := strconv.ParseInt(s, 10, 64)
+			counts[ids[i]] = max(count, 0)
+		}
+	}
+	return counts
+}
+
+// AcquireChannelInFlight 记录一个发往渠道的进行中请求，返回的函数在请求结束时调用
+func AcquireChannelInFlight(channelId int) func() {
+	inFlightCounter.Acquire(channelId)
+	return func() {
+		inFlightCounter.Release(channelId)
+	}
+}
+
+// GetChannelInFlightCounts 渠道进行中的请求数
+func GetChannelInFlightCounts(channelIds []int) map[int]int64 {
+	return inFlightCounter.Counts(channelIds)
+}
+
+// GetChannelSelectStrategy 选中渠道所用的选择策略：经由配置了策略的渠道池路由时使用渠道池的策略，否则使用分组的策略
+func GetChannelSelectStrategy(group string, pool *ChannelPool) string {
+	if pool != nil && pool.SelectStrategy != "" {
+		return pool.SelectStrategy
+	}
+	return operation_setting.GetGroupSelectStrategy(group)
+}
+
+// IsInFlightTrackedStrategy 只有 least_in_flight 策略依赖渠道进行中的请求数
+func IsInFlightTrackedStrategy(strategy string) bool {
+	return strategy == balancer.StrategyLeastInFlight
+}
+
+// getSelectStrategy 同一优先级内的选择策略：候选渠道都来自同一个配置了策略的渠道池时使用渠道池的策略，否则使用分组的策略
+func getSelectStrategy(group string, poolIds []int, getPool func(id int) *ChannelPool) string {
+	if len(poolIds) > 0 && poolIds[0] > 0 {
+		same := true
+		for _, poolId := range poolIds[1:] {
+			if poolId != poolIds[0] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return GetChannelSelectStrategy(group, getPool(poolIds[0]))
+		}
+	}
+	return operation_setting.GetGroupSelectStrategy(group)
+}
+
+// selectCandidate 按策略从同一优先级的候选渠道中选择一个，返回其下标
+func selectCandidate(strategy string, group string, model string, priority int64, hashKey string, candidates []balancer.Candidate) int {
+	return balancer.Get(strategy).Select(balancer.Request{
+		Scope:   fmt.Sprintf("%s/%s/%d", group, model, priority),
+		HashKey: hashKey,
+	}, candidates)
+}
+
+// ChannelRouteInfo 分组 + 模型下一个候选渠道的路由信息，用于路由诊断
+type ChannelRouteInfo struct {
+	ChannelId      int    `json:"channel_id"`
+	ChannelName    string `json:"channel_name"`
+	ChannelType    int    `json:"channel_type"`
+	ChannelStatus  int    `json:"channel_status"`
+	AbilityEnabled bool   `json:"ability_enabled"`
+	Priority       int64  `json:"priority"`
+	Weight         uint   `json:"weight"`
+	PoolId         int    `json:"pool_id"`
+	PoolName       string `json:"pool_name,omitempty"`
+	CoolingUntil   int64  `json:"cooling_until,omitempty"`
+	BalanceLow     bool   `json:"balance_low"`
+	InFlight       int64  `json:"in_flight"`
+	Available      bool   `json:"available"`        // 是否会参与选择
+	Reason         string `json:"reason,omitempty"` // 不参与选择或被降级的原因
+
+	Channel *Channel     `json:"-"`
+	Pool    *ChannelPool `json:"-"`
+}
+
+// GetChannelRouteInfos 从 abilities 读取分组 + 模型下的全部候选渠道（含已禁用的），按优先级从高到低排列。
+// 与选择渠道时相同，先按模型名精确匹配，没有时按标准化后的模型名匹配，返回实际匹配的模型名
+func GetChannelRouteInfos(group string, modelName string) (string, []*ChannelRouteInfo, error) {
+	var abilities []Ability
+	matchedModel := modelName
+	err := DB.Where(commonGroupCol+" = ? and model = ?", group, modelName).Find(&abilities).Error
+	if err == nil && len(abilities) == 0 {
+		if normalized := ratio_setting.FormatMatchingModelName(modelName); normalized != modelName {
+			matchedModel = normalized
+			err = DB.Where(commonGroupCol+" = ? and model = ?", group, normalized).Find(&abilities).Error
+		}
+	}
+	if err != nil || len(abilities) == 0 {
+		return matchedModel, nil, err
+	}
+
+	channelIds := lo.Uniq(lo.Map(abilities, func(ability Ability, _ int) int {
+		return ability.ChannelId
+	}))
+	channels, err := GetChannelsByIds(channelIds)
+	if err != nil {
+		return matchedModel, nil, err
+	}
+	channelsById := lo.KeyBy(channels, func(channel *Channel) int {
+		return channel.Id
+	})
+	pools := make(map[int]*ChannelPool)
+	inFlight := GetChannelInFlightCounts(channelIds)
+
+	infos := make([]*ChannelRouteInfo, 0, len(abilities))
+	for _, ability := range abilities {
+		info := &ChannelRouteInfo{
+			ChannelId:      ability.ChannelId,
+			AbilityEnabled: ability.Enabled,
+			Weight:         ability.Weight,
+			PoolId:         ability.PoolId,
+			CoolingUntil:   GetChannelCooldown(ability.ChannelId),
+			InFlight:       inFlight[ability.ChannelId],
+		}
+		if ability.Priority != nil {
+			info.Priority = *ability.Priority
+		}
+		if ability.PoolId > 0 {
+			if _, ok := pools[ability.PoolId]; !ok {
+				pool, err := GetChannelPoolById(ability.PoolId)
+				if err != nil {
+					pool = nil
+				}
+				pools[ability.PoolId] = pool
+			}
+			if pool := pools[ability.PoolId]; pool != nil {
+				info.Pool = pool
+				info.PoolName = pool.Name
+			}
+		}
+		channel, ok := channelsById[ability.ChannelId]
+		switch {
+		case !ok:
+			info.Reason = "渠道不存在"
+		case channel.Status != common.ChannelStatusEnabled:
+			info.Reason = "渠道已禁用"
+		case !ability.Enabled:
+			info.Reason = "能力未启用"
+		case info.CoolingUntil > 0:
+			info.Reason = "渠道冷却中"
+		default:
+			info.Available = true
+			if channel.IsBalanceLow() {
+				info.BalanceLow = true
+				info.Reason = "余额不足，仅在没有其他可用渠道时使用"
+			}
+		}
+		if ok {
+			info.Channel = channel
+			info.ChannelName = channel.Name
+			info.ChannelType = channel.Type
+			info.ChannelStatus = channel.Status
+		}
+		infos = append(infos, info)
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		if infos[i].Priority != infos[j].Priority {
+			return infos[i].Priority > infos[j].Priority
+		}
+		return infos[i].ChannelId < infos[j].ChannelId
+	})
+	return matchedModel, infos, nil
+}
+
+// GetSelectStrategy 同一优先级的候选渠道使用的选择策略
+func GetSelectStrategy(group string, routes []*ChannelRouteInfo) string {
+	poolsById := make(map[int]*ChannelPool)
+	for _, route := range routes {
+		if route.Pool != nil {
+			poolsById[route.PoolId] = route.Pool
+		}
+	}
+	return getSelectStrategy(group, lo.Map(routes, func(route *ChannelRouteInfo, _ int) int {
+		return route.PoolId
+	}), func(id int) *ChannelPool {
+		return poolsById[id]
+	})
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ChannelStatBucketSeconds        = 300  // 原始统计粒度
+	ChannelStatCompactBucketSeconds = 3600 // 压缩后的统计粒度
+)
+
+// ChannelStat 渠道 + 模型按时间分桶的请求与测试统计，时延为成功请求耗时之和（毫秒）
+type ChannelStat struct {
+	Id           int64  `json:"id"`
+	ChannelId    int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_stat_bucket,priority:1"`
+	ModelName    string `json:"model_name" gorm:"type:varchar(191);uniqueIndex:idx_channel_stat_bucket,priority:2"`
+	BucketStart  int64  `json:"bucket_start" gorm:"bigint;uniqueIndex:idx_channel_stat_bucket,priority:3;index"`
+	BucketSize   int    `json:"bucket_size" gorm:"uniqueIndex:idx_channel_stat_bucket,priority:4"`
+	RelaySuccess int64  `json:"relay_success"`
+	RelayFailure int64  `json:"relay_failure"`
+	RelayLatency int64  `json:"relay_latency"`
+	TestSuccess  int64  `json:"test_success"`
+	TestFailure  int64  `json:"test_failure"`
+	TestLatency  int64  `json:"test_latency"`
+}
+
+var channelStatCounterColumns = []string{"relay_success", "relay_failure", "relay_latency", "test_success", "test_failure", "test_latency"}
+
+// IncreaseChannelStat 将 stat 中的计数累加到对应的统计桶，多节点可同时写入
+func IncreaseChannelStat(tx *gorm.DB, stat *ChannelStat) error {
+	values := map[string]int64{
+		"relay_success": stat.RelaySuccess,
+		"relay_failure": stat.RelayFailure,
+		"relay_latency": stat.RelayLatency,
+		"test_success":  stat.TestSuccess,
+		"test_failure":  stat.TestFailure,
+		"test_latency":  stat.TestLatency,
+	}
+	assignments := make(map[string]any, len(values))
+	for _, column := range channelStatCounterColumns {
+		// 列名需带表名，否则 PostgreSQL 会报 column reference is ambiguous
+		assignments[column] = gorm.Expr("channel_stats."+column+" + ?", values[column])
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "channel_id"}, {Name: "model_name"}, {Name: "bucket_start"}, {Name: "bucket_size"}},
+		DoUpdates: clause.Assignments(assignments),
+	}).Create(stat).Error
+}
+
+// CompactChannelStats 将 before 之前的原始统计桶合并为小时桶，返回合并的原始桶数量
+func CompactChannelStats(before int64, limit int) (int, error) {
+	var stats []*ChannelStat
+	err := DB.Where("bucket_size = ? AND bucket_start < ?", ChannelStatBucketSeconds, before).Order("id").Limit(limit).Find(&stats).Error
+	if err != nil || len(stats) == 0 {
+		return 0, err
+	}
+	type compactKey struct {
+		channelId   int
+		modelName   string
+		bucketStart int64
+	}
+	compacted := make(map[compactKey]*ChannelStat)
+	ids := make([]int64, 0, len(stats))
+	for _, stat := range stats {
+		ids = append(ids, stat.Id)
+		key := compactKey{stat.ChannelId, stat.ModelName, stat.BucketStart - stat.BucketStart%ChannelStatCompactBucketSeconds}
+		target, ok := compacted[key]
+		if !ok {
+			target = &ChannelStat{
+				ChannelId:   key.channelId,
+				ModelName:   key.modelName,
+				BucketStart: key.bucketStart,
+				BucketSize:  ChannelStatCompactBucketSeconds,
+			}
+			compacted[key] = target
+		}
+		target.RelaySuccess += stat.RelaySuccess
+		target.RelayFailure += stat.RelayFailure
+		target.RelayLatency += stat.RelayLatency
+		target.TestSuccess += stat.TestSuccess
+		target.TestFailure += stat.TestFailure
+		target.TestLatency += stat.TestLatency
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		for _, stat := range compacted {
+			if err := IncreaseChannelStat(tx, stat); err != nil {
+				return err
+			}
+		}
+		return tx.Where("id in (?)", ids).Delete(&ChannelStat{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(stats), nil
+}
+
+func DeleteChannelStatsBefore(before int64) (int64, error) {
+	result := DB.Where("bucket_start < ?", before).Delete(&ChannelStat{})
+	return result.RowsAffected, result.Error
+}
+
+// ChannelStatSum 按模型与桶汇总的统计
+type ChannelStatSum struct {
+	ModelName    string `json:"model_name"`
+	BucketStart  int64  `json:"bucket_start"`
+	RelaySuccess int64  `json:"relay_success"`
+	RelayFailure int64  `json:"relay_failure"`
+	RelayLatency int64  `json:"relay_latency"`
+	TestSuccess  int64  `json:"test_success"`
+	TestFailure  int64  `json:"test_failure"`
+	TestLatency  int64  `json:"test_latency"`
+}
+
+// GetChannelStatSums 汇总 since 之后的统计，channelId 为 0 时汇总所有渠道
+func GetChannelStatSums(channelId int, since int64) ([]*ChannelStatSum, error) {
+	var sums []*ChannelStatSum
+	query := DB.Model(&ChannelStat{}).
+		Select("model_name, bucket_start, SUM(relay_success) AS relay_success, SUM(relay_failure) AS relay_failure, SUM(relay_latency) AS relay_latency, "+
+			"SUM(test_success) AS test_success, SUM(test_failure) AS test_failure, SUM(test_latency) AS test_latency").
+		Where("bucket_start >= ?", since)
+	if channelId > 0 {
+		query = query.Where("channel_id = ?", channelId)
+	}
+	err := query.Group("model_name, bucket_start").Order("bucket_start").Scan(&sums).Error
+	return sums, err
+}
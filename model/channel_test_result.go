@@ -0,0 +1,41 @@
+package model
+
+import (
+	"gorm.io/gorm/clause"
+)
+
+// ChannelTestResult 渠道测试矩阵中每个测试点（渠道、模型、key、端点类型、是否流式）最近一次的测试结果
+type ChannelTestResult struct {
+	Id           int    `json:"id"`
+	ChannelId    int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_test_point,priority:1"`
+	Model        string `json:"model" gorm:"type:varchar(191);uniqueIndex:idx_channel_test_point,priority:2"`
+	KeyIndex     int    `json:"key_index" gorm:"uniqueIndex:idx_channel_test_point,priority:3"` // 多 Key 渠道中 key 的索引，单 Key 渠道为 0
+	EndpointType string `json:"endpoint_type" gorm:"type:varchar(32);uniqueIndex:idx_channel_test_point,priority:4"`
+	Stream       bool   `json:"stream" gorm:"uniqueIndex:idx_channel_test_point,priority:5"`
+	Success      bool   `json:"success"`
+	StatusCode   int    `json:"status_code"`
+	Message      string `json:"message" gorm:"type:text"`
+	ResponseTime int64  `json:"response_time"` // 毫秒
+	TestedAt     int64  `json:"tested_at" gorm:"bigint;index"`
+}
+
+// SaveChannelTestResult 写入测试结果，同一测试点只保留最近一次
+func SaveChannelTestResult(result *ChannelTestResult) error {
+	return DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "channel_id"}, {Name: "model"}, {Name: "key_index"}, {Name: "endpoint_type"}, {Name: "stream"}},
+		DoUpdates: clause.AssignmentColumns([]string{"success", "status_code", "message", "response_time", "tested_at"}),
+	}).Create(result).Error
+}
+
+func GetChannelTestResults(channelId int) ([]*ChannelTestResult, error) {
+	var results []*ChannelTestResult
+	err := DB.Where("channel_id = ?", channelId).Order("model, key_index, endpoint_type, stream").Find(&results).Error
+	return results, err
+}
+
+func DeleteChannelTestResults(channelIds ...int) error {
+	if len(channelIds) == 0 {
+		return nil
+	}
+	return DB.Where("channel_id in (?)", channelIds).Delete(&ChannelTestResult{}).Error
+}
@@ -0,0 +1,274 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"gorm.io/gorm"
+)
+
+// 事件总线：业务代码通过 PublishEvent（或在事务内通过 PublishEventTx）发布事件，事件与每个订阅 sink 的投递记录一起同步落库，
+// 由后台投递器按 sink 批量发送，失败后退避重试，保证至少一次投递。
+
+const (
+	EventTypeUsageConsume    = "usage.consume"
+	EventTypeBillingTopup    = "billing.topup"
+	EventTypeBillingRefund   = "billing.refund"
+	EventTypeChannelDisabled = "channel.disabled"
+	EventTypeChannelEnabled  = "channel.enabled"
+	EventTypeUserRegistered  = "user.registered"
+	EventTypeTokenCreated    = "token.created"
+	EventTypeSystemTest      = "system.test"
+)
+
+const (
+	EventDeliveryStatusPending = "pending"
+	EventDeliveryStatusSuccess = "success"
+	EventDeliveryStatusFailed  = "failed"
+)
+
+type EventRecord struct {
+	Id        int64  `json:"id"`
+	Type      string `json:"type" gorm:"type:varchar(64);index"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	Payload   string `json:"payload" gorm:"type:text"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+type EventDelivery struct {
+	Id            int64  `json:"id"`
+	EventId       int64  `json:"event_id" gorm:"index"`
+	Sink          string `json:"sink" gorm:"type:varchar(64);index"`
+	Status        string `json:"status" gorm:"type:varchar(20);index"`
+	Attempts      int    `json:"attempts" gorm:"default:0"`
+	NextAttemptAt int64  `json:"next_attempt_at" gorm:"index"`
+	LeaseOwner    string `json:"-" gorm:"type:varchar(64)"`
+	LeaseUntil    int64  `json:"-" gorm:"index;default:0"`
+	LastError     string `json:"last_error" gorm:"type:text"`
+	DeliveredAt   int64  `json:"delivered_at"`
+	CreatedAt     int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// eventBusNotify 有新事件时唤醒投递器
+var eventBusNotify = make(chan struct{}, 1)
+
+func EventBusNotifyChan() <-chan struct{} {
+	return eventBusNotify
+}
+
+// NotifyEventBus 唤醒投递器，事务内发布的事件应在提交后调用
+func NotifyEventBus() {
+	select {
+	case eventBusNotify <- struct{}{}:
+	default:
+	}
+}
+
+// PublishEvent 发布事件，未启用事件总线或没有 sink 订阅时直接忽略。
+// 事件与投递记录同步落库后才唤醒投递器，由投递器异步发送给各 sink
+func PublishEvent(eventType string, userId int, data any) {
+	stored := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		stored, err = publishEvent(tx, eventType, userId, data)
+		return err
+	})
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to save event %s: %s", eventType, err.Error()))
+		return
+	}
+	if stored {
+		NotifyEventBus()
+	}
+}
+
+// PublishEventTx 在调用方的事务内发布事件，事件随业务数据一起提交或回滚。
+// 调用方在事务提交后调用 NotifyEventBus，否则事件在投递器下一次轮询时发送
+func PublishEventTx(tx *gorm.DB, eventType string, userId int, data any) error {
+	_, err := publishEvent(tx, eventType, userId, data)
+	return err
+}
+
+func publishEvent(tx *gorm.DB, eventType string, userId int, data any) (bool, error) {
+	sinks := operation_setting.GetEventBusSetting().MatchingSinks(eventType)
+	if len(sinks) == 0 {
+		return false, nil
+	}
+	payload, err := common.Marshal(data)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().Unix()
+	event := &EventRecord{
+		Type:      eventType,
+		UserId:    userId,
+		Payload:   string(payload),
+		CreatedAt: now,
+	}
+	if err := tx.Create(event).Error; err != nil {
+		return false, err
+	}
+	deliveries := make([]*EventDelivery, 0, len(sinks))
+	for _, sink := range sinks {
+		deliveries = append(deliveries, &EventDelivery{
+			EventId:       event.Id,
+			Sink:          sink.Name,
+			Status:        EventDeliveryStatusPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	if err := tx.Create(&deliveries).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetDueEventSinks 返回有到期待投递记录的 sink 名称
+func GetDueEventSinks(now int64) ([]string, error) {
+	var sinks []string
+	err := DB.Model(&EventDelivery{}).
+		Where("status = ? AND next_attempt_at <= ? AND lease_until < ?", EventDeliveryStatusPending, now, now).
+		Distinct("sink").Pluck("sink", &sinks).Error
+	return sinks, err
+}
+
+// LeaseEventDeliveries 领取某个 sink 的一批到期投递记录，返回记录及对应事件
+func LeaseEventDeliveries(sink string, now int64, leaseSeconds int, limit int) ([]*EventDelivery, map[int64]*EventRecord, error) {
+	var ids []int64
+	err := DB.Model(&EventDelivery{}).
+		Where("sink = ? AND status = ? AND next_attempt_at <= ? AND lease_until < ?", sink, EventDeliveryStatusPending, now, now).
+		Order("id").Limit(limit).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return nil, nil, err
+	}
+	leaseUntil := now + int64(leaseSeconds)
+	owner := GetPollOwner()
+	err = DB.Model(&EventDelivery{}).Where("id in (?) AND lease_until < ?", ids, now).
+		Updates(map[string]any{"lease_owner": owner, "lease_until": leaseUntil}).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	var deliveries []*EventDelivery
+	err = DB.Where("id in (?) AND lease_owner = ? AND lease_until = ?", ids, owner, leaseUntil).Order("id").Find(&deliveries).Error
+	if err != nil || len(deliveries) == 0 {
+		return nil, nil, err
+	}
+	eventIds := make([]int64, 0, len(deliveries))
+	for _, d := range deliveries {
+		eventIds = append(eventIds, d.EventId)
+	}
+	var events []*EventRecord
+	if err := DB.Where("id in (?)", eventIds).Find(&events).Error; err != nil {
+		return nil, nil, err
+	}
+	eventMap := make(map[int64]*EventRecord, len(events))
+	for _, e := range events {
+		eventMap[e.Id] = e
+	}
+	return deliveries, eventMap, nil
+}
+
+// MarkEventDeliveriesSuccess 标记投递成功
+func MarkEventDeliveriesSuccess(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return DB.Model(&EventDelivery{}).Where("id in (?)", ids).Updates(map[string]any{
+		"status":       EventDeliveryStatusSuccess,
+		"attempts":     gorm.Expr("attempts + ?", 1),
+		"delivered_at": time.Now().Unix(),
+		"last_error":   "",
+		"lease_until":  0,
+	}).Error
+}
+
+// MarkEventDeliveriesRetry 记录投递失败，达到最大次数时标记为失败，否则在 nextAttemptAt 重试
+func MarkEventDeliveriesRetry(deliveries []*EventDelivery, errMsg string, nextAttemptAt int64, maxAttempts int) error {
+	for _, d := range deliveries {
+		d.Attempts++
+		status := EventDeliveryStatusPending
+		if maxAttempts > 0 && d.Attempts >= maxAttempts {
+			status = EventDeliveryStatusFailed
+		}
+		err := DB.Model(&EventDelivery{}).Where("id = ?", d.Id).Updates(map[string]any{
+			"status":          status,
+			"attempts":        d.Attempts,
+			"next_attempt_at": nextAttemptAt,
+			"last_error":      errMsg,
+			"lease_until":     0,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetryFailedEventDeliveries 将某个 sink 的失败投递重新置为待投递
+func RetryFailedEventDeliveries(sink string) (int64, error) {
+	result := DB.Model(&EventDelivery{}).Where("sink = ? AND status = ?", sink, EventDeliveryStatusFailed).
+		Updates(map[string]any{
+			"status":          EventDeliveryStatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now().Unix(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+type EventSinkStat struct {
+	Sink            string `json:"sink"`
+	Status          string `json:"status"`
+	Count           int64  `json:"count"`
+	OldestCreatedAt int64  `json:"oldest_created_at"`
+}
+
+// GetEventSinkStats 按 sink 与状态统计投递记录
+func GetEventSinkStats() ([]EventSinkStat, error) {
+	var stats []EventSinkStat
+	err := DB.Model(&EventDelivery{}).
+		Select("sink, status, count(*) as count, min(created_at) as oldest_created_at").
+		Group("sink, status").Order("sink").Scan(&stats).Error
+	return stats, err
+}
+
+// CleanupEvents 删除超过保留期且已投递完成的事件
+func CleanupEvents(before int64) error {
+	err := DB.Where("created_at < ? AND status != ?", before, EventDeliveryStatusPending).Delete(&EventDelivery{}).Error
+	if err != nil {
+		return err
+	}
+	return DB.Where("created_at < ? AND id NOT IN (?)", before, DB.Model(&EventDelivery{}).Select("event_id")).
+		Delete(&EventRecord{}).Error
+}
+
+func topupEventData(source string, tradeNo string, quota int, money float64) map[string]any {
+	return map[string]any{
+		"source":   source,
+		"trade_no": tradeNo,
+		"quota":    quota,
+		"money":    money,
+	}
+}
+
+// PublishTopupEvent 发布充值事件
+func PublishTopupEvent(userId int, source string, tradeNo string, quota int, money float64) {
+	PublishEvent(EventTypeBillingTopup, userId, topupEventData(source, tradeNo, quota, money))
+}
+
+// PublishTopupEventTx 在充值事务内发布充值事件
+func PublishTopupEventTx(tx *gorm.DB, userId int, source string, tradeNo string, quota int, money float64) error {
+	return PublishEventTx(tx, EventTypeBillingTopup, userId, topupEventData(source, tradeNo, quota, money))
+}
+
+// PublishRefundEvent 发布退款（额度返还）事件
+func PublishRefundEvent(userId int, quota int, reason string, taskId string) {
+	PublishEvent(EventTypeBillingRefund, userId, map[string]any{
+		"quota":   quota,
+		"reason":  reason,
+		"task_id": taskId,
+	})
+}
@@ -155,11 +155,29 @@ type RecordConsumeLogParams struct {
 }
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
+	cost, costConfigured := 0, false
+	if params.ChannelId > 0 {
+		cost, costConfigured = CalculateChannelCost(params.ChannelId, params.ModelName, params.PromptTokens, params.CompletionTokens, params.Quota, consumeLogGroupRatio(params.Other))
+		// 渠道毛利统计不受消费日志与数据看板开关影响
+		LogChannelCostData(params.ChannelId, params.ModelName, params.Group, params.Quota, cost, common.GetTimestamp(), params.PromptTokens+params.CompletionTokens)
+	}
 	if !common.LogConsumeEnabled {
 		return
 	}
 	logger.LogInfo(c, fmt.Sprintf("record consume log: userId=%d, params=%s", userId, common.GetJsonString(params)))
 	username := c.GetString("username")
+	if costConfigured {
+		// 上游成本仅管理员可见
+		if params.Other == nil {
+			params.Other = make(map[string]interface{})
+		}
+		adminInfo, ok := params.Other["admin_info"].(map[string]interface{})
+		if !ok {
+			adminInfo = make(map[string]interface{})
+			params.Other["admin_info"] = adminInfo
+		}
+		adminInfo["upstream_cost"] = cost
+	}
 	otherStr := common.MapToJsonStr(params.Other)
 	// 判断是否需要记录 IP
 	needRecordIp := false
@@ -195,6 +213,26 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 	err := LOG_DB.Create(log).Error
 	if err != nil {
 		logger.LogError(c, "failed to record log: "+err.Error())
+	} else {
+		consumeEvent := map[string]any{
+			"log_id":            log.Id,
+			"username":          username,
+			"token_id":          params.TokenId,
+			"token_name":        params.TokenName,
+			"model_name":        params.ModelName,
+			"channel_id":        params.ChannelId,
+			"group":             params.Group,
+			"quota":             params.Quota,
+			"prompt_tokens":     params.PromptTokens,
+			"completion_tokens": params.CompletionTokens,
+			"use_time_seconds":  params.UseTimeSeconds,
+			"is_stream":         params.IsStream,
+			"created_at":        log.CreatedAt,
+		}
+		if costConfigured {
+			consumeEvent["upstream_cost"] = cost
+		}
+		PublishEvent(EventTypeUsageConsume, userId, consumeEvent)
 	}
 	if common.DataExportEnabled {
 		gopool.Go(func() {
@@ -203,6 +241,17 @@ func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams)
 	}
 }
 
+// consumeLogGroupRatio 从日志附加信息中读取分组倍率，缺失时视为 1
+func consumeLogGroupRatio(other map[string]interface{}) float64 {
+	if other == nil {
+		return 1
+	}
+	if groupRatio, ok := other["group_ratio"].(float64); ok {
+		return groupRatio
+	}
+	return 1
+}
+
 func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int, channel int, group string) (logs []*Log, total int64, err error) {
 	var tx *gorm.DB
 	if logType == LogTypeUnknown {
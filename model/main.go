@@ -257,13 +257,30 @@ func migrateDB() error {
 		&Redemption{},
 		&Ability{},
 		&Log{},
-		&Midjourney{},
 		&TopUp{},
 		&QuotaData{},
 		&Task{},
 		&Model{},
 		&Vendor{},
 		&PrefillGroup{},
+		&VirtualModel{},
+		&ChannelCostData{},
+		&SubscriptionPlan{},
+		&UserSubscription{},
+		&WebhookEndpoint{},
+		&WebhookDelivery{},
+		&EventRecord{},
+		&EventDelivery{},
+		&MediaObject{},
+		&MediaUsage{},
+		&StoredResponse{},
+		&ChannelTestResult{},
+		&ChannelStat{},
+		&ChannelIncident{},
+		&ChannelKeyUsage{},
+		&ChannelBalanceRecord{},
+		&ChannelPool{},
+		&ChannelConfigVersion{},
 		&Setup{},
 		&TwoFA{},
 		&TwoFABackupCode{},
@@ -272,7 +289,7 @@ func migrateDB() error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return migrateMidjourneyTasks()
 }
 
 func migrateDBFast() error {
@@ -291,13 +308,30 @@ func migrateDBFast() error {
 		{&Redemption{}, "Redemption"},
 		{&Ability{}, "Ability"},
 		{&Log{}, "Log"},
-		{&Midjourney{}, "Midjourney"},
 		{&TopUp{}, "TopUp"},
 		{&QuotaData{}, "QuotaData"},
 		{&Task{}, "Task"},
 		{&Model{}, "Model"},
 		{&Vendor{}, "Vendor"},
 		{&PrefillGroup{}, "PrefillGroup"},
+		{&VirtualModel{}, "VirtualModel"},
+		{&ChannelCostData{}, "ChannelCostData"},
+		{&SubscriptionPlan{}, "SubscriptionPlan"},
+		{&UserSubscription{}, "UserSubscription"},
+		{&WebhookEndpoint{}, "WebhookEndpoint"},
+		{&WebhookDelivery{}, "WebhookDelivery"},
+		{&EventRecord{}, "EventRecord"},
+		{&EventDelivery{}, "EventDelivery"},
+		{&MediaObject{}, "MediaObject"},
+		{&MediaUsage{}, "MediaUsage"},
+		{&StoredResponse{}, "StoredResponse"},
+		{&ChannelTestResult{}, "ChannelTestResult"},
+		{&ChannelStat{}, "ChannelStat"},
+		{&ChannelIncident{}, "ChannelIncident"},
+		{&ChannelKeyUsage{}, "ChannelKeyUsage"},
+		{&ChannelBalanceRecord{}, "ChannelBalanceRecord"},
+		{&ChannelPool{}, "ChannelPool"},
+		{&ChannelConfigVersion{}, "ChannelConfigVersion"},
 		{&Setup{}, "Setup"},
 		{&TwoFA{}, "TwoFA"},
 		{&TwoFABackupCode{}, "TwoFABackupCode"},
@@ -326,6 +360,9 @@ func migrateDBFast() error {
 			return err
 		}
 	}
+	if err := migrateMidjourneyTasks(); err != nil {
+		return err
+	}
 	common.SysLog("database migrated")
 	return nil
 }
@@ -0,0 +1,144 @@
+package model
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MediaObject 持久化存储的生成媒体，对外通过网关签名链接 /media/:key 访问
+type MediaObject struct {
+	Id          int64  `json:"id"`
+	ObjectKey   string `json:"object_key" gorm:"type:varchar(64);uniqueIndex"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	Source      string `json:"source" gorm:"type:varchar(32);index"`     // image / audio / midjourney / suno / video
+	SourceId    string `json:"source_id" gorm:"type:varchar(191);index"` // 任务 ID 或请求 ID
+	ContentType string `json:"content_type" gorm:"type:varchar(128)"`
+	Size        int64  `json:"size"`
+	Backend     string `json:"backend" gorm:"type:varchar(16)"`
+	StorageKey  string `json:"-" gorm:"type:varchar(255)"`
+	ExpiresAt   int64  `json:"expires_at" gorm:"bigint;index"` // 0 表示不过期
+	CreatedAt   int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// MediaUsage 用户当前占用的媒体存储空间计数，配额检查与占用累加在同一条条件更新中完成
+type MediaUsage struct {
+	UserId    int   `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	UsedBytes int64 `json:"used_bytes" gorm:"bigint;default:0"`
+}
+
+func (m *MediaObject) Insert() error {
+	return DB.Create(m).Error
+}
+
+func GetMediaObjectByKey(key string) (*MediaObject, error) {
+	var obj MediaObject
+	err := DB.First(&obj, "object_key = ?", key).Error
+	return &obj, err
+}
+
+func GetMediaObjectById(id int64) (*MediaObject, error) {
+	var obj MediaObject
+	err := DB.First(&obj, "id = ?", id).Error
+	return &obj, err
+}
+
+// GetLatestMediaObjectBySource 查询某个任务或请求最近持久化的媒体
+func GetLatestMediaObjectBySource(source string, sourceId string) (*MediaObject, error) {
+	var obj MediaObject
+	err := DB.Where("source = ? AND source_id = ?", source, sourceId).Order("id desc").First(&obj).Error
+	return &obj, err
+}
+
+// GetUserMediaUsage 返回用户当前占用的存储空间（字节）
+func GetUserMediaUsage(userId int) (int64, error) {
+	var size int64
+	err := DB.Model(&MediaObject{}).Where("user_id = ?", userId).Select("COALESCE(SUM(size), 0)").Scan(&size).Error
+	return size, err
+}
+
+type MediaObjectQueryParams struct {
+	UserId   int // <0 表示不限
+	Source   string
+	SourceId string
+}
+
+func GetMediaObjects(params MediaObjectQueryParams, startIdx int, num int) ([]*MediaObject, int64, error) {
+	var objects []*MediaObject
+	var total int64
+	query := DB.Model(&MediaObject{})
+	if params.UserId >= 0 {
+		query = query.Where("user_id = ?", params.UserId)
+	}
+	if params.Source != "" {
+		query = query.Where("source = ?", params.Source)
+	}
+	if params.SourceId != "" {
+		query = query.Where("source_id = ?", params.SourceId)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("id desc").Limit(num).Offset(startIdx).Find(&objects).Error
+	return objects, total, err
+}
+
+// GetExpiredMediaObjects 获取一批已过期的媒体
+func GetExpiredMediaObjects(now int64, limit int) ([]*MediaObject, error) {
+	var objects []*MediaObject
+	err := DB.Where("expires_at > 0 AND expires_at <= ?", now).Order("id").Limit(limit).Find(&objects).Error
+	return objects, err
+}
+
+// DeleteMediaObject 删除媒体记录并释放其占用的存储空间
+func DeleteMediaObject(obj *MediaObject) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&MediaObject{}, "id = ?", obj.Id)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return releaseMediaUsage(tx, obj.UserId, obj.Size)
+	})
+}
+
+// ensureMediaUsage 首次使用时按已有媒体初始化用户的占用计数
+func ensureMediaUsage(userId int) error {
+	var count int64
+	if err := DB.Model(&MediaUsage{}).Where("user_id = ?", userId).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	used, err := GetUserMediaUsage(userId)
+	if err != nil {
+		return err
+	}
+	return DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&MediaUsage{UserId: userId, UsedBytes: used}).Error
+}
+
+// ReserveUserMediaUsage 为用户预占 size 字节的存储空间，quota 大于 0 时超出配额返回 false
+func ReserveUserMediaUsage(userId int, size int64, quota int64) (bool, error) {
+	if err := ensureMediaUsage(userId); err != nil {
+		return false, err
+	}
+	query := DB.Model(&MediaUsage{}).Where("user_id = ?", userId)
+	if quota > 0 {
+		query = query.Where("used_bytes + ? <= ?", size, quota)
+	}
+	result := query.Update("used_bytes", gorm.Expr("used_bytes + ?", size))
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReleaseUserMediaUsage 释放预占的存储空间，用于媒体保存失败时回滚
+func ReleaseUserMediaUsage(userId int, size int64) error {
+	return releaseMediaUsage(DB, userId, size)
+}
+
+func releaseMediaUsage(tx *gorm.DB, userId int, size int64) error {
+	return tx.Model(&MediaUsage{}).Where("user_id = ?", userId).
+		Update("used_bytes", gorm.Expr("CASE WHEN used_bytes > ? THEN used_bytes - ? ELSE 0 END", size, size)).Error
+}
+
+func UpdateMediaObjectExpiresAt(id int64, expiresAt int64) error {
+	return DB.Model(&MediaObject{}).Where("id = ?", id).Update("expires_at", expiresAt).Error
+}
@@ -1,5 +1,19 @@
 package model
 
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+
+	"gorm.io/gorm"
+)
+
+// Midjourney 任务已迁移到统一任务表（platform = mj），该结构仅用于读取旧表数据及 /mj 相关接口的兼容输出，
+// 其中时间字段保持旧接口的毫秒单位
 type Midjourney struct {
 	Id          int    `json:"id"`
 	Code        int    `json:"code"`
@@ -23,185 +37,337 @@ type Midjourney struct {
 	Quota       int    `json:"quota"`
 	Buttons     string `json:"buttons"`
 	Properties  string `json:"properties"`
+	// Migrated 旧表中该行已复制到统一任务表
+	Migrated bool `json:"-" gorm:"default:false;index"`
 }
 
-// TaskQueryParams 用于包含所有搜索条件的结构体，可以根据需求添加更多字段
-type TaskQueryParams struct {
-	ChannelID      string
-	MjID           string
-	StartTimestamp string
-	EndTimestamp   string
+// MidjourneyTaskData Midjourney 任务特有的字段，存放在 Task.Data 中
+type MidjourneyTaskData struct {
+	Code        int             `json:"code"`
+	Prompt      string          `json:"prompt"`
+	PromptEn    string          `json:"prompt_en,omitempty"`
+	Description string          `json:"description,omitempty"`
+	State       string          `json:"state,omitempty"`
+	ImageUrl    string          `json:"image_url,omitempty"`
+	VideoUrl    string          `json:"video_url,omitempty"`
+	VideoUrls   json.RawMessage `json:"video_urls,omitempty"`
+	Buttons     json.RawMessage `json:"buttons,omitempty"`
+	Properties  json.RawMessage `json:"properties,omitempty"`
 }
 
-func GetAllUserTask(userId int, startIdx int, num int, queryParams TaskQueryParams) []*Midjourney {
-	var tasks []*Midjourney
-	var err error
+// GetMidjourneyData 读取 Midjourney 任务数据，数据为空或格式错误时返回空结构
+func (t *Task) GetMidjourneyData() *MidjourneyTaskData {
+	data := &MidjourneyTaskData{}
+	if len(t.Data) > 0 {
+		_ = common.Unmarshal(t.Data, data)
+	}
+	return data
+}
+
+func (t *Task) SetMidjourneyData(data *MidjourneyTaskData) {
+	t.SetData(data)
+}
 
-	// 初始化查询构建器
-	query := DB.Where("user_id = ?", userId)
+// ToMidjourney 转换为旧版 Midjourney 任务结构
+func (t *Task) ToMidjourney() *Midjourney {
+	data := t.GetMidjourneyData()
+	return &Midjourney{
+		Id:          int(t.ID),
+		Code:        data.Code,
+		UserId:      t.UserId,
+		Action:      t.Action,
+		MjId:        t.TaskID,
+		Prompt:      data.Prompt,
+		PromptEn:    data.PromptEn,
+		Description: data.Description,
+		State:       data.State,
+		SubmitTime:  t.SubmitTime * 1000,
+		StartTime:   t.StartTime * 1000,
+		FinishTime:  t.FinishTime * 1000,
+		ImageUrl:    data.ImageUrl,
+		VideoUrl:    data.VideoUrl,
+		VideoUrls:   string(data.VideoUrls),
+		Status:      string(t.Status),
+		Progress:    t.Progress,
+		FailReason:  t.FailReason,
+		ChannelId:   t.ChannelId,
+		Quota:       t.Quota,
+		Buttons:     string(data.Buttons),
+		Properties:  string(data.Properties),
+	}
+}
 
-	if queryParams.MjID != "" {
-		query = query.Where("mj_id = ?", queryParams.MjID)
+// ApplyMidjourneyUpdate 使用上游返回（轮询或回调通知）的任务信息更新任务，时间字段由毫秒转换为秒
+func (t *Task) ApplyMidjourneyUpdate(item *dto.MidjourneyDto) {
+	data := t.GetMidjourneyData()
+	data.Code = 1
+	data.PromptEn = item.PromptEn
+	data.State = item.State
+	data.ImageUrl = item.ImageUrl
+	data.VideoUrl = item.VideoUrl
+	data.VideoUrls = nil
+	if len(item.VideoUrls) > 0 {
+		data.VideoUrls, _ = common.Marshal(item.VideoUrls)
 	}
-	if queryParams.StartTimestamp != "" {
-		// 假设您已将前端传来的时间戳转换为数据库所需的时间格式，并处理了时间戳的验证和解析
-		query = query.Where("submit_time >= ?", queryParams.StartTimestamp)
+	if item.Buttons != nil {
+		data.Buttons, _ = common.Marshal(item.Buttons)
 	}
-	if queryParams.EndTimestamp != "" {
-		query = query.Where("submit_time <= ?", queryParams.EndTimestamp)
+	if item.Properties != nil {
+		data.Properties, _ = common.Marshal(item.Properties)
 	}
+	t.SetMidjourneyData(data)
 
-	// 获取数据
-	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&tasks).Error
-	if err != nil {
+	if item.Status != "" {
+		t.Status = TaskStatus(item.Status)
+	}
+	t.Progress = item.Progress
+	t.FailReason = item.FailReason
+	if item.SubmitTime != 0 {
+		t.SubmitTime = item.SubmitTime / 1000
+	}
+	t.StartTime = item.StartTime / 1000
+	t.FinishTime = item.FinishTime / 1000
+}
+
+// NewMidjourneyTask 根据旧版 Midjourney 任务结构构造统一任务，时间字段由毫秒转换为秒
+func NewMidjourneyTask(mj *Midjourney) *Task {
+	status := TaskStatus(mj.Status)
+	if status == "" {
+		status = TaskStatusSubmitted
+	}
+	task := &Task{
+		CreatedAt:  mj.SubmitTime / 1000,
+		TaskID:     mj.MjId,
+		Platform:   constant.TaskPlatformMidjourney,
+		UserId:     mj.UserId,
+		ChannelId:  mj.ChannelId,
+		Quota:      mj.Quota,
+		Action:     mj.Action,
+		Status:     status,
+		FailReason: mj.FailReason,
+		SubmitTime: mj.SubmitTime / 1000,
+		StartTime:  mj.StartTime / 1000,
+		FinishTime: mj.FinishTime / 1000,
+		Progress:   mj.Progress,
+		Properties: Properties{
+			Input:           mj.Prompt,
+			OriginModelName: midjourneyActionModelName(mj.Action),
+		},
+	}
+	task.SetMidjourneyData(&MidjourneyTaskData{
+		Code:        mj.Code,
+		Prompt:      mj.Prompt,
+		PromptEn:    mj.PromptEn,
+		Description: mj.Description,
+		State:       mj.State,
+		ImageUrl:    mj.ImageUrl,
+		VideoUrl:    mj.VideoUrl,
+		VideoUrls:   rawJSONOrNil(mj.VideoUrls),
+		Buttons:     rawJSONOrNil(mj.Buttons),
+		Properties:  rawJSONOrNil(mj.Properties),
+	})
+	return task
+}
+
+func midjourneyActionModelName(action string) string {
+	for modelName, modelAction := range constant.MidjourneyModel2Action {
+		if modelAction == action {
+			return modelName
+		}
+	}
+	return ""
+}
+
+func rawJSONOrNil(s string) json.RawMessage {
+	if s == "" || !json.Valid([]byte(s)) {
 		return nil
 	}
+	return json.RawMessage(s)
+}
 
-	return tasks
+// migrateMidjourneyTasks 将旧版 midjourneys 表中的任务分批复制到统一任务表。
+// 每批的写入与旧表标记在同一事务内完成，中断后重新执行不会产生重复数据；
+// 旧表保留不删除，确认迁移完整后由管理员通过 DropMidjourneyLegacyTable 删除
+func migrateMidjourneyTasks() error {
+	if !DB.Migrator().HasTable(&Midjourney{}) {
+		return nil
+	}
+	if !DB.Migrator().HasColumn(&Midjourney{}, "Migrated") {
+		if err := DB.Migrator().AddColumn(&Midjourney{}, "Migrated"); err != nil {
+			return fmt.Errorf("failed to add midjourney migrated column: %w", err)
+		}
+	}
+	migrated := 0
+	for {
+		var items []*Midjourney
+		if err := DB.Where("migrated = ?", false).Order("id").Limit(500).Find(&items).Error; err != nil {
+			return fmt.Errorf("failed to query midjourney tasks: %w", err)
+		}
+		if len(items) == 0 {
+			break
+		}
+		ids := make([]int, 0, len(items))
+		tasks := make([]*Task, 0, len(items))
+		for _, item := range items {
+			ids = append(ids, item.Id)
+			tasks = append(tasks, NewMidjourneyTask(item))
+		}
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&tasks).Error; err != nil {
+				return err
+			}
+			return tx.Model(&Midjourney{}).Where("id in (?)", ids).Update("migrated", true).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate midjourney tasks: %w", err)
+		}
+		migrated += len(items)
+	}
+	if migrated > 0 {
+		common.SysLog(fmt.Sprintf("migrated %d midjourney tasks to unified task table, legacy table kept", migrated))
+	}
+	return nil
 }
 
-func GetAllTasks(startIdx int, num int, queryParams TaskQueryParams) []*Midjourney {
-	var tasks []*Midjourney
-	var err error
+// MidjourneyLegacyStatus 旧版 midjourneys 表的迁移情况
+type MidjourneyLegacyStatus struct {
+	Exists   bool  `json:"exists"`
+	Total    int64 `json:"total"`
+	Pending  int64 `json:"pending"`  // 尚未复制到统一任务表的行数
+	Migrated int64 `json:"migrated"` // 统一任务表中 Midjourney 任务数
+}
 
-	// 初始化查询构建器
-	query := DB
+func GetMidjourneyLegacyStatus() (*MidjourneyLegacyStatus, error) {
+	status := &MidjourneyLegacyStatus{}
+	if err := DB.Model(&Task{}).Where("platform = ?", constant.TaskPlatformMidjourney).Count(&status.Migrated).Error; err != nil {
+		return nil, err
+	}
+	if !DB.Migrator().HasTable(&Midjourney{}) {
+		return status, nil
+	}
+	status.Exists = true
+	if err := DB.Model(&Midjourney{}).Count(&status.Total).Error; err != nil {
+		return nil, err
+	}
+	if DB.Migrator().HasColumn(&Midjourney{}, "Migrated") {
+		if err := DB.Model(&Midjourney{}).Where("migrated = ?", false).Count(&status.Pending).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		status.Pending = status.Total
+	}
+	return status, nil
+}
 
-	// 添加过滤条件
+// DropMidjourneyLegacyTable 删除旧版 midjourneys 表，仍有未迁移的数据时拒绝删除
+func DropMidjourneyLegacyTable() error {
+	status, err := GetMidjourneyLegacyStatus()
+	if err != nil {
+		return err
+	}
+	if !status.Exists {
+		return nil
+	}
+	if status.Pending > 0 {
+		return fmt.Errorf("旧表仍有 %d 条任务未迁移，请先重启完成迁移", status.Pending)
+	}
+	return DB.Migrator().DropTable(&Midjourney{})
+}
+
+// TaskQueryParams 用于包含所有搜索条件的结构体，可以根据需求添加更多字段
+type TaskQueryParams struct {
+	ChannelID      string
+	MjID           string
+	StartTimestamp string
+	EndTimestamp   string
+}
+
+// midjourneyTaskQuery 构造 Midjourney 任务查询，时间条件沿用旧接口的毫秒单位
+func midjourneyTaskQuery(queryParams TaskQueryParams) *gorm.DB {
+	query := DB.Model(&Task{}).Where("platform = ?", constant.TaskPlatformMidjourney)
 	if queryParams.ChannelID != "" {
 		query = query.Where("channel_id = ?", queryParams.ChannelID)
 	}
 	if queryParams.MjID != "" {
-		query = query.Where("mj_id = ?", queryParams.MjID)
+		query = query.Where("task_id = ?", queryParams.MjID)
 	}
 	if queryParams.StartTimestamp != "" {
-		query = query.Where("submit_time >= ?", queryParams.StartTimestamp)
+		startTimestamp, _ := strconv.ParseInt(queryParams.StartTimestamp, 10, 64)
+		query = query.Where("submit_time >= ?", startTimestamp/1000)
 	}
 	if queryParams.EndTimestamp != "" {
-		query = query.Where("submit_time <= ?", queryParams.EndTimestamp)
+		endTimestamp, _ := strconv.ParseInt(queryParams.EndTimestamp, 10, 64)
+		query = query.Where("submit_time <= ?", endTimestamp/1000)
 	}
+	return query
+}
 
-	// 获取数据
-	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&tasks).Error
+func findMidjourneyTasks(query *gorm.DB, startIdx int, num int) []*Midjourney {
+	var tasks []*Task
+	err := query.Order("id desc").Limit(num).Offset(startIdx).Find(&tasks).Error
 	if err != nil {
 		return nil
 	}
+	items := make([]*Midjourney, 0, len(tasks))
+	for _, task := range tasks {
+		items = append(items, task.ToMidjourney())
+	}
+	return items
+}
 
-	return tasks
+func GetAllUserTask(userId int, startIdx int, num int, queryParams TaskQueryParams) []*Midjourney {
+	queryParams.ChannelID = ""
+	return findMidjourneyTasks(midjourneyTaskQuery(queryParams).Where("user_id = ?", userId), startIdx, num)
 }
 
-func GetAllUnFinishTasks() []*Midjourney {
-	var tasks []*Midjourney
-	var err error
-	// get all tasks progress is not 100%
-	err = DB.Where("progress != ?", "100%").Find(&tasks).Error
-	if err != nil {
-		return nil
-	}
-	return tasks
+func GetAllTasks(startIdx int, num int, queryParams TaskQueryParams) []*Midjourney {
+	return findMidjourneyTasks(midjourneyTaskQuery(queryParams), startIdx, num)
 }
 
-func GetByOnlyMJId(mjId string) *Midjourney {
-	var mj *Midjourney
-	var err error
-	err = DB.Where("mj_id = ?", mjId).First(&mj).Error
-	if err != nil {
+func GetByOnlyMJId(mjId string) *Task {
+	if mjId == "" {
 		return nil
 	}
-	return mj
-}
-
-func GetByMJId(userId int, mjId string) *Midjourney {
-	var mj *Midjourney
-	var err error
-	err = DB.Where("user_id = ? and mj_id = ?", userId, mjId).First(&mj).Error
+	var task *Task
+	err := DB.Where("platform = ? and task_id = ?", constant.TaskPlatformMidjourney, mjId).First(&task).Error
 	if err != nil {
 		return nil
 	}
-	return mj
+	return task
 }
 
-func GetByMJIds(userId int, mjIds []string) []*Midjourney {
-	var mj []*Midjourney
-	var err error
-	err = DB.Where("user_id = ? and mj_id in (?)", userId, mjIds).Find(&mj).Error
+func GetByMJId(userId int, mjId string) *Task {
+	if mjId == "" {
+		return nil
+	}
+	var task *Task
+	err := DB.Where("platform = ? and user_id = ? and task_id = ?", constant.TaskPlatformMidjourney, userId, mjId).First(&task).Error
 	if err != nil {
 		return nil
 	}
-	return mj
+	return task
 }
 
-func GetMjByuId(id int) *Midjourney {
-	var mj *Midjourney
-	var err error
-	err = DB.Where("id = ?", id).First(&mj).Error
+func GetByMJIds(userId int, mjIds []string) []*Task {
+	var tasks []*Task
+	err := DB.Where("platform = ? and user_id = ? and task_id in (?)", constant.TaskPlatformMidjourney, userId, mjIds).Find(&tasks).Error
 	if err != nil {
 		return nil
 	}
-	return mj
-}
-
-func UpdateProgress(id int, progress string) error {
-	return DB.Model(&Midjourney{}).Where("id = ?", id).Update("progress", progress).Error
-}
-
-func (midjourney *Midjourney) Insert() error {
-	var err error
-	err = DB.Create(midjourney).Error
-	return err
-}
-
-func (midjourney *Midjourney) Update() error {
-	var err error
-	err = DB.Save(midjourney).Error
-	return err
-}
-
-func MjBulkUpdate(mjIds []string, params map[string]any) error {
-	return DB.Model(&Midjourney{}).
-		Where("mj_id in (?)", mjIds).
-		Updates(params).Error
-}
-
-func MjBulkUpdateByTaskIds(taskIDs []int, params map[string]any) error {
-	return DB.Model(&Midjourney{}).
-		Where("id in (?)", taskIDs).
-		Updates(params).Error
+	return tasks
 }
 
 // CountAllTasks returns total midjourney tasks for admin query
 func CountAllTasks(queryParams TaskQueryParams) int64 {
 	var total int64
-	query := DB.Model(&Midjourney{})
-	if queryParams.ChannelID != "" {
-		query = query.Where("channel_id = ?", queryParams.ChannelID)
-	}
-	if queryParams.MjID != "" {
-		query = query.Where("mj_id = ?", queryParams.MjID)
-	}
-	if queryParams.StartTimestamp != "" {
-		query = query.Where("submit_time >= ?", queryParams.StartTimestamp)
-	}
-	if queryParams.EndTimestamp != "" {
-		query = query.Where("submit_time <= ?", queryParams.EndTimestamp)
-	}
-	_ = query.Count(&total).Error
+	_ = midjourneyTaskQuery(queryParams).Count(&total).Error
 	return total
 }
 
 // CountAllUserTask returns total midjourney tasks for user
 func CountAllUserTask(userId int, queryParams TaskQueryParams) int64 {
 	var total int64
-	query := DB.Model(&Midjourney{}).Where("user_id = ?", userId)
-	if queryParams.MjID != "" {
-		query = query.Where("mj_id = ?", queryParams.MjID)
-	}
-	if queryParams.StartTimestamp != "" {
-		query = query.Where("submit_time >= ?", queryParams.StartTimestamp)
-	}
-	if queryParams.EndTimestamp != "" {
-		query = query.Where("submit_time <= ?", queryParams.EndTimestamp)
-	}
-	_ = query.Count(&total).Error
+	queryParams.ChannelID = ""
+	_ = midjourneyTaskQuery(queryParams).Where("user_id = ?", userId).Count(&total).Error
 	return total
 }
@@ -3,6 +3,7 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
 	"strings"
 
 	"sync"
@@ -15,18 +16,21 @@ import (
 )
 
 type Pricing struct {
-	ModelName              string                  `json:"model_name"`
-	Description            string                  `json:"description,omitempty"`
-	Icon                   string                  `json:"icon,omitempty"`
-	Tags                   string                  `json:"tags,omitempty"`
-	VendorID               int                     `json:"vendor_id,omitempty"`
-	QuotaType              int                     `json:"quota_type"`
-	ModelRatio             float64                 `json:"model_ratio"`
-	ModelPrice             float64                 `json:"model_price"`
-	OwnerBy                string                  `json:"owner_by"`
-	CompletionRatio        float64                 `json:"completion_ratio"`
-	EnableGroup            []string                `json:"enable_groups"`
-	SupportedEndpointTypes []constant.EndpointType `json:"supported_endpoint_types"`
+	ModelName              string                           `json:"model_name"`
+	Description            string                           `json:"description,omitempty"`
+	Icon                   string                           `json:"icon,omitempty"`
+	Tags                   string                           `json:"tags,omitempty"`
+	VendorID               int                              `json:"vendor_id,omitempty"`
+	QuotaType              int                              `json:"quota_type"`
+	ModelRatio             float64                          `json:"model_ratio"`
+	ModelPrice             float64                          `json:"model_price"`
+	OwnerBy                string                           `json:"owner_by"`
+	CompletionRatio        float64                          `json:"completion_ratio"`
+	EnableGroup            []string                         `json:"enable_groups"`
+	SupportedEndpointTypes []constant.EndpointType          `json:"supported_endpoint_types"`
+	PricingTiers           *ratio_setting.TieredPricingRule `json:"pricing_tiers,omitempty"`
+	IsVirtual              bool                             `json:"is_virtual,omitempty"`
+	VirtualTargets         []string                         `json:"virtual_targets,omitempty"`
 }
 
 type PricingVendor struct {
@@ -281,6 +285,7 @@ func updatePricing() {
 			pricing.Tags = meta.Tags
 			pricing.VendorID = meta.VendorID
 		}
+		pricing.PricingTiers = ratio_setting.GetTieredPricingRule(model)
 		modelPrice, findPrice := ratio_setting.GetModelPrice(model, false)
 		if findPrice {
 			pricing.ModelPrice = modelPrice
@@ -294,6 +299,60 @@ func updatePricing() {
 		pricingMap = append(pricingMap, pricing)
 	}
 
+	// 虚拟模型：启用分组与端点取各目标模型的并集，价格按权重最高的默认目标展示
+	for _, vm := range CacheGetAllVirtualModels() {
+		if _, exists := modelGroupsMap[vm.Name]; exists {
+			continue
+		}
+		targetModels := vm.GetAllTargetModels()
+		groups := types.NewSet[string]()
+		endpoints := make([]constant.EndpointType, 0)
+		for _, target := range targetModels {
+			if targetGroups, ok := modelGroupsMap[target]; ok {
+				for _, g := range targetGroups.Items() {
+					groups.Add(g)
+				}
+			}
+			for _, et := range modelSupportEndpointTypes[target] {
+				if !slices.Contains(endpoints, et) {
+					endpoints = append(endpoints, et)
+				}
+			}
+		}
+		if groups.Len() == 0 {
+			continue
+		}
+		modelSupportEndpointTypes[vm.Name] = endpoints
+		pricing := Pricing{
+			ModelName:              vm.Name,
+			Description:            vm.Description,
+			OwnerBy:                "virtual",
+			EnableGroup:            groups.Items(),
+			SupportedEndpointTypes: endpoints,
+			IsVirtual:              true,
+			VirtualTargets:         targetModels,
+		}
+		pricing.PricingTiers = ratio_setting.GetTieredPricingRule(vm.Name)
+		primary := ""
+		maxWeight := -1
+		for _, t := range vm.GetTargets() {
+			if t.Weight > maxWeight {
+				primary = t.Model
+				maxWeight = t.Weight
+			}
+		}
+		if modelPrice, findPrice := ratio_setting.GetModelPrice(primary, false); findPrice {
+			pricing.ModelPrice = modelPrice
+			pricing.QuotaType = 1
+		} else {
+			modelRatio, _, _ := ratio_setting.GetModelRatio(primary)
+			pricing.ModelRatio = modelRatio
+			pricing.CompletionRatio = ratio_setting.GetCompletionRatio(primary)
+			pricing.QuotaType = 0
+		}
+		pricingMap = append(pricingMap, pricing)
+	}
+
 	// 刷新缓存映射，供高并发快速查询
 	modelEnableGroupsLock.Lock()
 	modelEnableGroups = make(map[string][]string)
@@ -145,12 +145,16 @@ func Redeem(key string, userId int) (quota int, err error) {
 		redemption.Status = common.RedemptionCodeStatusUsed
 		redemption.UsedUserId = userId
 		err = tx.Save(redemption).Error
-		return err
+		if err != nil {
+			return err
+		}
+		return PublishTopupEventTx(tx, userId, "redemption", strconv.Itoa(redemption.Id), redemption.Quota, 0)
 	})
 	if err != nil {
 		return 0, errors.New("兑换失败，" + err.Error())
 	}
 	RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s，兑换码ID %d", logger.LogQuota(redemption.Quota), redemption.Id))
+	NotifyEventBus()
 	return redemption.Quota, nil
 }
 
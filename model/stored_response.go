@@ -0,0 +1,48 @@
+package model
+
+import (
+	"encoding/json"
+)
+
+// StoredResponse 网关侧保存的 Responses API 响应，归属于创建它的用户
+type StoredResponse struct {
+	Id                 int64           `json:"-"`
+	ResponseId         string          `json:"response_id" gorm:"type:varchar(191);uniqueIndex"`
+	UserId             int             `json:"user_id" gorm:"index"`
+	TokenId            int             `json:"token_id"`
+	ChannelId          int             `json:"channel_id"`
+	Model              string          `json:"model" gorm:"type:varchar(191)"`
+	PreviousResponseId string          `json:"previous_response_id" gorm:"type:varchar(191)"`
+	Input              json.RawMessage `json:"input" gorm:"type:json"`    // 本轮请求的 input items（不含展开的历史）
+	Response           json.RawMessage `json:"response" gorm:"type:json"` // 上游返回的完整 response 对象
+	CreatedAt          int64           `json:"created_at" gorm:"bigint;index"`
+}
+
+func (r *StoredResponse) Insert() error {
+	return DB.Create(r).Error
+}
+
+// GetUserStoredResponse 查询用户自己的响应，不存在时返回 gorm.ErrRecordNotFound
+func GetUserStoredResponse(userId int, responseId string) (*StoredResponse, error) {
+	var resp StoredResponse
+	err := DB.Where("user_id = ? AND response_id = ?", userId, responseId).First(&resp).Error
+	return &resp, err
+}
+
+func DeleteUserStoredResponse(userId int, responseId string) (bool, error) {
+	result := DB.Where("user_id = ? AND response_id = ?", userId, responseId).Delete(&StoredResponse{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteStoredResponsesBefore 删除 before 之前创建的响应，返回删除数量
+func DeleteStoredResponsesBefore(before int64, limit int) (int64, error) {
+	var ids []int64
+	if err := DB.Model(&StoredResponse{}).Where("created_at < ?", before).Order("id").Limit(limit).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := DB.Where("id in (?)", ids).Delete(&StoredResponse{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,363 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SubscriptionPeriodMonth = "month"
+	SubscriptionPeriodYear  = "year"
+)
+
+const (
+	// SubscriptionOverageBlock 订阅额度用尽后拒绝请求
+	SubscriptionOverageBlock = "block"
+	// SubscriptionOverageBalance 订阅额度用尽后使用账户余额
+	SubscriptionOverageBalance = "balance"
+)
+
+const (
+	UserSubscriptionStatusPending  = "pending"
+	UserSubscriptionStatusActive   = "active"
+	UserSubscriptionStatusCanceled = "canceled"
+	UserSubscriptionStatusExpired  = "expired"
+)
+
+// stripe 续费存在延迟，到期后保留一段宽限期再判定过期
+const subscriptionStripeGracePeriod = 24 * 3600
+
+// SubscriptionPlan 订阅套餐
+type SubscriptionPlan struct {
+	Id            int            `json:"id"`
+	Name          string         `json:"name" gorm:"size:128;not null"`
+	Description   string         `json:"description" gorm:"type:text"`
+	Price         float64        `json:"price"`                                            // 每个周期的价格（仅展示，实际扣款以 Stripe 价格为准）
+	Period        string         `json:"period" gorm:"type:varchar(16);default:'month'"`   // month / year
+	IncludedQuota int            `json:"included_quota" gorm:"default:0"`                  // 每个周期包含的额度，周期开始时重置
+	UpgradeGroup  string         `json:"upgrade_group" gorm:"type:varchar(64);default:''"` // 订阅期间将用户切换到该分组，为空表示不变
+	AllowedModels string         `json:"allowed_models" gorm:"type:text"`                  // 订阅额度可用的模型，逗号分隔，为空表示不限
+	OveragePolicy string         `json:"overage_policy" gorm:"type:varchar(16);default:'block'"`
+	StripePriceId string         `json:"stripe_price_id" gorm:"type:varchar(128);default:''"`
+	Status        int            `json:"status" gorm:"default:1"`
+	CreatedTime   int64          `json:"created_time" gorm:"bigint"`
+	UpdatedTime   int64          `json:"updated_time" gorm:"bigint"`
+	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// UserSubscription 用户订阅
+type UserSubscription struct {
+	Id                   int    `json:"id"`
+	UserId               int    `json:"user_id" gorm:"index"`
+	PlanId               int    `json:"plan_id" gorm:"index"`
+	Status               string `json:"status" gorm:"type:varchar(16);index"`
+	QuotaRemaining       int    `json:"quota_remaining" gorm:"default:0"`
+	QuotaUsed            int    `json:"quota_used" gorm:"default:0"`
+	PeriodStart          int64  `json:"period_start" gorm:"bigint"`
+	PeriodEnd            int64  `json:"period_end" gorm:"bigint;index"`
+	PreviousGroup        string `json:"previous_group" gorm:"type:varchar(64);default:''"`
+	TradeNo              string `json:"trade_no" gorm:"type:varchar(255);index"`
+	StripeSubscriptionId string `json:"stripe_subscription_id" gorm:"type:varchar(255);index"`
+	CancelAtPeriodEnd    bool   `json:"cancel_at_period_end"`
+	CreatedTime          int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime          int64  `json:"updated_time" gorm:"bigint"`
+
+	Plan *SubscriptionPlan `json:"plan,omitempty" gorm:"-"`
+}
+
+func (plan *SubscriptionPlan) GetAllowedModels() []string {
+	models := make([]string, 0)
+	for _, m := range strings.Split(plan.AllowedModels, ",") {
+		m = strings.TrimSpace(m)
+		if m != "" {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// AllowsModel 判断模型是否可以使用订阅额度
+func (plan *SubscriptionPlan) AllowsModel(modelName string) bool {
+	allowed := plan.GetAllowedModels()
+	if len(allowed) == 0 {
+		return true
+	}
+	return common.StringsContains(allowed, modelName)
+}
+
+// NextPeriodEnd 返回从 start 开始一个周期后的时间
+func (plan *SubscriptionPlan) NextPeriodEnd(start int64) int64 {
+	t := time.Unix(start, 0)
+	if plan.Period == SubscriptionPeriodYear {
+		return t.AddDate(1, 0, 0).Unix()
+	}
+	return t.AddDate(0, 1, 0).Unix()
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	now := common.GetTimestamp()
+	plan.CreatedTime = now
+	plan.UpdatedTime = now
+	return DB.Create(plan).Error
+}
+
+func (plan *SubscriptionPlan) Update() error {
+	plan.UpdatedTime = common.GetTimestamp()
+	defer invalidateAllSubscriptionCache()
+	return DB.Save(plan).Error
+}
+
+func DeleteSubscriptionPlanById(id int) error {
+	defer invalidateAllSubscriptionCache()
+	return DB.Delete(&SubscriptionPlan{}, id).Error
+}
+
+func GetAllSubscriptionPlans(enabledOnly bool) ([]*SubscriptionPlan, error) {
+	var plans []*SubscriptionPlan
+	tx := DB.Order("id ASC")
+	if enabledOnly {
+		tx = tx.Where("status = ?", 1)
+	}
+	err := tx.Find(&plans).Error
+	return plans, err
+}
+
+func GetSubscriptionPlanById(id int) (*SubscriptionPlan, error) {
+	var plan SubscriptionPlan
+	err := DB.Unscoped().First(&plan, id).Error
+	return &plan, err
+}
+
+func (sub *UserSubscription) Insert() error {
+	now := common.GetTimestamp()
+	sub.CreatedTime = now
+	sub.UpdatedTime = now
+	return DB.Create(sub).Error
+}
+
+func (sub *UserSubscription) Update() error {
+	sub.UpdatedTime = common.GetTimestamp()
+	defer InvalidateUserSubscriptionCache(sub.UserId)
+	return DB.Save(sub).Error
+}
+
+func (sub *UserSubscription) IsActive() bool {
+	return sub.Status == UserSubscriptionStatusActive
+}
+
+func GetUserSubscriptionById(id int) (*UserSubscription, error) {
+	var sub UserSubscription
+	err := DB.First(&sub, id).Error
+	return &sub, err
+}
+
+func GetUserSubscriptionByTradeNo(tradeNo string) (*UserSubscription, error) {
+	var sub UserSubscription
+	err := DB.Where("trade_no = ?", tradeNo).First(&sub).Error
+	return &sub, err
+}
+
+func GetUserSubscriptionByStripeId(stripeSubscriptionId string) (*UserSubscription, error) {
+	var sub UserSubscription
+	err := DB.Where("stripe_subscription_id = ?", stripeSubscriptionId).First(&sub).Error
+	return &sub, err
+}
+
+// GetActiveUserSubscription 获取用户当前生效的订阅，没有时返回 nil
+func GetActiveUserSubscription(userId int) (*UserSubscription, error) {
+	var sub UserSubscription
+	err := DB.Where("user_id = ? AND status = ?", userId, UserSubscriptionStatusActive).Order("id DESC").First(&sub).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	plan, err := GetSubscriptionPlanById(sub.PlanId)
+	if err != nil {
+		return nil, err
+	}
+	sub.Plan = plan
+	return &sub, nil
+}
+
+func GetUserSubscriptions(userId int) ([]*UserSubscription, error) {
+	var subs []*UserSubscription
+	err := DB.Where("user_id = ?", userId).Order("id DESC").Find(&subs).Error
+	return subs, err
+}
+
+func GetAllUserSubscriptions(pageInfo *common.PageInfo, status string) ([]*UserSubscription, int64, error) {
+	var subs []*UserSubscription
+	var total int64
+	tx := DB.Model(&UserSubscription{})
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := tx.Order("id DESC").Limit(pageInfo.GetPageSize()).Offset(pageInfo.GetStartIdx()).Find(&subs).Error
+	return subs, total, err
+}
+
+// ActivateUserSubscription 开始一个新的订阅周期：重置包含额度，并在需要时升级用户分组
+func ActivateUserSubscription(sub *UserSubscription, plan *SubscriptionPlan) error {
+	now := common.GetTimestamp()
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if !sub.IsActive() && plan.UpgradeGroup != "" {
+			var user User
+			if err := tx.Select("id", commonGroupCol).First(&user, sub.UserId).Error; err != nil {
+				return err
+			}
+			if user.Group != plan.UpgradeGroup {
+				sub.PreviousGroup = user.Group
+				if err := tx.Model(&User{}).Where("id = ?", sub.UserId).Update("group", plan.UpgradeGroup).Error; err != nil {
+					return err
+				}
+			}
+		}
+		sub.Status = UserSubscriptionStatusActive
+		sub.PeriodStart = now
+		sub.PeriodEnd = plan.NextPeriodEnd(now)
+		sub.QuotaRemaining = plan.IncludedQuota
+		sub.QuotaUsed = 0
+		sub.UpdatedTime = now
+		return tx.Save(sub).Error
+	})
+	if err == nil {
+		_ = invalidateUserCache(sub.UserId)
+		InvalidateUserSubscriptionCache(sub.UserId)
+	}
+	return err
+}
+
+// EndUserSubscription 结束订阅并还原用户分组
+func EndUserSubscription(sub *UserSubscription, status string) error {
+	wasActive := sub.IsActive()
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if wasActive && sub.PreviousGroup != "" {
+			if err := tx.Model(&User{}).Where("id = ?", sub.UserId).Update("group", sub.PreviousGroup).Error; err != nil {
+				return err
+			}
+		}
+		sub.Status = status
+		sub.UpdatedTime = common.GetTimestamp()
+		return tx.Save(sub).Error
+	})
+	if err == nil && wasActive {
+		_ = invalidateUserCache(sub.UserId)
+	}
+	if err == nil {
+		InvalidateUserSubscriptionCache(sub.UserId)
+	}
+	return err
+}
+
+// DecreaseUserSubscriptionQuota 从订阅额度中扣除，quota 为负数时返还
+func DecreaseUserSubscriptionQuota(id int, quota int) error {
+	return DB.Model(&UserSubscription{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"quota_remaining": gorm.Expr("quota_remaining - ?", quota),
+		"quota_used":      gorm.Expr("quota_used + ?", quota),
+	}).Error
+}
+
+// PreConsumeUserSubscriptionQuota 在订阅剩余额度充足时预扣额度，额度不足时返回 false
+func PreConsumeUserSubscriptionQuota(id int, quota int) (bool, error) {
+	result := DB.Model(&UserSubscription{}).Where("id = ? AND status = ? AND quota_remaining >= ? AND quota_remaining > 0", id, UserSubscriptionStatusActive, quota).Updates(map[string]interface{}{
+		"quota_remaining": gorm.Expr("quota_remaining - ?", quota),
+		"quota_used":      gorm.Expr("quota_used + ?", quota),
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ExpireUserSubscriptions 处理到期的订阅，返回处理数量
+func ExpireUserSubscriptions() (int, error) {
+	now := common.GetTimestamp()
+	var subs []*UserSubscription
+	err := DB.Where("status = ? AND period_end < ?", UserSubscriptionStatusActive, now).Find(&subs).Error
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, sub := range subs {
+		if sub.StripeSubscriptionId != "" && !sub.CancelAtPeriodEnd && sub.PeriodEnd+subscriptionStripeGracePeriod > now {
+			// 等待 Stripe 续费回调
+			continue
+		}
+		if err := EndUserSubscription(sub, UserSubscriptionStatusExpired); err != nil {
+			common.SysLog(fmt.Sprintf("failed to expire subscription %d: %s", sub.Id, err.Error()))
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// CompleteSubscriptionCheckout Stripe 订阅支付完成后激活订阅，重复回调时直接返回
+func CompleteSubscriptionCheckout(tradeNo string, stripeSubscriptionId string, customerId string) error {
+	if tradeNo == "" {
+		return errors.New("未提供订阅单号")
+	}
+	sub, err := GetUserSubscriptionByTradeNo(tradeNo)
+	if err != nil {
+		return errors.New("订阅订单不存在")
+	}
+	if sub.Status != UserSubscriptionStatusPending {
+		return nil
+	}
+	plan, err := GetSubscriptionPlanById(sub.PlanId)
+	if err != nil {
+		return err
+	}
+	sub.StripeSubscriptionId = stripeSubscriptionId
+	if customerId != "" {
+		if err := DB.Model(&User{}).Where("id = ?", sub.UserId).Update("stripe_customer", customerId).Error; err != nil {
+			return err
+		}
+	}
+	// 同一用户只保留一个生效订阅
+	if old, err := GetActiveUserSubscription(sub.UserId); err == nil && old != nil && old.Id != sub.Id {
+		if err := EndUserSubscription(old, UserSubscriptionStatusCanceled); err != nil {
+			return err
+		}
+	}
+	if err := ActivateUserSubscription(sub, plan); err != nil {
+		return err
+	}
+	RecordLog(sub.UserId, LogTypeTopup, fmt.Sprintf("订阅套餐 %s 已生效，包含额度 %s", plan.Name, logger.LogQuota(plan.IncludedQuota)))
+	PublishTopupEvent(sub.UserId, "subscription", sub.TradeNo, plan.IncludedQuota, plan.Price)
+	return nil
+}
+
+// RenewStripeUserSubscription Stripe 续费成功后开始新的订阅周期
+func RenewStripeUserSubscription(stripeSubscriptionId string) error {
+	sub, err := GetUserSubscriptionByStripeId(stripeSubscriptionId)
+	if err != nil {
+		return errors.New("订阅不存在")
+	}
+	if sub.Status == UserSubscriptionStatusPending || sub.Status == UserSubscriptionStatusCanceled {
+		// 首次支付由 checkout 回调激活；已取消的订阅不再续期
+		return nil
+	}
+	plan, err := GetSubscriptionPlanById(sub.PlanId)
+	if err != nil {
+		return err
+	}
+	if err := ActivateUserSubscription(sub, plan); err != nil {
+		return err
+	}
+	RecordLog(sub.UserId, LogTypeTopup, fmt.Sprintf("订阅套餐 %s 已续费，额度已重置为 %s", plan.Name, logger.LogQuota(plan.IncludedQuota)))
+	PublishTopupEvent(sub.UserId, "subscription", sub.TradeNo, plan.IncludedQuota, plan.Price)
+	return nil
+}
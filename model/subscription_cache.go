@@ -0,0 +1,124 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 生效订阅缓存，转发请求时避免每次查询订阅与套餐。
+// 缓存中的 QuotaRemaining 仅用于展示，额度是否充足始终由 PreConsumeUserSubscriptionQuota 在数据库中原子判断。
+// 开启 Redis 时缓存在 Redis 中，否则缓存在本节点内存中，均在 SyncFrequency 秒后过期。
+// Redis 中的缓存带有写入时的全局版本号，套餐变更时递增版本号，使所有用户的缓存同时失效
+
+const subscriptionCacheVersionKey = "user_subscription:version"
+
+type subscriptionCacheEntry struct {
+	Sub       *UserSubscription `json:"sub"` // nil 表示没有生效订阅
+	Version   int64             `json:"version"`
+	ExpiresAt int64             `json:"-"`
+}
+
+var (
+	subscriptionMemoryCache     = make(map[int]*subscriptionCacheEntry)
+	subscriptionMemoryCacheLock sync.RWMutex
+)
+
+func getSubscriptionCacheKey(userId int) string {
+	return fmt.Sprintf("user_subscription:%d", userId)
+}
+
+func subscriptionCacheDuration() time.Duration {
+	return time.Duration(common.RedisKeyCacheSeconds()) * time.Second
+}
+
+// GetActiveUserSubscriptionCache 带缓存地获取用户生效的订阅，没有订阅时返回 nil
+func GetActiveUserSubscriptionCache(userId int) (*UserSubscription, error) {
+	entry, version, ok := getSubscriptionCacheEntry(userId)
+	if ok {
+		return entry.Sub, nil
+	}
+	sub, err := GetActiveUserSubscription(userId)
+	if err != nil {
+		return nil, err
+	}
+	setSubscriptionCacheEntry(userId, &subscriptionCacheEntry{Sub: sub, Version: version})
+	return sub, nil
+}
+
+// getSubscriptionCacheEntry 读取缓存，同时返回当前的全局版本号，写入缓存时使用该版本号
+func getSubscriptionCacheEntry(userId int) (*subscriptionCacheEntry, int64, bool) {
+	if common.RedisEnabled {
+		values, err := common.RDB.MGet(context.Background(), subscriptionCacheVersionKey, getSubscriptionCacheKey(userId)).Result()
+		if err != nil {
+			return nil, 0, false
+		}
+		var version int64
+		if v, ok := values[0].(string); ok {
+			version, _ = strconv.ParseInt(v, 10, 64)
+		}
+		value, ok := values[1].(string)
+		if !ok || value == "" {
+			return nil, version, false
+		}
+		var entry subscriptionCacheEntry
+		if err = common.UnmarshalJsonStr(value, &entry); err != nil || entry.Version != version {
+			return nil, version, false
+		}
+		return &entry, version, true
+	}
+	subscriptionMemoryCacheLock.RLock()
+	entry, ok := subscriptionMemoryCache[userId]
+	subscriptionMemoryCacheLock.RUnlock()
+	if !ok || entry.ExpiresAt < time.Now().Unix() {
+		return nil, 0, false
+	}
+	return entry, 0, true
+}
+
+func setSubscriptionCacheEntry(userId int, entry *subscriptionCacheEntry) {
+	if common.RedisEnabled {
+		data, err := common.Marshal(entry)
+		if err != nil {
+			return
+		}
+		if err = common.RedisSet(getSubscriptionCacheKey(userId), string(data), subscriptionCacheDuration()); err != nil {
+			common.SysLog("failed to update subscription cache: " + err.Error())
+		}
+		return
+	}
+	entry.ExpiresAt = time.Now().Add(subscriptionCacheDuration()).Unix()
+	subscriptionMemoryCacheLock.Lock()
+	subscriptionMemoryCache[userId] = entry
+	subscriptionMemoryCacheLock.Unlock()
+}
+
+// InvalidateUserSubscriptionCache 订阅购买、续期、到期、取消或额度用尽时清除缓存
+func InvalidateUserSubscriptionCache(userId int) {
+	if common.RedisEnabled {
+		if err := common.RedisDel(getSubscriptionCacheKey(userId)); err != nil {
+			common.SysLog("failed to invalidate subscription cache: " + err.Error())
+		}
+		return
+	}
+	subscriptionMemoryCacheLock.Lock()
+	delete(subscriptionMemoryCache, userId)
+	subscriptionMemoryCacheLock.Unlock()
+}
+
+// invalidateAllSubscriptionCache 套餐变更时清除所有用户的缓存：Redis 中递增全局版本号，内存中直接清空
+func invalidateAllSubscriptionCache() {
+	if common.RedisEnabled {
+		if err := common.RDB.Incr(context.Background(), subscriptionCacheVersionKey).Err(); err != nil {
+			common.SysLog("failed to invalidate subscription cache: " + err.Error())
+		}
+		return
+	}
+	subscriptionMemoryCacheLock.Lock()
+	subscriptionMemoryCache = make(map[int]*subscriptionCacheEntry)
+	subscriptionMemoryCacheLock.Unlock()
+}
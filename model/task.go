@@ -65,6 +65,14 @@ type Task struct {
 	CallBackStatus     string `json:"callback_status" gorm:"type:varchar(20)"`
 	CallBackRetryCount int    `json:"callback_retry_count" gorm:"default:0"`
 	CallBackTime       int64  `json:"callback_time" gorm:"index"`
+	// Polling schedule related fields
+	NextPollAt   int64  `json:"next_poll_at" gorm:"index;default:0"`
+	PollAttempts int    `json:"poll_attempts" gorm:"default:0"`
+	LeaseOwner   string `json:"-" gorm:"type:varchar(64)"`
+	LeaseUntil   int64  `json:"-" gorm:"index;default:0"`
+	// 计费来源，退款与补扣时按原路径处理；TokenId 为 0 表示未扣令牌额度（如操练场）
+	TokenId        int `json:"-" gorm:"default:0"`
+	SubscriptionId int `json:"-" gorm:"default:0"`
 }
 
 func (t *Task) SetData(data any) {
@@ -134,7 +142,9 @@ type SyncTaskQueryParams struct {
 func InitTask(platform constant.TaskPlatform, relayInfo *commonRelay.RelayInfo) *Task {
 	properties := Properties{}
 	privateData := TaskPrivateData{}
+	channelId := 0
 	if relayInfo != nil && relayInfo.ChannelMeta != nil {
+		channelId = relayInfo.ChannelId
 		if relayInfo.ChannelMeta.ChannelType == constant.ChannelTypeGemini {
 			privateData.Key = relayInfo.ChannelMeta.ApiKey
 		}
@@ -152,11 +162,15 @@ func InitTask(platform constant.TaskPlatform, relayInfo *commonRelay.RelayInfo)
 		SubmitTime:  time.Now().Unix(),
 		Status:      TaskStatusNotStart,
 		Progress:    "0%",
-		ChannelId:   relayInfo.ChannelId,
+		ChannelId:   channelId,
 		Platform:    platform,
 		Properties:  properties,
 		PrivateData: privateData,
 	}
+	t.SubscriptionId = relayInfo.SubscriptionId
+	if !relayInfo.IsPlayground {
+		t.TokenId = relayInfo.TokenId
+	}
 	return t
 }
 
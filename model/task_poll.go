@@ -0,0 +1,186 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// 异步任务轮询调度：每个任务记录下次轮询时间，节点通过租约领取到期任务，
+// 启用 Redis 时使用 SETNX 加锁，否则使用数据库条件更新，保证同一任务同一时刻只被一个节点轮询。
+
+const taskPollLeaseKeyPrefix = "task_poll_lease:task:"
+
+var (
+	pollOwnerOnce sync.Once
+	pollOwner     string
+)
+
+// GetPollOwner 返回当前节点的租约标识
+func GetPollOwner() string {
+	pollOwnerOnce.Do(func() {
+		host := common.GetEnvOrDefaultString("NODE_NAME", "")
+		if host == "" {
+			host, _ = os.Hostname()
+		}
+		if host == "" {
+			host = "node"
+		}
+		pollOwner = fmt.Sprintf("%s-%d-%s", host, os.Getpid(), common.GetRandomString(6))
+		if len(pollOwner) > 64 {
+			pollOwner = pollOwner[len(pollOwner)-64:]
+		}
+	})
+	return pollOwner
+}
+
+func unfinishedTaskQuery(db *gorm.DB) *gorm.DB {
+	return db.Model(&Task{}).Where("progress != ?", "100%").
+		Where("status != ?", TaskStatusFailure).Where("status != ?", TaskStatusSuccess)
+}
+
+// acquirePollLeases 对候选 id 加租约，返回成功领取的 id
+func acquirePollLeases(query *gorm.DB, keyPrefix string, ids []int64, owner string, now int64, leaseSeconds int) ([]int64, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	leaseUntil := now + int64(leaseSeconds)
+	if common.RedisEnabled {
+		acquired := make([]int64, 0, len(ids))
+		for _, id := range ids {
+			ok, err := common.RDB.SetNX(context.Background(), fmt.Sprintf("%s%d", keyPrefix, id), owner, time.Duration(leaseSeconds)*time.Second).Result()
+			if err != nil {
+				return acquired, err
+			}
+			if ok {
+				acquired = append(acquired, id)
+			}
+		}
+		if len(acquired) > 0 {
+			err := query.Session(&gorm.Session{}).Where("id in (?)", acquired).
+				Updates(map[string]any{"lease_owner": owner, "lease_until": leaseUntil}).Error
+			if err != nil {
+				return acquired, err
+			}
+		}
+		return acquired, nil
+	}
+	err := query.Session(&gorm.Session{}).Where("id in (?)", ids).Where("lease_until < ?", now).
+		Updates(map[string]any{"lease_owner": owner, "lease_until": leaseUntil}).Error
+	if err != nil {
+		return nil, err
+	}
+	var acquired []int64
+	err = query.Session(&gorm.Session{}).Where("id in (?)", ids).
+		Where("lease_owner = ? AND lease_until = ?", owner, leaseUntil).Pluck("id", &acquired).Error
+	return acquired, err
+}
+
+// 只有租约仍由 owner 持有时才删除，避免本节点租约过期后误删其他节点重新领取的租约
+var releasePollLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func releasePollLease(keyPrefix string, id int64, owner string) {
+	if common.RedisEnabled {
+		key := fmt.Sprintf("%s%d", keyPrefix, id)
+		if err := releasePollLeaseScript.Run(context.Background(), common.RDB, []string{key}, owner).Err(); err != nil {
+			common.SysLog(fmt.Sprintf("failed to release poll lease: key=%s, error=%v", key, err))
+		}
+	}
+}
+
+// AcquireDueTasks 领取到期待轮询的任务
+func AcquireDueTasks(owner string, now int64, leaseSeconds int, limit int) ([]*Task, error) {
+	var ids []int64
+	err := unfinishedTaskQuery(DB).Where("next_poll_at <= ?", now).Where("lease_until < ?", now).
+		Order("next_poll_at, id").Limit(limit).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	acquired, err := acquirePollLeases(unfinishedTaskQuery(DB), taskPollLeaseKeyPrefix, ids, owner, now, leaseSeconds)
+	if len(acquired) == 0 {
+		return nil, err
+	}
+	var tasks []*Task
+	if findErr := DB.Where("id in (?)", acquired).Order("id").Find(&tasks).Error; findErr != nil {
+		return nil, findErr
+	}
+	return tasks, err
+}
+
+// ScheduleTaskNextPoll 记录下次轮询时间并释放租约
+func ScheduleTaskNextPoll(id int64, owner string, nextPollAt int64, attempts int) error {
+	err := DB.Model(&Task{}).Where("id = ? AND lease_owner = ?", id, owner).
+		Updates(map[string]any{
+			"next_poll_at":  nextPollAt,
+			"poll_attempts": attempts,
+			"lease_owner":   "",
+			"lease_until":   0,
+		}).Error
+	releasePollLease(taskPollLeaseKeyPrefix, id, owner)
+	return err
+}
+
+// FailUnfinishedTask 将未完成的任务标记为失败，返回是否由本次调用完成状态变更，用于防止重复退款
+func FailUnfinishedTask(id int64, reason string) (bool, error) {
+	result := unfinishedTaskQuery(DB).Where("id = ?", id).Updates(map[string]any{
+		"status":      TaskStatusFailure,
+		"progress":    "100%",
+		"fail_reason": reason,
+		"finish_time": time.Now().Unix(),
+		"lease_owner": "",
+		"lease_until": 0,
+	})
+	releasePollLease(taskPollLeaseKeyPrefix, id, GetPollOwner())
+	return result.RowsAffected > 0, result.Error
+}
+
+// RenewTaskLease 续期由当前节点执行中的任务租约，返回是否仍持有租约
+func RenewTaskLease(id int64, owner string, leaseUntil int64) (bool, error) {
+	result := unfinishedTaskQuery(DB).Where("id = ? AND lease_owner = ?", id, owner).
+		Update("lease_until", leaseUntil)
+	return result.RowsAffected > 0, result.Error
+}
+
+// CompleteUnfinishedTask 以条件更新完成未结束的任务，返回是否由本次调用完成状态变更
+func CompleteUnfinishedTask(id int64, params map[string]any) (bool, error) {
+	params["lease_owner"] = ""
+	params["lease_until"] = 0
+	result := unfinishedTaskQuery(DB).Where("id = ?", id).Updates(params)
+	releasePollLease(taskPollLeaseKeyPrefix, id, GetPollOwner())
+	return result.RowsAffected > 0, result.Error
+}
+
+// TaskQueueStat 异步任务队列深度统计
+type TaskQueueStat struct {
+	Platform         string `json:"platform"`
+	Pending          int64  `json:"pending"`            // 未完成任务数
+	Due              int64  `json:"due"`                // 已到轮询时间的任务数
+	Leased           int64  `json:"leased"`             // 正在被节点轮询的任务数
+	OldestSubmitTime int64  `json:"oldest_submit_time"` // 最早未完成任务的提交时间（Unix 秒）
+}
+
+func GetTaskQueueStats() ([]TaskQueueStat, error) {
+	now := time.Now().Unix()
+	selectSql := "sum(case when next_poll_at <= ? then 1 else 0 end) as due, " +
+		"sum(case when lease_until >= ? then 1 else 0 end) as leased, " +
+		"min(submit_time) as oldest_submit_time"
+	var stats []TaskQueueStat
+	err := unfinishedTaskQuery(DB).Select("platform, count(*) as pending, "+selectSql, now, now).
+		Group("platform").Order("platform").Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -77,38 +77,17 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	}
 	token, err = GetTokenByKey(key, false)
 	if err == nil {
-		if token.Status == common.TokenStatusExhausted {
-			keyPrefix := key[:3]
-			keySuffix := key[len(key)-3:]
-			return token, errors.New("该令牌额度已用尽 TokenStatusExhausted[sk-" + keyPrefix + "***" + keySuffix + "]")
-		} else if token.Status == common.TokenStatusExpired {
-			return token, errors.New("该令牌已过期")
-		}
-		if token.Status != common.TokenStatusEnabled {
-			return token, errors.New("该令牌状态不可用")
-		}
-		if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
-			if !common.RedisEnabled {
-				token.Status = common.TokenStatusExpired
+		newStatus, statusErr := token.CheckStatus()
+		if statusErr != nil {
+			if newStatus != 0 && !common.RedisEnabled {
+				// in this case, we can make sure the token is expired or exhausted
+				token.Status = newStatus
 				err := token.SelectUpdate()
 				if err != nil {
 					common.SysLog("failed to update token status" + err.Error())
 				}
 			}
-			return token, errors.New("该令牌已过期")
-		}
-		if !token.UnlimitedQuota && token.RemainQuota <= 0 {
-			if !common.RedisEnabled {
-				// in this case, we can make sure the token is exhausted
-				token.Status = common.TokenStatusExhausted
-				err := token.SelectUpdate()
-				if err != nil {
-					common.SysLog("failed to update token status" + err.Error())
-				}
-			}
-			keyPrefix := key[:3]
-			keySuffix := key[len(key)-3:]
-			return token, errors.New(fmt.Sprintf("[sk-%s***%s] 该令牌额度已用尽 !token.UnlimitedQuota && token.RemainQuota = %d", keyPrefix, keySuffix, token.RemainQuota))
+			return token, statusErr
 		}
 		return token, nil
 	}
@@ -120,6 +99,31 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	}
 }
 
+// CheckStatus 检查令牌是否可用，不会修改令牌。不可用时返回原因，
+// 以及令牌因过期或额度用尽应更新为的状态（0 表示无需更新）
+func (token *Token) CheckStatus() (int, error) {
+	keyPrefix, keySuffix := "", ""
+	if len(token.Key) >= 3 {
+		keyPrefix = token.Key[:3]
+		keySuffix = token.Key[len(token.Key)-3:]
+	}
+	if token.Status == common.TokenStatusExhausted {
+		return 0, errors.New("该令牌额度已用尽 TokenStatusExhausted[sk-" + keyPrefix + "***" + keySuffix + "]")
+	} else if token.Status == common.TokenStatusExpired {
+		return 0, errors.New("该令牌已过期")
+	}
+	if token.Status != common.TokenStatusEnabled {
+		return 0, errors.New("该令牌状态不可用")
+	}
+	if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
+		return common.TokenStatusExpired, errors.New("该令牌已过期")
+	}
+	if !token.UnlimitedQuota && token.RemainQuota <= 0 {
+		return common.TokenStatusExhausted, errors.New(fmt.Sprintf("[sk-%s***%s] 该令牌额度已用尽 !token.UnlimitedQuota && token.RemainQuota = %d", keyPrefix, keySuffix, token.RemainQuota))
+	}
+	return 0, nil
+}
+
 func GetTokenByIds(id int, userId int) (*Token, error) {
 	if id == 0 || userId == 0 {
 		return nil, errors.New("id 或 userId 为空！")
@@ -174,6 +178,16 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 func (token *Token) Insert() error {
 	var err error
 	err = DB.Create(token).Error
+	if err == nil {
+		PublishEvent(EventTypeTokenCreated, token.UserId, map[string]any{
+			"token_id":        token.Id,
+			"name":            token.Name,
+			"group":           token.Group,
+			"unlimited_quota": token.UnlimitedQuota,
+			"remain_quota":    token.RemainQuota,
+			"expired_time":    token.ExpiredTime,
+		})
+	}
 	return err
 }
 
@@ -91,7 +91,7 @@ func Recharge(referenceId string, customerId string) (err error) {
 			return err
 		}
 
-		return nil
+		return PublishTopupEventTx(tx, topUp.UserId, topUp.PaymentMethod, topUp.TradeNo, int(quota), topUp.Money)
 	})
 
 	if err != nil {
@@ -99,6 +99,7 @@ func Recharge(referenceId string, customerId string) (err error) {
 	}
 
 	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%d", logger.FormatQuota(int(quota)), topUp.Amount))
+	NotifyEventBus()
 
 	return nil
 }
@@ -294,15 +295,20 @@ func ManualCompleteTopUp(tradeNo string) error {
 
 		userId = topUp.UserId
 		payMoney = topUp.Money
-		return nil
+		return PublishTopupEventTx(tx, topUp.UserId, topUp.PaymentMethod, tradeNo, quotaToAdd, topUp.Money)
 	})
 
 	if err != nil {
 		return err
 	}
+	if userId == 0 {
+		// 订单此前已完成，不重复记录
+		return nil
+	}
 
 	// 事务外记录日志，避免阻塞
 	RecordLog(userId, LogTypeTopup, fmt.Sprintf("管理员补单成功，充值金额: %v，支付金额：%f", logger.FormatQuota(quotaToAdd), payMoney))
+	NotifyEventBus()
 	return nil
 }
 func RechargeCreem(referenceId string, customerEmail string, customerName string) (err error) {
@@ -363,7 +369,7 @@ func RechargeCreem(referenceId string, customerEmail string, customerName string
 			return err
 		}
 
-		return nil
+		return PublishTopupEventTx(tx, topUp.UserId, topUp.PaymentMethod, topUp.TradeNo, int(quota), topUp.Money)
 	})
 
 	if err != nil {
@@ -371,6 +377,7 @@ func RechargeCreem(referenceId string, customerEmail string, customerName string
 	}
 
 	RecordLog(topUp.UserId, LogTypeTopup, fmt.Sprintf("使用Creem充值成功，充值额度: %v，支付金额：%.2f", quota, topUp.Money))
+	NotifyEventBus()
 
 	return nil
 }
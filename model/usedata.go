@@ -27,6 +27,7 @@ func UpdateQuotaData() {
 			common.SysLog("正在更新数据看板数据...")
 			SaveQuotaDataCache()
 		}
+		SaveChannelCostDataCache()
 		time.Sleep(time.Duration(common.DataExportInterval) * time.Minute)
 	}
 }
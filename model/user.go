@@ -412,6 +412,12 @@ func (user *User) Insert(inviterId int) error {
 		}
 	}
 
+	PublishEvent(EventTypeUserRegistered, user.Id, map[string]any{
+		"username":   user.Username,
+		"email":      user.Email,
+		"group":      user.Group,
+		"inviter_id": inviterId,
+	})
 	if common.QuotaForNewUser > 0 {
 		RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", logger.LogQuota(common.QuotaForNewUser)))
 	}
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	VirtualModelStickyNone  = ""
+	VirtualModelStickyUser  = "user"
+	VirtualModelStickyToken = "token"
+)
+
+// VirtualModelTarget 虚拟模型的一个流量目标
+type VirtualModelTarget struct {
+	Model  string `json:"model"`
+	Weight int    `json:"weight"`
+}
+
+// VirtualModel 网关级虚拟模型（别名），按权重将流量拆分到多个真实模型，用于 A/B 测试和灰度发布。
+// Targets 为 JSON 数组 []VirtualModelTarget；GroupTargets 为 JSON 对象 map[group][]VirtualModelTarget，
+// 用于按分组覆盖默认目标；StickyBy 为 user 或 token 时，同一用户/令牌总是被分配到同一个目标。
+type VirtualModel struct {
+	Id           int            `json:"id"`
+	Name         string         `json:"name" gorm:"size:128;not null;uniqueIndex:uk_virtual_model_name,where:deleted_at IS NULL"`
+	Description  string         `json:"description,omitempty" gorm:"type:text"`
+	Targets      string         `json:"targets" gorm:"type:text"`
+	GroupTargets string         `json:"group_targets,omitempty" gorm:"type:text"`
+	StickyBy     string         `json:"sticky_by" gorm:"type:varchar(16);default:''"`
+	Status       int            `json:"status" gorm:"default:1"`
+	CreatedTime  int64          `json:"created_time" gorm:"bigint"`
+	UpdatedTime  int64          `json:"updated_time" gorm:"bigint"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+func (vm *VirtualModel) GetTargets() []VirtualModelTarget {
+	targets := make([]VirtualModelTarget, 0)
+	if vm.Targets == "" {
+		return targets
+	}
+	if err := common.UnmarshalJsonStr(vm.Targets, &targets); err != nil {
+		common.SysError("failed to unmarshal virtual model targets: " + err.Error())
+	}
+	return targets
+}
+
+func (vm *VirtualModel) GetGroupTargets() map[string][]VirtualModelTarget {
+	groupTargets := make(map[string][]VirtualModelTarget)
+	if vm.GroupTargets == "" {
+		return groupTargets
+	}
+	if err := common.UnmarshalJsonStr(vm.GroupTargets, &groupTargets); err != nil {
+		common.SysError("failed to unmarshal virtual model group targets: " + err.Error())
+	}
+	return groupTargets
+}
+
+// GetTargetsForGroup 返回分组下生效的目标，分组未覆盖时使用默认目标
+func (vm *VirtualModel) GetTargetsForGroup(group string) []VirtualModelTarget {
+	if group != "" {
+		if targets, ok := vm.GetGroupTargets()[group]; ok && len(targets) > 0 {
+			return targets
+		}
+	}
+	return vm.GetTargets()
+}
+
+// GetAllTargetModels 返回所有分组下出现过的目标模型（去重）
+func (vm *VirtualModel) GetAllTargetModels() []string {
+	models := make([]string, 0)
+	appendTargets := func(targets []VirtualModelTarget) {
+		for _, t := range targets {
+			if t.Model != "" && !common.StringsContains(models, t.Model) {
+				models = append(models, t.Model)
+			}
+		}
+	}
+	appendTargets(vm.GetTargets())
+	for _, targets := range vm.GetGroupTargets() {
+		appendTargets(targets)
+	}
+	return models
+}
+
+func (vm *VirtualModel) Insert() error {
+	now := common.GetTimestamp()
+	vm.CreatedTime = now
+	vm.UpdatedTime = now
+	err := DB.Create(vm).Error
+	RefreshVirtualModelCache()
+	return err
+}
+
+func (vm *VirtualModel) Update() error {
+	vm.UpdatedTime = common.GetTimestamp()
+	err := DB.Save(vm).Error
+	RefreshVirtualModelCache()
+	return err
+}
+
+func DeleteVirtualModelById(id int) error {
+	err := DB.Delete(&VirtualModel{}, id).Error
+	RefreshVirtualModelCache()
+	return err
+}
+
+func IsVirtualModelNameDuplicated(id int, name string) (bool, error) {
+	if name == "" {
+		return false, nil
+	}
+	var cnt int64
+	err := DB.Model(&VirtualModel{}).Where("name = ? AND id <> ?", name, id).Count(&cnt).Error
+	return cnt > 0, err
+}
+
+func GetAllVirtualModels() ([]*VirtualModel, error) {
+	var virtualModels []*VirtualModel
+	err := DB.Order("id DESC").Find(&virtualModels).Error
+	return virtualModels, err
+}
+
+func GetVirtualModelById(id int) (*VirtualModel, error) {
+	var vm VirtualModel
+	err := DB.First(&vm, id).Error
+	return &vm, err
+}
+
+var (
+	virtualModelCache         map[string]*VirtualModel
+	virtualModelCacheLock     sync.RWMutex
+	lastVirtualModelCacheTime time.Time
+)
+
+// RefreshVirtualModelCache 从数据库重新加载已启用的虚拟模型
+func RefreshVirtualModelCache() {
+	var virtualModels []*VirtualModel
+	if err := DB.Where("status = ?", 1).Find(&virtualModels).Error; err != nil {
+		common.SysError("failed to load virtual models: " + err.Error())
+		return
+	}
+	newCache := make(map[string]*VirtualModel, len(virtualModels))
+	for _, vm := range virtualModels {
+		newCache[vm.Name] = vm
+	}
+	virtualModelCacheLock.Lock()
+	virtualModelCache = newCache
+	lastVirtualModelCacheTime = time.Now()
+	virtualModelCacheLock.Unlock()
+}
+
+func ensureVirtualModelCache() {
+	virtualModelCacheLock.RLock()
+	expired := virtualModelCache == nil || time.Since(lastVirtualModelCacheTime) > time.Minute
+	virtualModelCacheLock.RUnlock()
+	if expired {
+		RefreshVirtualModelCache()
+	}
+}
+
+// CacheGetVirtualModel 获取已启用的虚拟模型，不存在时返回 nil
+func CacheGetVirtualModel(name string) *VirtualModel {
+	if name == "" {
+		return nil
+	}
+	ensureVirtualModelCache()
+	virtualModelCacheLock.RLock()
+	defer virtualModelCacheLock.RUnlock()
+	return virtualModelCache[name]
+}
+
+// CacheGetAllVirtualModels 获取所有已启用的虚拟模型
+func CacheGetAllVirtualModels() []*VirtualModel {
+	ensureVirtualModelCache()
+	virtualModelCacheLock.RLock()
+	defer virtualModelCacheLock.RUnlock()
+	result := make([]*VirtualModel, 0, len(virtualModelCache))
+	for _, vm := range virtualModelCache {
+		result = append(result, vm)
+	}
+	return result
+}
@@ -0,0 +1,242 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	WebhookEndpointStatusEnabled      = 1
+	WebhookEndpointStatusDisabled     = 2 // 手动禁用
+	WebhookEndpointStatusAutoDisabled = 3 // 连续失败自动禁用
+)
+
+const (
+	WebhookDeliveryStatusPending = "pending"
+	WebhookDeliveryStatusSuccess = "success"
+	WebhookDeliveryStatusFailed  = "failed"
+)
+
+// WebhookEndpoint 出站 webhook 目标地址，按 (user_id, url) 唯一。
+// 用户通知与任务回调可能指向同一地址，两者的签名密钥分别保存，互不覆盖
+type WebhookEndpoint struct {
+	Id                  int    `json:"id"`
+	UserId              int    `json:"user_id" gorm:"uniqueIndex:idx_user_url,priority:1"`               // 0 表示系统级地址
+	Url                 string `json:"url" gorm:"type:varchar(191);uniqueIndex:idx_user_url,priority:2"` // 长度不超过 MaxWebhookURLLength
+	Secret              string `json:"-" gorm:"type:varchar(255)"`                                       // 用户通知 webhook 的密钥
+	CallbackSecret      string `json:"-" gorm:"type:varchar(255)"`                                       // 任务回调的密钥
+	Status              int    `json:"status" gorm:"default:1"`
+	ConsecutiveFailures int    `json:"consecutive_failures" gorm:"default:0"`
+	LastSuccessAt       int64  `json:"last_success_at"`
+	LastFailureAt       int64  `json:"last_failure_at"`
+	LastError           string `json:"last_error" gorm:"type:text"`
+	CreatedAt           int64  `json:"created_at" gorm:"bigint"`
+	UpdatedAt           int64  `json:"updated_at" gorm:"bigint"`
+}
+
+// WebhookDelivery 出站 webhook 投递记录（outbox）
+type WebhookDelivery struct {
+	Id             int    `json:"id"`
+	EndpointId     int    `json:"endpoint_id" gorm:"index"`
+	UserId         int    `json:"user_id" gorm:"index"`
+	EventType      string `json:"event_type" gorm:"type:varchar(64);index"`
+	SourceId       string `json:"source_id" gorm:"type:varchar(191);index"` // 关联对象 id，如任务 id
+	Payload        string `json:"payload" gorm:"type:text"`
+	Status         string `json:"status" gorm:"type:varchar(20);index"`
+	Attempts       int    `json:"attempts" gorm:"default:0"`
+	NextAttemptAt  int64  `json:"next_attempt_at" gorm:"index"`
+	LastStatusCode int    `json:"last_status_code"`
+	LastError      string `json:"last_error" gorm:"type:text"`
+	LeaseUntil     int64  `json:"-" gorm:"index;default:0"`
+	ReplayOf       int    `json:"replay_of" gorm:"default:0"`
+	DeliveredAt    int64  `json:"delivered_at"`
+	CreatedAt      int64  `json:"created_at" gorm:"bigint;index"`
+	UpdatedAt      int64  `json:"updated_at" gorm:"bigint"`
+
+	Endpoint *WebhookEndpoint `json:"endpoint,omitempty" gorm:"-"`
+}
+
+// MaxWebhookURLLength webhook 地址的最大长度，MySQL utf8mb4 下唯一索引列最长 191 个字符
+const MaxWebhookURLLength = 191
+
+// GetOrCreateWebhookEndpoint 获取或创建 webhook 地址，secret 变化时只更新对应用途（通知或任务回调）的密钥
+func GetOrCreateWebhookEndpoint(userId int, url string, secret string, isCallback bool) (*WebhookEndpoint, error) {
+	if len(url) > MaxWebhookURLLength {
+		return nil, fmt.Errorf("webhook url exceeds %d characters", MaxWebhookURLLength)
+	}
+	secretColumn := "secret"
+	if isCallback {
+		secretColumn = "callback_secret"
+	}
+	var endpoint WebhookEndpoint
+	err := DB.Where("user_id = ? AND url = ?", userId, url).First(&endpoint).Error
+	if err == nil {
+		current := &endpoint.Secret
+		if isCallback {
+			current = &endpoint.CallbackSecret
+		}
+		if *current != secret {
+			*current = secret
+			err = DB.Model(&endpoint).Update(secretColumn, secret).Error
+		}
+		return &endpoint, err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	endpoint = WebhookEndpoint{
+		UserId: userId,
+		Url:    url,
+		Status: WebhookEndpointStatusEnabled,
+	}
+	if isCallback {
+		endpoint.CallbackSecret = secret
+	} else {
+		endpoint.Secret = secret
+	}
+	if err = DB.Create(&endpoint).Error; err != nil {
+		// 并发创建时唯一索引冲突，改为使用已创建的记录
+		if DB.Where("user_id = ? AND url = ?", userId, url).First(&WebhookEndpoint{}).Error == nil {
+			return GetOrCreateWebhookEndpoint(userId, url, secret, isCallback)
+		}
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+func GetWebhookEndpointById(id int) (*WebhookEndpoint, error) {
+	var endpoint WebhookEndpoint
+	err := DB.First(&endpoint, "id = ?", id).Error
+	return &endpoint, err
+}
+
+func GetWebhookEndpoints(userId int, startIdx int, num int) ([]*WebhookEndpoint, int64, error) {
+	var endpoints []*WebhookEndpoint
+	var total int64
+	query := DB.Model(&WebhookEndpoint{})
+	if userId >= 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("id desc").Limit(num).Offset(startIdx).Find(&endpoints).Error
+	return endpoints, total, err
+}
+
+// UpdateWebhookEndpointStatus 修改地址状态，重新启用时清零连续失败次数
+func UpdateWebhookEndpointStatus(id int, status int) error {
+	updates := map[string]any{"status": status}
+	if status == WebhookEndpointStatusEnabled {
+		updates["consecutive_failures"] = 0
+	}
+	return DB.Model(&WebhookEndpoint{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// RecordWebhookEndpointSuccess 记录投递成功
+func RecordWebhookEndpointSuccess(id int) error {
+	return DB.Model(&WebhookEndpoint{}).Where("id = ?", id).Updates(map[string]any{
+		"consecutive_failures": 0,
+		"last_success_at":      time.Now().Unix(),
+	}).Error
+}
+
+// RecordWebhookEndpointFailure 记录投递失败，连续失败达到阈值时自动禁用，返回是否触发了禁用
+func RecordWebhookEndpointFailure(id int, errMsg string, disableThreshold int) (bool, error) {
+	err := DB.Model(&WebhookEndpoint{}).Where("id = ?", id).Updates(map[string]any{
+		"consecutive_failures": gorm.Expr("consecutive_failures + ?", 1),
+		"last_failure_at":      time.Now().Unix(),
+		"last_error":           errMsg,
+	}).Error
+	if err != nil || disableThreshold <= 0 {
+		return false, err
+	}
+	result := DB.Model(&WebhookEndpoint{}).
+		Where("id = ? AND status = ? AND consecutive_failures >= ?", id, WebhookEndpointStatusEnabled, disableThreshold).
+		Update("status", WebhookEndpointStatusAutoDisabled)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (d *WebhookDelivery) Insert() error {
+	return DB.Create(d).Error
+}
+
+func GetWebhookDeliveryById(id int) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := DB.First(&delivery, "id = ?", id).Error
+	return &delivery, err
+}
+
+type WebhookDeliveryQueryParams struct {
+	UserId     int // <0 表示不限
+	EndpointId int
+	EventType  string
+	Status     string
+	SourceId   string
+}
+
+func GetWebhookDeliveries(params WebhookDeliveryQueryParams, startIdx int, num int) ([]*WebhookDelivery, int64, error) {
+	var deliveries []*WebhookDelivery
+	var total int64
+	query := DB.Model(&WebhookDelivery{})
+	if params.UserId >= 0 {
+		query = query.Where("user_id = ?", params.UserId)
+	}
+	if params.EndpointId != 0 {
+		query = query.Where("endpoint_id = ?", params.EndpointId)
+	}
+	if params.EventType != "" {
+		query = query.Where("event_type = ?", params.EventType)
+	}
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+	if params.SourceId != "" {
+		query = query.Where("source_id = ?", params.SourceId)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("id desc").Limit(num).Offset(startIdx).Find(&deliveries).Error
+	return deliveries, total, err
+}
+
+// GetDueWebhookDeliveryIds 获取到期待投递的记录
+func GetDueWebhookDeliveryIds(now int64, limit int) ([]int, error) {
+	var ids []int
+	err := DB.Model(&WebhookDelivery{}).
+		Where("status = ? AND next_attempt_at <= ? AND lease_until < ?", WebhookDeliveryStatusPending, now, now).
+		Order("next_attempt_at, id").Limit(limit).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// LeaseWebhookDelivery 领取投递记录，多节点下同一记录只会被一个节点领取
+func LeaseWebhookDelivery(id int, now int64, leaseSeconds int) (*WebhookDelivery, bool, error) {
+	result := DB.Model(&WebhookDelivery{}).
+		Where("id = ? AND status = ? AND lease_until < ?", id, WebhookDeliveryStatusPending, now).
+		Update("lease_until", now+int64(leaseSeconds))
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, false, result.Error
+	}
+	delivery, err := GetWebhookDeliveryById(id)
+	if err != nil {
+		return nil, false, err
+	}
+	return delivery, true, nil
+}
+
+// FinishWebhookDeliveryAttempt 保存一次投递尝试的结果并释放租约
+func FinishWebhookDeliveryAttempt(d *WebhookDelivery) error {
+	return DB.Model(&WebhookDelivery{}).Where("id = ?", d.Id).Updates(map[string]any{
+		"status":           d.Status,
+		"attempts":         d.Attempts,
+		"next_attempt_at":  d.NextAttemptAt,
+		"last_status_code": d.LastStatusCode,
+		"last_error":       d.LastError,
+		"delivered_at":     d.DeliveredAt,
+		"lease_until":      0,
+	}).Error
+}
@@ -0,0 +1,85 @@
+// Package balancer 同一优先级内的渠道选择策略，不依赖数据库与缓存，便于单独测试
+package balancer
+
+import (
+	"sync"
+)
+
+const (
+	StrategyRandom         = "random"          // 按权重随机（默认）
+	StrategyRoundRobin     = "round_robin"     // 平滑加权轮询
+	StrategyLeastInFlight  = "least_in_flight" // 进行中请求数最少
+	StrategyConsistentHash = "consistent_hash" // 按用户一致性哈希
+)
+
+// Candidate 候选渠道
+type Candidate struct {
+	Id     int
+	Weight int
+}
+
+// Request 一次选择的上下文
+type Request struct {
+	Scope   string // 选择范围（如 分组/模型/优先级），有状态的策略按范围分别记录状态
+	HashKey string // 一致性哈希的键，通常为用户 id
+}
+
+// Strategy 从候选渠道中选择一个，返回其下标；candidates 不为空
+type Strategy interface {
+	Select(req Request, candidates []Candidate) int
+}
+
+var (
+	strategiesLock sync.RWMutex
+	strategies     = map[string]Strategy{
+		StrategyRandom:         NewRandom(),
+		StrategyRoundRobin:     NewRoundRobin(),
+		StrategyLeastInFlight:  NewLeastInFlight(NewMemoryInFlightCounter()),
+		StrategyConsistentHash: NewConsistentHash(),
+	}
+)
+
+// Register 注册选择策略，重复注册时覆盖
+func Register(name string, strategy Strategy) {
+	strategiesLock.Lock()
+	defer strategiesLock.Unlock()
+	strategies[name] = strategy
+}
+
+// Get 按名称获取选择策略，不存在时返回默认的按权重随机策略
+func Get(name string) Strategy {
+	strategiesLock.RLock()
+	defer strategiesLock.RUnlock()
+	if strategy, ok := strategies[name]; ok {
+		return strategy
+	}
+	return strategies[StrategyRandom]
+}
+
+// IsValid 策略名称是否已注册，空字符串表示使用上一级的配置
+func IsValid(name string) bool {
+	if name == "" {
+		return true
+	}
+	strategiesLock.RLock()
+	defer strategiesLock.RUnlock()
+	_, ok := strategies[name]
+	return ok
+}
+
+// effectiveWeights 候选渠道的有效权重：全部为 0 时视为相同权重
+func effectiveWeights(candidates []Candidate) ([]int, int) {
+	weights := make([]int, len(candidates))
+	sum := 0
+	for i, candidate := range candidates {
+		weights[i] = max(candidate.Weight, 0)
+		sum += weights[i]
+	}
+	if sum == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		sum = len(weights)
+	}
+	return weights, sum
+}
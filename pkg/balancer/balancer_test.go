@@ -0,0 +1,130 @@
+package balancer
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func countSelections(s Strategy, req Request, candidates []Candidate, n int) map[int]int {
+	counts := make(map[int]int)
+	for i := 0; i < n; i++ {
+		counts[candidates[s.Select(req, candidates)].Id]++
+	}
+	return counts
+}
+
+func TestRoundRobin_SmoothWeighted(t *testing.T) {
+	s := NewRoundRobin()
+	candidates := []Candidate{{Id: 1, Weight: 5}, {Id: 2, Weight: 1}, {Id: 3, Weight: 1}}
+	var order []int
+	for i := 0; i < 7; i++ {
+		order = append(order, candidates[s.Select(Request{Scope: "a"}, candidates)].Id)
+	}
+	// nginx 平滑加权轮询的经典序列
+	require.Equal(t, []int{1, 1, 2, 1, 3, 1, 1}, order)
+}
+
+func TestRoundRobin_ZeroWeights(t *testing.T) {
+	s := NewRoundRobin()
+	candidates := []Candidate{{Id: 1}, {Id: 2}, {Id: 3}}
+	require.Equal(t, map[int]int{1: 2, 2: 2, 3: 2}, countSelections(s, Request{Scope: "a"}, candidates, 6))
+
+	// 只有部分渠道权重为 0 时，权重为 0 的渠道不会被选中
+	candidates = []Candidate{{Id: 1, Weight: 1}, {Id: 2}}
+	require.Equal(t, map[int]int{1: 4}, countSelections(s, Request{Scope: "b"}, candidates, 4))
+}
+
+func TestRoundRobin_ScopesAreIndependent(t *testing.T) {
+	s := NewRoundRobin()
+	candidates := []Candidate{{Id: 1, Weight: 1}, {Id: 2, Weight: 1}}
+	require.Equal(t, 1, candidates[s.Select(Request{Scope: "a"}, candidates)].Id)
+	require.Equal(t, 1, candidates[s.Select(Request{Scope: "b"}, candidates)].Id)
+	require.Equal(t, 2, candidates[s.Select(Request{Scope: "a"}, candidates)].Id)
+}
+
+func TestLeastInFlight(t *testing.T) {
+	counter := NewMemoryInFlightCounter()
+	s := NewLeastInFlight(counter)
+	candidates := []Candidate{{Id: 1, Weight: 1}, {Id: 2, Weight: 1}}
+
+	counter.Acquire(1)
+	require.Equal(t, map[int]int{2: 10}, countSelections(s, Request{}, candidates, 10))
+
+	counter.Acquire(2)
+	counter.Acquire(2)
+	require.Equal(t, map[int]int{1: 10}, countSelections(s, Request{}, candidates, 10))
+
+	// 按权重折算：渠道 2 的权重是渠道 1 的 3 倍，2 个进行中请求仍少于渠道 1 的 1 个
+	candidates[1].Weight = 3
+	require.Equal(t, map[int]int{2: 10}, countSelections(s, Request{}, candidates, 10))
+
+	counter.Release(2)
+	counter.Release(2)
+	counter.Release(2)
+	require.Equal(t, map[int]int64{1: 1, 2: 0}, counter.Counts([]int{1, 2}))
+}
+
+func TestLeastInFlight_TiesUseAllCandidates(t *testing.T) {
+	s := NewLeastInFlight(NewMemoryInFlightCounter())
+	candidates := []Candidate{{Id: 1, Weight: 1}, {Id: 2, Weight: 1}}
+	counts := countSelections(s, Request{}, candidates, 200)
+	require.Greater(t, counts[1], 0)
+	require.Greater(t, counts[2], 0)
+}
+
+func TestConsistentHash_Stable(t *testing.T) {
+	s := NewConsistentHash()
+	candidates := []Candidate{{Id: 1, Weight: 1}, {Id: 2, Weight: 1}, {Id: 3, Weight: 1}}
+	for user := 0; user < 50; user++ {
+		req := Request{HashKey: strconv.Itoa(user)}
+		first := s.Select(req, candidates)
+		for i := 0; i < 5; i++ {
+			require.Equal(t, first, s.Select(req, candidates))
+		}
+	}
+}
+
+func TestConsistentHash_MinimalReassignment(t *testing.T) {
+	s := NewConsistentHash()
+	full := []Candidate{{Id: 1, Weight: 1}, {Id: 2, Weight: 1}, {Id: 3, Weight: 1}}
+	reduced := []Candidate{{Id: 1, Weight: 1}, {Id: 3, Weight: 1}}
+	used := make(map[int]bool)
+	for user := 0; user < 300; user++ {
+		req := Request{HashKey: strconv.Itoa(user)}
+		before := full[s.Select(req, full)].Id
+		after := reduced[s.Select(req, reduced)].Id
+		used[before] = true
+		// 移除渠道 2 后，只有原本分配到渠道 2 的用户会被重新分配
+		if before != 2 {
+			require.Equal(t, before, after)
+		}
+	}
+	require.Len(t, used, 3)
+}
+
+func TestConsistentHash_Weighted(t *testing.T) {
+	s := NewConsistentHash()
+	candidates := []Candidate{{Id: 1, Weight: 3}, {Id: 2, Weight: 1}}
+	counts := make(map[int]int)
+	for user := 0; user < 4000; user++ {
+		counts[candidates[s.Select(Request{HashKey: strconv.Itoa(user)}, candidates)].Id]++
+	}
+	require.InDelta(t, 3000, counts[1], 200)
+}
+
+func TestRandom_Weighted(t *testing.T) {
+	s := NewRandom()
+	candidates := []Candidate{{Id: 1, Weight: 90}, {Id: 2, Weight: 10}, {Id: 3, Weight: 0}}
+	counts := countSelections(s, Request{}, candidates, 10000)
+	require.InDelta(t, 9000, counts[1], 400)
+	require.Zero(t, counts[3])
+}
+
+func TestRegistry(t *testing.T) {
+	require.True(t, IsValid(""))
+	require.True(t, IsValid(StrategyRoundRobin))
+	require.False(t, IsValid("unknown"))
+	require.Equal(t, Get(StrategyRandom), Get("unknown"))
+}
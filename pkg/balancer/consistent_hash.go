@@ -0,0 +1,56 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+)
+
+type consistentHash struct {
+	fallback Strategy
+}
+
+// NewConsistentHash 按 HashKey 的加权一致性哈希（rendezvous hashing）：同一用户在候选渠道不变时总是选到同一个渠道，
+// 增减渠道时只有原本落在变化渠道上的用户会被重新分配。HashKey 为空时按权重随机
+func NewConsistentHash() Strategy {
+	return &consistentHash{fallback: NewRandom()}
+}
+
+func (s *consistentHash) Select(req Request, candidates []Candidate) int {
+	if len(candidates) == 1 {
+		return 0
+	}
+	if req.HashKey == "" {
+		return s.fallback.Select(req, candidates)
+	}
+	weights, _ := effectiveWeights(candidates)
+	selected := -1
+	bestScore := math.Inf(-1)
+	for i, candidate := range candidates {
+		if weights[i] == 0 {
+			continue
+		}
+		// score = weight / -ln(u)，u 为 (0, 1) 内的哈希值
+		u := (float64(hashUint64(req.HashKey, candidate.Id)>>11) + 0.5) / (1 << 53)
+		score := float64(weights[i]) / -math.Log(u)
+		if score > bestScore {
+			selected, bestScore = i, score
+		}
+	}
+	return selected
+}
+
+func hashUint64(key string, id int) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(strconv.Itoa(id)))
+	// fnv 的高位分布较差，再做一次混合
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb3f53e63b9fe
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,99 @@
+package balancer
+
+import (
+	"sync"
+)
+
+// InFlightCounter 记录每个渠道进行中的请求数
+type InFlightCounter interface {
+	Acquire(id int)
+	Release(id int)
+	Counts(ids []int) map[int]int64
+}
+
+type memoryInFlightCounter struct {
+	mu     sync.Mutex
+	counts map[int]int64
+}
+
+// NewMemoryInFlightCounter 本节点内存中的进行中请求计数
+func NewMemoryInFlightCounter() InFlightCounter {
+	return &memoryInFlightCounter{counts: make(map[int]int64)}
+}
+
+func (c *memoryInFlightCounter) Acquire(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[id]++
+}
+
+func (c *memoryInFlightCounter) Release(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[id] <= 1 {
+		delete(c.counts, id)
+		return
+	}
+	c.counts[id]--
+}
+
+func (c *memoryInFlightCounter) Counts(ids []int) map[int]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[int]int64, len(ids))
+	for _, id := range ids {
+		counts[id] = c.counts[id]
+	}
+	return counts
+}
+
+type leastInFlight struct {
+	counter  InFlightCounter
+	fallback Strategy
+}
+
+// NewLeastInFlight 选择进行中请求数与权重之比最小的渠道，多个渠道相同时按权重随机。
+// 权重为 0 的渠道仅在其他渠道都为 0 时参与选择
+func NewLeastInFlight(counter InFlightCounter) Strategy {
+	return &leastInFlight{counter: counter, fallback: NewRandom()}
+}
+
+func (s *leastInFlight) Select(req Request, candidates []Candidate) int {
+	if len(candidates) == 1 {
+		return 0
+	}
+	weights, _ := effectiveWeights(candidates)
+	ids := make([]int, len(candidates))
+	for i, candidate := range candidates {
+		ids[i] = candidate.Id
+	}
+	counts := s.counter.Counts(ids)
+
+	// 比较 count/weight，交叉相乘避免浮点误差
+	var best []int
+	for i, candidate := range candidates {
+		if weights[i] == 0 {
+			continue
+		}
+		if len(best) == 0 {
+			best = []int{i}
+			continue
+		}
+		b := best[0]
+		lhs := counts[candidate.Id] * int64(weights[b])
+		rhs := counts[candidates[b].Id] * int64(weights[i])
+		if lhs < rhs {
+			best = []int{i}
+		} else if lhs == rhs {
+			best = append(best, i)
+		}
+	}
+	if len(best) == 1 {
+		return best[0]
+	}
+	tied := make([]Candidate, len(best))
+	for i, idx := range best {
+		tied[i] = candidates[idx]
+	}
+	return best[s.fallback.Select(req, tied)]
+}
@@ -0,0 +1,41 @@
+package balancer
+
+import (
+	"math/rand"
+)
+
+type random struct{}
+
+// NewRandom 按权重随机选择。平均权重小于 10 时放大权重以平滑分布，权重全部为 0 时等概率选择
+func NewRandom() Strategy {
+	return random{}
+}
+
+func (random) Select(_ Request, candidates []Candidate) int {
+	if len(candidates) == 1 {
+		return 0
+	}
+	// smoothing factor and adjustment
+	smoothingFactor := 1
+	smoothingAdjustment := 0
+	sumWeight := 0
+	for _, candidate := range candidates {
+		sumWeight += candidate.Weight
+	}
+	if sumWeight == 0 {
+		// when all channels have weight 0, each channel's effective weight = 100
+		sumWeight = len(candidates) * 100
+		smoothingAdjustment = 100
+	} else if sumWeight/len(candidates) < 10 {
+		// when the average weight is less than 10, set smoothing factor to 100
+		smoothingFactor = 100
+	}
+	randomWeight := rand.Intn(sumWeight * smoothingFactor)
+	for i, candidate := range candidates {
+		randomWeight -= candidate.Weight*smoothingFactor + smoothingAdjustment
+		if randomWeight < 0 {
+			return i
+		}
+	}
+	return len(candidates) - 1
+}
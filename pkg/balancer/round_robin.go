@@ -0,0 +1,52 @@
+package balancer
+
+import (
+	"sync"
+)
+
+type roundRobin struct {
+	mu     sync.Mutex
+	scopes map[string]map[int]int // 范围 -> 渠道 -> 当前权重
+}
+
+// NewRoundRobin 平滑加权轮询（与 nginx 相同的算法）：每次选择时所有候选的当前权重加上各自的权重，
+// 选择当前权重最大的一个并减去权重总和。状态按选择范围记录在本节点内存中
+func NewRoundRobin() Strategy {
+	return &roundRobin{scopes: make(map[string]map[int]int)}
+}
+
+func (s *roundRobin) Select(req Request, candidates []Candidate) int {
+	if len(candidates) == 1 {
+		return 0
+	}
+	weights, sum := effectiveWeights(candidates)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.scopes[req.Scope]
+	if !ok {
+		current = make(map[int]int)
+		s.scopes[req.Scope] = current
+	}
+	// 候选渠道变化后，移除已不在候选中的渠道
+	if len(current) > len(candidates) {
+		present := make(map[int]struct{}, len(candidates))
+		for _, candidate := range candidates {
+			present[candidate.Id] = struct{}{}
+		}
+		for id := range current {
+			if _, ok := present[id]; !ok {
+				delete(current, id)
+			}
+		}
+	}
+	selected := -1
+	for i, candidate := range candidates {
+		current[candidate.Id] += weights[i]
+		if weights[i] > 0 && (selected < 0 || current[candidate.Id] > current[candidates[selected].Id]) {
+			selected = i
+		}
+	}
+	current[candidates[selected].Id] -= sum
+	return selected
+}
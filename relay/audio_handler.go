@@ -4,12 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -61,7 +64,19 @@ func AudioHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *type
 		}
 	}
 
+	// 语音链路的 TTS 环节只是中间结果，不单独持久化
+	var capture *mediaCaptureWriter
+	if info.RelayMode == relayconstant.RelayModeAudioSpeech && !info.IsStream && info.SpeechPipeline == "" &&
+		operation_setting.GetMediaStorageSetting().ShouldPersist(operation_setting.MediaSourceAudio) {
+		capture = captureMediaResponse(c)
+	}
 	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
+	if capture != nil {
+		if newAPIError == nil && capture.Status() == http.StatusOK && !strings.HasPrefix(capture.Header().Get("Content-Type"), "text/event-stream") {
+			persistSpeechResponse(c, info, capture)
+		}
+		capture.release(c, capture.body.Bytes())
+	}
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
@@ -41,19 +40,9 @@ func SetupApiRequestHeader(info *common.RelayInfo, c *gin.Context, req *http.Hea
 // processHeaderOverride 处理请求头覆盖，支持变量替换
 // 支持的变量：{api_key}
 func processHeaderOverride(info *common.RelayInfo) (map[string]string, error) {
-	headerOverride := make(map[string]string)
-	for k, v := range info.HeadersOverride {
-		str, ok := v.(string)
-		if !ok {
-			return nil, types.NewError(nil, types.ErrorCodeChannelHeaderOverrideInvalid)
-		}
-
-		// 替换支持的变量
-		if strings.Contains(str, "{api_key}") {
-			str = strings.ReplaceAll(str, "{api_key}", info.ApiKey)
-		}
-
-		headerOverride[k] = str
+	headerOverride, err := common.ApplyHeaderOverride(info.HeadersOverride, info.ApiKey)
+	if err != nil {
+		return nil, types.NewError(err, types.ErrorCodeChannelHeaderOverrideInvalid)
 	}
 	return headerOverride, nil
 }
@@ -66,6 +55,16 @@ func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	if common2.DebugEnabled {
 		println("fullRequestURL:", fullRequestURL)
 	}
+	scriptRunner, err := newChannelScriptRunner(info)
+	if err != nil {
+		return nil, err
+	}
+	if scriptRunner != nil {
+		requestBody, err = applyRequestScript(scriptRunner, requestBody)
+		if err != nil {
+			return nil, err
+		}
+	}
 	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("new request failed: %w", err)
@@ -86,6 +85,9 @@ func DoApiRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	if err != nil {
 		return nil, fmt.Errorf("do request failed: %w", err)
 	}
+	if scriptRunner != nil {
+		applyResponseScript(c, scriptRunner, resp)
+	}
 	return resp, nil
 }
 
@@ -0,0 +1,154 @@
+package claude
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	claudeFileProvider = "anthropic"
+	claudeFilesApiBeta = "files-api-2025-04-14"
+)
+
+// claudeMediaSource 构造图片 source。
+// 启用上游文件复用且文件足够大时，上传到 Anthropic Files API 并以 file_id 引用，同一内容在句柄有效期内只上传一次；
+// 其余情况（或上传失败）保持 base64。hash 为空时根据内容计算
+func claudeMediaSource(c *gin.Context, mediaType string, base64Data string, hash string) *dto.ClaudeMessageSource {
+	inline := &dto.ClaudeMessageSource{
+		Type:      "base64",
+		MediaType: mediaType,
+		Data:      base64Data,
+	}
+	// AWS / Vertex 等渠道同样复用该转换，但不支持 Files API
+	if common.GetContextKeyInt(c, constant.ContextKeyChannelType) != constant.ChannelTypeAnthropic ||
+		!operation_setting.GetFileCacheSetting().ShouldUseProviderFile(int64(base64.StdEncoding.DecodedLen(len(base64Data)))) {
+		return inline
+	}
+	baseUrl := common.GetContextKeyString(c, constant.ContextKeyChannelBaseUrl)
+	apiKey := common.GetContextKeyString(c, constant.ContextKeyChannelKey)
+	var data []byte
+	if hash == "" {
+		decoded, err := base64.StdEncoding.DecodeString(base64Data)
+		if err != nil {
+			return inline
+		}
+		data = decoded
+		hash = service.HashFileBytes(data)
+	}
+	handle, err := service.ResolveProviderFileHandle(claudeFileProvider, baseUrl+"|"+apiKey, hash, func() (*service.ProviderFileHandle, error) {
+		if data == nil {
+			decoded, err := base64.StdEncoding.DecodeString(base64Data)
+			if err != nil {
+				return nil, err
+			}
+			data = decoded
+		}
+		return uploadClaudeFile(c, baseUrl, apiKey, mediaType, data)
+	})
+	if err != nil {
+		logger.LogWarn(c, fmt.Sprintf("failed to upload file to anthropic, fallback to base64: %s", err.Error()))
+		return inline
+	}
+	common.SetContextKey(c, constant.ContextKeyClaudeFilesApiUsed, true)
+	return &dto.ClaudeMessageSource{
+		Type:   "file",
+		FileId: handle.Id,
+	}
+}
+
+// uploadClaudeFile 通过 Files API 上传文件
+func uploadClaudeFile(c *gin.Context, baseUrl string, apiKey string, mediaType string, data []byte) (*service.ProviderFileHandle, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, "upload"+fileExtensionByMediaType(mediaType)))
+	header.Set("Content-Type", mediaType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, fmt.Sprintf("%s/v1/files", baseUrl), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("anthropic-version", "2023-06-01")
+	req.Header.Set("anthropic-beta", claudeFilesApiBeta)
+
+	channelSetting, _ := common.GetContextKeyType[dto.ChannelSettings](c, constant.ContextKeyChannelSetting)
+	client, err := service.GetHttpClientWithProxy(channelSetting.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("anthropic files api status code: %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	var file struct {
+		Id       string `json:"id"`
+		MimeType string `json:"mime_type"`
+	}
+	if err := common.Unmarshal(respBody, &file); err != nil {
+		return nil, err
+	}
+	if file.Id == "" {
+		return nil, fmt.Errorf("anthropic file upload returned empty id")
+	}
+	return &service.ProviderFileHandle{
+		Id:       file.Id,
+		MimeType: file.MimeType,
+	}, nil
+}
+
+func fileExtensionByMediaType(mediaType string) string {
+	if _, ext, ok := strings.Cut(mediaType, "/"); ok && ext != "" {
+		return "." + ext
+	}
+	return ""
+}
+
+// appendFilesApiBeta 在 anthropic-beta 中追加 Files API 标识
+func appendFilesApiBeta(c *gin.Context, req *http.Header) {
+	if !common.GetContextKeyBool(c, constant.ContextKeyClaudeFilesApiUsed) {
+		return
+	}
+	beta := req.Get("anthropic-beta")
+	if strings.Contains(beta, claudeFilesApiBeta) {
+		return
+	}
+	if beta != "" {
+		beta += ","
+	}
+	req.Set("anthropic-beta", beta+claudeFilesApiBeta)
+}
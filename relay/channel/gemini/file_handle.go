@@ -0,0 +1,145 @@
+package gemini
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const geminiFileProvider = "gemini"
+
+// geminiFile Gemini File API 返回的文件信息
+type geminiFile struct {
+	Name           string `json:"name"`
+	Uri            string `json:"uri"`
+	MimeType       string `json:"mimeType"`
+	State          string `json:"state"`
+	ExpirationTime string `json:"expirationTime"`
+}
+
+// geminiMediaPart 将媒体数据转换为 Gemini part。
+// 启用上游文件复用且文件足够大时，上传到 File API 并以 fileData 引用，同一内容在句柄有效期内只上传一次；
+// 其余情况（或上传失败）保持内联数据。hash 为空时根据内容计算
+func geminiMediaPart(c *gin.Context, info *relaycommon.RelayInfo, mimeType string, base64Data string, hash string) dto.GeminiPart {
+	inline := dto.GeminiPart{
+		InlineData: &dto.GeminiInlineData{
+			MimeType: mimeType,
+			Data:     base64Data,
+		},
+	}
+	// Vertex 等渠道不支持 File API
+	if info.ChannelType != constant.ChannelTypeGemini ||
+		!operation_setting.GetFileCacheSetting().ShouldUseProviderFile(int64(base64.StdEncoding.DecodedLen(len(base64Data)))) {
+		return inline
+	}
+	var data []byte
+	if hash == "" {
+		decoded, err := base64.StdEncoding.DecodeString(base64Data)
+		if err != nil {
+			return inline
+		}
+		data = decoded
+		hash = service.HashFileBytes(data)
+	}
+	handle, err := service.ResolveProviderFileHandle(geminiFileProvider, info.ChannelBaseUrl+"|"+info.ApiKey, hash, func() (*service.ProviderFileHandle, error) {
+		if data == nil {
+			decoded, err := base64.StdEncoding.DecodeString(base64Data)
+			if err != nil {
+				return nil, err
+			}
+			data = decoded
+		}
+		return uploadGeminiFile(c, info, mimeType, data)
+	})
+	if err != nil {
+		logger.LogWarn(c, fmt.Sprintf("failed to upload file to gemini, fallback to inline data: %s", err.Error()))
+		return inline
+	}
+	return dto.GeminiPart{
+		FileData: &dto.GeminiFileData{
+			MimeType: mimeType,
+			FileUri:  handle.Uri,
+		},
+	}
+}
+
+// uploadGeminiFile 通过 File API 上传文件，并等待文件处理完成
+func uploadGeminiFile(c *gin.Context, info *relaycommon.RelayInfo, mimeType string, data []byte) (*service.ProviderFileHandle, error) {
+	client, err := service.GetHttpClientWithProxy(info.ChannelSetting.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, fmt.Sprintf("%s/upload/v1beta/files", info.ChannelBaseUrl), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-goog-api-key", info.ApiKey)
+	req.Header.Set("X-Goog-Upload-Protocol", "raw")
+	req.Header.Set("Content-Type", mimeType)
+	var uploaded struct {
+		File geminiFile `json:"file"`
+	}
+	if err := doGeminiFileRequest(client, req, &uploaded); err != nil {
+		return nil, err
+	}
+	file := uploaded.File
+
+	// 视频、PDF 等文件需要处理后才能引用
+	for i := 0; file.State == "PROCESSING" && i < 10; i++ {
+		time.Sleep(time.Second)
+		req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, fmt.Sprintf("%s/v1beta/%s", info.ChannelBaseUrl, file.Name), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("x-goog-api-key", info.ApiKey)
+		if err := doGeminiFileRequest(client, req, &file); err != nil {
+			return nil, err
+		}
+	}
+	if file.State != "" && file.State != "ACTIVE" {
+		return nil, fmt.Errorf("gemini file %s is not active: %s", file.Name, file.State)
+	}
+	if file.Uri == "" {
+		return nil, fmt.Errorf("gemini file upload returned empty uri")
+	}
+
+	handle := &service.ProviderFileHandle{
+		Id:       file.Name,
+		Uri:      file.Uri,
+		MimeType: file.MimeType,
+	}
+	if expiration, err := time.Parse(time.RFC3339, file.ExpirationTime); err == nil {
+		// 预留余量，避免引用即将过期的文件
+		handle.ExpiresAt = expiration.Add(-time.Hour).Unix()
+	}
+	return handle, nil
+}
+
+func doGeminiFileRequest(client *http.Client, req *http.Request, v any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gemini file api status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+	return common.Unmarshal(body, v)
+}
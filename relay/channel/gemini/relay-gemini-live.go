@@ -0,0 +1,740 @@
+package gemini
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/channel/openai"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Gemini Live 与 OpenAI Realtime 协议互转：
+// 客户端始终使用 OpenAI Realtime 事件，网关在首个 session.update（或首个输入事件）时向上游发送 setup，
+// 之后把音频/文本输入转换为 realtimeInput / clientContent，把上游 serverContent 转换为 response.* 事件。
+
+const geminiLiveSetupTimeout = 30 * time.Second
+
+// geminiLiveVoices Gemini Live 内置音色，OpenAI 音色名无法对应时使用上游默认音色
+var geminiLiveVoices = map[string]string{
+	"puck":    "Puck",
+	"charon":  "Charon",
+	"kore":    "Kore",
+	"fenrir":  "Fenrir",
+	"aoede":   "Aoede",
+	"leda":    "Leda",
+	"orus":    "Orus",
+	"zephyr":  "Zephyr",
+	"alloy":   "Puck",
+	"echo":    "Charon",
+	"shimmer": "Kore",
+	"ash":     "Fenrir",
+	"coral":   "Aoede",
+	"sage":    "Leda",
+	"verse":   "Orus",
+	"ballad":  "Zephyr",
+}
+
+type geminiLiveBridge struct {
+	c          *gin.Context
+	info       *relaycommon.RelayInfo
+	clientConn *websocket.Conn
+	targetConn *websocket.Conn
+	clientMu   sync.Mutex
+	targetMu   sync.Mutex
+
+	mu              sync.Mutex
+	session         dto.RealtimeSession
+	manualTurn      bool // turn_detection 为 null 时由客户端通过 commit / response.create 控制轮次
+	setupSent       bool
+	setupDone       chan struct{}
+	setupOnce       sync.Once
+	activityStarted bool
+	pendingTurns    []dto.GeminiChatContent
+	responseId      string
+	itemId          string
+	functionNames   map[string]string
+	turnUsage       *dto.GeminiLiveUsageMetadata
+	localUsage      *dto.RealtimeUsage
+	sumUsage        *dto.RealtimeUsage
+}
+
+func GeminiRealtimeHandler(c *gin.Context, info *relaycommon.RelayInfo) (*types.NewAPIError, *dto.RealtimeUsage) {
+	if info == nil || info.ClientWs == nil || info.TargetWs == nil {
+		return types.NewError(fmt.Errorf("invalid websocket connection"), types.ErrorCodeBadResponse), nil
+	}
+	info.IsStream = true
+
+	b := &geminiLiveBridge{
+		c:          c,
+		info:       info,
+		clientConn: info.ClientWs,
+		targetConn: info.TargetWs,
+		session: dto.RealtimeSession{
+			Modalities:        []string{"text", "audio"},
+			InputAudioFormat:  info.InputAudioFormat,
+			OutputAudioFormat: info.OutputAudioFormat,
+			TurnDetection:     map[string]any{"type": "server_vad"},
+		},
+		setupDone:     make(chan struct{}),
+		functionNames: make(map[string]string),
+		localUsage:    &dto.RealtimeUsage{},
+		sumUsage:      &dto.RealtimeUsage{},
+	}
+
+	// OpenAI 在连接建立后立即下发 session.created，这里由网关本地生成
+	if err := b.sendClient(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionCreated, Session: &b.session}); err != nil {
+		return types.NewError(err, types.ErrorCodeBadResponse), nil
+	}
+
+	clientClosed := make(chan struct{})
+	targetClosed := make(chan struct{})
+	errChan := make(chan error, 2)
+
+	gopool.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("panic in client reader: %v", r)
+			}
+		}()
+		for {
+			_, message, err := b.clientConn.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					errChan <- fmt.Errorf("error reading from client: %v", err)
+				}
+				close(clientClosed)
+				return
+			}
+			if err := b.handleClientMessage(message); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	})
+
+	gopool.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("panic in target reader: %v", r)
+			}
+		}()
+		for {
+			_, message, err := b.targetConn.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					errChan <- fmt.Errorf("error reading from target: %v", err)
+				}
+				close(targetClosed)
+				return
+			}
+			info.SetFirstResponseTime()
+			if err := b.handleServerMessage(message); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	})
+
+	select {
+	case <-clientClosed:
+	case <-targetClosed:
+	case err := <-errChan:
+		logger.LogError(c, "gemini realtime error: "+err.Error())
+	case <-c.Done():
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if usage := b.takeTurnUsage(); usage.TotalTokens != 0 {
+		_ = openai.PreConsumeRealtimeUsage(c, info, usage, b.sumUsage)
+	}
+	return nil, b.sumUsage
+}
+
+func (b *geminiLiveBridge) sendClient(event *dto.RealtimeEvent) error {
+	if event.EventId == "" {
+		event.EventId = "evt_" + common.GetRandomString(16)
+	}
+	data, err := common.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshalling realtime event: %w", err)
+	}
+	b.clientMu.Lock()
+	defer b.clientMu.Unlock()
+	return b.clientConn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (b *geminiLiveBridge) sendTarget(message *dto.GeminiLiveClientMessage) error {
+	data, err := common.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("error marshalling gemini live message: %w", err)
+	}
+	b.targetMu.Lock()
+	defer b.targetMu.Unlock()
+	return b.targetConn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (b *geminiLiveBridge) sendClientError(code string, message string) error {
+	return b.sendClient(&dto.RealtimeEvent{
+		Type:  dto.RealtimeEventTypeError,
+		Error: &types.OpenAIError{Type: "invalid_request_error", Code: code, Message: message},
+	})
+}
+
+func (b *geminiLiveBridge) handleClientMessage(message []byte) error {
+	event := &dto.RealtimeEvent{}
+	if err := common.Unmarshal(message, event); err != nil {
+		return fmt.Errorf("error unmarshalling message: %v", err)
+	}
+	if event.Type == dto.RealtimeEventTypeSessionUpdate {
+		return b.handleSessionUpdate(message, event)
+	}
+	if err := b.ensureSetup(); err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case dto.RealtimeEventInputAudioBufferAppend:
+		return b.appendAudio(event)
+	case dto.RealtimeEventInputAudioBufferCommit:
+		b.mu.Lock()
+		endActivity := b.manualTurn && b.activityStarted
+		b.activityStarted = false
+		b.mu.Unlock()
+		if endActivity {
+			if err := b.sendTarget(&dto.GeminiLiveClientMessage{RealtimeInput: &dto.GeminiLiveRealtimeInput{ActivityEnd: &struct{}{}}}); err != nil {
+				return fmt.Errorf("error writing to target: %v", err)
+			}
+		}
+		return b.sendClient(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferCommitted, ItemId: "item_" + common.GetRandomString(16)})
+	case dto.RealtimeEventInputAudioBufferClear:
+		return b.sendClient(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferCleared})
+	case dto.RealtimeEventTypeConversationCreate:
+		return b.createConversationItem(event)
+	case dto.RealtimeEventTypeResponseCreate:
+		b.mu.Lock()
+		turns := b.pendingTurns
+		b.pendingTurns = nil
+		endActivity := b.manualTurn && b.activityStarted
+		b.activityStarted = false
+		b.mu.Unlock()
+		if len(turns) > 0 {
+			return b.sendTarget(&dto.GeminiLiveClientMessage{ClientContent: &dto.GeminiLiveClientContent{Turns: turns, TurnComplete: true}})
+		}
+		if endActivity {
+			return b.sendTarget(&dto.GeminiLiveClientMessage{RealtimeInput: &dto.GeminiLiveRealtimeInput{ActivityEnd: &struct{}{}}})
+		}
+		return nil
+	default:
+		// response.cancel 等事件 Gemini Live 没有对应能力，忽略
+		logger.LogDebug(b.c, "gemini realtime ignored client event: "+event.Type)
+		return nil
+	}
+}
+
+func (b *geminiLiveBridge) handleSessionUpdate(message []byte, event *dto.RealtimeEvent) error {
+	if event.Session == nil {
+		return nil
+	}
+	update := event.Session
+	// turn_detection 显式为 null 表示关闭服务端 VAD，需要区分未传与传 null
+	var raw struct {
+		Session map[string]json.RawMessage `json:"session"`
+	}
+	_ = common.Unmarshal(message, &raw)
+	turnDetection, hasTurnDetection := raw.Session["turn_detection"]
+
+	b.mu.Lock()
+	started := b.setupSent
+	if started && (update.Instructions != "" && update.Instructions != b.session.Instructions ||
+		update.Voice != "" && update.Voice != b.session.Voice || update.Tools != nil) {
+		b.mu.Unlock()
+		// Gemini Live 的 setup 只能在会话开始时发送一次，音频格式由网关转换，可以随时修改
+		b.applyAudioFormat(update)
+		if err := b.sendClientError("session_update_not_supported", "instructions, voice and tools cannot be changed after the gemini live session has started"); err != nil {
+			return err
+		}
+		return b.sendClient(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdated, Session: b.snapshotSession()})
+	}
+	if !started {
+		if len(update.Modalities) > 0 {
+			b.session.Modalities = update.Modalities
+		}
+		b.session.Instructions = common.GetStringIfEmpty(update.Instructions, b.session.Instructions)
+		b.session.Voice = common.GetStringIfEmpty(update.Voice, b.session.Voice)
+		if update.Tools != nil {
+			b.session.Tools = update.Tools
+			b.info.RealtimeTools = update.Tools
+		}
+		if update.Temperature > 0 {
+			b.session.Temperature = update.Temperature
+		}
+		if update.InputAudioTranscription.Model != "" {
+			b.session.InputAudioTranscription = update.InputAudioTranscription
+		}
+		if hasTurnDetection {
+			b.session.TurnDetection = update.TurnDetection
+			b.manualTurn = string(turnDetection) == "null"
+		}
+		textToken, _, err := service.CountTokenRealtime(b.info, *event, b.info.UpstreamModelName)
+		if err == nil {
+			b.addLocalInput(textToken, 0)
+		}
+	}
+	b.mu.Unlock()
+	b.applyAudioFormat(update)
+
+	if err := b.ensureSetup(); err != nil {
+		return err
+	}
+	return b.sendClient(&dto.RealtimeEvent{Type: dto.RealtimeEventTypeSessionUpdated, Session: b.snapshotSession()})
+}
+
+func (b *geminiLiveBridge) applyAudioFormat(update *dto.RealtimeSession) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if isSupportedRealtimeAudioFormat(update.InputAudioFormat) {
+		b.session.InputAudioFormat = update.InputAudioFormat
+		b.info.InputAudioFormat = update.InputAudioFormat
+	}
+	if isSupportedRealtimeAudioFormat(update.OutputAudioFormat) {
+		b.session.OutputAudioFormat = update.OutputAudioFormat
+		b.info.OutputAudioFormat = update.OutputAudioFormat
+	}
+}
+
+func (b *geminiLiveBridge) snapshotSession() *dto.RealtimeSession {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	session := b.session
+	return &session
+}
+
+// ensureSetup 首次调用时向上游发送 setup 并等待 setupComplete
+func (b *geminiLiveBridge) ensureSetup() error {
+	b.mu.Lock()
+	if b.setupSent {
+		b.mu.Unlock()
+		return nil
+	}
+	b.setupSent = true
+	setup := b.buildSetup()
+	b.mu.Unlock()
+
+	if err := b.sendTarget(&dto.GeminiLiveClientMessage{Setup: setup}); err != nil {
+		return fmt.Errorf("error writing setup to target: %v", err)
+	}
+	select {
+	case <-b.setupDone:
+		return nil
+	case <-time.After(geminiLiveSetupTimeout):
+		return errors.New("timeout waiting for gemini live setup to complete")
+	case <-b.c.Done():
+		return b.c.Err()
+	}
+}
+
+func (b *geminiLiveBridge) buildSetup() *dto.GeminiLiveSetup {
+	audioOutput := false
+	for _, modality := range b.session.Modalities {
+		if modality == "audio" {
+			audioOutput = true
+		}
+	}
+	// Gemini Live 单个会话只支持一种输出模态
+	generationConfig := &dto.GeminiChatGenerationConfig{ResponseModalities: []string{"TEXT"}}
+	if audioOutput {
+		generationConfig.ResponseModalities = []string{"AUDIO"}
+		if voice, ok := geminiLiveVoices[strings.ToLower(b.session.Voice)]; ok {
+			generationConfig.SpeechConfig = json.RawMessage(fmt.Sprintf(`{"voiceConfig":{"prebuiltVoiceConfig":{"voiceName":%q}}}`, voice))
+		}
+	}
+	if b.session.Temperature > 0 {
+		temperature := b.session.Temperature
+		generationConfig.Temperature = &temperature
+	}
+
+	setup := &dto.GeminiLiveSetup{
+		Model:            "models/" + b.info.UpstreamModelName,
+		GenerationConfig: generationConfig,
+	}
+	if b.session.Instructions != "" {
+		setup.SystemInstruction = &dto.GeminiChatContent{Parts: []dto.GeminiPart{{Text: b.session.Instructions}}}
+	}
+	if len(b.session.Tools) > 0 {
+		declarations := make([]map[string]any, 0, len(b.session.Tools))
+		for _, tool := range b.session.Tools {
+			declaration := map[string]any{"name": tool.Name, "description": tool.Description}
+			if tool.Parameters != nil {
+				declaration["parameters"] = tool.Parameters
+			}
+			declarations = append(declarations, declaration)
+		}
+		setup.Tools = []dto.GeminiChatTool{{FunctionDeclarations: declarations}}
+	}
+	if b.manualTurn {
+		setup.RealtimeInputConfig = &dto.GeminiLiveRealtimeConfig{
+			AutomaticActivityDetection: &dto.GeminiLiveActivityDetection{Disabled: true},
+		}
+	}
+	if b.session.InputAudioTranscription.Model != "" {
+		setup.InputAudioTranscription = &struct{}{}
+	}
+	if audioOutput {
+		setup.OutputAudioTranscription = &struct{}{}
+	}
+	return setup
+}
+
+func (b *geminiLiveBridge) appendAudio(event *dto.RealtimeEvent) error {
+	b.mu.Lock()
+	format := b.info.InputAudioFormat
+	startActivity := b.manualTurn && !b.activityStarted
+	if startActivity {
+		b.activityStarted = true
+	}
+	_, audioToken, err := service.CountTokenRealtime(b.info, *event, b.info.UpstreamModelName)
+	if err == nil {
+		b.addLocalInput(0, audioToken)
+	}
+	b.mu.Unlock()
+
+	data, err := base64.StdEncoding.DecodeString(event.Audio)
+	if err != nil {
+		return b.sendClientError("invalid_audio", "input audio must be base64 encoded")
+	}
+	pcm := service.DecodeRealtimeAudio(data, format)
+
+	if startActivity {
+		if err := b.sendTarget(&dto.GeminiLiveClientMessage{RealtimeInput: &dto.GeminiLiveRealtimeInput{ActivityStart: &struct{}{}}}); err != nil {
+			return fmt.Errorf("error writing to target: %v", err)
+		}
+	}
+	err = b.sendTarget(&dto.GeminiLiveClientMessage{RealtimeInput: &dto.GeminiLiveRealtimeInput{
+		Audio: &dto.GeminiInlineData{
+			MimeType: fmt.Sprintf("audio/pcm;rate=%d", service.AudioSampleRate(format)),
+			Data:     base64.StdEncoding.EncodeToString(pcm),
+		},
+	}})
+	if err != nil {
+		return fmt.Errorf("error writing to target: %v", err)
+	}
+	return nil
+}
+
+func (b *geminiLiveBridge) createConversationItem(event *dto.RealtimeEvent) error {
+	item := event.Item
+	if item == nil {
+		return nil
+	}
+	switch item.Type {
+	case "function_call_output":
+		b.mu.Lock()
+		name := b.functionNames[item.CallId]
+		b.addLocalInput(service.CountTextToken(item.Output, b.info.UpstreamModelName), 0)
+		b.mu.Unlock()
+		err := b.sendTarget(&dto.GeminiLiveClientMessage{ToolResponse: &dto.GeminiLiveToolResponse{
+			FunctionResponses: []dto.GeminiLiveFunctionResponse{{
+				Id:       item.CallId,
+				Name:     name,
+				Response: map[string]any{"output": item.Output},
+			}},
+		}})
+		if err != nil {
+			return fmt.Errorf("error writing to target: %v", err)
+		}
+	case "message":
+		role := "user"
+		if item.Role == "assistant" {
+			role = "model"
+		}
+		content := dto.GeminiChatContent{Role: role}
+		textToken := 0
+		for _, part := range item.Content {
+			switch part.Type {
+			case "input_text", "text":
+				content.Parts = append(content.Parts, dto.GeminiPart{Text: part.Text})
+				textToken += service.CountTextToken(part.Text, b.info.UpstreamModelName)
+			case "input_audio":
+				data, err := base64.StdEncoding.DecodeString(part.Audio)
+				if err != nil {
+					return b.sendClientError("invalid_audio", "input audio must be base64 encoded")
+				}
+				format := b.info.InputAudioFormat
+				content.Parts = append(content.Parts, dto.GeminiPart{InlineData: &dto.GeminiInlineData{
+					MimeType: fmt.Sprintf("audio/pcm;rate=%d", service.AudioSampleRate(format)),
+					Data:     base64.StdEncoding.EncodeToString(service.DecodeRealtimeAudio(data, format)),
+				}})
+			}
+		}
+		if len(content.Parts) == 0 {
+			return nil
+		}
+		b.mu.Lock()
+		b.pendingTurns = append(b.pendingTurns, content)
+		b.addLocalInput(textToken, 0)
+		b.mu.Unlock()
+	default:
+		return nil
+	}
+	if item.Id == "" {
+		item.Id = "item_" + common.GetRandomString(16)
+	}
+	return b.sendClient(&dto.RealtimeEvent{Type: dto.RealtimeEventConversationItemCreated, Item: item})
+}
+
+func (b *geminiLiveBridge) handleServerMessage(message []byte) error {
+	serverMessage := &dto.GeminiLiveServerMessage{}
+	if err := common.Unmarshal(message, serverMessage); err != nil {
+		return fmt.Errorf("error unmarshalling gemini live message: %v", err)
+	}
+	if serverMessage.SetupComplete != nil {
+		b.setupOnce.Do(func() { close(b.setupDone) })
+	}
+	// usageMetadata 可能与 turnComplete 在同一条消息中，需先记录
+	if serverMessage.UsageMetadata != nil {
+		b.mu.Lock()
+		b.turnUsage = serverMessage.UsageMetadata
+		b.mu.Unlock()
+	}
+	if serverMessage.GoAway != nil {
+		logger.LogWarn(b.c, "gemini live session will be closed by upstream, time left: "+serverMessage.GoAway.TimeLeft)
+	}
+	if serverMessage.ToolCall != nil {
+		if err := b.handleToolCall(serverMessage.ToolCall); err != nil {
+			return err
+		}
+	}
+	if serverMessage.ServerContent != nil {
+		return b.handleServerContent(serverMessage.ServerContent)
+	}
+	return nil
+}
+
+func (b *geminiLiveBridge) handleServerContent(content *dto.GeminiLiveServerContent) error {
+	if content.Interrupted {
+		// 用户插话，OpenAI 客户端在收到 speech_started 后停止播放
+		if err := b.sendClient(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioBufferSpeechStarted}); err != nil {
+			return err
+		}
+	}
+	if content.InputTranscription != nil && content.InputTranscription.Text != "" {
+		if err := b.sendClient(&dto.RealtimeEvent{Type: dto.RealtimeEventInputAudioTranscriptionDelta, Delta: content.InputTranscription.Text}); err != nil {
+			return err
+		}
+	}
+	if content.ModelTurn != nil {
+		for _, part := range content.ModelTurn.Parts {
+			if part.InlineData != nil && strings.HasPrefix(part.InlineData.MimeType, "audio/") {
+				if err := b.sendAudioDelta(part.InlineData); err != nil {
+					return err
+				}
+			} else if part.Text != "" && !part.Thought {
+				if err := b.sendResponseDelta(dto.RealtimeEventResponseTextDelta, part.Text); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if content.OutputTranscription != nil && content.OutputTranscription.Text != "" {
+		if err := b.sendResponseDelta(dto.RealtimeEventResponseAudioTranscriptionDelta, content.OutputTranscription.Text); err != nil {
+			return err
+		}
+	}
+	if content.TurnComplete {
+		return b.finishResponse("completed")
+	}
+	return nil
+}
+
+func (b *geminiLiveBridge) handleToolCall(toolCall *dto.GeminiLiveToolCall) error {
+	for _, call := range toolCall.FunctionCalls {
+		arguments, err := common.Marshal(call.Args)
+		if err != nil {
+			return fmt.Errorf("error marshalling function call arguments: %v", err)
+		}
+		responseId, itemId, err := b.ensureResponse()
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.functionNames[call.Id] = call.Name
+		b.addLocalOutput(service.CountTextToken(string(arguments), b.info.UpstreamModelName), 0)
+		b.mu.Unlock()
+		err = b.sendClient(&dto.RealtimeEvent{
+			Type:       dto.RealtimeEventResponseFunctionCallArgumentsDone,
+			ResponseId: responseId,
+			ItemId:     itemId,
+			CallId:     call.Id,
+			Name:       call.Name,
+			Arguments:  string(arguments),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// 上游等待 toolResponse 后才会继续本轮，客户端按 OpenAI 语义需要先收到 response.done
+	return b.finishResponse("completed")
+}
+
+// ensureResponse 当前没有进行中的 response 时下发 response.created
+func (b *geminiLiveBridge) ensureResponse() (string, string, error) {
+	b.mu.Lock()
+	if b.responseId != "" {
+		responseId, itemId := b.responseId, b.itemId
+		b.mu.Unlock()
+		return responseId, itemId, nil
+	}
+	b.responseId = "resp_" + common.GetRandomString(16)
+	b.itemId = "item_" + common.GetRandomString(16)
+	responseId, itemId := b.responseId, b.itemId
+	b.mu.Unlock()
+	err := b.sendClient(&dto.RealtimeEvent{
+		Type:     dto.RealtimeEventTypeResponseCreated,
+		Response: &dto.RealtimeResponse{Id: responseId, Status: "in_progress"},
+	})
+	return responseId, itemId, err
+}
+
+func (b *geminiLiveBridge) sendResponseDelta(eventType string, delta string) error {
+	responseId, itemId, err := b.ensureResponse()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.addLocalOutput(service.CountTextToken(delta, b.info.UpstreamModelName), 0)
+	b.mu.Unlock()
+	return b.sendClient(&dto.RealtimeEvent{Type: eventType, ResponseId: responseId, ItemId: itemId, Delta: delta})
+}
+
+func (b *geminiLiveBridge) sendAudioDelta(inlineData *dto.GeminiInlineData) error {
+	responseId, itemId, err := b.ensureResponse()
+	if err != nil {
+		return err
+	}
+	data, err := base64.StdEncoding.DecodeString(inlineData.Data)
+	if err != nil {
+		return fmt.Errorf("error decoding gemini audio: %v", err)
+	}
+	b.mu.Lock()
+	format := b.info.OutputAudioFormat
+	b.mu.Unlock()
+	pcm := service.ResamplePCM16(data, geminiAudioSampleRate(inlineData.MimeType), service.AudioSampleRate(format))
+	delta := base64.StdEncoding.EncodeToString(service.EncodeRealtimeAudio(pcm, format))
+	if audioToken, err := service.CountAudioTokenOutput(delta, format); err == nil {
+		b.mu.Lock()
+		b.addLocalOutput(0, audioToken)
+		b.mu.Unlock()
+	}
+	return b.sendClient(&dto.RealtimeEvent{
+		Type:       dto.RealtimeEventResponseAudioDelta,
+		ResponseId: responseId,
+		ItemId:     itemId,
+		Delta:      delta,
+	})
+}
+
+// finishResponse 结束当前 response 并按本轮用量预扣费，优先使用上游返回的 usageMetadata
+func (b *geminiLiveBridge) finishResponse(status string) error {
+	b.mu.Lock()
+	responseId := b.responseId
+	b.responseId = ""
+	b.itemId = ""
+	if responseId == "" {
+		b.mu.Unlock()
+		return nil
+	}
+	usage := b.takeTurnUsage()
+	err := openai.PreConsumeRealtimeUsage(b.c, b.info, usage, b.sumUsage)
+	b.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error consume usage: %v", err)
+	}
+	return b.sendClient(&dto.RealtimeEvent{
+		Type:     dto.RealtimeEventTypeResponseDone,
+		Response: &dto.RealtimeResponse{Id: responseId, Status: status, Usage: usage},
+	})
+}
+
+// takeTurnUsage 取出并清空本轮用量，调用方需持有 b.mu
+func (b *geminiLiveBridge) takeTurnUsage() *dto.RealtimeUsage {
+	usage := b.localUsage
+	if b.turnUsage != nil {
+		usage = convertGeminiLiveUsage(b.turnUsage)
+	}
+	b.turnUsage = nil
+	b.localUsage = &dto.RealtimeUsage{}
+	return usage
+}
+
+func (b *geminiLiveBridge) addLocalInput(textToken int, audioToken int) {
+	b.localUsage.TotalTokens += textToken + audioToken
+	b.localUsage.InputTokens += textToken + audioToken
+	b.localUsage.InputTokenDetails.TextTokens += textToken
+	b.localUsage.InputTokenDetails.AudioTokens += audioToken
+}
+
+func (b *geminiLiveBridge) addLocalOutput(textToken int, audioToken int) {
+	b.localUsage.TotalTokens += textToken + audioToken
+	b.localUsage.OutputTokens += textToken + audioToken
+	b.localUsage.OutputTokenDetails.TextTokens += textToken
+	b.localUsage.OutputTokenDetails.AudioTokens += audioToken
+}
+
+func convertGeminiLiveUsage(metadata *dto.GeminiLiveUsageMetadata) *dto.RealtimeUsage {
+	usage := &dto.RealtimeUsage{
+		InputTokens:  metadata.PromptTokenCount,
+		OutputTokens: metadata.ResponseTokenCount + metadata.ThoughtsTokenCount,
+	}
+	usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	usage.InputTokenDetails.CachedTokens = metadata.CachedContentTokenCount
+	for _, detail := range metadata.PromptTokensDetails {
+		if detail.Modality == "AUDIO" {
+			usage.InputTokenDetails.AudioTokens += detail.TokenCount
+		}
+	}
+	for _, detail := range metadata.ResponseTokensDetails {
+		if detail.Modality == "AUDIO" {
+			usage.OutputTokenDetails.AudioTokens += detail.TokenCount
+		}
+	}
+	// 未按模态拆分的部分均按文本计
+	usage.InputTokenDetails.TextTokens = usage.InputTokens - usage.InputTokenDetails.AudioTokens
+	usage.OutputTokenDetails.TextTokens = usage.OutputTokens - usage.OutputTokenDetails.AudioTokens
+	return usage
+}
+
+func isSupportedRealtimeAudioFormat(format string) bool {
+	switch format {
+	case "pcm16", "g711_ulaw", "g711_alaw":
+		return true
+	}
+	return false
+}
+
+// geminiAudioSampleRate 从 "audio/pcm;rate=24000" 中解析采样率，Gemini Live 输出默认为 24kHz
+func geminiAudioSampleRate(mimeType string) int {
+	for _, param := range strings.Split(mimeType, ";") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(param), "rate="); ok {
+			if rate, err := strconv.Atoi(value); err == nil && rate > 0 {
+				return rate
+			}
+		}
+	}
+	return 24000
+}
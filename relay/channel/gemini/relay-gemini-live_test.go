@@ -0,0 +1,204 @@
+package gemini
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+)
+
+const geminiLiveTestModel = "gemini-live-test"
+
+var geminiLiveTestUpgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+
+// 上一轮计费的异步缓存更新可能仍在读取这些开关，只在首次设置
+var geminiLiveTestFlagsOnce sync.Once
+
+func setupGeminiLiveBilling(t *testing.T) (*model.User, *model.Token) {
+	geminiLiveTestFlagsOnce.Do(func() {
+		common.RedisEnabled = false
+		common.BatchUpdateEnabled = false
+		common.IsMasterNode = true
+	})
+	common.SQLitePath = filepath.Join(t.TempDir(), "gemini-live.db")
+	require.NoError(t, model.InitDB())
+	model.LOG_DB = model.DB
+	require.NoError(t, ratio_setting.UpdateModelRatioByJSONString(`{"`+geminiLiveTestModel+`":1}`))
+	require.NoError(t, ratio_setting.UpdateCompletionRatioByJSONString(`{"`+geminiLiveTestModel+`":2}`))
+
+	user := &model.User{Username: "live", Password: "password123", Group: "default", Quota: 10000, Status: common.UserStatusEnabled}
+	require.NoError(t, model.DB.Create(user).Error)
+	token := &model.Token{UserId: user.Id, Key: common.GetRandomString(48), Name: "live", Status: common.TokenStatusEnabled, RemainQuota: 10000, ExpiredTime: -1}
+	require.NoError(t, model.DB.Create(token).Error)
+	return user, token
+}
+
+// startGeminiLiveUpstream 模拟 Gemini Live：校验 setup 与 clientContent，返回文本、音频和带 usageMetadata 的 turnComplete
+func startGeminiLiveUpstream(t *testing.T, setups chan<- *dto.GeminiLiveSetup, contents chan<- *dto.GeminiLiveClientContent, audio []byte) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := geminiLiveTestUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var message dto.GeminiLiveClientMessage
+			if err := conn.ReadJSON(&message); err != nil {
+				return
+			}
+			switch {
+			case message.Setup != nil:
+				setups <- message.Setup
+				_ = conn.WriteJSON(map[string]any{"setupComplete": map[string]any{}})
+			case message.ClientContent != nil:
+				contents <- message.ClientContent
+				_ = conn.WriteJSON(dto.GeminiLiveServerMessage{ServerContent: &dto.GeminiLiveServerContent{
+					ModelTurn: &dto.GeminiChatContent{Role: "model", Parts: []dto.GeminiPart{
+						{Text: "Hello"},
+						{InlineData: &dto.GeminiInlineData{MimeType: "audio/pcm;rate=24000", Data: base64.StdEncoding.EncodeToString(audio)}},
+					}},
+				}})
+				_ = conn.WriteJSON(dto.GeminiLiveServerMessage{
+					ServerContent: &dto.GeminiLiveServerContent{TurnComplete: true},
+					UsageMetadata: &dto.GeminiLiveUsageMetadata{PromptTokenCount: 10, ResponseTokenCount: 5, TotalTokenCount: 15},
+				})
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGeminiRealtimeHandler_TranslatesFramesAndBillsUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	user, token := setupGeminiLiveBilling(t)
+
+	audio := []byte{0x01, 0x02, 0x03, 0x04}
+	setups := make(chan *dto.GeminiLiveSetup, 1)
+	contents := make(chan *dto.GeminiLiveClientContent, 1)
+	upstream := startGeminiLiveUpstream(t, setups, contents, audio)
+
+	type handlerResult struct {
+		err   *types.NewAPIError
+		usage *dto.RealtimeUsage
+	}
+	results := make(chan handlerResult, 1)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientConn, err := geminiLiveTestUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer clientConn.Close()
+		targetConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(upstream.URL, "http"), nil)
+		if err != nil {
+			results <- handlerResult{err: types.NewError(err, types.ErrorCodeDoRequestFailed)}
+			return
+		}
+		defer targetConn.Close()
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = r
+		info := &relaycommon.RelayInfo{
+			UserId:            user.Id,
+			TokenId:           token.Id,
+			TokenKey:          "sk-" + token.Key,
+			UsingGroup:        "default",
+			UserGroup:         "default",
+			OriginModelName:   geminiLiveTestModel,
+			StartTime:         time.Now(),
+			ClientWs:          clientConn,
+			TargetWs:          targetConn,
+			InputAudioFormat:  "pcm16",
+			OutputAudioFormat: "pcm16",
+			ChannelMeta:       &relaycommon.ChannelMeta{UpstreamModelName: geminiLiveTestModel},
+		}
+		apiErr, usage := GeminiRealtimeHandler(c, info)
+		results <- handlerResult{err: apiErr, usage: usage}
+	}))
+	defer gateway.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(10*time.Second)))
+	readEvent := func(expectedType string) *dto.RealtimeEvent {
+		var event dto.RealtimeEvent
+		require.NoError(t, client.ReadJSON(&event))
+		require.Equal(t, expectedType, event.Type, "unexpected event: %+v", event)
+		return &event
+	}
+
+	readEvent(dto.RealtimeEventTypeSessionCreated)
+
+	// session.update 触发 setup，指令与音色转换为 Gemini 格式
+	require.NoError(t, client.WriteJSON(map[string]any{
+		"type":    dto.RealtimeEventTypeSessionUpdate,
+		"session": map[string]any{"instructions": "be brief", "voice": "shimmer"},
+	}))
+	setup := <-setups
+	require.Equal(t, "models/"+geminiLiveTestModel, setup.Model)
+	require.Equal(t, []string{"AUDIO"}, setup.GenerationConfig.ResponseModalities)
+	require.JSONEq(t, `{"voiceConfig":{"prebuiltVoiceConfig":{"voiceName":"Kore"}}}`, string(setup.GenerationConfig.SpeechConfig))
+	require.Equal(t, "be brief", setup.SystemInstruction.Parts[0].Text)
+	require.NotNil(t, setup.OutputAudioTranscription)
+	readEvent(dto.RealtimeEventTypeSessionUpdated)
+
+	// 文本消息在 response.create 时作为 clientContent 发送
+	require.NoError(t, client.WriteJSON(map[string]any{
+		"type": dto.RealtimeEventTypeConversationCreate,
+		"item": map[string]any{"type": "message", "role": "user", "content": []map[string]any{{"type": "input_text", "text": "hi"}}},
+	}))
+	readEvent(dto.RealtimeEventConversationItemCreated)
+	require.NoError(t, client.WriteJSON(map[string]any{"type": dto.RealtimeEventTypeResponseCreate}))
+	content := <-contents
+	require.True(t, content.TurnComplete)
+	require.Len(t, content.Turns, 1)
+	require.Equal(t, "user", content.Turns[0].Role)
+	require.Equal(t, "hi", content.Turns[0].Parts[0].Text)
+
+	created := readEvent(dto.RealtimeEventTypeResponseCreated)
+	textDelta := readEvent(dto.RealtimeEventResponseTextDelta)
+	require.Equal(t, "Hello", textDelta.Delta)
+	require.Equal(t, created.Response.Id, textDelta.ResponseId)
+	audioDelta := readEvent(dto.RealtimeEventResponseAudioDelta)
+	require.Equal(t, base64.StdEncoding.EncodeToString(audio), audioDelta.Delta)
+	done := readEvent(dto.RealtimeEventTypeResponseDone)
+	require.Equal(t, created.Response.Id, done.Response.Id)
+	require.Equal(t, 15, done.Response.Usage.TotalTokens)
+	require.Equal(t, 10, done.Response.Usage.InputTokens)
+	require.Equal(t, 5, done.Response.Usage.OutputTokens)
+
+	require.NoError(t, client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+	var result handlerResult
+	select {
+	case result = <-results:
+	case <-time.After(10 * time.Second):
+		t.Fatal("handler did not return after client closed")
+	}
+	require.Nil(t, result.err)
+	require.Equal(t, 15, result.usage.TotalTokens)
+
+	// 本轮按上游 usageMetadata 计费：(10 + 5 * 补全倍率 2) * 模型倍率 1 * 分组倍率 1
+	const expectedQuota = 20
+	quota, err := model.GetUserQuota(user.Id, true)
+	require.NoError(t, err)
+	require.Equal(t, 10000-expectedQuota, quota)
+	updatedToken, err := model.GetTokenById(token.Id)
+	require.NoError(t, err)
+	require.Equal(t, 10000-expectedQuota, updatedToken.RemainQuota)
+}
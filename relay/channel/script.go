@@ -0,0 +1,167 @@
+package channel
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newChannelScriptRunner 渠道配置了转换脚本时创建本次请求的脚本运行时，未配置或已全局关闭时返回 nil
+func newChannelScriptRunner(info *common.RelayInfo) (*common.ScriptRunner, error) {
+	if info.ChannelMeta == nil || strings.TrimSpace(info.ChannelOtherSettings.TransformScript) == "" {
+		return nil, nil
+	}
+	if !operation_setting.GetChannelScriptSetting().Enabled {
+		return nil, nil
+	}
+	runner, err := common.NewScriptRunner(info.ChannelOtherSettings.TransformScript, common.BuildScriptContext(info))
+	if err != nil {
+		return nil, fmt.Errorf("channel script: %w", err)
+	}
+	return runner, nil
+}
+
+// applyRequestScript 对 JSON 请求体执行 onRequest，脚本出错时本次请求失败
+func applyRequestScript(runner *common.ScriptRunner, requestBody io.Reader) (io.Reader, error) {
+	if requestBody == nil || !runner.HasHook(common.ScriptHookRequest) {
+		return requestBody, nil
+	}
+	data, err := io.ReadAll(requestBody)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return bytes.NewReader(data), nil
+	}
+	data, _, err = runner.Run(common.ScriptHookRequest, data)
+	if err != nil {
+		return nil, fmt.Errorf("channel script %s: %w", common.ScriptHookRequest, err)
+	}
+	return bytes.NewReader(data), nil
+}
+
+// applyResponseScript 替换成功响应的响应体：JSON 响应执行 onResponse，SSE 响应逐个事件执行 onStreamChunk。
+// 脚本在适配器解析响应之前执行，处理的是上游原始格式；出错时记录日志并保留原内容
+func applyResponseScript(c *gin.Context, runner *common.ScriptRunner, resp *http.Response) {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || resp.Body == nil {
+		return
+	}
+	contentType := resp.Header.Get("Content-Type")
+	switch {
+	case strings.Contains(contentType, "text/event-stream"):
+		if runner.HasHook(common.ScriptHookStreamChunk) {
+			resp.Body = newScriptStreamReader(c, runner, resp.Body)
+			resp.ContentLength = -1
+			resp.Header.Del("Content-Length")
+		}
+	case strings.Contains(contentType, "json"):
+		if !runner.HasHook(common.ScriptHookResponse) {
+			return
+		}
+		data, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err == nil {
+			var transformed []byte
+			transformed, _, err = runner.Run(common.ScriptHookResponse, data)
+			if err == nil {
+				data = transformed
+			}
+		}
+		if err != nil {
+			logger.LogError(c, fmt.Sprintf("channel script %s failed: %s", common.ScriptHookResponse, err.Error()))
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		resp.ContentLength = int64(len(data))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	}
+}
+
+// scriptStreamReader 按事件读取 SSE 响应，对 data 行的 JSON 执行 onStreamChunk
+type scriptStreamReader struct {
+	c      *gin.Context
+	runner *common.ScriptRunner
+	body   io.ReadCloser
+	reader *bufio.Reader
+	event  [][]byte // 当前事件已读取的行，遇到空行时整体输出
+	buf    bytes.Buffer
+	err    error
+}
+
+func newScriptStreamReader(c *gin.Context, runner *common.ScriptRunner, body io.ReadCloser) *scriptStreamReader {
+	return &scriptStreamReader{c: c, runner: runner, body: body, reader: bufio.NewReaderSize(body, 64*1024)}
+}
+
+func (r *scriptStreamReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 && r.err == nil {
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) > 0 {
+			r.event = append(r.event, line)
+			if len(bytes.TrimRight(line, "\r\n")) == 0 {
+				r.flushEvent()
+			}
+		}
+		r.err = err
+		if err != nil {
+			r.flushEvent()
+		}
+	}
+	if r.buf.Len() > 0 {
+		return r.buf.Read(p)
+	}
+	return 0, r.err
+}
+
+// flushEvent 输出当前事件。onStreamChunk 丢弃其中的 data 时，整个事件（包括 event、id 等行及结尾空行）一起丢弃，
+// 避免下游收到没有数据的 event 行
+func (r *scriptStreamReader) flushEvent() {
+	lines := r.event
+	r.event = r.event[:0]
+	for i, line := range lines {
+		transformed, drop := r.transformLine(line)
+		if drop {
+			return
+		}
+		lines[i] = transformed
+	}
+	for _, line := range lines {
+		r.buf.Write(line)
+	}
+}
+
+func (r *scriptStreamReader) transformLine(line []byte) ([]byte, bool) {
+	content := bytes.TrimRight(line, "\r\n")
+	if !bytes.HasPrefix(content, []byte("data:")) {
+		return line, false
+	}
+	payload := bytes.TrimSpace(content[len("data:"):])
+	if len(payload) == 0 || (payload[0] != '{' && payload[0] != '[') || string(payload) == "[DONE]" {
+		return line, false
+	}
+	transformed, drop, err := r.runner.Run(common.ScriptHookStreamChunk, payload)
+	if err != nil {
+		logger.LogError(r.c, fmt.Sprintf("channel script %s failed: %s", common.ScriptHookStreamChunk, err.Error()))
+		return line, false
+	}
+	if drop {
+		return nil, true
+	}
+	result := make([]byte, 0, len(transformed)+len(line)-len(content)+6)
+	result = append(result, "data: "...)
+	result = append(result, transformed...)
+	return append(result, line[len(content):]...), false
+}
+
+func (r *scriptStreamReader) Close() error {
+	return r.body.Close()
+}
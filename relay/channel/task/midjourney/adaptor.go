@@ -0,0 +1,159 @@
+package midjourney
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/relay/channel"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TaskAdaptor Midjourney Proxy 任务适配器，文档：https://github.com/novicezk/midjourney-proxy/blob/main/docs/api.md
+// /mj/* 兼容接口仍由 mjproxy_handler 处理请求与响应格式，任务的存储与轮询与其他平台一致
+type TaskAdaptor struct {
+	ChannelType int
+}
+
+func (a *TaskAdaptor) Init(info *relaycommon.RelayInfo) {
+	a.ChannelType = info.ChannelType
+}
+
+func (a *TaskAdaptor) ValidateRequestAndSetAction(c *gin.Context, info *relaycommon.RelayInfo) (taskErr *dto.TaskError) {
+	var midjRequest dto.MidjourneyRequest
+	err := common.UnmarshalBodyReusable(c, &midjRequest)
+	if err != nil {
+		taskErr = service.TaskErrorWrapperLocal(err, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	modelName, mjErr, ok := service.GetMjRequestModel(info.RelayMode, &midjRequest)
+	if !ok || mjErr != nil {
+		description := "invalid_request"
+		if mjErr != nil {
+			description = mjErr.Description
+		}
+		taskErr = service.TaskErrorWrapperLocal(fmt.Errorf("%s", description), "invalid_request", http.StatusBadRequest)
+		return
+	}
+	info.Action = constant.MidjourneyModel2Action[modelName]
+	if midjRequest.TaskId != "" {
+		info.OriginTaskID = midjRequest.TaskId
+	}
+	return nil
+}
+
+func (a *TaskAdaptor) BuildRequestURL(info *relaycommon.RelayInfo) (string, error) {
+	path := info.RequestURLPath
+	// /{mode}/mj/... 形式的路径去掉模式前缀
+	if idx := strings.Index(path, "/mj/"); idx > 0 {
+		path = path[idx:]
+	}
+	return fmt.Sprintf("%s%s", info.ChannelBaseUrl, path), nil
+}
+
+func (a *TaskAdaptor) BuildRequestHeader(c *gin.Context, req *http.Request, info *relaycommon.RelayInfo) error {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", c.Request.Header.Get("Accept"))
+	req.Header.Set("mj-api-secret", strings.TrimPrefix(info.ApiKey, "Bearer "))
+	return nil
+}
+
+func (a *TaskAdaptor) BuildRequestBody(c *gin.Context, info *relaycommon.RelayInfo) (io.Reader, error) {
+	var mapResult map[string]any
+	if err := common.UnmarshalBodyReusable(c, &mapResult); err != nil {
+		return nil, err
+	}
+	service.SanitizeMidjourneyRequest(mapResult)
+	data, err := common.Marshal(mapResult)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (a *TaskAdaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (*http.Response, error) {
+	return channel.DoTaskApiRequest(a, c, info, requestBody)
+}
+
+func (a *TaskAdaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (taskID string, taskData []byte, taskErr *dto.TaskError) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		taskErr = service.TaskErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+		return
+	}
+	var midjResponse dto.MidjourneyResponse
+	if err := common.Unmarshal(responseBody, &midjResponse); err != nil {
+		taskErr = service.TaskErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)
+		return
+	}
+	// 1-提交成功，21-任务已存在，22-排队中
+	if midjResponse.Code != 1 && midjResponse.Code != 21 && midjResponse.Code != 22 {
+		taskErr = service.TaskErrorWrapper(fmt.Errorf("%s", midjResponse.Description), fmt.Sprintf("midjourney_%d", midjResponse.Code), http.StatusInternalServerError)
+		return
+	}
+
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(c.Writer, bytes.NewBuffer(responseBody))
+	if err != nil {
+		taskErr = service.TaskErrorWrapper(err, "copy_response_body_failed", http.StatusInternalServerError)
+		return
+	}
+	return midjResponse.Result, nil, nil
+}
+
+func (a *TaskAdaptor) GetModelList() []string {
+	return ModelList
+}
+
+func (a *TaskAdaptor) GetChannelName() string {
+	return ChannelName
+}
+
+// FetchTask 批量查询任务，body 为 {"ids": [...]}，响应为 []dto.MidjourneyDto
+func (a *TaskAdaptor) FetchTask(baseUrl, key string, body map[string]any, proxy string) (*http.Response, error) {
+	requestUrl := fmt.Sprintf("%s/mj/task/list-by-condition", baseUrl)
+	byteBody, err := common.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, requestUrl, bytes.NewBuffer(byteBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("mj-api-secret", key)
+	client, err := service.GetHttpClientWithProxy(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("new proxy http client failed: %w", err)
+	}
+	// 与旧版轮询保持一致的 15 秒超时
+	timeoutClient := *client
+	timeoutClient.Timeout = 15 * time.Second
+	return timeoutClient.Do(req)
+}
+
+// ParseTaskResult 解析单个任务
+func (a *TaskAdaptor) ParseTaskResult(respBody []byte) (*relaycommon.TaskInfo, error) {
+	var item dto.MidjourneyDto
+	if err := common.Unmarshal(respBody, &item); err != nil {
+		return nil, err
+	}
+	return &relaycommon.TaskInfo{
+		Code:     0,
+		TaskID:   item.MjId,
+		Status:   item.Status,
+		Reason:   item.FailReason,
+		Url:      item.ImageUrl,
+		Progress: item.Progress,
+	}, nil
+}
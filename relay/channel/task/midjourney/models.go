@@ -0,0 +1,18 @@
+package midjourney
+
+import (
+	"sort"
+
+	"github.com/QuantumNous/new-api/constant"
+)
+
+var ModelList = func() []string {
+	models := make([]string, 0, len(constant.MidjourneyModel2Action))
+	for modelName := range constant.MidjourneyModel2Action {
+		models = append(models, modelName)
+	}
+	sort.Strings(models)
+	return models
+}()
+
+var ChannelName = "midjourney"
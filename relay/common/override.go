@@ -1,8 +1,10 @@
 package common
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -32,6 +34,25 @@ type ParamOperation struct {
 	Logic      string               `json:"logic,omitempty"`      // AND, OR (默认OR)
 }
 
+// ParamOverrideTrace 记录参数覆盖中单个操作的执行情况，用于管理端测试
+type ParamOverrideTrace struct {
+	Index   int             `json:"index"`
+	Mode    string          `json:"mode"`
+	Path    string          `json:"path,omitempty"`
+	From    string          `json:"from,omitempty"`
+	To      string          `json:"to,omitempty"`
+	Applied bool            `json:"applied"`
+	Skipped string          `json:"skipped,omitempty"` // conditions_not_met / keep_origin
+	Error   string          `json:"error,omitempty"`
+	Before  json.RawMessage `json:"before,omitempty"` // 操作前目标路径的值
+	After   json.RawMessage `json:"after,omitempty"`  // 操作后目标路径的值
+}
+
+const (
+	ParamOverrideSkipConditions = "conditions_not_met"
+	ParamOverrideSkipKeepOrigin = "keep_origin"
+)
+
 func ApplyParamOverride(jsonData []byte, paramOverride map[string]interface{}, conditionContext map[string]interface{}) ([]byte, error) {
 	if len(paramOverride) == 0 {
 		return jsonData, nil
@@ -40,7 +61,7 @@ func ApplyParamOverride(jsonData []byte, paramOverride map[string]interface{}, c
 	// 尝试断言为操作格式
 	if operations, ok := tryParseOperations(paramOverride); ok {
 		// 使用新方法
-		result, err := applyOperations(string(jsonData), operations, conditionContext)
+		result, err := applyOperations(string(jsonData), operations, conditionContext, nil)
 		return []byte(result), err
 	}
 
@@ -48,6 +69,79 @@ func ApplyParamOverride(jsonData []byte, paramOverride map[string]interface{}, c
 	return applyOperationsLegacy(jsonData, paramOverride)
 }
 
+// ApplyParamOverrideWithTrace 与 ApplyParamOverride 行为一致，额外返回每个操作的执行记录。
+// 出错时返回已执行部分的记录，最后一条记录包含错误信息
+func ApplyParamOverrideWithTrace(jsonData []byte, paramOverride map[string]interface{}, conditionContext map[string]interface{}) ([]byte, []ParamOverrideTrace, error) {
+	trace := make([]ParamOverrideTrace, 0)
+	if len(paramOverride) == 0 {
+		return jsonData, trace, nil
+	}
+
+	if operations, ok := tryParseOperations(paramOverride); ok {
+		result, err := applyOperations(string(jsonData), operations, conditionContext, &trace)
+		return []byte(result), trace, err
+	}
+
+	// 旧格式为顶层字段直接覆盖，按字段名排序逐个记录
+	keys := make([]string, 0, len(paramOverride))
+	for key := range paramOverride {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		entry := ParamOverrideTrace{Index: i, Mode: "set", Path: key, Applied: true}
+		if value := gjson.GetBytes(jsonData, gjson.Escape(key)); value.Exists() {
+			entry.Before = json.RawMessage(value.Raw)
+		}
+		if after, err := common.Marshal(paramOverride[key]); err == nil {
+			entry.After = after
+		}
+		trace = append(trace, entry)
+	}
+	result, err := applyOperationsLegacy(jsonData, paramOverride)
+	return result, trace, err
+}
+
+// ParseParamOverride 解析渠道配置中的参数覆盖 JSON，返回是否为 operations 格式
+func ParseParamOverride(paramOverride string) (map[string]interface{}, bool, error) {
+	override := make(map[string]interface{})
+	if strings.TrimSpace(paramOverride) == "" {
+		return override, false, nil
+	}
+	if err := common.Unmarshal([]byte(paramOverride), &override); err != nil {
+		return nil, false, fmt.Errorf("参数覆盖必须是合法的 JSON 对象: %v", err)
+	}
+	if _, exists := override["operations"]; exists {
+		operations, ok := tryParseOperations(override)
+		if !ok {
+			return nil, true, fmt.Errorf("operations 格式错误，每个操作都必须是包含 mode 的对象")
+		}
+		for i, op := range operations {
+			if !isKnownParamOperation(op.Mode) {
+				return nil, true, fmt.Errorf("operations[%d]: 未知的操作 %s", i, op.Mode)
+			}
+		}
+		return override, true, nil
+	}
+	return override, false, nil
+}
+
+// ApplyHeaderOverride 将请求头覆盖配置转换为请求头，支持变量 {api_key}
+func ApplyHeaderOverride(headerOverride map[string]interface{}, apiKey string) (map[string]string, error) {
+	headers := make(map[string]string, len(headerOverride))
+	for k, v := range headerOverride {
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("header %s must be a string", k)
+		}
+		if strings.Contains(str, "{api_key}") {
+			str = strings.ReplaceAll(str, "{api_key}", apiKey)
+		}
+		headers[k] = str
+	}
+	return headers, nil
+}
+
 func tryParseOperations(paramOverride map[string]interface{}) ([]ParamOperation, bool) {
 	// 检查是否包含 "operations" 字段
 	if opsValue, exists := paramOverride["operations"]; exists {
@@ -308,7 +402,23 @@ func applyOperationsLegacy(jsonData []byte, paramOverride map[string]interface{}
 	return common.Marshal(reqMap)
 }
 
-func applyOperations(jsonStr string, operations []ParamOperation, conditionContext map[string]interface{}) (string, error) {
+var paramOperationModes = []string{
+	"delete", "set", "move", "copy", "prepend", "append",
+	"trim_prefix", "trim_suffix", "ensure_prefix", "ensure_suffix",
+	"trim_space", "to_lower", "to_upper", "replace", "regex_replace",
+}
+
+func isKnownParamOperation(mode string) bool {
+	for _, m := range paramOperationModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// applyOperations 依次执行操作，trace 不为 nil 时记录每个操作的执行情况
+func applyOperations(jsonStr string, operations []ParamOperation, conditionContext map[string]interface{}, trace *[]ParamOverrideTrace) (string, error) {
 	var contextJSON string
 	if conditionContext != nil && len(conditionContext) > 0 {
 		ctxBytes, err := common.Marshal(conditionContext)
@@ -319,23 +429,47 @@ func applyOperations(jsonStr string, operations []ParamOperation, conditionConte
 	}
 
 	result := jsonStr
-	for _, op := range operations {
+	for i, op := range operations {
+		var entry *ParamOverrideTrace
+		if trace != nil {
+			*trace = append(*trace, ParamOverrideTrace{Index: i, Mode: op.Mode, Path: op.Path, From: op.From, To: op.To})
+			entry = &(*trace)[len(*trace)-1]
+		}
 		// 检查条件是否满足
 		ok, err := checkConditions(result, contextJSON, op.Conditions, op.Logic)
 		if err != nil {
+			if entry != nil {
+				entry.Error = err.Error()
+			}
 			return "", err
 		}
 		if !ok {
+			if entry != nil {
+				entry.Skipped = ParamOverrideSkipConditions
+			}
 			continue // 条件不满足，跳过当前操作
 		}
 		// 处理路径中的负数索引
 		opPath := processNegativeIndex(result, op.Path)
+		// move/copy 的目标路径为 to
+		tracePath := opPath
+		if op.Mode == "move" || op.Mode == "copy" {
+			tracePath = processNegativeIndex(result, op.To)
+		}
+		if entry != nil && tracePath != "" {
+			if value := gjson.Get(result, tracePath); value.Exists() {
+				entry.Before = json.RawMessage(value.Raw)
+			}
+		}
 
 		switch op.Mode {
 		case "delete":
 			result, err = sjson.Delete(result, opPath)
 		case "set":
 			if op.KeepOrigin && gjson.Get(result, opPath).Exists() {
+				if entry != nil {
+					entry.Skipped = ParamOverrideSkipKeepOrigin
+				}
 				continue
 			}
 			result, err = sjson.Set(result, opPath, op.Value)
@@ -345,7 +479,11 @@ func applyOperations(jsonStr string, operations []ParamOperation, conditionConte
 			result, err = moveValue(result, opFrom, opTo)
 		case "copy":
 			if op.From == "" || op.To == "" {
-				return "", fmt.Errorf("copy from/to is required")
+				err = fmt.Errorf("copy from/to is required")
+				if entry != nil {
+					entry.Error = err.Error()
+				}
+				return "", err
 			}
 			opFrom := processNegativeIndex(result, op.From)
 			opTo := processNegativeIndex(result, op.To)
@@ -373,10 +511,26 @@ func applyOperations(jsonStr string, operations []ParamOperation, conditionConte
 		case "regex_replace":
 			result, err = regexReplaceStringValue(result, opPath, op.From, op.To)
 		default:
-			return "", fmt.Errorf("unknown operation: %s", op.Mode)
+			err = fmt.Errorf("unknown operation: %s", op.Mode)
+			if entry != nil {
+				entry.Error = err.Error()
+			}
+			return "", err
 		}
 		if err != nil {
-			return "", fmt.Errorf("operation %s failed: %v", op.Mode, err)
+			err = fmt.Errorf("operation %s failed: %v", op.Mode, err)
+			if entry != nil {
+				entry.Error = err.Error()
+			}
+			return "", err
+		}
+		if entry != nil {
+			entry.Applied = true
+			if tracePath != "" {
+				if value := gjson.Get(result, tracePath); value.Exists() {
+					entry.After = json.RawMessage(value.Raw)
+				}
+			}
 		}
 	}
 	return result, nil
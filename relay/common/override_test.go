@@ -789,3 +789,86 @@ func assertJSONEqual(t *testing.T, want, got string) {
 		t.Fatalf("json not equal\nwant: %s\ngot:  %s", want, got)
 	}
 }
+
+func TestApplyParamOverrideWithTrace(t *testing.T) {
+	input := []byte(`{"model":"gpt-4","temperature":0.7}`)
+	override := map[string]interface{}{
+		"operations": []interface{}{
+			map[string]interface{}{
+				"path":  "temperature",
+				"mode":  "set",
+				"value": 0.1,
+			},
+			map[string]interface{}{
+				"path":        "model",
+				"mode":        "set",
+				"value":       "gpt-4o",
+				"keep_origin": true,
+			},
+			map[string]interface{}{
+				"path":  "max_tokens",
+				"mode":  "set",
+				"value": 100,
+				"conditions": []interface{}{
+					map[string]interface{}{
+						"path":  "model",
+						"mode":  "prefix",
+						"value": "claude",
+					},
+				},
+			},
+		},
+	}
+
+	out, trace, err := ApplyParamOverrideWithTrace(input, override, map[string]interface{}{"model": "gpt-4"})
+	if err != nil {
+		t.Fatalf("ApplyParamOverrideWithTrace returned error: %v", err)
+	}
+	assertJSONEqual(t, `{"model":"gpt-4","temperature":0.1}`, string(out))
+	if len(trace) != 3 {
+		t.Fatalf("expected 3 trace entries, got %d", len(trace))
+	}
+	if !trace[0].Applied || string(trace[0].Before) != "0.7" || string(trace[0].After) != "0.1" {
+		t.Fatalf("unexpected trace for set: %+v", trace[0])
+	}
+	if trace[1].Applied || trace[1].Skipped != ParamOverrideSkipKeepOrigin {
+		t.Fatalf("expected keep_origin skip, got %+v", trace[1])
+	}
+	if trace[2].Applied || trace[2].Skipped != ParamOverrideSkipConditions {
+		t.Fatalf("expected conditions skip, got %+v", trace[2])
+	}
+}
+
+func TestApplyParamOverrideWithTraceError(t *testing.T) {
+	input := []byte(`{"model":"gpt-4"}`)
+	override := map[string]interface{}{
+		"operations": []interface{}{
+			map[string]interface{}{
+				"path": "model",
+				"mode": "to_upper",
+			},
+			map[string]interface{}{
+				"mode": "copy",
+				"from": "model",
+			},
+		},
+	}
+
+	_, trace, err := ApplyParamOverrideWithTrace(input, override, nil)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if len(trace) != 2 || !trace[0].Applied || trace[1].Error == "" {
+		t.Fatalf("unexpected trace: %+v", trace)
+	}
+}
+
+func TestParseParamOverrideUnknownMode(t *testing.T) {
+	if _, _, err := ParseParamOverride(`{"operations":[{"path":"model","mode":"sett"}]}`); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+	override, isOperations, err := ParseParamOverride(`{"temperature":0.2}`)
+	if err != nil || isOperations || override["temperature"] != 0.2 {
+		t.Fatalf("unexpected legacy parse result: %v %v %v", override, isOperations, err)
+	}
+}
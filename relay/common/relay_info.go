@@ -97,6 +97,11 @@ type RelayInfo struct {
 	UsePrice               bool
 	RelayMode              int
 	OriginModelName        string
+	RequestedModelName     string // 客户端请求的模型名称，发生跨模型回退时 OriginModelName 为实际使用的模型
+	VirtualModelName       string // 客户端请求的虚拟模型名称，非虚拟模型时为空
+	SpeechPipeline         string // 语音链路模型名称，作为链路中的一个环节执行时非空
+	SpeechPipelineLeg      string // 语音链路环节：stt / chat / tts
+	AsyncTaskId            string // 异步图片任务 ID，在后台执行时非空
 	RequestURLPath         string
 	ShouldIncludeUsage     bool
 	DisablePing            bool // 是否禁止向下游发送自定义 Ping
@@ -114,6 +119,7 @@ type RelayInfo struct {
 	RelayFormat            types.RelayFormat
 	SendResponseCount      int
 	FinalPreConsumedQuota  int  // 最终预消耗的配额
+	SubscriptionId         int  // 本次请求使用的订阅，0 表示使用账户余额
 	IsClaudeBetaQuery      bool // /v1/messages?beta=true
 	IsChannelTest          bool // channel test request
 
@@ -409,7 +415,11 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		UserQuota:  common.GetContextKeyInt(c, constant.ContextKeyUserQuota),
 		UserEmail:  common.GetContextKeyString(c, constant.ContextKeyUserEmail),
 
-		OriginModelName: common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+		OriginModelName:    common.GetContextKeyString(c, constant.ContextKeyOriginalModel),
+		RequestedModelName: common.GetContextKeyString(c, constant.ContextKeyRequestedModel),
+		VirtualModelName:   common.GetContextKeyString(c, constant.ContextKeyVirtualModel),
+		SpeechPipeline:     common.GetContextKeyString(c, constant.ContextKeySpeechPipeline),
+		SpeechPipelineLeg:  common.GetContextKeyString(c, constant.ContextKeySpeechLeg),
 
 		TokenId:        common.GetContextKeyInt(c, constant.ContextKeyTokenId),
 		TokenKey:       common.GetContextKeyString(c, constant.ContextKeyTokenKey),
@@ -433,6 +443,10 @@ func genBaseRelayInfo(c *gin.Context, request dto.Request) *RelayInfo {
 		},
 	}
 
+	if info.RequestedModelName == "" {
+		info.RequestedModelName = info.OriginModelName
+	}
+
 	if info.RelayMode == relayconstant.RelayModeUnknown {
 		info.RelayMode = c.GetInt("relay_mode")
 	}
@@ -543,6 +557,11 @@ func (info *RelayInfo) GetEstimatePromptTokens() int {
 	return info.estimatePromptTokens
 }
 
+// IsModelFallback 是否发生了跨模型回退
+func (info *RelayInfo) IsModelFallback() bool {
+	return info.RequestedModelName != "" && info.RequestedModelName != info.OriginModelName
+}
+
 func (info *RelayInfo) SetFirstResponseTime() {
 	if info.isFirstResponse {
 		info.FirstResponseTime = time.Now()
@@ -0,0 +1,194 @@
+package common
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/dop251/goja"
+)
+
+// 渠道转换脚本（JavaScript）可以定义以下函数，未定义的阶段不执行：
+//
+//	function onRequest(body, ctx)     // 发送到上游前的请求体
+//	function onResponse(body, ctx)    // 非流式的上游响应体
+//	function onStreamChunk(chunk, ctx) // 流式响应中每个 data 事件的 JSON
+//
+// body/chunk 为解析后的 JSON；返回 undefined 表示不修改，返回字符串时原样作为新的内容，返回其他值时序列化为 JSON；
+// onStreamChunk 返回 null 时丢弃该事件。ctx 为只读的请求信息（模型、分组、用户 id 等）
+const (
+	ScriptHookRequest     = "onRequest"
+	ScriptHookResponse    = "onResponse"
+	ScriptHookStreamChunk = "onStreamChunk"
+)
+
+var scriptHooks = []string{ScriptHookRequest, ScriptHookResponse, ScriptHookStreamChunk}
+
+var ErrScriptTimeout = errors.New("script execution timed out")
+
+// 编译后的脚本按源码缓存，Program 可以在多个 Runtime 中复用
+var scriptPrograms sync.Map // [32]byte -> *goja.Program
+
+func compileScript(source string) (*goja.Program, error) {
+	key := sha256.Sum256([]byte(source))
+	if program, ok := scriptPrograms.Load(key); ok {
+		return program.(*goja.Program), nil
+	}
+	program, err := goja.Compile("channel_script.js", source, true)
+	if err != nil {
+		return nil, err
+	}
+	scriptPrograms.Store(key, program)
+	return program, nil
+}
+
+// ScriptRunner 一次请求中执行渠道脚本的运行时，流式响应的各个事件复用同一个运行时；不能并发使用
+type ScriptRunner struct {
+	vm        *goja.Runtime
+	hooks     map[string]goja.Callable
+	ctx       goja.Value
+	jsonParse goja.Callable
+	jsonStr   goja.Callable
+	timeout   time.Duration
+	maxBytes  int
+}
+
+// NewScriptRunner 编译并加载脚本，scriptCtx 以冻结对象的形式作为 ctx 参数传给各阶段
+func NewScriptRunner(source string, scriptCtx map[string]interface{}) (*ScriptRunner, error) {
+	program, err := compileScript(source)
+	if err != nil {
+		return nil, fmt.Errorf("compile script failed: %w", err)
+	}
+	setting := operation_setting.GetChannelScriptSetting()
+	r := &ScriptRunner{
+		vm:       goja.New(),
+		hooks:    make(map[string]goja.Callable),
+		timeout:  time.Duration(max(setting.TimeoutMs, 1)) * time.Millisecond,
+		maxBytes: setting.MaxPayloadBytes,
+	}
+	r.vm.SetMaxCallStackSize(max(setting.MaxCallStack, 16))
+	// 顶层代码同样受时间限制
+	if _, err = r.withTimeout(func() (goja.Value, error) {
+		return r.vm.RunProgram(program)
+	}); err != nil {
+		return nil, fmt.Errorf("run script failed: %w", err)
+	}
+	for _, name := range scriptHooks {
+		if fn, ok := goja.AssertFunction(r.vm.Get(name)); ok {
+			r.hooks[name] = fn
+		}
+	}
+	jsonObj := r.vm.Get("JSON").ToObject(r.vm)
+	r.jsonParse, _ = goja.AssertFunction(jsonObj.Get("parse"))
+	r.jsonStr, _ = goja.AssertFunction(jsonObj.Get("stringify"))
+
+	ctxObj := r.vm.NewObject()
+	for k, v := range scriptCtx {
+		_ = ctxObj.Set(k, v)
+	}
+	freeze, _ := goja.AssertFunction(r.vm.Get("Object").ToObject(r.vm).Get("freeze"))
+	if r.ctx, err = freeze(goja.Undefined(), ctxObj); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// HasHook 脚本是否定义了该阶段的函数
+func (r *ScriptRunner) HasHook(hook string) bool {
+	_, ok := r.hooks[hook]
+	return ok
+}
+
+// Run 执行一个阶段，返回新的内容以及是否丢弃（仅 onStreamChunk 返回 null 时）；未定义该阶段时原样返回
+func (r *ScriptRunner) Run(hook string, payload []byte) ([]byte, bool, error) {
+	fn, ok := r.hooks[hook]
+	if !ok {
+		return payload, false, nil
+	}
+	if r.maxBytes > 0 && len(payload) > r.maxBytes {
+		return payload, false, fmt.Errorf("payload exceeds %d bytes", r.maxBytes)
+	}
+	output, err := r.withTimeout(func() (goja.Value, error) {
+		input, err := r.jsonParse(goja.Undefined(), r.vm.ToValue(string(payload)))
+		if err != nil {
+			return nil, err
+		}
+		return fn(goja.Undefined(), input, r.ctx)
+	})
+	if err != nil {
+		return payload, false, err
+	}
+	switch {
+	case goja.IsUndefined(output):
+		return payload, false, nil
+	case goja.IsNull(output):
+		if hook != ScriptHookStreamChunk {
+			return payload, false, fmt.Errorf("%s returned null", hook)
+		}
+		return nil, true, nil
+	}
+	var result string
+	if s, ok := output.Export().(string); ok {
+		result = s
+	} else {
+		str, err := r.withTimeout(func() (goja.Value, error) {
+			return r.jsonStr(goja.Undefined(), output)
+		})
+		if err != nil {
+			return payload, false, err
+		}
+		result = str.String()
+	}
+	if r.maxBytes > 0 && len(result) > r.maxBytes {
+		return payload, false, fmt.Errorf("%s output exceeds %d bytes", hook, r.maxBytes)
+	}
+	return []byte(result), false, nil
+}
+
+// withTimeout 超时后中断脚本。goja 无法统计单个运行时的内存，脚本的资源占用由输入输出大小（MaxPayloadBytes）与执行时间共同限制
+func (r *ScriptRunner) withTimeout(fn func() (goja.Value, error)) (goja.Value, error) {
+	fired := make(chan struct{})
+	timer := time.AfterFunc(r.timeout, func() {
+		r.vm.Interrupt(ErrScriptTimeout)
+		close(fired)
+	})
+	value, err := fn()
+	if !timer.Stop() {
+		// 计时器在执行结束前后触发时，等待中断设置完成再清除，避免下一次调用被中断
+		<-fired
+		r.vm.ClearInterrupt()
+	}
+	if err != nil {
+		var interrupted *goja.InterruptedError
+		if errors.As(err, &interrupted) {
+			if v, ok := interrupted.Value().(error); ok {
+				return nil, v
+			}
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+// BuildScriptContext 传给脚本的只读请求信息
+func BuildScriptContext(info *RelayInfo) map[string]interface{} {
+	ctx := BuildParamOverrideContext(info)
+	if ctx == nil {
+		return map[string]interface{}{}
+	}
+	ctx["group"] = info.UsingGroup
+	ctx["user_group"] = info.UserGroup
+	ctx["user_id"] = info.UserId
+	ctx["token_id"] = info.TokenId
+	ctx["relay_mode"] = info.RelayMode
+	ctx["is_stream"] = info.IsStream
+	if info.ChannelMeta != nil {
+		ctx["channel_id"] = info.ChannelId
+		ctx["channel_type"] = info.ChannelType
+	}
+	return ctx
+}
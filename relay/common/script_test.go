@@ -0,0 +1,142 @@
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+func TestScriptRunnerRequestHook(t *testing.T) {
+	script := `
+function onRequest(body, ctx) {
+	body.model = ctx.model + "-mapped";
+	body.user = "u" + ctx.user_id;
+	delete body.temperature;
+	return body;
+}`
+	runner, err := NewScriptRunner(script, map[string]interface{}{"model": "gpt-4", "user_id": 7})
+	if err != nil {
+		t.Fatalf("NewScriptRunner returned error: %v", err)
+	}
+	if !runner.HasHook(ScriptHookRequest) || runner.HasHook(ScriptHookResponse) {
+		t.Fatalf("unexpected hooks")
+	}
+	out, dropped, err := runner.Run(ScriptHookRequest, []byte(`{"model":"x","temperature":0.7}`))
+	if err != nil || dropped {
+		t.Fatalf("Run returned error: %v, dropped: %v", err, dropped)
+	}
+	assertJSONEqual(t, `{"model":"gpt-4-mapped","user":"u7"}`, string(out))
+
+	// 未定义的阶段原样返回
+	out, _, err = runner.Run(ScriptHookResponse, []byte(`{"a":1}`))
+	if err != nil || string(out) != `{"a":1}` {
+		t.Fatalf("undefined hook should pass through, got %s, %v", out, err)
+	}
+}
+
+func TestScriptRunnerReturnValues(t *testing.T) {
+	script := `
+function onResponse(body) {
+	if (body.keep) return;
+	return "raw:" + body.id;
+}
+function onStreamChunk(chunk) {
+	if (chunk.drop) return null;
+	chunk.seen = true;
+	return chunk;
+}`
+	runner, err := NewScriptRunner(script, nil)
+	if err != nil {
+		t.Fatalf("NewScriptRunner returned error: %v", err)
+	}
+	out, _, err := runner.Run(ScriptHookResponse, []byte(`{"keep":true}`))
+	if err != nil || string(out) != `{"keep":true}` {
+		t.Fatalf("undefined return should keep payload, got %s, %v", out, err)
+	}
+	out, _, err = runner.Run(ScriptHookResponse, []byte(`{"id":"abc"}`))
+	if err != nil || string(out) != "raw:abc" {
+		t.Fatalf("string return should be used as is, got %s, %v", out, err)
+	}
+	_, dropped, err := runner.Run(ScriptHookStreamChunk, []byte(`{"drop":true}`))
+	if err != nil || !dropped {
+		t.Fatalf("null return should drop chunk, dropped: %v, err: %v", dropped, err)
+	}
+	out, _, err = runner.Run(ScriptHookStreamChunk, []byte(`{"n":1}`))
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	assertJSONEqual(t, `{"n":1,"seen":true}`, string(out))
+}
+
+func TestScriptRunnerContextIsReadOnly(t *testing.T) {
+	script := `
+function onRequest(body, ctx) {
+	ctx.user_id = 1;
+	return body;
+}`
+	runner, err := NewScriptRunner(script, map[string]interface{}{"user_id": 7})
+	if err != nil {
+		t.Fatalf("NewScriptRunner returned error: %v", err)
+	}
+	if _, _, err = runner.Run(ScriptHookRequest, []byte(`{}`)); err == nil {
+		t.Fatalf("expected error when modifying frozen ctx in strict mode")
+	}
+}
+
+func TestScriptRunnerLimits(t *testing.T) {
+	runner, err := NewScriptRunner(`function onRequest(body) { while (true) {} }`, nil)
+	if err != nil {
+		t.Fatalf("NewScriptRunner returned error: %v", err)
+	}
+	if _, _, err = runner.Run(ScriptHookRequest, []byte(`{}`)); !errors.Is(err, ErrScriptTimeout) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+
+	if _, err = NewScriptRunner(`while (true) {}`, nil); !errors.Is(err, ErrScriptTimeout) {
+		t.Fatalf("expected timeout error for top-level code, got %v", err)
+	}
+
+	runner, err = NewScriptRunner(`function f(n) { return f(n + 1); } function onRequest(body) { return f(0); }`, nil)
+	if err != nil {
+		t.Fatalf("NewScriptRunner returned error: %v", err)
+	}
+	if _, _, err = runner.Run(ScriptHookRequest, []byte(`{}`)); err == nil {
+		t.Fatalf("expected call stack overflow error")
+	}
+
+	if _, err = NewScriptRunner(`function onRequest(body) {`, nil); err == nil {
+		t.Fatalf("expected compile error")
+	}
+}
+
+func TestScriptRunnerReusableAfterTimeout(t *testing.T) {
+	setting := operation_setting.GetChannelScriptSetting()
+	origin := *setting
+	defer func() { *setting = origin }()
+	setting.TimeoutMs = 50
+
+	runner, err := NewScriptRunner(`
+function onRequest(body) {
+	if (body.spin) {
+		var chunks = [];
+		while (true) { chunks.push(new Array(64).fill("x")); }
+	}
+	body.ok = true;
+	return body;
+}`, nil)
+	if err != nil {
+		t.Fatalf("NewScriptRunner returned error: %v", err)
+	}
+	if _, _, err = runner.Run(ScriptHookRequest, []byte(`{"spin":true}`)); !errors.Is(err, ErrScriptTimeout) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	// 超时中断被清除后，同一个运行时可以继续执行
+	for i := 0; i < 3; i++ {
+		out, _, err := runner.Run(ScriptHookRequest, []byte(`{}`))
+		if err != nil {
+			t.Fatalf("Run returned error: %v", err)
+		}
+		assertJSONEqual(t, `{"ok":true}`, string(out))
+	}
+}
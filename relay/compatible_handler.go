@@ -247,6 +247,13 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 
 	modelName := relayInfo.OriginModelName
 
+	// 按实际上下文长度重新匹配阶梯计费；Anthropic 的 input_tokens 不包含缓存 tokens
+	contextTokens := promptTokens
+	if relayInfo.ChannelType == constant.ChannelTypeAnthropic {
+		contextTokens += cacheTokens + cachedCreationTokens
+	}
+	service.ApplyPricingTier(relayInfo, contextTokens)
+
 	tokenName := ctx.GetString("token_name")
 	completionRatio := relayInfo.PriceData.CompletionRatio
 	cacheRatio := relayInfo.PriceData.CacheRatio
@@ -423,6 +430,9 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage
 		}
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		if relayInfo.ChannelIsMultiKey {
+			model.RecordChannelKeyConsumption(relayInfo.ChannelId, relayInfo.ChannelMultiKeyIndex, totalTokens, quota)
+		}
 	}
 
 	quotaDelta := quota - relayInfo.FinalPreConsumedQuota
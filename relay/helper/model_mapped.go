@@ -12,47 +12,51 @@ import (
 
 func ModelMappedHelper(c *gin.Context, info *common.RelayInfo, request dto.Request) error {
 	// map model name
-	modelMapping := c.GetString("model_mapping")
-	if modelMapping != "" && modelMapping != "{}" {
-		modelMap := make(map[string]string)
-		err := json.Unmarshal([]byte(modelMapping), &modelMap)
-		if err != nil {
-			return fmt.Errorf("unmarshal_model_mapping_failed")
-		}
-
-		// 支持链式模型重定向，最终使用链尾的模型
-		currentModel := info.OriginModelName
-		visitedModels := map[string]bool{
-			currentModel: true,
-		}
-		for {
-			if mappedModel, exists := modelMap[currentModel]; exists && mappedModel != "" {
-				// 模型重定向循环检测，避免无限循环
-				if visitedModels[mappedModel] {
-					if mappedModel == currentModel {
-						if currentModel == info.OriginModelName {
-							info.IsModelMapped = false
-							return nil
-						} else {
-							info.IsModelMapped = true
-							break
-						}
-					}
-					return errors.New("model_mapping_contains_cycle")
-				}
-				visitedModels[mappedModel] = true
-				currentModel = mappedModel
-				info.IsModelMapped = true
-			} else {
-				break
-			}
-		}
-		if info.IsModelMapped {
-			info.UpstreamModelName = currentModel
-		}
+	upstreamModel, mapped, err := ResolveModelMapping(c.GetString("model_mapping"), info.OriginModelName)
+	if err != nil {
+		return err
+	}
+	if mapped {
+		info.IsModelMapped = true
+		info.UpstreamModelName = upstreamModel
 	}
 	if request != nil {
 		request.SetModelName(info.UpstreamModelName)
 	}
 	return nil
 }
+
+// ResolveModelMapping 按渠道模型映射解析上游模型名，返回最终模型及是否发生了重定向
+func ResolveModelMapping(modelMapping string, modelName string) (string, bool, error) {
+	if modelMapping == "" || modelMapping == "{}" {
+		return modelName, false, nil
+	}
+	modelMap := make(map[string]string)
+	err := json.Unmarshal([]byte(modelMapping), &modelMap)
+	if err != nil {
+		return modelName, false, fmt.Errorf("unmarshal_model_mapping_failed")
+	}
+
+	// 支持链式模型重定向，最终使用链尾的模型
+	currentModel := modelName
+	visitedModels := map[string]bool{
+		currentModel: true,
+	}
+	mapped := false
+	for {
+		mappedModel, exists := modelMap[currentModel]
+		if !exists || mappedModel == "" {
+			return currentModel, mapped, nil
+		}
+		// 模型重定向循环检测，避免无限循环
+		if visitedModels[mappedModel] {
+			if mappedModel == currentModel {
+				return currentModel, currentModel != modelName, nil
+			}
+			return modelName, false, errors.New("model_mapping_contains_cycle")
+		}
+		visitedModels[mappedModel] = true
+		currentModel = mappedModel
+		mapped = true
+	}
+}
@@ -2,6 +2,7 @@ package helper
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/logger"
@@ -18,11 +19,6 @@ const claudeCacheCreation1hMultiplier = 6 / 3.75
 
 // HandleGroupRatio checks for "auto_group" in the context and updates the group ratio and relayInfo.UsingGroup if present
 func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) types.GroupRatioInfo {
-	groupRatioInfo := types.GroupRatioInfo{
-		GroupRatio:        1.0, // default ratio
-		GroupSpecialRatio: -1,
-	}
-
 	// check auto group
 	autoGroup, exists := ctx.Get("auto_group")
 	if exists {
@@ -30,8 +26,18 @@ func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) types.
 		relayInfo.UsingGroup = autoGroup.(string)
 	}
 
+	return GetGroupRatioInfo(relayInfo.UserGroup, relayInfo.UsingGroup)
+}
+
+// GetGroupRatioInfo 计算用户分组使用指定分组时的倍率，优先使用用户分组的特殊倍率
+func GetGroupRatioInfo(userGroup string, usingGroup string) types.GroupRatioInfo {
+	groupRatioInfo := types.GroupRatioInfo{
+		GroupRatio:        1.0, // default ratio
+		GroupSpecialRatio: -1,
+	}
+
 	// check user group special ratio
-	userGroupRatio, ok := ratio_setting.GetGroupGroupRatio(relayInfo.UserGroup, relayInfo.UsingGroup)
+	userGroupRatio, ok := ratio_setting.GetGroupGroupRatio(userGroup, usingGroup)
 	if ok {
 		// user group special ratio
 		groupRatioInfo.GroupSpecialRatio = userGroupRatio
@@ -39,16 +45,31 @@ func HandleGroupRatio(ctx *gin.Context, relayInfo *relaycommon.RelayInfo) types.
 		groupRatioInfo.HasSpecialRatio = true
 	} else {
 		// normal group ratio
-		groupRatioInfo.GroupRatio = ratio_setting.GetGroupRatio(relayInfo.UsingGroup)
+		groupRatioInfo.GroupRatio = ratio_setting.GetGroupRatio(usingGroup)
 	}
 
 	return groupRatioInfo
 }
 
 func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int, meta *types.TokenCountMeta) (types.PriceData, error) {
-	modelPrice, usePrice := ratio_setting.GetModelPrice(info.OriginModelName, false)
-
 	groupRatioInfo := HandleGroupRatio(c, info)
+	priceData, configured, matchName := CalculateModelPrice(info.OriginModelName, groupRatioInfo, promptTokens, meta, info.StartTime)
+	if !configured && !info.UserSetting.AcceptUnsetRatioModel {
+		return types.PriceData{}, fmt.Errorf("模型 %s 倍率或价格未配置，请联系管理员设置或开始自用模式；Model %s ratio or price not set, please set or start self-use mode", matchName, matchName)
+	}
+
+	if common.DebugEnabled {
+		println(fmt.Sprintf("model_price_helper result: %s", priceData.ToSetting()))
+	}
+	info.PriceData = priceData
+	return priceData, nil
+}
+
+// CalculateModelPrice 按模型、分组倍率与预估提示 tokens 计算价格与预扣额度，不依赖请求上下文。
+// configured 为 false 表示模型未配置倍率，此时 matchName 为匹配倍率时使用的模型名
+func CalculateModelPrice(modelName string, groupRatioInfo types.GroupRatioInfo, promptTokens int, meta *types.TokenCountMeta, requestTime time.Time) (priceData types.PriceData, configured bool, matchName string) {
+	modelPrice, usePrice := ratio_setting.GetModelPrice(modelName, false)
+	configured = true
 
 	var preConsumedQuota int
 	var modelRatio float64
@@ -61,38 +82,35 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 	var audioRatio float64
 	var audioCompletionRatio float64
 	var freeModel bool
+	// 阶梯计费：按预估提示 tokens 与请求时间匹配，实际用量返回后会重新匹配
+	pricingTier := ratio_setting.MatchPricingTier(modelName, promptTokens, usePrice, requestTime)
 	if !usePrice {
 		preConsumedTokens := common.Max(promptTokens, common.PreConsumedQuota)
 		if meta.MaxTokens != 0 {
 			preConsumedTokens += meta.MaxTokens
 		}
-		var success bool
-		var matchName string
-		modelRatio, success, matchName = ratio_setting.GetModelRatio(info.OriginModelName)
-		if !success {
-			acceptUnsetRatio := false
-			if info.UserSetting.AcceptUnsetRatioModel {
-				acceptUnsetRatio = true
-			}
-			if !acceptUnsetRatio {
-				return types.PriceData{}, fmt.Errorf("模型 %s 倍率或价格未配置，请联系管理员设置或开始自用模式；Model %s ratio or price not set, please set or start self-use mode", matchName, matchName)
-			}
-		}
-		completionRatio = ratio_setting.GetCompletionRatio(info.OriginModelName)
-		cacheRatio, _ = ratio_setting.GetCacheRatio(info.OriginModelName)
-		cacheCreationRatio, _ = ratio_setting.GetCreateCacheRatio(info.OriginModelName)
+		modelRatio, configured, matchName = ratio_setting.GetModelRatio(modelName)
+		completionRatio = ratio_setting.GetCompletionRatio(modelName)
+		cacheRatio, _ = ratio_setting.GetCacheRatio(modelName)
+		cacheCreationRatio, _ = ratio_setting.GetCreateCacheRatio(modelName)
 		cacheCreationRatio5m = cacheCreationRatio
 		// 固定1h和5min缓存写入价格的比例
 		cacheCreationRatio1h = cacheCreationRatio * claudeCacheCreation1hMultiplier
-		imageRatio, _ = ratio_setting.GetImageRatio(info.OriginModelName)
-		audioRatio = ratio_setting.GetAudioRatio(info.OriginModelName)
-		audioCompletionRatio = ratio_setting.GetAudioCompletionRatio(info.OriginModelName)
+		imageRatio, _ = ratio_setting.GetImageRatio(modelName)
+		audioRatio = ratio_setting.GetAudioRatio(modelName)
+		audioCompletionRatio = ratio_setting.GetAudioCompletionRatio(modelName)
+		if pricingTier != nil {
+			modelRatio, completionRatio, modelPrice = pricingTier.Apply(modelRatio, completionRatio, modelPrice)
+		}
 		ratio := modelRatio * groupRatioInfo.GroupRatio
 		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
 	} else {
 		if meta.ImagePriceRatio != 0 {
 			modelPrice = modelPrice * meta.ImagePriceRatio
 		}
+		if pricingTier != nil {
+			modelRatio, completionRatio, modelPrice = pricingTier.Apply(modelRatio, completionRatio, modelPrice)
+		}
 		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatioInfo.GroupRatio)
 	}
 
@@ -115,7 +133,7 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		}
 	}
 
-	priceData := types.PriceData{
+	priceData = types.PriceData{
 		FreeModel:            freeModel,
 		ModelPrice:           modelPrice,
 		ModelRatio:           modelRatio,
@@ -130,13 +148,9 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		CacheCreation5mRatio: cacheCreationRatio5m,
 		CacheCreation1hRatio: cacheCreationRatio1h,
 		QuotaToPreConsume:    preConsumedQuota,
+		PricingTier:          pricingTier,
 	}
-
-	if common.DebugEnabled {
-		println(fmt.Sprintf("model_price_helper result: %s", priceData.ToSetting()))
-	}
-	info.PriceData = priceData
-	return priceData, nil
+	return priceData, configured, matchName
 }
 
 // ModelPriceHelperPerCall 按次计费的 PriceHelper (MJ、Task)
@@ -15,6 +15,7 @@ import (
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -106,7 +107,18 @@ func ImageHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *type
 		}
 	}
 
+	var capture *mediaCaptureWriter
+	if !info.IsStream && operation_setting.GetMediaStorageSetting().ShouldPersist(operation_setting.MediaSourceImage) {
+		capture = captureMediaResponse(c)
+	}
 	usage, newAPIError := adaptor.DoResponse(c, httpResp, info)
+	if capture != nil {
+		body := capture.body.Bytes()
+		if newAPIError == nil && capture.Status() == http.StatusOK {
+			body = persistImageResponse(c, info, body)
+		}
+		capture.release(c, body)
+	}
 	if newAPIError != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(newAPIError, statusCodeMappingStr)
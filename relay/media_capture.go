@@ -0,0 +1,145 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mediaCaptureWriter 缓存适配器写出的响应，持久化生成的媒体并改写响应后再返回给客户端
+type mediaCaptureWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func captureMediaResponse(c *gin.Context) *mediaCaptureWriter {
+	w := &mediaCaptureWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	return w
+}
+
+func (w *mediaCaptureWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *mediaCaptureWriter) WriteHeaderNow() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *mediaCaptureWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+func (w *mediaCaptureWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *mediaCaptureWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *mediaCaptureWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *mediaCaptureWriter) Written() bool {
+	return w.status != 0
+}
+
+func (w *mediaCaptureWriter) Flush() {}
+
+// release 恢复原始 writer，并写出（可能已改写的）响应体
+func (w *mediaCaptureWriter) release(c *gin.Context, body []byte) {
+	c.Writer = w.ResponseWriter
+	if w.status == 0 {
+		return
+	}
+	c.Writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	c.Writer.WriteHeader(w.status)
+	_, _ = c.Writer.Write(body)
+}
+
+// persistImageResponse 持久化图片生成结果，url 改写为网关签名链接；
+// b64_json 仅在异步任务中持久化并改写为网关链接，避免任务结果中保存大体积数据。
+// 同步请求的 b64_json 原样返回且不持久化，客户端拿不到链接的图片不占用存储配额
+func persistImageResponse(c *gin.Context, info *relaycommon.RelayInfo, body []byte) []byte {
+	var resp map[string]any
+	if err := common.Unmarshal(body, &resp); err != nil {
+		return body
+	}
+	items, _ := resp["data"].([]any)
+	requestId := c.GetString(common.RequestIdKey)
+	if info.AsyncTaskId != "" {
+		requestId = info.AsyncTaskId
+	}
+	changed := false
+	for _, item := range items {
+		image, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if rawURL, _ := image["url"].(string); rawURL != "" {
+			mediaURL, err := service.PersistMediaFromURL(c.Request.Context(), info.UserId, operation_setting.MediaSourceImage, requestId, rawURL)
+			if err != nil {
+				logger.LogWarn(c, fmt.Sprintf("failed to persist image: %s", err.Error()))
+				continue
+			}
+			// 异步任务结果在查询时签名
+			if info.AsyncTaskId == "" {
+				mediaURL = service.SignMediaURL(mediaURL)
+			}
+			image["url"] = mediaURL
+			changed = true
+		} else if b64, _ := image["b64_json"].(string); b64 != "" && info.AsyncTaskId != "" {
+			data, err := base64.StdEncoding.DecodeString(b64)
+			if err != nil {
+				logger.LogWarn(c, fmt.Sprintf("failed to persist image: %s", err.Error()))
+				continue
+			}
+			obj, err := service.PersistMediaBytes(c.Request.Context(), info.UserId, operation_setting.MediaSourceImage, requestId, "", data)
+			if err != nil {
+				logger.LogWarn(c, fmt.Sprintf("failed to persist image: %s", err.Error()))
+				continue
+			}
+			delete(image, "b64_json")
+			image["url"] = service.MediaObjectURL(obj)
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	rewritten, err := common.Marshal(resp)
+	if err != nil {
+		return body
+	}
+	return rewritten
+}
+
+// persistSpeechResponse 持久化 TTS 音频，签名链接通过 X-Media-Url 响应头返回
+func persistSpeechResponse(c *gin.Context, info *relaycommon.RelayInfo, w *mediaCaptureWriter) {
+	obj, err := service.PersistMediaBytes(c.Request.Context(), info.UserId, operation_setting.MediaSourceAudio,
+		c.GetString(common.RequestIdKey), w.Header().Get("Content-Type"), w.body.Bytes())
+	if err != nil {
+		logger.LogWarn(c, fmt.Sprintf("failed to persist speech: %s", err.Error()))
+		return
+	}
+	w.Header().Set("X-Media-Url", service.SignMediaURL(service.MediaObjectURL(obj)))
+}
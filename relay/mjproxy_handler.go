@@ -20,7 +20,6 @@ import (
 	"github.com/QuantumNous/new-api/relay/helper"
 	"github.com/QuantumNous/new-api/service"
 	"github.com/QuantumNous/new-api/setting"
-	"github.com/QuantumNous/new-api/setting/system_setting"
 
 	"github.com/gin-gonic/gin"
 )
@@ -34,6 +33,12 @@ func RelayMidjourneyImage(c *gin.Context) {
 		})
 		return
 	}
+	imageUrl := midjourneyTask.GetMidjourneyData().ImageUrl
+	// 已持久化的图片直接从媒体存储读取
+	if obj, err := service.GetMediaObjectByURL(imageUrl); err == nil {
+		service.ServeMediaObject(c, obj)
+		return
+	}
 	var httpClient *http.Client
 	if channel, err := model.CacheGetChannel(midjourneyTask.ChannelId); err == nil {
 		proxy := channel.GetSetting().Proxy
@@ -49,7 +54,7 @@ func RelayMidjourneyImage(c *gin.Context) {
 	if httpClient == nil {
 		httpClient = service.GetHttpClient()
 	}
-	resp, err := httpClient.Get(midjourneyTask.ImageUrl)
+	resp, err := httpClient.Get(imageUrl)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "http_get_image_failed",
@@ -100,18 +105,8 @@ func RelayMidjourneyNotify(c *gin.Context) *dto.MidjourneyResponse {
 			Result:      "",
 		}
 	}
-	midjourneyTask.Progress = midjRequest.Progress
-	midjourneyTask.PromptEn = midjRequest.PromptEn
-	midjourneyTask.State = midjRequest.State
-	midjourneyTask.SubmitTime = midjRequest.SubmitTime
-	midjourneyTask.StartTime = midjRequest.StartTime
-	midjourneyTask.FinishTime = midjRequest.FinishTime
-	midjourneyTask.ImageUrl = midjRequest.ImageUrl
-	midjourneyTask.VideoUrl = midjRequest.VideoUrl
-	videoUrlsStr, _ := json.Marshal(midjRequest.VideoUrls)
-	midjourneyTask.VideoUrls = string(videoUrlsStr)
-	midjourneyTask.Status = midjRequest.Status
-	midjourneyTask.FailReason = midjRequest.FailReason
+	preStatus := midjourneyTask.Status
+	midjourneyTask.ApplyMidjourneyUpdate(&midjRequest)
 	err = midjourneyTask.Update()
 	if err != nil {
 		return &dto.MidjourneyResponse{
@@ -119,57 +114,14 @@ func RelayMidjourneyNotify(c *gin.Context) *dto.MidjourneyResponse {
 			Description: "update_midjourney_task_failed",
 		}
 	}
-
-	return nil
-}
-
-func coverMidjourneyTaskDto(c *gin.Context, originTask *model.Midjourney) (midjourneyTask dto.MidjourneyDto) {
-	midjourneyTask.MjId = originTask.MjId
-	midjourneyTask.Progress = originTask.Progress
-	midjourneyTask.PromptEn = originTask.PromptEn
-	midjourneyTask.State = originTask.State
-	midjourneyTask.SubmitTime = originTask.SubmitTime
-	midjourneyTask.StartTime = originTask.StartTime
-	midjourneyTask.FinishTime = originTask.FinishTime
-	midjourneyTask.ImageUrl = ""
-	if originTask.ImageUrl != "" && setting.MjForwardUrlEnabled {
-		midjourneyTask.ImageUrl = system_setting.ServerAddress + "/mj/image/" + originTask.MjId
-		if originTask.Status != "SUCCESS" {
-			midjourneyTask.ImageUrl += "?rand=" + strconv.FormatInt(time.Now().UnixNano(), 10)
-		}
-	} else {
-		midjourneyTask.ImageUrl = originTask.ImageUrl
-	}
-	if originTask.VideoUrl != "" {
-		midjourneyTask.VideoUrl = originTask.VideoUrl
-	}
-	midjourneyTask.Status = originTask.Status
-	midjourneyTask.FailReason = originTask.FailReason
-	midjourneyTask.Action = originTask.Action
-	midjourneyTask.Description = originTask.Description
-	midjourneyTask.Prompt = originTask.Prompt
-	if originTask.Buttons != "" {
-		var buttons []dto.ActionButton
-		err := json.Unmarshal([]byte(originTask.Buttons), &buttons)
-		if err == nil {
-			midjourneyTask.Buttons = buttons
-		}
-	}
-	if originTask.VideoUrls != "" {
-		var videoUrls []dto.ImgUrls
-		err := json.Unmarshal([]byte(originTask.VideoUrls), &videoUrls)
-		if err == nil {
-			midjourneyTask.VideoUrls = videoUrls
+	// 任务状态变更为成功或失败时触发回调
+	if (midjourneyTask.Status == model.TaskStatusSuccess || midjourneyTask.Status == model.TaskStatusFailure) && preStatus != midjourneyTask.Status {
+		if midjourneyTask.CallBackUrl != "" && midjourneyTask.CallBackStatus != service.CallbackStatusSuccess {
+			service.TriggerTaskCallback(midjourneyTask)
 		}
 	}
-	if originTask.Properties != "" {
-		var properties dto.Properties
-		err := json.Unmarshal([]byte(originTask.Properties), &properties)
-		if err == nil {
-			midjourneyTask.Properties = &properties
-		}
-	}
-	return
+
+	return nil
 }
 
 func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyResponse {
@@ -253,7 +205,7 @@ func RelaySwapFace(c *gin.Context, info *relaycommon.RelayInfo) *dto.MidjourneyR
 		ChannelId:   c.GetInt("channel_id"),
 		Quota:       priceData.Quota,
 	}
-	err = midjourneyTask.Insert()
+	err = insertMidjourneyTask(info, midjourneyTask, "")
 	if err != nil {
 		return service.MidjourneyErrorWrapper(constant.MjRequestError, "insert_midjourney_task_failed")
 	}
@@ -316,7 +268,7 @@ func RelayMidjourneyTask(c *gin.Context, relayMode int) *dto.MidjourneyResponse
 				Description: "task_no_found",
 			}
 		}
-		midjourneyTask := coverMidjourneyTaskDto(c, originTask)
+		midjourneyTask := service.CoverMidjourneyTaskDto(originTask.ToMidjourney())
 		respBody, err = json.Marshal(midjourneyTask)
 		if err != nil {
 			return &dto.MidjourneyResponse{
@@ -339,7 +291,7 @@ func RelayMidjourneyTask(c *gin.Context, relayMode int) *dto.MidjourneyResponse
 		if len(condition.IDs) != 0 {
 			originTasks := model.GetByMJIds(userId, condition.IDs)
 			for _, originTask := range originTasks {
-				midjourneyTask := coverMidjourneyTaskDto(c, originTask)
+				midjourneyTask := service.CoverMidjourneyTaskDto(originTask.ToMidjourney())
 				tasks = append(tasks, midjourneyTask)
 			}
 		}
@@ -446,7 +398,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 			return service.MidjourneyErrorWrapper(constant.MjRequestError, "task_not_found")
 		} else { //原任务的Status=SUCCESS，则可以做放大UPSCALE、变换VARIATION等动作，此时必须使用原来的请求地址才能正确处理
 			if setting.MjActionCheckSuccessEnabled {
-				if originTask.Status != "SUCCESS" && relayInfo.RelayMode != relayconstant.RelayModeMidjourneyModal {
+				if originTask.Status != model.TaskStatusSuccess && relayInfo.RelayMode != relayconstant.RelayModeMidjourneyModal {
 					return service.MidjourneyErrorWrapper(constant.MjRequestError, "task_status_not_success")
 				}
 			}
@@ -462,7 +414,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 			c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
 			log.Printf("检测到此操作为放大、变换、重绘，获取原channel信息: %s,%s", strconv.Itoa(originTask.ChannelId), channel.GetBaseURL())
 		}
-		midjRequest.Prompt = originTask.Prompt
+		midjRequest.Prompt = originTask.GetMidjourneyData().Prompt
 
 		//if channelType == common.ChannelTypeMidjourneyPlus {
 		//	// plus
@@ -573,6 +525,8 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 	if midjResponse.Code != 1 && midjResponse.Code != 21 && midjResponse.Code != 22 {
 		//非1-提交成功,21-任务已存在和22-排队中，则记录错误原因
 		midjourneyTask.FailReason = midjResponse.Description
+		midjourneyTask.Status = "FAILURE"
+		midjourneyTask.Progress = "100%"
 		consumeQuota = false
 	}
 
@@ -603,7 +557,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 		midjourneyTask.Progress = "100%"
 		midjourneyTask.Status = "SUCCESS"
 	}
-	err = midjourneyTask.Insert()
+	err = insertMidjourneyTask(relayInfo, midjourneyTask, midjRequest.NotifyHook)
 	if err != nil {
 		return &dto.MidjourneyResponse{
 			Code:        4,
@@ -641,6 +595,19 @@ func RelayMidjourneySubmit(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dt
 	return nil
 }
 
+// insertMidjourneyTask 以统一任务记录保存 Midjourney 任务。
+// 未开启 notifyHook 透传时，由网关在任务完成后向 notifyHook 发送 Midjourney 格式的任务回调
+func insertMidjourneyTask(info *relaycommon.RelayInfo, midjourneyTask *model.Midjourney, notifyHook string) error {
+	task := model.NewMidjourneyTask(midjourneyTask)
+	task.Group = info.UsingGroup
+	task.Properties.OriginModelName = service.CoverActionToModelName(midjourneyTask.Action)
+	if notifyHook != "" && !setting.MjNotifyEnabled && task.Progress != "100%" {
+		task.CallBackUrl = notifyHook
+		task.CallBackStatus = service.CallbackStatusPending
+	}
+	return task.Insert()
+}
+
 type taskChangeParams struct {
 	ID     string
 	Action string
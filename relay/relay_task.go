@@ -315,6 +315,8 @@ func RelayTaskFetch(c *gin.Context, relayMode int) (taskResp *dto.TaskError) {
 		respBody = []byte("{\"code\":\"success\",\"data\":null}")
 	}
 
+	respBody = service.SignMediaURLsInBody(respBody)
+
 	c.Writer.Header().Set("Content-Type", "application/json")
 	_, err := io.Copy(c.Writer, bytes.NewBuffer(respBody))
 	if err != nil {
@@ -430,7 +432,7 @@ func videoFetchByIDRespBodyBuilder(c *gin.Context) (respBody []byte, taskResp *d
 			if ti.Progress != "" {
 				originTask.Progress = ti.Progress
 			}
-			if ti.Url != "" {
+			if ti.Url != "" && !service.IsMediaURL(originTask.FailReason) {
 				if strings.HasPrefix(ti.Url, "data:") {
 				} else {
 					originTask.FailReason = ti.Url
@@ -17,6 +17,7 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.POST("/setup", controller.PostSetup)
 		apiRouter.GET("/status", controller.GetStatus)
 		apiRouter.GET("/uptime/status", controller.GetUptimeKumaStatus)
+		apiRouter.GET("/status/models", controller.GetPublicModelStatus)
 		apiRouter.GET("/models", middleware.UserAuth(), controller.DashboardListModels)
 		apiRouter.GET("/status/test", middleware.AdminAuth(), controller.TestStatus)
 		apiRouter.GET("/notice", controller.GetNotice)
@@ -143,8 +144,17 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/:id/key", middleware.RootAuth(), middleware.CriticalRateLimit(), middleware.DisableCache(), middleware.SecureVerificationRequired(), controller.GetChannelKey)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
+			channelRoute.POST("/test/matrix", controller.TestChannelMatrix)
+			channelRoute.POST("/script/test", controller.TestChannelScript)
+			channelRoute.GET("/test/:id/results", controller.GetChannelTestResults)
+			channelRoute.GET("/:id/status", controller.GetChannelStatus)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
 			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
+			channelRoute.GET("/:id/balance", controller.GetChannelBalanceHistory)
+			channelRoute.POST("/override/test", controller.TestChannelOverride)
+			channelRoute.GET("/:id/config_versions", controller.GetChannelConfigVersions)
+			channelRoute.GET("/:id/config_versions/diff", controller.DiffChannelConfigVersions)
+			channelRoute.POST("/:id/config_versions/:version/rollback", controller.RollbackChannelConfigVersion)
 			channelRoute.POST("/", controller.AddChannel)
 			channelRoute.PUT("/", controller.UpdateChannel)
 			channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
@@ -169,7 +179,7 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/batch/tag", controller.BatchSetChannelTag)
 			channelRoute.GET("/tag/models", controller.GetTagModels)
 			channelRoute.POST("/copy/:id", controller.CopyChannel)
-			channelRoute.POST("/multi_key/manage", controller.ManageMultiKeys)
+			channelRoute.POST("/multi_key/manage", middleware.OptionalSecureVerification(), controller.ManageMultiKeys)
 		}
 		tokenRoute := apiRouter.Group("/token")
 		tokenRoute.Use(middleware.UserAuth())
@@ -216,6 +226,28 @@ func SetApiRouter(router *gin.Engine) {
 		dataRoute := apiRouter.Group("/data")
 		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
 		dataRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaDates)
+		dataRoute.GET("/channel_cost", middleware.AdminAuth(), controller.GetChannelCostReport)
+
+		subscriptionRoute := apiRouter.Group("/subscription")
+		{
+			subscriptionRoute.GET("/plans", middleware.UserAuth(), controller.GetEnabledSubscriptionPlans)
+			subscriptionRoute.GET("/self", middleware.UserAuth(), controller.GetSelfSubscription)
+			subscriptionRoute.POST("/self/cancel", middleware.UserAuth(), controller.CancelSelfSubscription)
+			subscriptionRoute.POST("/stripe/pay", middleware.UserAuth(), middleware.CriticalRateLimit(), controller.RequestSubscriptionStripePay)
+
+			subscriptionAdminRoute := subscriptionRoute.Group("/")
+			subscriptionAdminRoute.Use(middleware.AdminAuth())
+			{
+				subscriptionAdminRoute.GET("/plan", controller.GetSubscriptionPlans)
+				subscriptionAdminRoute.POST("/plan", controller.CreateSubscriptionPlan)
+				subscriptionAdminRoute.PUT("/plan", controller.UpdateSubscriptionPlan)
+				subscriptionAdminRoute.DELETE("/plan/:id", controller.DeleteSubscriptionPlan)
+				subscriptionAdminRoute.GET("/", controller.GetAllUserSubscriptions)
+				subscriptionAdminRoute.POST("/grant", controller.GrantUserSubscription)
+				subscriptionAdminRoute.DELETE("/:id", controller.AdminCancelUserSubscription)
+			}
+			subscriptionRoute.POST("/webhook/test", middleware.RootAuth(), controller.TestSubscriptionWebhook)
+		}
 
 		logRoute.Use(middleware.CORS())
 		{
@@ -236,14 +268,72 @@ func SetApiRouter(router *gin.Engine) {
 			prefillGroupRoute.DELETE("/:id", controller.DeletePrefillGroup)
 		}
 
+		routingRoute := apiRouter.Group("/routing")
+		routingRoute.Use(middleware.AdminAuth())
+		{
+			routingRoute.POST("/explain", controller.ExplainRouting)
+		}
+
+		channelPoolRoute := apiRouter.Group("/channel_pool")
+		channelPoolRoute.Use(middleware.AdminAuth())
+		{
+			channelPoolRoute.GET("/", controller.GetChannelPools)
+			channelPoolRoute.GET("/:id", controller.GetChannelPool)
+			channelPoolRoute.POST("/", controller.CreateChannelPool)
+			channelPoolRoute.PUT("/", controller.UpdateChannelPool)
+			channelPoolRoute.DELETE("/:id", controller.DeleteChannelPool)
+		}
+
+		virtualModelRoute := apiRouter.Group("/virtual_model")
+		virtualModelRoute.Use(middleware.AdminAuth())
+		{
+			virtualModelRoute.GET("/", controller.GetVirtualModels)
+			virtualModelRoute.POST("/", controller.CreateVirtualModel)
+			virtualModelRoute.PUT("/", controller.UpdateVirtualModel)
+			virtualModelRoute.DELETE("/:id", controller.DeleteVirtualModel)
+		}
+
 		mjRoute := apiRouter.Group("/mj")
 		mjRoute.GET("/self", middleware.UserAuth(), controller.GetUserMidjourney)
 		mjRoute.GET("/", middleware.AdminAuth(), controller.GetAllMidjourney)
+		mjRoute.GET("/legacy", middleware.AdminAuth(), controller.GetMidjourneyLegacyStatus)
+		mjRoute.DELETE("/legacy", middleware.RootAuth(), controller.DropMidjourneyLegacyTable)
 
 		taskRoute := apiRouter.Group("/task")
 		{
 			taskRoute.GET("/self", middleware.UserAuth(), controller.GetUserTask)
 			taskRoute.GET("/", middleware.AdminAuth(), controller.GetAllTask)
+			taskRoute.GET("/queue", middleware.AdminAuth(), controller.GetTaskQueueStats)
+		}
+
+		webhookRoute := apiRouter.Group("/webhook")
+		{
+			webhookRoute.GET("/self/endpoint", middleware.UserAuth(), controller.GetSelfWebhookEndpoints)
+			webhookRoute.PUT("/self/endpoint/:id/status", middleware.UserAuth(), controller.UpdateSelfWebhookEndpointStatus)
+			webhookRoute.GET("/self/delivery", middleware.UserAuth(), controller.GetSelfWebhookDeliveries)
+			webhookRoute.POST("/self/delivery/:id/replay", middleware.UserAuth(), controller.ReplaySelfWebhookDelivery)
+			webhookRoute.GET("/endpoint", middleware.AdminAuth(), controller.GetAllWebhookEndpoints)
+			webhookRoute.PUT("/endpoint/:id/status", middleware.AdminAuth(), controller.UpdateWebhookEndpointStatus)
+			webhookRoute.GET("/delivery", middleware.AdminAuth(), controller.GetAllWebhookDeliveries)
+			webhookRoute.POST("/delivery/:id/replay", middleware.AdminAuth(), controller.ReplayWebhookDelivery)
+		}
+
+		mediaRoute := apiRouter.Group("/media")
+		{
+			mediaRoute.GET("/self", middleware.UserAuth(), controller.GetSelfMediaObjects)
+			mediaRoute.GET("/self/usage", middleware.UserAuth(), controller.GetSelfMediaUsage)
+			mediaRoute.DELETE("/self/:id", middleware.UserAuth(), controller.DeleteSelfMediaObject)
+			mediaRoute.GET("/", middleware.AdminAuth(), controller.GetAllMediaObjects)
+			mediaRoute.DELETE("/:id", middleware.AdminAuth(), controller.DeleteMediaObject)
+			mediaRoute.POST("/cleanup", middleware.AdminAuth(), controller.CleanupMediaObjects)
+		}
+
+		eventBusRoute := apiRouter.Group("/event_bus")
+		eventBusRoute.Use(middleware.AdminAuth())
+		{
+			eventBusRoute.GET("/status", controller.GetEventBusStatus)
+			eventBusRoute.POST("/test", controller.TestEventBus)
+			eventBusRoute.POST("/sink/:sink/retry", controller.RetryEventSinkDeliveries)
 		}
 
 		vendorRoute := apiRouter.Group("/vendors")
@@ -14,6 +14,8 @@ func SetRelayRouter(router *gin.Engine) {
 	router.Use(middleware.CORS())
 	router.Use(middleware.DecompressRequestMiddleware())
 	router.Use(middleware.StatsMiddleware())
+	// 持久化媒体的签名下载链接
+	router.GET("/media/:key", controller.GetMediaObject)
 	// https://platform.openai.com/docs/api-reference/introduction
 	modelsRouter := router.Group("/v1/models")
 	modelsRouter.Use(middleware.TokenAuth())
@@ -55,6 +57,15 @@ func SetRelayRouter(router *gin.Engine) {
 		})
 	}
 
+	// 网关侧保存的 Responses API 响应（需开启 response_store_setting）
+	storedResponsesRouter := router.Group("/v1/responses")
+	storedResponsesRouter.Use(middleware.TokenAuth())
+	{
+		storedResponsesRouter.GET("/:id", controller.GetStoredResponse)
+		storedResponsesRouter.GET("/:id/input_items", controller.GetStoredResponseInputItems)
+		storedResponsesRouter.DELETE("/:id", controller.DeleteStoredResponse)
+	}
+
 	playgroundRouter := router.Group("/pg")
 	playgroundRouter.Use(middleware.UserAuth(), middleware.Distribute())
 	{
@@ -120,6 +131,7 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/audio/speech", func(c *gin.Context) {
 			controller.Relay(c, types.RelayFormatOpenAIAudio)
 		})
+		httpRouter.POST("/audio/chat", controller.RelaySpeechPipeline)
 
 		// rerank related routes
 		httpRouter.POST("/rerank", func(c *gin.Context) {
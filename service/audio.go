@@ -2,6 +2,7 @@ package service
 
 import (
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"strings"
 )
@@ -46,3 +47,169 @@ func DecodeBase64AudioData(audioBase64 string) (string, error) {
 
 	return audioBase64, nil
 }
+
+// AudioSampleRate 返回 realtime 音频格式对应的采样率
+func AudioSampleRate(format string) int {
+	switch format {
+	case "g711_ulaw", "g711_alaw":
+		return 8000
+	default:
+		return 24000
+	}
+}
+
+// DecodeRealtimeAudio 将 realtime 音频（pcm16 / g711）解码为 16 位小端 PCM
+func DecodeRealtimeAudio(data []byte, format string) []byte {
+	switch format {
+	case "g711_ulaw", "g711_alaw":
+		pcm := make([]byte, len(data)*2)
+		for i, b := range data {
+			var sample int16
+			if format == "g711_ulaw" {
+				sample = ulawToLinear(b)
+			} else {
+				sample = alawToLinear(b)
+			}
+			binary.LittleEndian.PutUint16(pcm[i*2:], uint16(sample))
+		}
+		return pcm
+	default:
+		return data
+	}
+}
+
+// EncodeRealtimeAudio 将 16 位小端 PCM 编码为指定的 realtime 音频格式
+func EncodeRealtimeAudio(pcm []byte, format string) []byte {
+	switch format {
+	case "g711_ulaw", "g711_alaw":
+		out := make([]byte, len(pcm)/2)
+		for i := range out {
+			sample := int16(binary.LittleEndian.Uint16(pcm[i*2:]))
+			if format == "g711_ulaw" {
+				out[i] = linearToUlaw(sample)
+			} else {
+				out[i] = linearToAlaw(sample)
+			}
+		}
+		return out
+	default:
+		return pcm
+	}
+}
+
+// ResamplePCM16 对 16 位小端单声道 PCM 做线性插值重采样
+func ResamplePCM16(pcm []byte, fromRate, toRate int) []byte {
+	if fromRate == toRate || fromRate <= 0 || toRate <= 0 || len(pcm) < 2 {
+		return pcm
+	}
+	inSamples := len(pcm) / 2
+	outSamples := int(int64(inSamples) * int64(toRate) / int64(fromRate))
+	out := make([]byte, outSamples*2)
+	sampleAt := func(i int) float64 {
+		if i >= inSamples {
+			i = inSamples - 1
+		}
+		return float64(int16(binary.LittleEndian.Uint16(pcm[i*2:])))
+	}
+	step := float64(fromRate) / float64(toRate)
+	for i := 0; i < outSamples; i++ {
+		pos := float64(i) * step
+		idx := int(pos)
+		frac := pos - float64(idx)
+		v := sampleAt(idx)*(1-frac) + sampleAt(idx+1)*frac
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(int16(v)))
+	}
+	return out
+}
+
+func ulawToLinear(u byte) int16 {
+	u = ^u
+	t := (int(u&0x0F) << 3) + 0x84
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(0x84 - t)
+	}
+	return int16(t - 0x84)
+}
+
+func linearToUlaw(sample int16) byte {
+	const bias, clip = 0x84, 32635
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		sign = 0x80
+		s = -s
+	}
+	if s > clip {
+		s = clip
+	}
+	s += bias
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0F
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+func alawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0x0F) << 4
+	seg := int(a&0x70) >> 4
+	switch seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
+
+func linearToAlaw(sample int16) byte {
+	pcm := int(sample) >> 3
+	mask := 0xD5
+	if pcm < 0 {
+		mask = 0x55
+		pcm = -pcm - 1
+	}
+	segEnd := [8]int{0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF}
+	seg := 0
+	for seg < 8 && pcm > segEnd[seg] {
+		seg++
+	}
+	if seg >= 8 {
+		return byte(0x7F ^ mask)
+	}
+	aval := seg << 4
+	if seg < 2 {
+		aval |= (pcm >> 1) & 0x0F
+	} else {
+		aval |= (pcm >> seg) & 0x0F
+	}
+	return byte(aval ^ mask)
+}
+
+// PCM16ToWav 为 16 位小端单声道 PCM 添加 WAV 文件头
+func PCM16ToWav(pcm []byte, sampleRate int) []byte {
+	wav := make([]byte, 44+len(pcm))
+	copy(wav[0:], "RIFF")
+	binary.LittleEndian.PutUint32(wav[4:], uint32(36+len(pcm)))
+	copy(wav[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(wav[16:], 16)
+	binary.LittleEndian.PutUint16(wav[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(wav[22:], 1) // mono
+	binary.LittleEndian.PutUint32(wav[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(wav[28:], uint32(sampleRate*2))
+	binary.LittleEndian.PutUint16(wav[32:], 2)
+	binary.LittleEndian.PutUint16(wav[34:], 16)
+	copy(wav[36:], "data")
+	binary.LittleEndian.PutUint32(wav[40:], uint32(len(pcm)))
+	copy(wav[44:], pcm)
+	return wav
+}
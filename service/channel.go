@@ -29,18 +29,65 @@ func DisableChannel(channelError types.ChannelError, reason string) {
 
 	success := model.UpdateChannelStatus(channelError.ChannelId, channelError.UsingKey, common.ChannelStatusAutoDisabled, reason)
 	if success {
+		recordChannelDisableIncident(channelError.ChannelId, channelKeyIndex(channelError.ChannelId, channelError.UsingKey), reason)
 		subject := fmt.Sprintf("通道「%s」（#%d）已被禁用", channelError.ChannelName, channelError.ChannelId)
 		content := fmt.Sprintf("通道「%s」（#%d）已被禁用，原因：%s", channelError.ChannelName, channelError.ChannelId, reason)
 		NotifyRootUser(formatNotifyType(channelError.ChannelId, common.ChannelStatusAutoDisabled), subject, content)
+		model.PublishEvent(model.EventTypeChannelDisabled, 0, map[string]any{
+			"channel_id":   channelError.ChannelId,
+			"channel_name": channelError.ChannelName,
+			"reason":       reason,
+		})
 	}
 }
 
 func EnableChannel(channelId int, usingKey string, channelName string) {
 	success := model.UpdateChannelStatus(channelId, usingKey, common.ChannelStatusEnabled, "")
 	if success {
+		resolveChannelEnableIncidents(channelId, channelKeyIndex(channelId, usingKey))
 		subject := fmt.Sprintf("通道「%s」（#%d）已被启用", channelName, channelId)
 		content := fmt.Sprintf("通道「%s」（#%d）已被启用", channelName, channelId)
 		NotifyRootUser(formatNotifyType(channelId, common.ChannelStatusEnabled), subject, content)
+		model.PublishEvent(model.EventTypeChannelEnabled, 0, map[string]any{
+			"channel_id":   channelId,
+			"channel_name": channelName,
+		})
+	}
+}
+
+// DisableChannelKey 禁用多 Key 渠道中指定索引的 key，所有 key 均被禁用时渠道随之禁用
+func DisableChannelKey(channelError types.ChannelError, keyIndex int, reason string) {
+	if !channelError.AutoBan {
+		return
+	}
+	common.SysLog(fmt.Sprintf("通道「%s」（#%d）的 key #%d 发生错误，准备禁用，原因：%s", channelError.ChannelName, channelError.ChannelId, keyIndex, reason))
+	success := model.UpdateChannelKeyStatus(channelError.ChannelId, keyIndex, common.ChannelStatusAutoDisabled, reason)
+	if success {
+		recordChannelDisableIncident(channelError.ChannelId, keyIndex, reason)
+		subject := fmt.Sprintf("通道「%s」（#%d）的 key #%d 已被禁用", channelError.ChannelName, channelError.ChannelId, keyIndex)
+		content := fmt.Sprintf("通道「%s」（#%d）的 key #%d 已被禁用，原因：%s", channelError.ChannelName, channelError.ChannelId, keyIndex, reason)
+		NotifyRootUser(formatNotifyType(channelError.ChannelId, common.ChannelStatusAutoDisabled), subject, content)
+		model.PublishEvent(model.EventTypeChannelDisabled, 0, map[string]any{
+			"channel_id":   channelError.ChannelId,
+			"channel_name": channelError.ChannelName,
+			"key_index":    keyIndex,
+			"reason":       reason,
+		})
+	}
+}
+
+// EnableChannelKey 启用多 Key 渠道中指定索引的 key
+func EnableChannelKey(channelId int, keyIndex int, channelName string) {
+	success := model.UpdateChannelKeyStatus(channelId, keyIndex, common.ChannelStatusEnabled, "")
+	if success {
+		resolveChannelEnableIncidents(channelId, keyIndex)
+		subject := fmt.Sprintf("通道「%s」（#%d）的 key #%d 已被启用", channelName, channelId, keyIndex)
+		NotifyRootUser(formatNotifyType(channelId, common.ChannelStatusEnabled), subject, subject)
+		model.PublishEvent(model.EventTypeChannelEnabled, 0, map[string]any{
+			"channel_id":   channelId,
+			"channel_name": channelName,
+			"key_index":    keyIndex,
+		})
 	}
 }
 
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// ChannelBalanceInfo 一次余额查询的结果
+type ChannelBalanceInfo struct {
+	Balance     *float64 `json:"balance"`            // 余额，单位为上游的计费币种；只有用量窗口的渠道（如 Codex）为 null
+	UsedPercent *float64 `json:"used_percent"`       // 用量窗口中已用比例最高的一个（0-100）
+	ResetAt     int64    `json:"reset_at,omitempty"` // 该用量窗口的重置时间
+}
+
+// ChannelBalanceFetcher 按渠道类型实现的余额查询
+type ChannelBalanceFetcher interface {
+	FetchBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error)
+}
+
+type ChannelBalanceFetcherFunc func(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error)
+
+func (f ChannelBalanceFetcherFunc) FetchBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	return f(ctx, channel)
+}
+
+var channelBalanceFetchers = make(map[int]ChannelBalanceFetcher)
+
+// RegisterChannelBalanceFetcher 注册渠道类型的余额查询实现，重复注册时覆盖
+func RegisterChannelBalanceFetcher(channelType int, fetcher ChannelBalanceFetcher) {
+	channelBalanceFetchers[channelType] = fetcher
+}
+
+func GetChannelBalanceFetcher(channelType int) (ChannelBalanceFetcher, bool) {
+	fetcher, ok := channelBalanceFetchers[channelType]
+	return fetcher, ok
+}
+
+// UpdateChannelBalance 查询渠道余额，更新渠道余额并记录历史；开启余额监控时检查告警条件
+func UpdateChannelBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	if channel.ChannelInfo.IsMultiKey {
+		return nil, errors.New("多密钥渠道不支持余额查询")
+	}
+	fetcher, ok := GetChannelBalanceFetcher(channel.Type)
+	if !ok {
+		return nil, errors.New("尚未实现")
+	}
+	info, err := fetcher.FetchBalance(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	if info.Balance != nil {
+		channel.UpdateBalance(*info.Balance)
+	}
+	err = model.RecordChannelBalance(&model.ChannelBalanceRecord{
+		ChannelId:   channel.Id,
+		Balance:     info.Balance,
+		UsedPercent: info.UsedPercent,
+		ResetAt:     info.ResetAt,
+		CreatedAt:   common.GetTimestamp(),
+	})
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to record balance: channel_id=%d, error=%v", channel.Id, err))
+	}
+	if operation_setting.GetBalanceMonitorSetting().Enabled {
+		checkChannelBalanceAlert(channel, info)
+	}
+	return info, nil
+}
+
+// UpdateAllChannelsBalance 查询所有已启用渠道的余额，余额耗尽的渠道自动禁用
+func UpdateAllChannelsBalance() error {
+	channels, err := model.GetAllChannels(0, 0, true, false)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if channel.Status != common.ChannelStatusEnabled || channel.ChannelInfo.IsMultiKey {
+			continue
+		}
+		if _, ok := GetChannelBalanceFetcher(channel.Type); !ok {
+			continue
+		}
+		info, err := UpdateChannelBalance(context.Background(), channel)
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to update balance: channel_id=%d, error=%v", channel.Id, err))
+		} else if info.Balance != nil && *info.Balance <= 0 {
+			// err is nil & balance <= 0 means quota is used up
+			DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, "", channel.GetAutoBan()), "余额不足")
+		}
+		time.Sleep(common.RequestInterval)
+	}
+	return nil
+}
+
+// ChannelBalanceProjection 按近期消耗预测的余额耗尽时间
+type ChannelBalanceProjection struct {
+	DailySpend  float64 `json:"daily_spend"`  // 近期平均每天的消耗
+	DepletionAt int64   `json:"depletion_at"` // 预计耗尽时间，无法预测（无消耗或数据不足）时为 0
+}
+
+// 用于预测的余额历史时长
+const balanceProjectionSeconds = 7 * 86400
+
+// projectChannelBalance 根据按时间排序的余额历史预测耗尽时间，余额上升（充值）的区间不计入消耗
+func projectChannelBalance(records []*model.ChannelBalanceRecord) *ChannelBalanceProjection {
+	var first, last *model.ChannelBalanceRecord
+	var spent float64
+	for _, record := range records {
+		if record.Balance == nil {
+			continue
+		}
+		if first == nil {
+			first = record
+		} else if *record.Balance < *last.Balance {
+			spent += *last.Balance - *record.Balance
+		}
+		last = record
+	}
+	projection := &ChannelBalanceProjection{}
+	if first == nil || last.CreatedAt-first.CreatedAt < 3600 || spent <= 0 {
+		return projection
+	}
+	elapsed := float64(last.CreatedAt - first.CreatedAt)
+	projection.DailySpend = spent / elapsed * 86400
+	projection.DepletionAt = last.CreatedAt
+	if *last.Balance > 0 {
+		projection.DepletionAt += int64(*last.Balance / spent * elapsed)
+	}
+	return projection
+}
+
+// GetChannelBalanceProjection 查询渠道近期的余额历史并预测耗尽时间
+func GetChannelBalanceProjection(channelId int) (*ChannelBalanceProjection, error) {
+	records, err := model.GetChannelBalanceRecords(channelId, common.GetTimestamp()-balanceProjectionSeconds)
+	if err != nil {
+		return nil, err
+	}
+	return projectChannelBalance(records), nil
+}
+
+// 同一渠道持续处于告警状态时，每天最多通知一次
+const balanceAlertIntervalSeconds = 86400
+
+var (
+	balanceAlertLock  sync.Mutex
+	balanceAlertTimes = make(map[int]int64)
+)
+
+func checkChannelBalanceAlert(channel *model.Channel, info *ChannelBalanceInfo) {
+	setting := operation_setting.GetBalanceMonitorSetting()
+	var reasons []string
+	if info.Balance != nil {
+		if setting.AlertBalance > 0 && *info.Balance < setting.AlertBalance {
+			reasons = append(reasons, fmt.Sprintf("余额 %.2f 低于告警阈值 %.2f", *info.Balance, setting.AlertBalance))
+		}
+		if setting.AlertDays > 0 {
+			projection, err := GetChannelBalanceProjection(channel.Id)
+			if err == nil && projection.DepletionAt > 0 && projection.DepletionAt-common.GetTimestamp() < int64(setting.AlertDays)*86400 {
+				reasons = append(reasons, fmt.Sprintf("按近期每天 %.2f 的消耗，预计于 %s 耗尽", projection.DailySpend, time.Unix(projection.DepletionAt, 0).Format("2006-01-02 15:04")))
+			}
+		}
+	}
+	if info.UsedPercent != nil && setting.AlertUsedPercent > 0 && *info.UsedPercent >= setting.AlertUsedPercent {
+		reason := fmt.Sprintf("用量窗口已使用 %.0f%%", *info.UsedPercent)
+		if info.ResetAt > 0 {
+			reason += fmt.Sprintf("，将于 %s 重置", time.Unix(info.ResetAt, 0).Format("2006-01-02 15:04"))
+		}
+		reasons = append(reasons, reason)
+	}
+
+	balanceAlertLock.Lock()
+	if len(reasons) == 0 {
+		delete(balanceAlertTimes, channel.Id)
+		balanceAlertLock.Unlock()
+		return
+	}
+	now := common.GetTimestamp()
+	if now-balanceAlertTimes[channel.Id] < balanceAlertIntervalSeconds {
+		balanceAlertLock.Unlock()
+		return
+	}
+	balanceAlertTimes[channel.Id] = now
+	balanceAlertLock.Unlock()
+
+	subject := fmt.Sprintf("通道「%s」（#%d）余额不足", channel.Name, channel.Id)
+	content := fmt.Sprintf("通道「%s」（#%d）：%s", channel.Name, channel.Id, strings.Join(reasons, "；"))
+	NotifyRootUser(dto.NotifyTypeChannelBalance, subject, content)
+}
+
+// StartChannelBalanceMonitorTask 主节点按配置的间隔查询所有渠道余额，并清理过期的余额历史
+func StartChannelBalanceMonitorTask() {
+	if !common.IsMasterNode {
+		return
+	}
+	var lastRun int64
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		setting := operation_setting.GetBalanceMonitorSetting()
+		now := common.GetTimestamp()
+		if !setting.Enabled || now-lastRun < int64(max(setting.IntervalMinutes, 1))*60 {
+			continue
+		}
+		lastRun = now
+		if err := UpdateAllChannelsBalance(); err != nil {
+			common.SysLog("failed to update channel balances: " + err.Error())
+		}
+		if setting.HistoryDays > 0 {
+			if _, err := model.DeleteChannelBalanceRecordsBefore(now - int64(setting.HistoryDays)*86400); err != nil {
+				common.SysLog("failed to delete balance history: " + err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/shopspring/decimal"
+)
+
+func init() {
+	RegisterChannelBalanceFetcher(constant.ChannelTypeOpenAI, ChannelBalanceFetcherFunc(fetchOpenAIBalance))
+	RegisterChannelBalanceFetcher(constant.ChannelTypeCustom, ChannelBalanceFetcherFunc(fetchOpenAIDashboardBalance))
+	RegisterChannelBalanceFetcher(constant.ChannelTypeAnthropic, ChannelBalanceFetcherFunc(fetchAnthropicAdminBalance))
+	RegisterChannelBalanceFetcher(constant.ChannelTypeCodex, ChannelBalanceFetcherFunc(fetchCodexUsageWindows))
+	RegisterChannelBalanceFetcher(constant.ChannelTypeAIProxy, ChannelBalanceFetcherFunc(fetchAIProxyBalance))
+	RegisterChannelBalanceFetcher(constant.ChannelTypeAPI2GPT, ChannelBalanceFetcherFunc(fetchAPI2GPTBalance))
+	RegisterChannelBalanceFetcher(constant.ChannelTypeAIGC2D, ChannelBalanceFetcherFunc(fetchAIGC2DBalance))
+	RegisterChannelBalanceFetcher(constant.ChannelTypeSiliconFlow, ChannelBalanceFetcherFunc(fetchSiliconFlowBalance))
+	RegisterChannelBalanceFetcher(constant.ChannelTypeDeepSeek, ChannelBalanceFetcherFunc(fetchDeepSeekBalance))
+	RegisterChannelBalanceFetcher(constant.ChannelTypeOpenRouter, ChannelBalanceFetcherFunc(fetchOpenRouterBalance))
+	RegisterChannelBalanceFetcher(constant.ChannelTypeMoonshot, ChannelBalanceFetcherFunc(fetchMoonshotBalance))
+}
+
+func balanceAuthHeader(token string) http.Header {
+	h := http.Header{}
+	h.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	return h
+}
+
+func getBalanceResponseBody(ctx context.Context, requestURL string, channel *model.Channel, headers http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k := range headers {
+		req.Header.Add(k, headers.Get(k))
+	}
+	client, err := NewProxyHttpClient(channel.GetSetting().Proxy)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", res.StatusCode)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func newBalanceInfo(balance float64) *ChannelBalanceInfo {
+	return &ChannelBalanceInfo{Balance: &balance}
+}
+
+// monthStart 本月第一天 0 点（UTC），组织用量接口按 UTC 天分桶
+func monthStart() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// fetchOpenAIBalance 配置了 Admin Key 时通过组织用量接口计算余额，否则使用旧版 dashboard 接口（多用于兼容 OpenAI 的中转站）
+func fetchOpenAIBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	if channel.GetOtherSettings().BalanceAdminKey != "" {
+		return fetchOpenAIAdminBalance(ctx, channel)
+	}
+	return fetchOpenAIDashboardBalance(ctx, channel)
+}
+
+func fetchOpenAIDashboardBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = constant.ChannelBaseURLs[channel.Type]
+	}
+	body, err := getBalanceResponseBody(ctx, fmt.Sprintf("%s/v1/dashboard/billing/subscription", baseURL), channel, balanceAuthHeader(channel.Key))
+	if err != nil {
+		return nil, err
+	}
+	var subscription struct {
+		HasPaymentMethod bool    `json:"has_payment_method"`
+		HardLimitUSD     float64 `json:"hard_limit_usd"`
+	}
+	if err = common.Unmarshal(body, &subscription); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	startDate := fmt.Sprintf("%s-01", now.Format("2006-01"))
+	endDate := now.Format("2006-01-02")
+	if !subscription.HasPaymentMethod {
+		startDate = now.AddDate(0, 0, -100).Format("2006-01-02")
+	}
+	usageURL := fmt.Sprintf("%s/v1/dashboard/billing/usage?start_date=%s&end_date=%s", baseURL, startDate, endDate)
+	body, err = getBalanceResponseBody(ctx, usageURL, channel, balanceAuthHeader(channel.Key))
+	if err != nil {
+		return nil, err
+	}
+	var usage struct {
+		TotalUsage float64 `json:"total_usage"` // unit: 0.01 dollar
+	}
+	if err = common.Unmarshal(body, &usage); err != nil {
+		return nil, err
+	}
+	return newBalanceInfo(subscription.HardLimitUSD - usage.TotalUsage/100), nil
+}
+
+// fetchOpenAIAdminBalance 通过 OpenAI 组织用量接口（/v1/organization/costs）统计本月用量，余额为每月预算减去本月用量
+func fetchOpenAIAdminBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	settings := channel.GetOtherSettings()
+	if settings.BalanceBudget <= 0 {
+		return nil, errors.New("使用 Admin Key 查询余额时需要配置每月预算")
+	}
+	spend := decimal.Zero
+	page := ""
+	for {
+		costURL := fmt.Sprintf("https://api.openai.com/v1/organization/costs?start_time=%d&bucket_width=1d&limit=31", monthStart().Unix())
+		if page != "" {
+			costURL += "&page=" + url.QueryEscape(page)
+		}
+		body, err := getBalanceResponseBody(ctx, costURL, channel, balanceAuthHeader(settings.BalanceAdminKey))
+		if err != nil {
+			return nil, err
+		}
+		var response struct {
+			Data []struct {
+				Results []struct {
+					Amount struct {
+						Value float64 `json:"value"`
+					} `json:"amount"`
+				} `json:"results"`
+			} `json:"data"`
+			HasMore  bool   `json:"has_more"`
+			NextPage string `json:"next_page"`
+		}
+		if err = common.Unmarshal(body, &response); err != nil {
+			return nil, err
+		}
+		for _, bucket := range response.Data {
+			for _, result := range bucket.Results {
+				spend = spend.Add(decimal.NewFromFloat(result.Amount.Value))
+			}
+		}
+		if !response.HasMore || response.NextPage == "" {
+			break
+		}
+		page = response.NextPage
+	}
+	return newBalanceInfo(decimal.NewFromFloat(settings.BalanceBudget).Sub(spend).InexactFloat64()), nil
+}
+
+// fetchAnthropicAdminBalance 通过 Anthropic 组织成本接口（/v1/organizations/cost_report）统计本月用量，余额为每月预算减去本月用量
+func fetchAnthropicAdminBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	settings := channel.GetOtherSettings()
+	if settings.BalanceAdminKey == "" || settings.BalanceBudget <= 0 {
+		return nil, errors.New("查询 Anthropic 余额需要配置 Admin Key 与每月预算")
+	}
+	headers := http.Header{}
+	headers.Add("x-api-key", settings.BalanceAdminKey)
+	headers.Add("anthropic-version", "2023-06-01")
+	spend := decimal.Zero
+	page := ""
+	for {
+		costURL := fmt.Sprintf("https://api.anthropic.com/v1/organizations/cost_report?starting_at=%s&bucket_width=1d&limit=31", url.QueryEscape(monthStart().Format(time.RFC3339)))
+		if page != "" {
+			costURL += "&page=" + url.QueryEscape(page)
+		}
+		body, err := getBalanceResponseBody(ctx, costURL, channel, headers)
+		if err != nil {
+			return nil, err
+		}
+		var response struct {
+			Data []struct {
+				Results []struct {
+					Amount string `json:"amount"` // 以美分为单位的小数字符串
+				} `json:"results"`
+			} `json:"data"`
+			HasMore  bool   `json:"has_more"`
+			NextPage string `json:"next_page"`
+		}
+		if err = common.Unmarshal(body, &response); err != nil {
+			return nil, err
+		}
+		for _, bucket := range response.Data {
+			for _, result := range bucket.Results {
+				cents, err := decimal.NewFromString(result.Amount)
+				if err != nil {
+					return nil, err
+				}
+				spend = spend.Add(cents.Div(decimal.NewFromInt(100)))
+			}
+		}
+		if !response.HasMore || response.NextPage == "" {
+			break
+		}
+		page = response.NextPage
+	}
+	return newBalanceInfo(decimal.NewFromFloat(settings.BalanceBudget).Sub(spend).InexactFloat64()), nil
+}
+
+// fetchCodexUsageWindows Codex 渠道没有余额，返回主次用量窗口中已用比例较高的一个
+func fetchCodexUsageWindows(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	statusCode, body, err := FetchCodexChannelUsage(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode < 200 || statusCode >= 300 {
+		return nil, fmt.Errorf("upstream status: %d", statusCode)
+	}
+	type usageWindow struct {
+		UsedPercent float64 `json:"used_percent"`
+		ResetAt     int64   `json:"reset_at"`
+	}
+	var response struct {
+		RateLimit struct {
+			PrimaryWindow   *usageWindow `json:"primary_window"`
+			SecondaryWindow *usageWindow `json:"secondary_window"`
+		} `json:"rate_limit"`
+	}
+	if err = common.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	info := &ChannelBalanceInfo{}
+	for _, window := range []*usageWindow{response.RateLimit.PrimaryWindow, response.RateLimit.SecondaryWindow} {
+		if window == nil || (info.UsedPercent != nil && window.UsedPercent <= *info.UsedPercent) {
+			continue
+		}
+		usedPercent := window.UsedPercent
+		info.UsedPercent = &usedPercent
+		info.ResetAt = window.ResetAt
+	}
+	if info.UsedPercent == nil {
+		return nil, errors.New("no usage window in response")
+	}
+	return info, nil
+}
+
+func fetchAIProxyBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	headers := http.Header{}
+	headers.Add("Api-Key", channel.Key)
+	body, err := getBalanceResponseBody(ctx, "https://aiproxy.io/api/report/getUserOverview", channel, headers)
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Success   bool   `json:"success"`
+		Message   string `json:"message"`
+		ErrorCode int    `json:"error_code"`
+		Data      struct {
+			TotalPoints float64 `json:"totalPoints"`
+		} `json:"data"`
+	}
+	if err = common.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("code: %d, message: %s", response.ErrorCode, response.Message)
+	}
+	return newBalanceInfo(response.Data.TotalPoints), nil
+}
+
+func fetchAPI2GPTBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	body, err := getBalanceResponseBody(ctx, "https://api.api2gpt.com/dashboard/billing/credit_grants", channel, balanceAuthHeader(channel.Key))
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		TotalRemaining float64 `json:"total_remaining"`
+	}
+	if err = common.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return newBalanceInfo(response.TotalRemaining), nil
+}
+
+func fetchAIGC2DBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	body, err := getBalanceResponseBody(ctx, "https://api.aigc2d.com/dashboard/billing/credit_grants", channel, balanceAuthHeader(channel.Key))
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		TotalAvailable float64 `json:"total_available"`
+	}
+	if err = common.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return newBalanceInfo(response.TotalAvailable), nil
+}
+
+func fetchSiliconFlowBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	body, err := getBalanceResponseBody(ctx, "https://api.siliconflow.cn/v1/user/info", channel, balanceAuthHeader(channel.Key))
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			TotalBalance string `json:"totalBalance"`
+		} `json:"data"`
+	}
+	if err = common.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if response.Code != 20000 {
+		return nil, fmt.Errorf("code: %d, message: %s", response.Code, response.Message)
+	}
+	balance, err := strconv.ParseFloat(response.Data.TotalBalance, 64)
+	if err != nil {
+		return nil, err
+	}
+	return newBalanceInfo(balance), nil
+}
+
+func fetchDeepSeekBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	body, err := getBalanceResponseBody(ctx, "https://api.deepseek.com/user/balance", channel, balanceAuthHeader(channel.Key))
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		BalanceInfos []struct {
+			Currency     string `json:"currency"`
+			TotalBalance string `json:"total_balance"`
+		} `json:"balance_infos"`
+	}
+	if err = common.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	for _, balanceInfo := range response.BalanceInfos {
+		if balanceInfo.Currency != "CNY" {
+			continue
+		}
+		balance, err := strconv.ParseFloat(balanceInfo.TotalBalance, 64)
+		if err != nil {
+			return nil, err
+		}
+		return newBalanceInfo(balance), nil
+	}
+	return nil, errors.New("currency CNY not found")
+}
+
+func fetchOpenRouterBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	body, err := getBalanceResponseBody(ctx, "https://openrouter.ai/api/v1/credits", channel, balanceAuthHeader(channel.Key))
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Data struct {
+			TotalCredits float64 `json:"total_credits"`
+			TotalUsage   float64 `json:"total_usage"`
+		} `json:"data"`
+	}
+	if err = common.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return newBalanceInfo(response.Data.TotalCredits - response.Data.TotalUsage), nil
+}
+
+func fetchMoonshotBalance(ctx context.Context, channel *model.Channel) (*ChannelBalanceInfo, error) {
+	body, err := getBalanceResponseBody(ctx, "https://api.moonshot.cn/v1/users/me/balance", channel, balanceAuthHeader(channel.Key))
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Code int `json:"code"`
+		Data struct {
+			AvailableBalance float64 `json:"available_balance"`
+		} `json:"data"`
+		Scode  string `json:"scode"`
+		Status bool   `json:"status"`
+	}
+	if err = common.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if !response.Status || response.Code != 0 {
+		return nil, fmt.Errorf("failed to update moonshot balance, status: %v, code: %d, scode: %s", response.Status, response.Code, response.Scode)
+	}
+	availableBalanceUsd := decimal.NewFromFloat(response.Data.AvailableBalance).Div(decimal.NewFromFloat(operation_setting.Price)).InexactFloat64()
+	return newBalanceInfo(availableBalanceUsd), nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/samber/lo"
+)
+
+// ChannelConfigDiffLine 一行差异，Op 为 " "（未变）、"-"（删除）或 "+"（新增）
+type ChannelConfigDiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+type ChannelConfigFieldDiff struct {
+	Field   string                  `json:"field"`
+	Changed bool                    `json:"changed"`
+	Lines   []ChannelConfigDiffLine `json:"lines,omitempty"`
+}
+
+// DiffChannelConfigVersions 按字段比较两个版本，JSON 内容格式化后逐行比较
+func DiffChannelConfigVersions(from *model.ChannelConfigVersion, to *model.ChannelConfigVersion) []ChannelConfigFieldDiff {
+	fields := []struct {
+		name     string
+		from, to *string
+	}{
+		{"model_mapping", from.ModelMapping, to.ModelMapping},
+		{"param_override", from.ParamOverride, to.ParamOverride},
+		{"header_override", from.HeaderOverride, to.HeaderOverride},
+	}
+	diffs := make([]ChannelConfigFieldDiff, 0, len(fields))
+	for _, field := range fields {
+		fromLines := configLines(lo.FromPtr(field.from))
+		toLines := configLines(lo.FromPtr(field.to))
+		diff := ChannelConfigFieldDiff{Field: field.name}
+		diff.Lines = diffLines(fromLines, toLines)
+		for _, line := range diff.Lines {
+			if line.Op != " " {
+				diff.Changed = true
+				break
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+func configLines(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(s), "", "  "); err == nil {
+		s = buf.String()
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffLines 基于最长公共子序列的逐行差异，配置通常只有几十行，O(n*m) 足够
+func diffLines(a, b []string) []ChannelConfigDiffLine {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	lines := make([]ChannelConfigDiffLine, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, ChannelConfigDiffLine{Op: " ", Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, ChannelConfigDiffLine{Op: "-", Text: a[i]})
+			i++
+		default:
+			lines = append(lines, ChannelConfigDiffLine{Op: "+", Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, ChannelConfigDiffLine{Op: "-", Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, ChannelConfigDiffLine{Op: "+", Text: b[j]})
+	}
+	return lines
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// ShouldCoolDownChannel 上游返回冷却状态码（默认 429）时渠道或 key 进入冷却而不是被禁用：
+// 多 Key 渠道冷却出错的 key；单 Key 渠道仅在上游给出了 Retry-After 等重置时间时冷却整个渠道。
+// 额度耗尽等无法自行恢复的错误仍按禁用处理
+func ShouldCoolDownChannel(isMultiKey bool, err *types.NewAPIError) bool {
+	if err == nil || !operation_setting.ShouldCoolDownByStatusCode(err.StatusCode) {
+		return false
+	}
+	if !isMultiKey && err.RetryAfter <= 0 {
+		return false
+	}
+	oaiErr := err.ToOpenAIError()
+	return oaiErr.Type != "insufficient_quota" && oaiErr.Code != "insufficient_quota"
+}
+
+// CoolDownChannel 按上游给出的重置时间（没有时使用 CooldownSeconds）让渠道或 key 冷却，冷却期间不参与选择。
+// keyIndex 小于 0 时冷却整个渠道；多 Key 渠道的 key 全部冷却时整个渠道也随之冷却
+func CoolDownChannel(channelId int, keyIndex int, err *types.NewAPIError) {
+	setting := operation_setting.GetKeyPoolSetting()
+	seconds := err.RetryAfter
+	if seconds <= 0 {
+		seconds = int64(setting.CooldownSeconds)
+	}
+	if setting.MaxCooldownSeconds > 0 {
+		seconds = min(seconds, int64(setting.MaxCooldownSeconds))
+	}
+	if seconds <= 0 {
+		return
+	}
+	until := time.Now().Unix() + seconds
+	reason := err.ErrorWithStatusCode()
+	if keyIndex >= 0 {
+		model.CoolDownChannelKey(channelId, keyIndex, until, reason)
+		model.RefreshChannelKeyCooldown(channelId)
+		common.SysLog(fmt.Sprintf("channel #%d key #%d cooling down for %ds: %s", channelId, keyIndex, seconds, reason))
+		return
+	}
+	model.CoolDownChannel(channelId, until)
+	common.SysLog(fmt.Sprintf("channel #%d cooling down for %ds: %s", channelId, seconds, reason))
+}
+
+// StartChannelKeyPoolTask 定期写入 key 用量；主节点同时负责按配置重新启用被自动禁用的 key
+func StartChannelKeyPoolTask() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		model.FlushChannelKeyUsages()
+		if !common.IsMasterNode {
+			continue
+		}
+		EnableExpiredDisabledChannelKeys()
+	}
+}
+
+// EnableExpiredDisabledChannelKeys 重新启用自动禁用时间超过 AutoEnableMinutes 的 key，返回启用的数量
+func EnableExpiredDisabledChannelKeys() int {
+	minutes := operation_setting.GetKeyPoolSetting().AutoEnableMinutes
+	if minutes <= 0 {
+		return 0
+	}
+	channels, err := model.GetMultiKeyChannels()
+	if err != nil {
+		common.SysLog("failed to load multi-key channels: " + err.Error())
+		return 0
+	}
+	deadline := time.Now().Unix() - int64(minutes)*60
+	enabled := 0
+	for _, channel := range channels {
+		for keyIndex, status := range channel.ChannelInfo.MultiKeyStatusList {
+			if status != common.ChannelStatusAutoDisabled || channel.ChannelInfo.MultiKeyDisabledTime[keyIndex] > deadline {
+				continue
+			}
+			EnableChannelKey(channel.Id, keyIndex, channel.Name)
+			enabled++
+		}
+	}
+	return enabled
+}
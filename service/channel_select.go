@@ -2,6 +2,7 @@ package service
 
 import (
 	"errors"
+	"strconv"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
@@ -85,6 +86,11 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 	var err error
 	selectGroup := param.TokenGroup
 	userGroup := common.GetContextKeyString(param.Ctx, constant.ContextKeyUserGroup)
+	// 一致性哈希策略按用户选择渠道
+	hashKey := ""
+	if userId := common.GetContextKeyInt(param.Ctx, constant.ContextKeyUserId); userId > 0 {
+		hashKey = strconv.Itoa(userId)
+	}
 
 	if param.TokenGroup == "auto" {
 		if len(setting.GetAutoGroups()) == 0 {
@@ -103,6 +109,7 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			}
 		}
 
+		var coolingErr *model.ChannelCoolingDownError
 		for i := startGroupIndex; i < len(autoGroups); i++ {
 			autoGroup := autoGroups[i]
 			// Calculate priorityRetry for current group
@@ -115,7 +122,13 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			}
 			logger.LogDebug(param.Ctx, "Auto selecting group: %s, priorityRetry: %d", autoGroup, priorityRetry)
 
-			channel, _ = model.GetRandomSatisfiedChannel(autoGroup, param.ModelName, priorityRetry)
+			var groupErr error
+			channel, groupErr = model.GetRandomSatisfiedChannel(autoGroup, param.ModelName, priorityRetry, hashKey)
+			var groupCoolingErr *model.ChannelCoolingDownError
+			if errors.As(groupErr, &groupCoolingErr) && (coolingErr == nil || groupCoolingErr.RetryAfter < coolingErr.RetryAfter) {
+				// 记录最早恢复的冷却时间，所有分组都无可用渠道时返回给客户端
+				coolingErr = groupCoolingErr
+			}
 			if channel == nil {
 				// Current group has no available channel for this model, try next group
 				// 当前分组没有该模型的可用渠道，尝试下一个分组
@@ -152,8 +165,11 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			}
 			break
 		}
+		if channel == nil && coolingErr != nil {
+			return nil, selectGroup, coolingErr
+		}
 	} else {
-		channel, err = model.GetRandomSatisfiedChannel(param.TokenGroup, param.ModelName, param.GetRetry())
+		channel, err = model.GetRandomSatisfiedChannel(param.TokenGroup, param.ModelName, param.GetRetry(), hashKey)
 		if err != nil {
 			return nil, param.TokenGroup, err
 		}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"gorm.io/gorm"
+)
+
+// 渠道请求与测试结果先在内存中按 5 分钟桶累加，定期以增量方式写入数据库，多节点写入互不覆盖
+
+type channelStatKey struct {
+	channelId   int
+	modelName   string
+	bucketStart int64
+}
+
+var (
+	channelStatsLock    sync.Mutex
+	channelStatsPending = make(map[channelStatKey]*model.ChannelStat)
+)
+
+func pendingChannelStat(channelId int, modelName string) *model.ChannelStat {
+	now := time.Now().Unix()
+	key := channelStatKey{channelId, modelName, now - now%model.ChannelStatBucketSeconds}
+	stat, ok := channelStatsPending[key]
+	if !ok {
+		stat = &model.ChannelStat{
+			ChannelId:   channelId,
+			ModelName:   modelName,
+			BucketStart: key.bucketStart,
+			BucketSize:  model.ChannelStatBucketSeconds,
+		}
+		channelStatsPending[key] = stat
+	}
+	return stat
+}
+
+// isChannelAvailabilityError 判断错误是否计入渠道不可用；参数错误等由客户端请求导致的错误不计入
+func isChannelAvailabilityError(err *types.NewAPIError) bool {
+	if err.StatusCode >= 400 && err.StatusCode < 500 {
+		switch err.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// RecordChannelRelayResult 记录一次转发尝试的结果
+func RecordChannelRelayResult(channelId int, modelName string, err *types.NewAPIError, latency time.Duration) {
+	if channelId == 0 || (err != nil && !isChannelAvailabilityError(err)) {
+		return
+	}
+	channelStatsLock.Lock()
+	defer channelStatsLock.Unlock()
+	stat := pendingChannelStat(channelId, modelName)
+	if err != nil {
+		stat.RelayFailure++
+		return
+	}
+	stat.RelaySuccess++
+	stat.RelayLatency += latency.Milliseconds()
+}
+
+// RecordChannelTestResult 记录一次渠道测试的结果
+func RecordChannelTestResult(channelId int, modelName string, success bool, latency time.Duration) {
+	channelStatsLock.Lock()
+	defer channelStatsLock.Unlock()
+	stat := pendingChannelStat(channelId, modelName)
+	if !success {
+		stat.TestFailure++
+		return
+	}
+	stat.TestSuccess++
+	stat.TestLatency += latency.Milliseconds()
+}
+
+// FlushChannelStats 将内存中的统计写入数据库，写入失败的部分保留到下次重试
+func FlushChannelStats() {
+	channelStatsLock.Lock()
+	pending := channelStatsPending
+	channelStatsPending = make(map[channelStatKey]*model.ChannelStat)
+	channelStatsLock.Unlock()
+
+	for key, stat := range pending {
+		if err := model.IncreaseChannelStat(model.DB, stat); err != nil {
+			common.SysLog(fmt.Sprintf("failed to save channel stat: channel_id=%d, error=%v", stat.ChannelId, err))
+			channelStatsLock.Lock()
+			if current, ok := channelStatsPending[key]; ok {
+				current.RelaySuccess += stat.RelaySuccess
+				current.RelayFailure += stat.RelayFailure
+				current.RelayLatency += stat.RelayLatency
+				current.TestSuccess += stat.TestSuccess
+				current.TestFailure += stat.TestFailure
+				current.TestLatency += stat.TestLatency
+			} else {
+				channelStatsPending[key] = stat
+			}
+			channelStatsLock.Unlock()
+		}
+	}
+}
+
+// StartChannelStatsTask 定期写入渠道统计；主节点同时负责统计压缩、过期清理与故障状态校正
+func StartChannelStatsTask() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	var lastMaintenance time.Time
+	for range ticker.C {
+		FlushChannelStats()
+		if !common.IsMasterNode {
+			continue
+		}
+		reconcileChannelIncidents()
+		if time.Since(lastMaintenance) < time.Hour {
+			continue
+		}
+		lastMaintenance = time.Now()
+		maintainChannelStats()
+	}
+}
+
+func maintainChannelStats() {
+	setting := operation_setting.GetStatusPageSetting()
+	now := time.Now().Unix()
+	if setting.RawRetentionHours > 0 {
+		before := now - int64(setting.RawRetentionHours)*3600
+		for {
+			n, err := model.CompactChannelStats(before, 1000)
+			if err != nil {
+				common.SysLog("failed to compact channel stats: " + err.Error())
+				break
+			}
+			if n == 0 {
+				break
+			}
+		}
+	}
+	if setting.RetentionDays > 0 {
+		before := now - int64(setting.RetentionDays)*86400
+		if _, err := model.DeleteChannelStatsBefore(before); err != nil {
+			common.SysLog("failed to delete expired channel stats: " + err.Error())
+		}
+		if err := model.DeleteChannelIncidentsBefore(before); err != nil {
+			common.SysLog("failed to delete expired channel incidents: " + err.Error())
+		}
+	}
+}
+
+// recordChannelDisableIncident 自动禁用成功后记录故障：keyIndex >= 0 时记录 key 级故障，渠道整体不可用时记录渠道级故障
+func recordChannelDisableIncident(channelId int, keyIndex int, reason string) {
+	if keyIndex >= 0 {
+		if err := model.OpenChannelIncident(channelId, keyIndex, reason); err != nil {
+			common.SysLog(fmt.Sprintf("failed to record channel incident: channel_id=%d, error=%v", channelId, err))
+		}
+	}
+	channel, err := model.GetChannelById(channelId, false)
+	if err != nil || channel.Status == common.ChannelStatusEnabled {
+		return
+	}
+	if err := model.OpenChannelIncident(channelId, -1, reason); err != nil {
+		common.SysLog(fmt.Sprintf("failed to record channel incident: channel_id=%d, error=%v", channelId, err))
+	}
+}
+
+// resolveChannelEnableIncidents 渠道（或 key）恢复启用后结束对应的故障
+func resolveChannelEnableIncidents(channelId int, keyIndex int) {
+	if err := model.ResolveChannelIncidents(channelId, keyIndex, true); err != nil {
+		common.SysLog(fmt.Sprintf("failed to resolve channel incidents: channel_id=%d, error=%v", channelId, err))
+	}
+}
+
+// channelKeyIndex 查询多 Key 渠道中 key 的索引，非多 Key 渠道或找不到时返回 -1
+func channelKeyIndex(channelId int, key string) int {
+	if key == "" {
+		return -1
+	}
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil || !channel.ChannelInfo.IsMultiKey {
+		return -1
+	}
+	return slices.Index(channel.GetKeys(), key)
+}
+
+// reconcileChannelIncidents 手动启用、删除渠道等操作不会经过自动启用流程，按渠道当前状态结束已恢复的故障
+func reconcileChannelIncidents() {
+	incidents, err := model.GetOpenChannelIncidents()
+	if err != nil || len(incidents) == 0 {
+		return
+	}
+	channels := make(map[int]*model.Channel)
+	for _, incident := range incidents {
+		channel, ok := channels[incident.ChannelId]
+		if !ok {
+			var err error
+			channel, err = model.GetChannelById(incident.ChannelId, false)
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			channels[incident.ChannelId] = channel
+		}
+		resolved := channel == nil
+		if channel != nil {
+			if incident.KeyIndex < 0 {
+				resolved = channel.Status == common.ChannelStatusEnabled
+			} else {
+				_, disabled := channel.ChannelInfo.MultiKeyStatusList[incident.KeyIndex]
+				resolved = !channel.ChannelInfo.IsMultiKey || !disabled
+			}
+		}
+		if resolved {
+			_ = model.ResolveChannelIncidentById(incident.Id)
+		}
+	}
+}
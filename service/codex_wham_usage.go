@@ -6,6 +6,9 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
 )
 
 func FetchCodexWhamUsage(
@@ -54,3 +57,41 @@ func FetchCodexWhamUsage(
 	}
 	return resp.StatusCode, body, nil
 }
+
+// FetchCodexChannelUsage 查询 Codex 渠道的用量窗口，access_token 失效时使用 refresh_token 刷新凭据后重试一次
+func FetchCodexChannelUsage(ctx context.Context, ch *model.Channel) (statusCode int, body []byte, err error) {
+	oauthKey, err := parseCodexOAuthKey(strings.TrimSpace(ch.Key))
+	if err != nil {
+		return 0, nil, err
+	}
+	accessToken := strings.TrimSpace(oauthKey.AccessToken)
+	accountID := strings.TrimSpace(oauthKey.AccountID)
+	if accessToken == "" {
+		return 0, nil, fmt.Errorf("codex channel: access_token is required")
+	}
+	if accountID == "" {
+		return 0, nil, fmt.Errorf("codex channel: account_id is required")
+	}
+
+	client, err := NewProxyHttpClient(ch.GetSetting().Proxy)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	statusCode, body, err = FetchCodexWhamUsage(fetchCtx, client, ch.GetBaseURL(), accessToken, accountID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if (statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden) && strings.TrimSpace(oauthKey.RefreshToken) != "" {
+		refreshed, _, refreshErr := RefreshCodexChannelCredential(ctx, ch.Id, CodexCredentialRefreshOptions{ResetCaches: true})
+		if refreshErr == nil {
+			retryCtx, retryCancel := context.WithTimeout(ctx, 15*time.Second)
+			defer retryCancel()
+			return FetchCodexWhamUsage(retryCtx, client, ch.GetBaseURL(), refreshed.AccessToken, accountID)
+		}
+	}
+	return statusCode, body, nil
+}
@@ -50,11 +50,17 @@ func DoWorkerRequest(req *WorkerRequest) (*http.Response, error) {
 }
 
 func DoDownloadRequest(originUrl string, reason ...string) (resp *http.Response, err error) {
+	return DoDownloadRequestWithHeaders(originUrl, nil, reason...)
+}
+
+// DoDownloadRequestWithHeaders 携带额外请求头下载文件（如条件请求的 If-None-Match）
+func DoDownloadRequestWithHeaders(originUrl string, headers map[string]string, reason ...string) (resp *http.Response, err error) {
 	if system_setting.EnableWorker() {
 		common.SysLog(fmt.Sprintf("downloading file from worker: %s, reason: %s", originUrl, strings.Join(reason, ", ")))
 		req := &WorkerRequest{
-			URL: originUrl,
-			Key: system_setting.WorkerValidKey,
+			URL:     originUrl,
+			Key:     system_setting.WorkerValidKey,
+			Headers: headers,
 		}
 		return DoWorkerRequest(req)
 	} else {
@@ -65,6 +71,16 @@ func DoDownloadRequest(originUrl string, reason ...string) (resp *http.Response,
 		}
 
 		common.SysLog(fmt.Sprintf("downloading from origin: %s, reason: %s", common.MaskSensitiveInfo(originUrl), strings.Join(reason, ", ")))
-		return GetHttpClient().Get(originUrl)
+		if len(headers) == 0 {
+			return GetHttpClient().Get(originUrl)
+		}
+		req, err := http.NewRequest(http.MethodGet, originUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return GetHttpClient().Do(req)
 	}
 }
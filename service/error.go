@@ -89,6 +89,11 @@ func RelayErrorHandler(ctx context.Context, resp *http.Response, showBodyWhenFai
 		return
 	}
 	CloseResponseBodyGracefully(resp)
+	defer func() {
+		if newApiErr != nil {
+			newApiErr.RetryAfter = ParseUpstreamRetryAfter(resp.Header, responseBody)
+		}
+	}()
 	var errResponse dto.GeneralErrorResponse
 	buildErrWithBody := func(message string) error {
 		if message == "" {
@@ -0,0 +1,405 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// EventEnvelope 投递给 sink 的事件结构，消费方应使用 id 去重
+type EventEnvelope struct {
+	Id        int64           `json:"id"`
+	Type      string          `json:"type"`
+	UserId    int             `json:"user_id"`
+	CreatedAt int64           `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// EventSink 事件投递目标，Send 返回 nil 表示整批已被目标确认接收
+type EventSink interface {
+	Send(ctx context.Context, events []*EventEnvelope) error
+}
+
+const eventBusLeaseSeconds = 60
+
+// StartEventBusDispatcher 事件投递器，所有节点均可运行，通过租约避免重复领取
+func StartEventBusDispatcher() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	lastCleanup := time.Time{}
+	for {
+		select {
+		case <-ticker.C:
+		case <-model.EventBusNotifyChan():
+		}
+		busSetting := operation_setting.GetEventBusSetting()
+		dispatchEvents(busSetting)
+		if time.Since(lastCleanup) > time.Hour && busSetting.RetentionDays > 0 {
+			lastCleanup = time.Now()
+			before := time.Now().AddDate(0, 0, -busSetting.RetentionDays).Unix()
+			if err := model.CleanupEvents(before); err != nil {
+				common.SysLog("failed to cleanup events: " + err.Error())
+			}
+		}
+	}
+}
+
+func dispatchEvents(busSetting *operation_setting.EventBusSetting) {
+	sinkNames, err := model.GetDueEventSinks(time.Now().Unix())
+	if err != nil {
+		common.SysLog("failed to get due event sinks: " + err.Error())
+		return
+	}
+	batchSize := busSetting.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	for _, name := range sinkNames {
+		sinkConfig, ok := busSetting.GetSink(name)
+		for {
+			deliveries, events, err := model.LeaseEventDeliveries(name, time.Now().Unix(), eventBusLeaseSeconds, batchSize)
+			if err != nil {
+				common.SysLog(fmt.Sprintf("failed to lease event deliveries for sink %s: %s", name, err.Error()))
+				break
+			}
+			if len(deliveries) == 0 {
+				break
+			}
+			var sendErr error
+			if !ok || !sinkConfig.Enabled {
+				// sink 已删除或停用，保留记录，待重新启用后继续投递
+				sendErr = fmt.Errorf("event sink %s not found or disabled", name)
+			} else {
+				sendErr = sendEventBatch(sinkConfig, deliveries, events)
+			}
+			if sendErr != nil {
+				attempts := deliveries[0].Attempts + 1
+				nextAttemptAt := time.Now().Add(eventRetryDelay(attempts)).Unix()
+				if err := model.MarkEventDeliveriesRetry(deliveries, sendErr.Error(), nextAttemptAt, busSetting.MaxAttempts); err != nil {
+					common.SysLog("failed to update event deliveries: " + err.Error())
+				}
+				common.SysLog(fmt.Sprintf("event sink %s delivery failed: %s", name, sendErr.Error()))
+				break
+			}
+			ids := make([]int64, 0, len(deliveries))
+			for _, d := range deliveries {
+				ids = append(ids, d.Id)
+			}
+			if err := model.MarkEventDeliveriesSuccess(ids); err != nil {
+				common.SysLog("failed to update event deliveries: " + err.Error())
+			}
+			if len(deliveries) < batchSize {
+				break
+			}
+		}
+	}
+}
+
+// eventRetryDelay 指数退避，最长 1 小时
+func eventRetryDelay(attempts int) time.Duration {
+	delay := 5 * time.Second << uint(min(attempts, 10))
+	if delay > time.Hour {
+		delay = time.Hour
+	}
+	return delay
+}
+
+func sendEventBatch(sinkConfig operation_setting.EventSinkConfig, deliveries []*model.EventDelivery, events map[int64]*model.EventRecord) error {
+	sink, err := newEventSink(sinkConfig)
+	if err != nil {
+		return err
+	}
+	envelopes := make([]*EventEnvelope, 0, len(deliveries))
+	for _, d := range deliveries {
+		e, ok := events[d.EventId]
+		if !ok {
+			continue
+		}
+		envelopes = append(envelopes, &EventEnvelope{
+			Id:        e.Id,
+			Type:      e.Type,
+			UserId:    e.UserId,
+			CreatedAt: e.CreatedAt,
+			Data:      json.RawMessage(e.Payload),
+		})
+	}
+	if len(envelopes) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return sink.Send(ctx, envelopes)
+}
+
+func newEventSink(c operation_setting.EventSinkConfig) (EventSink, error) {
+	switch c.Type {
+	case operation_setting.EventSinkTypeHttp:
+		if c.Url == "" {
+			return nil, errors.New("http sink url is empty")
+		}
+		return &httpEventSink{url: c.Url, secret: c.Secret}, nil
+	case operation_setting.EventSinkTypeNats:
+		return newNatsEventSink(c.Url, c.Subject)
+	case operation_setting.EventSinkTypeRedisStream:
+		return newRedisStreamEventSink(c.Url, c.Subject, c.MaxLen)
+	case operation_setting.EventSinkTypeJsonl:
+		if c.Path == "" {
+			return nil, errors.New("jsonl sink path is empty")
+		}
+		return &jsonlEventSink{path: c.Path}, nil
+	default:
+		return nil, fmt.Errorf("unsupported event sink type: %s", c.Type)
+	}
+}
+
+// httpEventSink 逐条 POST 事件，签名方式与出站 webhook 一致
+type httpEventSink struct {
+	url    string
+	secret string
+}
+
+func (s *httpEventSink) Send(ctx context.Context, events []*EventEnvelope) error {
+	for _, e := range events {
+		body, err := common.Marshal(e)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "new-api-event/1.0")
+		req.Header.Set("X-Event-Id", strconv.FormatInt(e.Id, 10))
+		req.Header.Set("X-Event-Type", e.Type)
+		if s.secret != "" {
+			timestamp := time.Now().Unix()
+			req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+			req.Header.Set("X-Webhook-Signature-256", fmt.Sprintf("t=%d,v1=%s", timestamp, generateTimestampSignature(s.secret, timestamp, body)))
+		}
+		resp, err := GetHttpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("event %d rejected with status code: %d", e.Id, resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+// natsEventSink 使用 NATS 文本协议发布事件，subject 为 "{前缀}.{事件类型}"，
+// 发布后发送 PING 并等待 PONG，确认服务端已处理全部消息
+type natsEventSink struct {
+	addr    string
+	user    string
+	pass    string
+	token   string
+	subject string
+}
+
+func newNatsEventSink(rawURL string, subject string) (*natsEventSink, error) {
+	if rawURL == "" {
+		return nil, errors.New("nats sink url is empty")
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "nats://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	sink := &natsEventSink{addr: addr, subject: subject}
+	if sink.subject == "" {
+		sink.subject = "new-api.events"
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			sink.user = u.User.Username()
+			sink.pass = pass
+		} else {
+			sink.token = u.User.Username()
+		}
+	}
+	return sink, nil
+}
+
+func (s *natsEventSink) Send(ctx context.Context, events []*EventEnvelope) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	reader := bufio.NewReader(conn)
+	// 服务端首先发送 INFO
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO") {
+		return fmt.Errorf("unexpected nats greeting: %s", strings.TrimSpace(line))
+	}
+	connectOpts := map[string]any{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "new-api",
+		"lang":     "go",
+		"version":  common.Version,
+	}
+	if s.user != "" {
+		connectOpts["user"] = s.user
+		connectOpts["pass"] = s.pass
+	}
+	if s.token != "" {
+		connectOpts["auth_token"] = s.token
+	}
+	connectBytes, _ := common.Marshal(connectOpts)
+	var buf bytes.Buffer
+	buf.WriteString("CONNECT ")
+	buf.Write(connectBytes)
+	buf.WriteString("\r\n")
+	for _, e := range events {
+		body, err := common.Marshal(e)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "PUB %s.%s %d\r\n", s.subject, e.Type, len(body))
+		buf.Write(body)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("PING\r\n")
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats error: %s", line)
+		}
+	}
+}
+
+// redisStreamEventSink 使用 XADD 写入 Redis Stream
+type redisStreamEventSink struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+var (
+	eventRedisClients   = make(map[string]*redis.Client)
+	eventRedisClientsMu sync.Mutex
+)
+
+func newRedisStreamEventSink(connString string, stream string, maxLen int64) (*redisStreamEventSink, error) {
+	if stream == "" {
+		stream = "new-api:events"
+	}
+	if connString == "" {
+		if !common.RedisEnabled || common.RDB == nil {
+			return nil, errors.New("redis is not enabled")
+		}
+		return &redisStreamEventSink{client: common.RDB, stream: stream, maxLen: maxLen}, nil
+	}
+	eventRedisClientsMu.Lock()
+	defer eventRedisClientsMu.Unlock()
+	client, ok := eventRedisClients[connString]
+	if !ok {
+		opt, err := redis.ParseURL(connString)
+		if err != nil {
+			return nil, err
+		}
+		client = redis.NewClient(opt)
+		eventRedisClients[connString] = client
+	}
+	return &redisStreamEventSink{client: client, stream: stream, maxLen: maxLen}, nil
+}
+
+func (s *redisStreamEventSink) Send(ctx context.Context, events []*EventEnvelope) error {
+	pipe := s.client.Pipeline()
+	for _, e := range events {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.stream,
+			MaxLen: s.maxLen,
+			Approx: s.maxLen > 0,
+			Values: map[string]interface{}{
+				"id":         e.Id,
+				"type":       e.Type,
+				"user_id":    e.UserId,
+				"created_at": e.CreatedAt,
+				"data":       string(e.Data),
+			},
+		})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// jsonlEventSink 追加写入本地 JSONL 文件，适合本地调试或由日志采集器转发
+type jsonlEventSink struct {
+	path string
+}
+
+var jsonlEventSinkMu sync.Mutex
+
+func (s *jsonlEventSink) Send(ctx context.Context, events []*EventEnvelope) error {
+	jsonlEventSinkMu.Lock()
+	defer jsonlEventSinkMu.Unlock()
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	for _, e := range events {
+		line, err := common.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return f.Sync()
+}
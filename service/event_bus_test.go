@@ -0,0 +1,195 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type natsPublish struct {
+	subject string
+	payload []byte
+}
+
+// startFakeNatsServer 模拟 NATS 服务端：发送 INFO，记录 CONNECT 与 PUB，收到 PING 时回复 PONG。
+// rejectAuth 为 true 时对 CONNECT 返回 -ERR
+func startFakeNatsServer(t *testing.T, rejectAuth bool) (string, <-chan map[string]any, <-chan natsPublish) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	connects := make(chan map[string]any, 4)
+	publishes := make(chan natsPublish, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeNatsConn(conn, rejectAuth, connects, publishes)
+		}
+	}()
+	return listener.Addr().String(), connects, publishes
+}
+
+func serveFakeNatsConn(conn net.Conn, rejectAuth bool, connects chan<- map[string]any, publishes chan<- natsPublish) {
+	defer conn.Close()
+	_, _ = conn.Write([]byte(`INFO {"server_id":"fake","version":"2.10.0","max_payload":1048576}` + "\r\n"))
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			var opts map[string]any
+			_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &opts)
+			connects <- opts
+			if rejectAuth {
+				_, _ = conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+				return
+			}
+		case strings.HasPrefix(line, "PUB "):
+			fields := strings.Fields(line)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			publishes <- natsPublish{subject: fields[1], payload: payload[:size]}
+		case line == "PING":
+			_, _ = conn.Write([]byte("PONG\r\n"))
+		}
+	}
+}
+
+func TestNatsEventSink_PublishesBatch(t *testing.T) {
+	addr, connects, publishes := startFakeNatsServer(t, false)
+	sink, err := newNatsEventSink("nats://alice:secret@"+addr, "billing")
+	require.NoError(t, err)
+
+	events := []*EventEnvelope{
+		{Id: 1, Type: "billing.topup", UserId: 7, CreatedAt: 100, Data: json.RawMessage(`{"quota":500}`)},
+		{Id: 2, Type: "usage.consume", UserId: 7, CreatedAt: 101, Data: json.RawMessage(`{"quota":3}`)},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, sink.Send(ctx, events))
+
+	opts := <-connects
+	require.Equal(t, "alice", opts["user"])
+	require.Equal(t, "secret", opts["pass"])
+	require.Equal(t, false, opts["verbose"])
+
+	for _, want := range events {
+		got := <-publishes
+		require.Equal(t, "billing."+want.Type, got.subject)
+		var envelope EventEnvelope
+		require.NoError(t, json.Unmarshal(got.payload, &envelope))
+		require.Equal(t, want.Id, envelope.Id)
+		require.Equal(t, want.UserId, envelope.UserId)
+		require.JSONEq(t, string(want.Data), string(envelope.Data))
+	}
+}
+
+func TestNatsEventSink_TokenAuthAndDefaultSubject(t *testing.T) {
+	addr, connects, publishes := startFakeNatsServer(t, false)
+	sink, err := newNatsEventSink("mytoken@"+addr, "")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, sink.Send(ctx, []*EventEnvelope{{Id: 3, Type: "token.created", Data: json.RawMessage(`{}`)}}))
+
+	opts := <-connects
+	require.Equal(t, "mytoken", opts["auth_token"])
+	require.NotContains(t, opts, "user")
+	require.Equal(t, "new-api.events.token.created", (<-publishes).subject)
+}
+
+func TestNatsEventSink_ServerError(t *testing.T) {
+	addr, _, _ := startFakeNatsServer(t, true)
+	sink, err := newNatsEventSink(addr, "events")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = sink.Send(ctx, []*EventEnvelope{{Id: 4, Type: "billing.refund", Data: json.RawMessage(`{}`)}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Authorization Violation")
+}
+
+func TestPublishEvent_StoresBeforeWakingDispatcher(t *testing.T) {
+	common.RedisEnabled = false
+	common.IsMasterNode = true
+	common.SQLitePath = filepath.Join(t.TempDir(), "event-bus.db")
+	require.NoError(t, model.InitDB())
+
+	busSetting := operation_setting.GetEventBusSetting()
+	origin := *busSetting
+	t.Cleanup(func() { *busSetting = origin })
+	busSetting.Enabled = true
+	busSetting.Sinks = []operation_setting.EventSinkConfig{
+		{Name: "audit", Type: "jsonl", Enabled: true, Events: []string{"billing.*"}, Path: filepath.Join(t.TempDir(), "events.jsonl")},
+	}
+	// 清空此前遗留的唤醒信号
+	select {
+	case <-model.EventBusNotifyChan():
+	default:
+	}
+	countEvents := func() (events int64, deliveries int64) {
+		require.NoError(t, model.DB.Model(&model.EventRecord{}).Count(&events).Error)
+		require.NoError(t, model.DB.Model(&model.EventDelivery{}).Where("sink = ? AND status = ?", "audit", model.EventDeliveryStatusPending).Count(&deliveries).Error)
+		return
+	}
+
+	// 未订阅的事件不落库
+	model.PublishEvent(model.EventTypeUserRegistered, 1, map[string]any{"username": "u"})
+	events, _ := countEvents()
+	require.Zero(t, events)
+
+	// 返回时事件与投递记录均已落库，并已唤醒投递器
+	model.PublishTopupEvent(1, "stripe", "trade-1", 500000, 1)
+	events, deliveries := countEvents()
+	require.EqualValues(t, 1, events)
+	require.EqualValues(t, 1, deliveries)
+	select {
+	case <-model.EventBusNotifyChan():
+	default:
+		t.Fatal("expected dispatcher to be notified")
+	}
+
+	// 事务回滚时事件一同回滚，提交后才可见
+	rollback := errors.New("rollback")
+	err := model.DB.Transaction(func(tx *gorm.DB) error {
+		require.NoError(t, model.PublishTopupEventTx(tx, 1, "stripe", "trade-2", 500000, 1))
+		return rollback
+	})
+	require.ErrorIs(t, err, rollback)
+	events, _ = countEvents()
+	require.EqualValues(t, 1, events)
+
+	require.NoError(t, model.DB.Transaction(func(tx *gorm.DB) error {
+		return model.PublishTopupEventTx(tx, 1, "stripe", "trade-3", 500000, 1)
+	}))
+	events, deliveries = countEvents()
+	require.EqualValues(t, 2, events)
+	require.EqualValues(t, 2, deliveries)
+}
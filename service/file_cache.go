@@ -0,0 +1,368 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	fileCacheURLKeyPrefix    = "file_cache:url:"
+	fileCacheHandleKeyPrefix = "file_cache:handle:"
+	fileCacheTempPrefix      = ".tmp-"
+)
+
+// fileCacheEntry URL 索引项，指向按内容哈希存储的缓存文件
+type fileCacheEntry struct {
+	Hash         string `json:"hash"`
+	MimeType     string `json:"mime_type"`
+	Size         int64  `json:"size"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	ValidatedAt  int64  `json:"validated_at"`
+}
+
+// ProviderFileHandle 上游文件句柄（Gemini File API / Anthropic Files API）
+type ProviderFileHandle struct {
+	Id        string `json:"id"`            // Gemini: files/xxx，Anthropic: file_xxx
+	Uri       string `json:"uri,omitempty"` // Gemini 引用文件使用的 uri
+	MimeType  string `json:"mime_type,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"` // 上游过期时间，0 表示未知
+}
+
+type fileCacheMemItem struct {
+	value     string
+	expiresAt int64
+}
+
+// 未启用 Redis 时的内存索引
+var fileCacheMemIndex = struct {
+	sync.Mutex
+	items map[string]fileCacheMemItem
+}{items: make(map[string]fileCacheMemItem)}
+
+func fileCacheIndexGet(key string) (string, bool) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		return value, err == nil && value != ""
+	}
+	fileCacheMemIndex.Lock()
+	defer fileCacheMemIndex.Unlock()
+	item, ok := fileCacheMemIndex.items[key]
+	if !ok {
+		return "", false
+	}
+	if item.expiresAt < time.Now().Unix() {
+		delete(fileCacheMemIndex.items, key)
+		return "", false
+	}
+	return item.value, true
+}
+
+func fileCacheIndexSet(key string, value any, ttl time.Duration) {
+	data, err := common.Marshal(value)
+	if err != nil {
+		return
+	}
+	if common.RedisEnabled {
+		if err := common.RedisSet(key, string(data), ttl); err != nil {
+			common.SysLog("failed to save file cache index: " + err.Error())
+		}
+		return
+	}
+	fileCacheMemIndex.Lock()
+	defer fileCacheMemIndex.Unlock()
+	fileCacheMemIndex.items[key] = fileCacheMemItem{value: string(data), expiresAt: time.Now().Add(ttl).Unix()}
+}
+
+func fileCacheIndexDel(key string) {
+	if common.RedisEnabled {
+		_ = common.RedisDel(key)
+		return
+	}
+	fileCacheMemIndex.Lock()
+	defer fileCacheMemIndex.Unlock()
+	delete(fileCacheMemIndex.items, key)
+}
+
+// HashFileBytes 返回文件内容的 sha256
+func HashFileBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func fileCacheBlobPath(hash string) (string, error) {
+	if len(hash) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid file hash: %s", hash)
+	}
+	return filepath.Join(operation_setting.GetFileCacheSetting().Dir, hash[:2], hash), nil
+}
+
+// readFileCacheBlob 读取缓存文件，并刷新修改时间用于 LRU 淘汰
+func readFileCacheBlob(hash string) ([]byte, error) {
+	path, err := fileCacheBlobPath(hash)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if HashFileBytes(data) != hash {
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("file cache blob corrupted: %s", hash)
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return data, nil
+}
+
+func writeFileCacheBlob(hash string, data []byte) error {
+	path, err := fileCacheBlobPath(hash)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		return os.Chtimes(path, now, now)
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, fileCacheTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// getCachedRemoteFile 优先从缓存读取远程文件；超过校验间隔时向源站发起条件请求
+func getCachedRemoteFile(c *gin.Context, url string, reason ...string) (*remoteFile, error) {
+	setting := operation_setting.GetFileCacheSetting()
+	key := fileCacheURLKeyPrefix + common.GenerateHMAC(url)
+
+	var entry fileCacheEntry
+	if raw, ok := fileCacheIndexGet(key); ok && common.UnmarshalJsonStr(raw, &entry) == nil {
+		// 索引存在但本地缓存文件缺失（如多节点共享 Redis 索引）时直接重新下载
+		if data, err := readFileCacheBlob(entry.Hash); err == nil {
+			cached := &remoteFile{
+				StatusCode:   http.StatusOK,
+				Bytes:        data,
+				MimeType:     entry.MimeType,
+				Hash:         entry.Hash,
+				ETag:         entry.ETag,
+				LastModified: entry.LastModified,
+			}
+			if time.Now().Unix()-entry.ValidatedAt < int64(setting.RevalidateSeconds) {
+				if common.DebugEnabled {
+					logger.LogDebug(c, fmt.Sprintf("file cache hit for URL: %s", common.MaskSensitiveInfo(url)))
+				}
+				return cached, nil
+			}
+			if entry.ETag != "" || entry.LastModified != "" {
+				headers := make(map[string]string)
+				if entry.ETag != "" {
+					headers["If-None-Match"] = entry.ETag
+				}
+				if entry.LastModified != "" {
+					headers["If-Modified-Since"] = entry.LastModified
+				}
+				file, err := downloadRemoteFile(c, url, headers, reason...)
+				if err != nil {
+					return nil, err
+				}
+				if file.StatusCode == http.StatusNotModified {
+					entry.ValidatedAt = time.Now().Unix()
+					fileCacheIndexSet(key, &entry, time.Duration(setting.TTLSeconds)*time.Second)
+					return cached, nil
+				}
+				storeRemoteFile(c, key, file)
+				return file, nil
+			}
+		}
+	}
+
+	file, err := downloadRemoteFile(c, url, nil, reason...)
+	if err != nil {
+		return nil, err
+	}
+	storeRemoteFile(c, key, file)
+	return file, nil
+}
+
+// storeRemoteFile 写入缓存文件与 URL 索引，仅缓存成功响应
+func storeRemoteFile(c *gin.Context, key string, file *remoteFile) {
+	setting := operation_setting.GetFileCacheSetting()
+	if file.StatusCode < 200 || file.StatusCode >= 300 || len(file.Bytes) == 0 {
+		return
+	}
+	if int64(len(file.Bytes)) > int64(setting.MaxFileMB)<<20 {
+		return
+	}
+	if err := writeFileCacheBlob(file.Hash, file.Bytes); err != nil {
+		logger.LogWarn(c, fmt.Sprintf("failed to write file cache: %s", err.Error()))
+		return
+	}
+	fileCacheIndexSet(key, &fileCacheEntry{
+		Hash:         file.Hash,
+		MimeType:     file.MimeType,
+		Size:         int64(len(file.Bytes)),
+		ETag:         file.ETag,
+		LastModified: file.LastModified,
+		ValidatedAt:  time.Now().Unix(),
+	}, time.Duration(setting.TTLSeconds)*time.Second)
+}
+
+func providerFileHandleKey(provider string, credential string, hash string) string {
+	return fileCacheHandleKeyPrefix + provider + ":" + common.GenerateHMAC(credential) + ":" + hash
+}
+
+// GetProviderFileHandle 查询已上传到上游的文件句柄，credential 用于区分不同的上游账号
+func GetProviderFileHandle(provider string, credential string, hash string) (*ProviderFileHandle, bool) {
+	raw, ok := fileCacheIndexGet(providerFileHandleKey(provider, credential, hash))
+	if !ok {
+		return nil, false
+	}
+	var handle ProviderFileHandle
+	if err := common.UnmarshalJsonStr(raw, &handle); err != nil {
+		return nil, false
+	}
+	if handle.ExpiresAt > 0 && handle.ExpiresAt <= time.Now().Unix() {
+		return nil, false
+	}
+	return &handle, true
+}
+
+// SetProviderFileHandle 记录上游文件句柄，有效期取配置与上游过期时间中的较小值
+func SetProviderFileHandle(provider string, credential string, hash string, handle *ProviderFileHandle) {
+	ttl := time.Duration(operation_setting.GetFileCacheSetting().ProviderFileTTLSeconds) * time.Second
+	if handle.ExpiresAt > 0 {
+		if remain := time.Until(time.Unix(handle.ExpiresAt, 0)); remain < ttl {
+			ttl = remain
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+	fileCacheIndexSet(providerFileHandleKey(provider, credential, hash), handle, ttl)
+}
+
+// DeleteProviderFileHandle 删除失效的上游文件句柄
+func DeleteProviderFileHandle(provider string, credential string, hash string) {
+	fileCacheIndexDel(providerFileHandleKey(provider, credential, hash))
+}
+
+// ResolveProviderFileHandle 按内容哈希复用上游文件句柄，不存在时调用 upload 上传并记录
+func ResolveProviderFileHandle(provider string, credential string, hash string, upload func() (*ProviderFileHandle, error)) (*ProviderFileHandle, error) {
+	if handle, ok := GetProviderFileHandle(provider, credential, hash); ok {
+		return handle, nil
+	}
+	handle, err := upload()
+	if err != nil {
+		return nil, err
+	}
+	SetProviderFileHandle(provider, credential, hash, handle)
+	return handle, nil
+}
+
+// StartFileCacheGC 定期清理过期与超出容量的缓存文件，缓存目录为节点本地，因此每个节点都需要执行
+func StartFileCacheGC() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !operation_setting.GetFileCacheSetting().Enabled {
+			continue
+		}
+		CleanupFileCache()
+	}
+}
+
+// CleanupFileCache 删除超过 TTL 未使用的缓存文件，总大小超限时按最久未使用淘汰，返回删除数量
+func CleanupFileCache() int {
+	setting := operation_setting.GetFileCacheSetting()
+	type blobInfo struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	now := time.Now()
+	ttl := time.Duration(setting.TTLSeconds) * time.Second
+	deleted := 0
+	var blobs []blobInfo
+	var total int64
+	err := filepath.WalkDir(setting.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		expired := now.Sub(info.ModTime()) > ttl
+		// 残留的临时文件
+		if strings.HasPrefix(d.Name(), fileCacheTempPrefix) {
+			expired = now.Sub(info.ModTime()) > time.Hour
+		}
+		if expired {
+			if os.Remove(path) == nil {
+				deleted++
+			}
+			return nil
+		}
+		blobs = append(blobs, blobInfo{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		common.SysLog("failed to walk file cache dir: " + err.Error())
+	}
+
+	limit := int64(setting.MaxTotalMB) << 20
+	if limit > 0 && total > limit {
+		sort.Slice(blobs, func(i, j int) bool {
+			return blobs[i].modTime.Before(blobs[j].modTime)
+		})
+		for _, blob := range blobs {
+			if total <= limit {
+				break
+			}
+			if os.Remove(blob.path) == nil {
+				total -= blob.size
+				deleted++
+			}
+		}
+	}
+
+	fileCacheMemIndex.Lock()
+	for key, item := range fileCacheMemIndex.items {
+		if item.expiresAt < now.Unix() {
+			delete(fileCacheMemIndex.items, key)
+		}
+	}
+	fileCacheMemIndex.Unlock()
+	return deleted
+}
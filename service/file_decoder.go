@@ -15,6 +15,7 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/gin-gonic/gin"
@@ -141,14 +142,58 @@ func GetFileBase64FromUrl(c *gin.Context, url string, reason ...string) (*types.
 		return cachedData.(*types.LocalFileData), nil
 	}
 
+	var file *remoteFile
+	var err error
+	if operation_setting.GetFileCacheSetting().Enabled {
+		file, err = getCachedRemoteFile(c, url, reason...)
+	} else {
+		file, err = downloadRemoteFile(c, url, nil, reason...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := &types.LocalFileData{
+		Base64Data: base64.StdEncoding.EncodeToString(file.Bytes),
+		MimeType:   file.MimeType,
+		Size:       int64(len(file.Bytes)),
+		Sha256:     file.Hash,
+	}
+	// Store the file data in the context to avoid re-downloading
+	c.Set(contextKey, data)
+
+	return data, nil
+}
+
+// remoteFile 下载得到的远程文件
+type remoteFile struct {
+	StatusCode   int
+	Bytes        []byte
+	MimeType     string
+	Hash         string // 内容 sha256
+	ETag         string
+	LastModified string
+}
+
+// downloadRemoteFile 下载远程文件并解析 MIME 类型，headers 用于条件请求，源站返回 304 时 Bytes 为空
+func downloadRemoteFile(c *gin.Context, url string, headers map[string]string, reason ...string) (*remoteFile, error) {
 	var maxFileSize = constant.MaxFileDownloadMB * 1024 * 1024
 
-	resp, err := DoDownloadRequest(url, reason...)
+	resp, err := DoDownloadRequestWithHeaders(url, headers, reason...)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	file := &remoteFile{
+		StatusCode:   resp.StatusCode,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return file, nil
+	}
+
 	// Always use LimitReader to prevent oversized downloads
 	fileBytes, err := io.ReadAll(io.LimitReader(resp.Body, int64(maxFileSize+1)))
 	if err != nil {
@@ -158,11 +203,15 @@ func GetFileBase64FromUrl(c *gin.Context, url string, reason ...string) (*types.
 	if len(fileBytes) > maxFileSize {
 		return nil, fmt.Errorf("file size exceeds maximum allowed size: %dMB", constant.MaxFileDownloadMB)
 	}
+	file.Bytes = fileBytes
+	file.Hash = HashFileBytes(fileBytes)
+	file.MimeType = resolveDownloadMimeType(c, url, resp.Header)
+	return file, nil
+}
 
-	// Convert to base64
-	base64Data := base64.StdEncoding.EncodeToString(fileBytes)
-
-	mimeType := resp.Header.Get("Content-Type")
+// resolveDownloadMimeType 根据响应头解析 MIME 类型，无法确定时根据 URL 或文件名猜测
+func resolveDownloadMimeType(c *gin.Context, url string, header http.Header) string {
+	mimeType := header.Get("Content-Type")
 	if len(strings.Split(mimeType, ";")) > 1 {
 		// If Content-Type has parameters, take the first part
 		mimeType = strings.Split(mimeType, ";")[0]
@@ -184,7 +233,7 @@ func GetFileBase64FromUrl(c *gin.Context, url string, reason ...string) (*types.
 			}
 		} else {
 			// try to guess the MIME type from the file extension
-			fileName := resp.Header.Get("Content-Disposition")
+			fileName := header.Get("Content-Disposition")
 			if fileName != "" {
 				// Extract the filename from the Content-Disposition header
 				parts := strings.Split(fileName, ";")
@@ -205,15 +254,7 @@ func GetFileBase64FromUrl(c *gin.Context, url string, reason ...string) (*types.
 			}
 		}
 	}
-	data := &types.LocalFileData{
-		Base64Data: base64Data,
-		MimeType:   mimeType,
-		Size:       int64(len(fileBytes)),
-	}
-	// Store the file data in the context to avoid re-downloading
-	c.Set(contextKey, data)
-
-	return data, nil
+	return mimeType
 }
 
 func GetMimeTypeByExtension(ext string) string {
@@ -49,6 +49,24 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
+	if relayInfo.IsModelFallback() {
+		other["is_model_fallback"] = true
+		other["requested_model_name"] = relayInfo.RequestedModelName
+	}
+	if relayInfo.PriceData.PricingTier != nil {
+		other["pricing_tier"] = relayInfo.PriceData.PricingTier
+	}
+	if relayInfo.VirtualModelName != "" {
+		other["virtual_model"] = relayInfo.VirtualModelName
+		other["virtual_model_target"] = relayInfo.RequestedModelName
+	}
+	if relayInfo.SpeechPipeline != "" {
+		other["speech_pipeline"] = relayInfo.SpeechPipeline
+		other["speech_pipeline_leg"] = relayInfo.SpeechPipelineLeg
+	}
+	if relayInfo.AsyncTaskId != "" {
+		other["async_task_id"] = relayInfo.AsyncTaskId
+	}
 
 	isSystemPromptOverwritten := common.GetContextKeyBool(ctx, constant.ContextKeySystemPromptOverride)
 	if isSystemPromptOverwritten {
@@ -0,0 +1,513 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/gin-gonic/gin"
+)
+
+// 媒体存储：生成结果在完成时写入本地磁盘或兼容 S3 的对象存储，数据库中保存稳定的网关地址 /media/:key，
+// 返回给用户时再附加带有效期的签名。过期对象由后台任务定期清理。
+
+const mediaPathPrefix = "/media/"
+
+var ErrMediaQuotaExceeded = errors.New("media storage quota exceeded")
+
+// MediaStore 媒体存储后端
+type MediaStore interface {
+	Put(ctx context.Context, key string, contentType string, data []byte) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type localMediaStore struct {
+	root string
+}
+
+func (s *localMediaStore) path(key string) (string, error) {
+	p := filepath.Join(s.root, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(s.root)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid media key: %s", key)
+	}
+	return p, nil
+}
+
+func (s *localMediaStore) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *localMediaStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *localMediaStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// s3MediaStore 通过 SigV4 签名直接调用 S3 REST 接口，兼容 MinIO 等实现
+type s3MediaStore struct {
+	endpoint  string
+	region    string
+	bucket    string
+	pathStyle bool
+	creds     aws.Credentials
+	signer    *v4.Signer
+}
+
+func (s *s3MediaStore) objectURL(key string) (string, error) {
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return "", err
+	}
+	// u.Path 为未转义路径，由 String() 统一转义
+	if s.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	return u.String(), nil
+}
+
+func (s *s3MediaStore) do(ctx context.Context, method string, key string, contentType string, data []byte) (*http.Response, error) {
+	objectURL, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, objectURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sum := sha256.Sum256(data)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if err := s.signer.SignHTTP(ctx, s.creds, req, payloadHash, "s3", s.region, time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := GetHttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && !(method == http.MethodDelete && resp.StatusCode == http.StatusNotFound) {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: status %d: %s", method, key, resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
+
+func (s *s3MediaStore) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, contentType, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3MediaStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3MediaStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+var (
+	mediaStoreMu    sync.Mutex
+	mediaStoreCache MediaStore
+	mediaStoreSig   string
+)
+
+// GetMediaStore 按后端名称返回存储实现，配置变更后自动重建
+func GetMediaStore(backend string) (MediaStore, error) {
+	setting := operation_setting.GetMediaStorageSetting()
+	if backend == "" {
+		backend = setting.Backend
+	}
+	sig := strings.Join([]string{backend, setting.LocalPath, setting.S3Endpoint, setting.S3Region, setting.S3Bucket,
+		setting.S3AccessKey, setting.S3SecretKey, strconv.FormatBool(setting.S3PathStyle)}, "|")
+	mediaStoreMu.Lock()
+	defer mediaStoreMu.Unlock()
+	if mediaStoreCache != nil && mediaStoreSig == sig {
+		return mediaStoreCache, nil
+	}
+	var store MediaStore
+	switch backend {
+	case operation_setting.MediaStorageBackendLocal:
+		root, err := filepath.Abs(common.GetStringIfEmpty(setting.LocalPath, "data/media"))
+		if err != nil {
+			return nil, err
+		}
+		store = &localMediaStore{root: root}
+	case operation_setting.MediaStorageBackendS3:
+		if setting.S3Endpoint == "" || setting.S3Bucket == "" {
+			return nil, errors.New("s3 endpoint and bucket are required")
+		}
+		store = &s3MediaStore{
+			endpoint:  setting.S3Endpoint,
+			region:    common.GetStringIfEmpty(setting.S3Region, "us-east-1"),
+			bucket:    setting.S3Bucket,
+			pathStyle: setting.S3PathStyle,
+			creds:     aws.Credentials{AccessKeyID: setting.S3AccessKey, SecretAccessKey: setting.S3SecretKey},
+			// S3 的 canonical URI 只转义一次，与 SDK 的 S3 客户端一致
+			signer: v4.NewSigner(func(o *v4.SignerOptions) { o.DisableURIPathEscaping = true }),
+		}
+	default:
+		return nil, fmt.Errorf("unknown media storage backend: %s", backend)
+	}
+	mediaStoreCache = store
+	mediaStoreSig = sig
+	return store, nil
+}
+
+func mediaExtension(contentType string) string {
+	switch strings.Split(contentType, ";")[0] {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	case "image/gif":
+		return ".gif"
+	case "audio/mpeg":
+		return ".mp3"
+	case "audio/wav", "audio/x-wav":
+		return ".wav"
+	case "audio/opus", "audio/ogg":
+		return ".ogg"
+	case "audio/aac":
+		return ".aac"
+	case "audio/flac":
+		return ".flac"
+	case "video/mp4":
+		return ".mp4"
+	case "video/webm":
+		return ".webm"
+	}
+	return ""
+}
+
+// PersistMediaBytes 持久化一段媒体数据，超出用户存储配额时返回 ErrMediaQuotaExceeded
+func PersistMediaBytes(ctx context.Context, userId int, source string, sourceId string, contentType string, data []byte) (*model.MediaObject, error) {
+	setting := operation_setting.GetMediaStorageSetting()
+	// 保留天数与存储配额按用户所在分组计算
+	group, err := model.GetUserGroup(userId, false)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(data))
+	if setting.MaxObjectMB > 0 && size > int64(setting.MaxObjectMB)<<20 {
+		return nil, fmt.Errorf("media object too large: %d bytes", size)
+	}
+	store, err := GetMediaStore(setting.Backend)
+	if err != nil {
+		return nil, err
+	}
+	// 配额检查与占用累加为同一条条件更新，并发写入不会超出配额；保存失败时释放
+	reserved, err := model.ReserveUserMediaUsage(userId, size, setting.GetQuotaBytes(group))
+	if err != nil {
+		return nil, err
+	}
+	if !reserved {
+		return nil, ErrMediaQuotaExceeded
+	}
+	saved := false
+	defer func() {
+		if !saved {
+			if err := model.ReleaseUserMediaUsage(userId, size); err != nil {
+				common.SysLog(fmt.Sprintf("failed to release media usage of user %d: %s", userId, err.Error()))
+			}
+		}
+	}()
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	now := time.Now()
+	objectKey := common.GetRandomString(32) + mediaExtension(contentType)
+	storageKey := fmt.Sprintf("%s/%d/%s", source, userId, objectKey)
+	if setting.Backend == operation_setting.MediaStorageBackendS3 {
+		storageKey = setting.S3Prefix + storageKey
+	}
+	if err := store.Put(ctx, storageKey, contentType, data); err != nil {
+		return nil, err
+	}
+	obj := &model.MediaObject{
+		ObjectKey:   objectKey,
+		UserId:      userId,
+		Source:      source,
+		SourceId:    sourceId,
+		ContentType: contentType,
+		Size:        size,
+		Backend:     setting.Backend,
+		StorageKey:  storageKey,
+		CreatedAt:   now.Unix(),
+	}
+	if days := setting.GetRetentionDays(group); days > 0 {
+		obj.ExpiresAt = now.AddDate(0, 0, days).Unix()
+	}
+	if err := obj.Insert(); err != nil {
+		_ = store.Delete(ctx, storageKey)
+		return nil, err
+	}
+	saved = true
+	return obj, nil
+}
+
+// PersistMediaFromResponse 读取上游响应并持久化
+func PersistMediaFromResponse(ctx context.Context, userId int, source string, sourceId string, resp *http.Response) (*model.MediaObject, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+	limit := int64(operation_setting.GetMediaStorageSetting().MaxObjectMB) << 20
+	reader := io.Reader(resp.Body)
+	if limit > 0 {
+		reader = io.LimitReader(resp.Body, limit+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return PersistMediaBytes(ctx, userId, source, sourceId, resp.Header.Get("Content-Type"), data)
+}
+
+// PersistMediaFromURL 下载并持久化上游返回的媒体链接，已经是网关媒体地址时直接返回
+func PersistMediaFromURL(ctx context.Context, userId int, source string, sourceId string, rawURL string) (string, error) {
+	if rawURL == "" || IsMediaURL(rawURL) {
+		return rawURL, nil
+	}
+	if strings.HasPrefix(rawURL, "data:") {
+		contentType, data, err := decodeMediaDataURL(rawURL)
+		if err != nil {
+			return "", err
+		}
+		obj, err := PersistMediaBytes(ctx, userId, source, sourceId, contentType, data)
+		if err != nil {
+			return "", err
+		}
+		return MediaObjectURL(obj), nil
+	}
+	resp, err := DoDownloadRequest(rawURL, "persist media")
+	if err != nil {
+		return "", err
+	}
+	obj, err := PersistMediaFromResponse(ctx, userId, source, sourceId, resp)
+	if err != nil {
+		return "", err
+	}
+	return MediaObjectURL(obj), nil
+}
+
+func decodeMediaDataURL(dataURL string) (string, []byte, error) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return "", nil, errors.New("unsupported data url")
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	return strings.TrimSuffix(header, ";base64"), data, err
+}
+
+// MediaObjectURL 返回媒体的稳定网关地址（未签名），用于落库
+func MediaObjectURL(obj *model.MediaObject) string {
+	return strings.TrimSuffix(system_setting.ServerAddress, "/") + mediaPathPrefix + obj.ObjectKey
+}
+
+// IsMediaURL 判断是否为网关媒体地址
+func IsMediaURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, strings.TrimSuffix(system_setting.ServerAddress, "/")+mediaPathPrefix)
+}
+
+func mediaSignature(key string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(common.CryptoSecret))
+	mac.Write([]byte(key + ":" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignMediaURL 为网关媒体地址附加带有效期的签名，其他地址原样返回
+func SignMediaURL(rawURL string) string {
+	if !IsMediaURL(rawURL) {
+		return rawURL
+	}
+	base, _, _ := strings.Cut(rawURL, "?")
+	key := base[strings.LastIndex(base, "/")+1:]
+	expireSeconds := operation_setting.GetMediaStorageSetting().UrlExpireSeconds
+	if expireSeconds <= 0 {
+		expireSeconds = 3600
+	}
+	expires := time.Now().Unix() + int64(expireSeconds)
+	return fmt.Sprintf("%s?expires=%d&signature=%s", base, expires, mediaSignature(key, expires))
+}
+
+// SignMediaURLsInBody 为响应体中所有网关媒体地址附加签名
+func SignMediaURLsInBody(body []byte) []byte {
+	base := strings.TrimSuffix(system_setting.ServerAddress, "/")
+	if !bytes.Contains(body, []byte(base+mediaPathPrefix)) {
+		return body
+	}
+	pattern, err := regexp.Compile(regexp.QuoteMeta(base+mediaPathPrefix) + `[A-Za-z0-9]+(?:\.[a-z0-9]+)?(?:\?[^"'\s]*)?`)
+	if err != nil {
+		return body
+	}
+	return pattern.ReplaceAllFunc(body, func(match []byte) []byte {
+		return []byte(SignMediaURL(string(match)))
+	})
+}
+
+// VerifyMediaSignature 校验签名链接
+func VerifyMediaSignature(key string, expiresStr string, signature string) bool {
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || expires < time.Now().Unix() {
+		return false
+	}
+	return hmac.Equal([]byte(mediaSignature(key, expires)), []byte(signature))
+}
+
+// OpenMediaObject 打开媒体内容
+func OpenMediaObject(ctx context.Context, obj *model.MediaObject) (io.ReadCloser, error) {
+	store, err := GetMediaStore(obj.Backend)
+	if err != nil {
+		return nil, err
+	}
+	return store.Get(ctx, obj.StorageKey)
+}
+
+// GetMediaObjectByURL 根据网关媒体地址查找媒体记录
+func GetMediaObjectByURL(rawURL string) (*model.MediaObject, error) {
+	if !IsMediaURL(rawURL) {
+		return nil, errors.New("not a media url")
+	}
+	base, _, _ := strings.Cut(rawURL, "?")
+	return model.GetMediaObjectByKey(base[strings.LastIndex(base, "/")+1:])
+}
+
+// ServeMediaObject 将存储中的媒体内容写入响应
+func ServeMediaObject(c *gin.Context, obj *model.MediaObject) {
+	reader, err := OpenMediaObject(c.Request.Context(), obj)
+	if err != nil {
+		logger.LogError(c.Request.Context(), fmt.Sprintf("failed to open media object %s: %s", obj.ObjectKey, err.Error()))
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": gin.H{
+				"message": "failed to read media",
+				"type":    "server_error",
+			},
+		})
+		return
+	}
+	defer reader.Close()
+	c.Writer.Header().Set("Content-Type", obj.ContentType)
+	c.Writer.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+	c.Writer.Header().Set("Cache-Control", "private, max-age=3600")
+	c.Writer.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(c.Writer, reader); err != nil {
+		logger.LogError(c.Request.Context(), fmt.Sprintf("failed to stream media object %s: %s", obj.ObjectKey, err.Error()))
+	}
+}
+
+// DeleteMediaObject 删除媒体内容及记录
+func DeleteMediaObject(ctx context.Context, obj *model.MediaObject) error {
+	store, err := GetMediaStore(obj.Backend)
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(ctx, obj.StorageKey); err != nil {
+		return err
+	}
+	return model.DeleteMediaObject(obj)
+}
+
+// StartMediaStorageGC 定期清理过期媒体
+func StartMediaStorageGC() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !common.IsMasterNode {
+			continue
+		}
+		CleanupExpiredMedia()
+	}
+}
+
+// CleanupExpiredMedia 删除所有已过期的媒体，返回删除数量
+func CleanupExpiredMedia() int {
+	deleted := 0
+	for {
+		objects, err := model.GetExpiredMediaObjects(time.Now().Unix(), 100)
+		if err != nil {
+			common.SysLog("failed to get expired media objects: " + err.Error())
+			return deleted
+		}
+		if len(objects) == 0 {
+			return deleted
+		}
+		for _, obj := range objects {
+			if err := DeleteMediaObject(context.Background(), obj); err != nil {
+				common.SysLog(fmt.Sprintf("failed to delete media object %s: %s", obj.ObjectKey, err.Error()))
+				// 存储删除失败时推迟到下次清理，避免阻塞整批
+				_ = model.UpdateMediaObjectExpiresAt(obj.Id, time.Now().Add(time.Hour).Unix())
+				continue
+			}
+			deleted++
+		}
+	}
+}
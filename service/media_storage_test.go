@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	s3TestAccessKey = "AKIDTEST"
+	s3TestSecretKey = "s3-test-secret"
+	s3TestRegion    = "us-west-2"
+	s3TestBucket    = "media"
+)
+
+type s3TestObject struct {
+	contentType string
+	data        []byte
+}
+
+// s3TestServer 模拟兼容 S3 的对象存储：按 SigV4 规则独立重算签名，签名不符时返回 403 SignatureDoesNotMatch
+type s3TestServer struct {
+	t         *testing.T
+	secretKey string
+
+	mu      sync.Mutex
+	objects map[string]s3TestObject
+	auths   []string
+}
+
+func (s *s3TestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if code, ok := s.verify(r, body); !ok {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("<Error><Code>" + code + "</Code></Error>"))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auths = append(s.auths, r.Header.Get("Authorization"))
+	switch r.Method {
+	case http.MethodPut:
+		s.objects[r.URL.Path] = s3TestObject{contentType: r.Header.Get("Content-Type"), data: body}
+	case http.MethodGet:
+		obj, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		_, _ = w.Write(obj.data)
+	case http.MethodDelete:
+		delete(s.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func s3TestHmac(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// verify 按 AWS SigV4 规范构造 canonical request 与 string to sign，并与请求中的签名比较
+func (s *s3TestServer) verify(r *http.Request, body []byte) (string, bool) {
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	if r.Header.Get("X-Amz-Content-Sha256") != payloadHash {
+		return "XAmzContentSHA256Mismatch", false
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	auth := r.Header.Get("Authorization")
+	if amzDate == "" || !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return "AccessDenied", false
+	}
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[name] = value
+	}
+	scope := amzDate[:8] + "/" + s3TestRegion + "/s3/aws4_request"
+	if fields["Credential"] != s3TestAccessKey+"/"+scope {
+		return "InvalidAccessKeyId", false
+	}
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	if !sort.StringsAreSorted(signedHeaders) {
+		return "AccessDenied", false
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{r.Method, r.URL.EscapedPath(), r.URL.RawQuery,
+		canonicalHeaders.String(), fields["SignedHeaders"], payloadHash}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := s3TestHmac([]byte("AWS4"+s.secretKey), amzDate[:8])
+	key = s3TestHmac(key, s3TestRegion)
+	key = s3TestHmac(key, "s3")
+	key = s3TestHmac(key, "aws4_request")
+	if !hmac.Equal([]byte(hex.EncodeToString(s3TestHmac(key, stringToSign))), []byte(fields["Signature"])) {
+		return "SignatureDoesNotMatch", false
+	}
+	return "", true
+}
+
+// setupS3MediaStorage 初始化数据库与指向模拟 S3 的媒体存储配置
+func setupS3MediaStorage(t *testing.T) (*s3TestServer, *model.User) {
+	common.RedisEnabled = false
+	common.IsMasterNode = true
+	common.SQLitePath = filepath.Join(t.TempDir(), "media-storage.db")
+	require.NoError(t, model.InitDB())
+	InitHttpClient()
+
+	s3 := &s3TestServer{t: t, secretKey: s3TestSecretKey, objects: map[string]s3TestObject{}}
+	server := httptest.NewServer(s3)
+	t.Cleanup(server.Close)
+
+	setting := operation_setting.GetMediaStorageSetting()
+	origin := *setting
+	originAddress := system_setting.ServerAddress
+	t.Cleanup(func() {
+		*setting = origin
+		system_setting.ServerAddress = originAddress
+	})
+	setting.Enabled = true
+	setting.Backend = operation_setting.MediaStorageBackendS3
+	setting.S3Endpoint = server.URL
+	setting.S3Region = s3TestRegion
+	setting.S3Bucket = s3TestBucket
+	setting.S3AccessKey = s3TestAccessKey
+	setting.S3SecretKey = s3TestSecretKey
+	setting.S3PathStyle = true
+	setting.S3Prefix = "gw media/" // 含空格，覆盖路径转义后的签名
+	setting.UrlExpireSeconds = 600
+	system_setting.ServerAddress = "https://gateway.example.com"
+
+	user := &model.User{Username: "media", Password: "password123", Group: "default", Status: common.UserStatusEnabled}
+	require.NoError(t, model.DB.Create(user).Error)
+	return s3, user
+}
+
+func TestS3MediaStore_UploadSignedURLAndDelete(t *testing.T) {
+	s3, user := setupS3MediaStorage(t)
+	data := []byte("\x89PNG fake image bytes")
+
+	obj, err := PersistMediaBytes(context.Background(), user.Id, operation_setting.MediaSourceImage, "task-1", "image/png", data)
+	require.NoError(t, err)
+	require.Equal(t, operation_setting.MediaStorageBackendS3, obj.Backend)
+	require.Equal(t, "gw media/image/"+strconv.Itoa(user.Id)+"/"+obj.ObjectKey, obj.StorageKey)
+
+	// 上传使用 path-style 地址，内容与签名均通过模拟 S3 的校验
+	stored, ok := s3.objects["/"+s3TestBucket+"/"+obj.StorageKey]
+	require.True(t, ok)
+	require.Equal(t, "image/png", stored.contentType)
+	require.Equal(t, data, stored.data)
+	require.Len(t, s3.auths, 1)
+	date := time.Now().UTC().Format("20060102")
+	require.Contains(t, s3.auths[0], "Credential="+s3TestAccessKey+"/"+date+"/"+s3TestRegion+"/s3/aws4_request")
+	require.Contains(t, s3.auths[0], "x-amz-content-sha256;x-amz-date")
+
+	// 网关签名链接：有效期内可校验，篡改或过期均失败
+	signed := SignMediaURL(MediaObjectURL(obj))
+	require.True(t, strings.HasPrefix(signed, "https://gateway.example.com/media/"+obj.ObjectKey+"?"))
+	parsed, err := url.Parse(signed)
+	require.NoError(t, err)
+	expires, signature := parsed.Query().Get("expires"), parsed.Query().Get("signature")
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	require.NoError(t, err)
+	require.InDelta(t, time.Now().Unix()+600, expiresAt, 5)
+	require.True(t, VerifyMediaSignature(obj.ObjectKey, expires, signature))
+	require.False(t, VerifyMediaSignature(obj.ObjectKey+"x", expires, signature))
+	require.False(t, VerifyMediaSignature(obj.ObjectKey, strconv.FormatInt(expiresAt+1, 10), signature))
+	past := time.Now().Unix() - 1
+	require.False(t, VerifyMediaSignature(obj.ObjectKey, strconv.FormatInt(past, 10), mediaSignature(obj.ObjectKey, past)))
+
+	// 通过签名链接找到记录后，从 S3 读取内容返回
+	found, err := GetMediaObjectByURL(signed)
+	require.NoError(t, err)
+	require.Equal(t, obj.Id, found.Id)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, parsed.RequestURI(), nil)
+	ServeMediaObject(c, found)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, "image/png", recorder.Header().Get("Content-Type"))
+	require.Equal(t, data, recorder.Body.Bytes())
+
+	require.NoError(t, DeleteMediaObject(context.Background(), found))
+	require.Empty(t, s3.objects)
+	_, err = model.GetMediaObjectByKey(obj.ObjectKey)
+	require.Error(t, err)
+}
+
+func TestS3MediaStore_RejectsBadSignature(t *testing.T) {
+	s3, user := setupS3MediaStorage(t)
+	// 密钥变更后存储实例重建，使用错误密钥签名的请求被拒绝且不落库
+	operation_setting.GetMediaStorageSetting().S3SecretKey = "wrong-secret"
+
+	_, err := PersistMediaBytes(context.Background(), user.Id, operation_setting.MediaSourceAudio, "", "audio/mpeg", []byte("mp3"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "status 403")
+	require.Contains(t, err.Error(), "SignatureDoesNotMatch")
+	require.Empty(t, s3.objects)
+	var count int64
+	require.NoError(t, model.DB.Model(&model.MediaObject{}).Count(&count).Error)
+	require.Zero(t, count)
+	// 上传失败时释放预占的存储空间
+	require.Zero(t, getTestMediaUsage(t, user.Id))
+}
+
+func getTestMediaUsage(t *testing.T, userId int) int64 {
+	var usage model.MediaUsage
+	require.NoError(t, model.DB.First(&usage, "user_id = ?", userId).Error)
+	return usage.UsedBytes
+}
+
+func TestPersistMediaBytes_ConcurrentWritesRespectQuota(t *testing.T) {
+	_, user := setupS3MediaStorage(t)
+	operation_setting.GetMediaStorageSetting().UserQuotaMB = 1
+	data := make([]byte, 400<<10)
+
+	// 1MB 配额只能容纳两个 400KB 的对象，并发写入时不会超出
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var saved []*model.MediaObject
+	exceeded := 0
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			obj, err := PersistMediaBytes(context.Background(), user.Id, operation_setting.MediaSourceImage, "", "image/png", data)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				require.ErrorIs(t, err, ErrMediaQuotaExceeded)
+				exceeded++
+				return
+			}
+			saved = append(saved, obj)
+		}()
+	}
+	wg.Wait()
+	require.Len(t, saved, 2)
+	require.Equal(t, 4, exceeded)
+	require.Equal(t, int64(2*len(data)), getTestMediaUsage(t, user.Id))
+	used, err := model.GetUserMediaUsage(user.Id)
+	require.NoError(t, err)
+	require.Equal(t, int64(2*len(data)), used)
+
+	// 删除后释放空间，可以再次写入
+	require.NoError(t, DeleteMediaObject(context.Background(), saved[0]))
+	require.Equal(t, int64(len(data)), getTestMediaUsage(t, user.Id))
+	_, err = PersistMediaBytes(context.Background(), user.Id, operation_setting.MediaSourceImage, "", "image/png", data)
+	require.NoError(t, err)
+	_, err = PersistMediaBytes(context.Background(), user.Id, operation_setting.MediaSourceImage, "", "image/png", data)
+	require.ErrorIs(t, err, ErrMediaQuotaExceeded)
+}
+
+func TestS3MediaStore_VirtualHostedObjectURL(t *testing.T) {
+	store := &s3MediaStore{endpoint: "https://s3.example.com/base", bucket: s3TestBucket}
+	objectURL, err := store.objectURL("gw/video/1/a b.mp4")
+	require.NoError(t, err)
+	require.Equal(t, "https://media.s3.example.com/base/gw/video/1/a%20b.mp4", objectURL)
+
+	store.pathStyle = true
+	objectURL, err = store.objectURL("gw/video/1/a b.mp4")
+	require.NoError(t, err)
+	require.Equal(t, "https://s3.example.com/base/media/gw/video/1/a%20b.mp4", objectURL)
+}
@@ -13,8 +13,10 @@ import (
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
 	relayconstant "github.com/QuantumNous/new-api/relay/constant"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/system_setting"
 
 	"github.com/gin-gonic/gin"
 )
@@ -162,6 +164,28 @@ func ConvertSimpleChangeParams(content string) *dto.MidjourneyRequest {
 	return changeParams
 }
 
+// SanitizeMidjourneyRequest 按系统设置移除或改写不允许透传到上游的请求字段
+func SanitizeMidjourneyRequest(mapResult map[string]interface{}) {
+	if mapResult == nil {
+		return
+	}
+	if !setting.MjAccountFilterEnabled {
+		delete(mapResult, "accountFilter")
+	}
+	if !setting.MjNotifyEnabled {
+		delete(mapResult, "notifyHook")
+	}
+	if setting.MjModeClearEnabled {
+		if prompt, ok := mapResult["prompt"].(string); ok {
+			prompt = strings.Replace(prompt, "--fast", "", -1)
+			prompt = strings.Replace(prompt, "--relax", "", -1)
+			prompt = strings.Replace(prompt, "--turbo", "", -1)
+
+			mapResult["prompt"] = prompt
+		}
+	}
+}
+
 func DoMidjourneyHttpRequest(c *gin.Context, timeout time.Duration, fullRequestURL string) (*dto.MidjourneyResponseWithStatusCode, []byte, error) {
 	var nullBytes []byte
 	//var requestBody io.Reader
@@ -174,24 +198,10 @@ func DoMidjourneyHttpRequest(c *gin.Context, timeout time.Duration, fullRequestU
 		if err != nil {
 			return MidjourneyErrorWithStatusCodeWrapper(constant.MjErrorUnknown, "read_request_body_failed", http.StatusInternalServerError), nullBytes, err
 		}
-		if !setting.MjAccountFilterEnabled {
-			delete(mapResult, "accountFilter")
-		}
-		if !setting.MjNotifyEnabled {
-			delete(mapResult, "notifyHook")
-		}
 		//req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
 		// make new request with mapResult
 	}
-	if setting.MjModeClearEnabled {
-		if prompt, ok := mapResult["prompt"].(string); ok {
-			prompt = strings.Replace(prompt, "--fast", "", -1)
-			prompt = strings.Replace(prompt, "--relax", "", -1)
-			prompt = strings.Replace(prompt, "--turbo", "", -1)
-
-			mapResult["prompt"] = prompt
-		}
-	}
+	SanitizeMidjourneyRequest(mapResult)
 	reqBody, err := json.Marshal(mapResult)
 	if err != nil {
 		return MidjourneyErrorWithStatusCodeWrapper(constant.MjErrorUnknown, "marshal_request_body_failed", http.StatusInternalServerError), nullBytes, err
@@ -257,3 +267,53 @@ func DoMidjourneyHttpRequest(c *gin.Context, timeout time.Duration, fullRequestU
 		Response:   midjResponse,
 	}, responseBody, nil
 }
+
+// CoverMidjourneyTaskDto 将任务转换为 /mj 接口与 notifyHook 回调使用的 Midjourney 格式
+func CoverMidjourneyTaskDto(originTask *model.Midjourney) (midjourneyTask dto.MidjourneyDto) {
+	midjourneyTask.MjId = originTask.MjId
+	midjourneyTask.Progress = originTask.Progress
+	midjourneyTask.PromptEn = originTask.PromptEn
+	midjourneyTask.State = originTask.State
+	midjourneyTask.SubmitTime = originTask.SubmitTime
+	midjourneyTask.StartTime = originTask.StartTime
+	midjourneyTask.FinishTime = originTask.FinishTime
+	midjourneyTask.ImageUrl = ""
+	if originTask.ImageUrl != "" && setting.MjForwardUrlEnabled {
+		midjourneyTask.ImageUrl = system_setting.ServerAddress + "/mj/image/" + originTask.MjId
+		if originTask.Status != "SUCCESS" {
+			midjourneyTask.ImageUrl += "?rand=" + strconv.FormatInt(time.Now().UnixNano(), 10)
+		}
+	} else {
+		midjourneyTask.ImageUrl = SignMediaURL(originTask.ImageUrl)
+	}
+	if originTask.VideoUrl != "" {
+		midjourneyTask.VideoUrl = SignMediaURL(originTask.VideoUrl)
+	}
+	midjourneyTask.Status = originTask.Status
+	midjourneyTask.FailReason = originTask.FailReason
+	midjourneyTask.Action = originTask.Action
+	midjourneyTask.Description = originTask.Description
+	midjourneyTask.Prompt = originTask.Prompt
+	if originTask.Buttons != "" {
+		var buttons []dto.ActionButton
+		err := json.Unmarshal([]byte(originTask.Buttons), &buttons)
+		if err == nil {
+			midjourneyTask.Buttons = buttons
+		}
+	}
+	if originTask.VideoUrls != "" {
+		var videoUrls []dto.ImgUrls
+		err := json.Unmarshal([]byte(originTask.VideoUrls), &videoUrls)
+		if err == nil {
+			midjourneyTask.VideoUrls = videoUrls
+		}
+	}
+	if originTask.Properties != "" {
+		var properties dto.Properties
+		err := json.Unmarshal([]byte(originTask.Properties), &properties)
+		if err == nil {
+			midjourneyTask.Properties = &properties
+		}
+	}
+	return
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/model_setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetModelFallbackChain 获取请求模型在当前分组下的回退链，并过滤掉令牌无权访问的模型
+func GetModelFallbackChain(c *gin.Context, group string, requestedModel string) []string {
+	chain := model_setting.GetModelFallbackChain(group, requestedModel)
+	if len(chain) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(chain))
+	for _, m := range chain {
+		if tokenAllowsModel(c, m) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// GetNextFallbackModel 返回回退链中下一个尚未尝试过的模型，没有时返回空字符串
+func GetNextFallbackModel(c *gin.Context, group string, requestedModel string, tried []string) string {
+	for _, m := range GetModelFallbackChain(c, group, requestedModel) {
+		if !slices.Contains(tried, m) {
+			return m
+		}
+	}
+	return ""
+}
+
+// ShouldFallbackModel 判断当前模型失败后是否应切换到回退链中的下一个模型
+// exhausted 表示当前模型的渠道已全部尝试或已无可用渠道
+func ShouldFallbackModel(c *gin.Context, err *types.NewAPIError, exhausted bool) bool {
+	if err == nil {
+		return false
+	}
+	if c.Writer.Written() {
+		// 已经向客户端写入了响应，无法再切换模型
+		return false
+	}
+	if model_setting.IsModelFallbackTrigger(err.StatusCode, string(err.GetErrorCode())) {
+		return true
+	}
+	if err.GetErrorCode() == types.ErrorCodeGetChannelFailed {
+		// 当前模型已无可用渠道
+		return true
+	}
+	return exhausted
+}
+
+// CacheGetFallbackChannel 当请求模型在分组内没有可用渠道时，按回退链查找第一个有可用渠道的模型
+func CacheGetFallbackChannel(c *gin.Context, group string, requestedModel string) (*model.Channel, string, string) {
+	for _, fallbackModel := range GetModelFallbackChain(c, group, requestedModel) {
+		channel, selectGroup, err := CacheGetRandomSatisfiedChannel(&RetryParam{
+			Ctx:        c,
+			ModelName:  fallbackModel,
+			TokenGroup: group,
+			Retry:      common.GetPointer(0),
+		})
+		if err == nil && channel != nil {
+			return channel, selectGroup, fallbackModel
+		}
+	}
+	return nil, group, ""
+}
+
+func tokenAllowsModel(c *gin.Context, modelName string) bool {
+	if !common.GetContextKeyBool(c, constant.ContextKeyTokenModelLimitEnabled) {
+		return true
+	}
+	s, ok := common.GetContextKey(c, constant.ContextKeyTokenModelLimit)
+	if !ok {
+		return false
+	}
+	tokenModelLimit, ok := s.(map[string]bool)
+	if !ok {
+		return false
+	}
+	_, ok = tokenModelLimit[ratio_setting.FormatMatchingModelName(modelName)]
+	return ok
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/relay/helper"
+
+	"github.com/samber/lo"
+)
+
+// OverridePreviewRequest 管理端测试参数覆盖与请求头覆盖，字段为 nil 时使用 ChannelId 对应渠道已保存的配置
+type OverridePreviewRequest struct {
+	ChannelId      int                    `json:"channel_id"`
+	ModelMapping   *string                `json:"model_mapping"`
+	ParamOverride  *string                `json:"param_override"`
+	HeaderOverride *string                `json:"header_override"`
+	Request        json.RawMessage        `json:"request"`      // 示例请求体
+	RequestPath    string                 `json:"request_path"` // 如 /v1/chat/completions
+	Context        map[string]interface{} `json:"context"`      // 覆盖默认生成的条件上下文
+}
+
+type OverridePreviewResult struct {
+	OriginalModel string                           `json:"original_model,omitempty"`
+	UpstreamModel string                           `json:"upstream_model,omitempty"`
+	Context       map[string]interface{}           `json:"context"`
+	Legacy        bool                             `json:"legacy"` // 参数覆盖为旧格式（顶层字段直接覆盖）
+	Body          json.RawMessage                  `json:"body,omitempty"`
+	Trace         []relaycommon.ParamOverrideTrace `json:"trace"`
+	Error         string                           `json:"error,omitempty"`
+	Headers       map[string]string                `json:"headers,omitempty"`
+	HeaderError   string                           `json:"header_error,omitempty"`
+}
+
+// PreviewOverride 按照转发时的顺序执行模型映射、参数覆盖与请求头覆盖，不会发送任何请求。
+// 配置本身无法解析时返回 error，执行过程中的错误记录在结果中
+func PreviewOverride(req *OverridePreviewRequest) (*OverridePreviewResult, error) {
+	if req.ChannelId > 0 && (req.ModelMapping == nil || req.ParamOverride == nil || req.HeaderOverride == nil) {
+		channel, err := model.GetChannelById(req.ChannelId, false)
+		if err != nil {
+			return nil, err
+		}
+		if req.ModelMapping == nil {
+			req.ModelMapping = channel.ModelMapping
+		}
+		if req.ParamOverride == nil {
+			req.ParamOverride = channel.ParamOverride
+		}
+		if req.HeaderOverride == nil {
+			req.HeaderOverride = channel.HeaderOverride
+		}
+	}
+	if len(req.Request) == 0 {
+		req.Request = json.RawMessage("{}")
+	}
+	var body map[string]interface{}
+	if err := common.Unmarshal(req.Request, &body); err != nil {
+		return nil, errors.New("示例请求必须是 JSON 对象")
+	}
+
+	paramOverride, legacy, err := relaycommon.ParseParamOverride(lo.FromPtr(req.ParamOverride))
+	if err != nil {
+		return nil, err
+	}
+	headerOverride := make(map[string]interface{})
+	if headerJson := strings.TrimSpace(lo.FromPtr(req.HeaderOverride)); headerJson != "" {
+		if err = common.UnmarshalJsonStr(headerJson, &headerOverride); err != nil {
+			return nil, errors.New("请求头覆盖必须是合法的 JSON 对象")
+		}
+	}
+
+	result := &OverridePreviewResult{Legacy: !legacy && len(paramOverride) > 0}
+	requestBody := []byte(req.Request)
+	if originModel, ok := body["model"].(string); ok && originModel != "" {
+		result.OriginalModel = originModel
+		upstreamModel, _, err := helper.ResolveModelMapping(strings.TrimSpace(lo.FromPtr(req.ModelMapping)), originModel)
+		if err != nil {
+			return nil, err
+		}
+		result.UpstreamModel = upstreamModel
+		if upstreamModel != originModel {
+			body["model"] = upstreamModel
+			if requestBody, err = common.Marshal(body); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 与 BuildParamOverrideContext 生成的字段保持一致
+	conditionContext := map[string]interface{}{"is_channel_test": false}
+	if result.UpstreamModel != "" {
+		conditionContext["model"] = result.UpstreamModel
+		conditionContext["upstream_model"] = result.UpstreamModel
+		conditionContext["original_model"] = result.OriginalModel
+	}
+	if req.RequestPath != "" {
+		conditionContext["request_path"] = req.RequestPath
+	}
+	for k, v := range req.Context {
+		conditionContext[k] = v
+	}
+	result.Context = conditionContext
+
+	output, trace, err := relaycommon.ApplyParamOverrideWithTrace(requestBody, paramOverride, conditionContext)
+	result.Trace = trace
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Body = output
+	}
+
+	// 预览中不展开真实密钥
+	headers, err := relaycommon.ApplyHeaderOverride(headerOverride, "{api_key}")
+	if err != nil {
+		result.HeaderError = err.Error()
+	} else {
+		result.Headers = headers
+	}
+	return result, nil
+}
@@ -37,6 +37,13 @@ type QuotaInfo struct {
 	ModelPrice    float64
 	ModelRatio    float64
 	GroupRatio    float64
+	PricingTier   *types.PricingTierInfo
+}
+
+// ApplyPricingTier 按实际提示 tokens 重新匹配阶梯计费，并更新 relayInfo.PriceData
+func ApplyPricingTier(relayInfo *relaycommon.RelayInfo, promptTokens int) {
+	tier := ratio_setting.MatchPricingTier(relayInfo.OriginModelName, promptTokens, relayInfo.PriceData.UsePrice, relayInfo.StartTime)
+	relayInfo.PriceData.ApplyPricingTier(tier)
 }
 
 func hasCustomModelRatio(modelName string, currentRatio float64) bool {
@@ -58,6 +65,9 @@ func calculateAudioQuota(info QuotaInfo) int {
 	}
 
 	completionRatio := decimal.NewFromFloat(ratio_setting.GetCompletionRatio(info.ModelName))
+	if info.PricingTier != nil {
+		completionRatio = completionRatio.Mul(decimal.NewFromFloat(info.PricingTier.CompletionRatioMultiplier))
+	}
 	audioRatio := decimal.NewFromFloat(ratio_setting.GetAudioRatio(info.ModelName))
 	audioCompletionRatio := decimal.NewFromFloat(ratio_setting.GetAudioCompletionRatio(info.ModelName))
 
@@ -120,6 +130,10 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	if ok {
 		actualGroupRatio = userGroupRatio
 	}
+	pricingTier := ratio_setting.MatchPricingTier(modelName, usage.InputTokens, false, relayInfo.StartTime)
+	if pricingTier != nil {
+		modelRatio, _, _ = pricingTier.Apply(modelRatio, 0, 0)
+	}
 
 	quotaInfo := QuotaInfo{
 		InputDetails: TokenDetails{
@@ -130,10 +144,11 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  modelName,
-		UsePrice:   relayInfo.UsePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: actualGroupRatio,
+		ModelName:   modelName,
+		UsePrice:    relayInfo.UsePrice,
+		ModelRatio:  modelRatio,
+		GroupRatio:  actualGroupRatio,
+		PricingTier: pricingTier,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
@@ -164,8 +179,13 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	audioInputTokens := usage.InputTokenDetails.AudioTokens
 	audioOutTokens := usage.OutputTokenDetails.AudioTokens
 
+	ApplyPricingTier(relayInfo, usage.InputTokens)
+
 	tokenName := ctx.GetString("token_name")
 	completionRatio := decimal.NewFromFloat(ratio_setting.GetCompletionRatio(modelName))
+	if relayInfo.PriceData.PricingTier != nil {
+		completionRatio = completionRatio.Mul(decimal.NewFromFloat(relayInfo.PriceData.PricingTier.CompletionRatioMultiplier))
+	}
 	audioRatio := decimal.NewFromFloat(ratio_setting.GetAudioRatio(relayInfo.OriginModelName))
 	audioCompletionRatio := decimal.NewFromFloat(ratio_setting.GetAudioCompletionRatio(modelName))
 
@@ -183,10 +203,11 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  modelName,
-		UsePrice:   usePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: groupRatio,
+		ModelName:   modelName,
+		UsePrice:    usePrice,
+		ModelRatio:  modelRatio,
+		GroupRatio:  groupRatio,
+		PricingTier: relayInfo.PriceData.PricingTier,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
@@ -211,6 +232,9 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		if relayInfo.ChannelIsMultiKey {
+			model.RecordChannelKeyConsumption(relayInfo.ChannelId, relayInfo.ChannelMultiKeyIndex, totalTokens, quota)
+		}
 	}
 
 	logModel := modelName
@@ -242,6 +266,13 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	completionTokens := usage.CompletionTokens
 	modelName := relayInfo.OriginModelName
 
+	// Claude 的 input_tokens 不包含缓存 tokens，阶梯按完整上下文长度匹配
+	contextTokens := usage.PromptTokens
+	if relayInfo.ChannelType != constant.ChannelTypeOpenRouter {
+		contextTokens += usage.PromptTokensDetails.CachedTokens + usage.PromptTokensDetails.CachedCreationTokens
+	}
+	ApplyPricingTier(relayInfo, contextTokens)
+
 	tokenName := ctx.GetString("token_name")
 	completionRatio := relayInfo.PriceData.CompletionRatio
 	modelRatio := relayInfo.PriceData.ModelRatio
@@ -305,6 +336,9 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		if relayInfo.ChannelIsMultiKey {
+			model.RecordChannelKeyConsumption(relayInfo.ChannelId, relayInfo.ChannelMultiKeyIndex, totalTokens, quota)
+		}
 	}
 
 	quotaDelta := quota - relayInfo.FinalPreConsumedQuota
@@ -383,8 +417,13 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 	audioInputTokens := usage.PromptTokensDetails.AudioTokens
 	audioOutTokens := usage.CompletionTokenDetails.AudioTokens
 
+	ApplyPricingTier(relayInfo, usage.PromptTokens)
+
 	tokenName := ctx.GetString("token_name")
 	completionRatio := decimal.NewFromFloat(ratio_setting.GetCompletionRatio(relayInfo.OriginModelName))
+	if relayInfo.PriceData.PricingTier != nil {
+		completionRatio = completionRatio.Mul(decimal.NewFromFloat(relayInfo.PriceData.PricingTier.CompletionRatioMultiplier))
+	}
 	audioRatio := decimal.NewFromFloat(ratio_setting.GetAudioRatio(relayInfo.OriginModelName))
 	audioCompletionRatio := decimal.NewFromFloat(ratio_setting.GetAudioCompletionRatio(relayInfo.OriginModelName))
 
@@ -402,10 +441,12 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  relayInfo.OriginModelName,
-		UsePrice:   usePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: groupRatio,
+		ModelName:   relayInfo.OriginModelName,
+		UsePrice:    usePrice,
+		ModelPrice:  modelPrice,
+		ModelRatio:  modelRatio,
+		GroupRatio:  groupRatio,
+		PricingTier: relayInfo.PriceData.PricingTier,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
@@ -430,6 +471,9 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, u
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		if relayInfo.ChannelIsMultiKey {
+			model.RecordChannelKeyConsumption(relayInfo.ChannelId, relayInfo.ChannelMultiKeyIndex, totalTokens, quota)
+		}
 	}
 
 	quotaDelta := quota - relayInfo.FinalPreConsumedQuota
@@ -503,7 +547,11 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
 
-	if quota > 0 {
+	if relayInfo.SubscriptionId != 0 {
+		// 订阅额度计费，不影响账户余额
+		err = model.DecreaseUserSubscriptionQuota(relayInfo.SubscriptionId, quota)
+		sendEmail = false
+	} else if quota > 0 {
 		err = model.DecreaseUserQuota(relayInfo.UserId, quota)
 	} else {
 		err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false)
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// storedResponseMeta 从上游 response 对象中读取存储所需的字段
+type storedResponseMeta struct {
+	ID     string            `json:"id"`
+	Model  string            `json:"model"`
+	Store  *bool             `json:"store"`
+	Output []json.RawMessage `json:"output"`
+}
+
+// normalizeResponsesInput 将 input 统一为 items 数组，字符串输入视为一条 user 消息
+func normalizeResponsesInput(input json.RawMessage) ([]json.RawMessage, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	switch common.GetJsonType(input) {
+	case "string":
+		var text string
+		if err := common.Unmarshal(input, &text); err != nil {
+			return nil, err
+		}
+		item, err := common.Marshal(map[string]any{
+			"role":    "user",
+			"content": text,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []json.RawMessage{item}, nil
+	case "array":
+		var items []json.RawMessage
+		if err := common.Unmarshal(input, &items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unsupported input type: %s", common.GetJsonType(input))
+	}
+}
+
+// storedOutputItems 将上一轮的输出转换为可以作为输入的 items：
+// reasoning 条目依赖上游的加密状态，跨渠道无法复用，直接丢弃；条目 id 仅对产生它的上游有效，一并移除
+func storedOutputItems(output []json.RawMessage) []json.RawMessage {
+	items := make([]json.RawMessage, 0, len(output))
+	for _, raw := range output {
+		var item map[string]any
+		if err := common.Unmarshal(raw, &item); err != nil {
+			continue
+		}
+		if item["type"] == "reasoning" {
+			continue
+		}
+		delete(item, "id")
+		data, err := common.Marshal(item)
+		if err != nil {
+			continue
+		}
+		items = append(items, data)
+	}
+	return items
+}
+
+// upstreamStoresResponses 判断当前渠道的上游是否保存 response 对象：
+// Azure 与直连 OpenAI 官方地址的渠道会保存，其它 OpenAI 兼容上游（中转、自建服务）通常不支持 previous_response_id
+func upstreamStoresResponses(info *relaycommon.RelayInfo) bool {
+	switch info.ChannelType {
+	case constant.ChannelTypeAzure:
+		return true
+	case constant.ChannelTypeOpenAI:
+		baseURL := strings.TrimSuffix(info.ChannelBaseUrl, "/")
+		return baseURL == "" || baseURL == constant.ChannelBaseURLs[constant.ChannelTypeOpenAI]
+	default:
+		return false
+	}
+}
+
+// canUpstreamResolve 判断上游能否直接读取之前的响应：上游会保存响应、同一渠道且上游确实保存了该响应
+func canUpstreamResolve(info *relaycommon.RelayInfo, stored *model.StoredResponse) bool {
+	if !upstreamStoresResponses(info) {
+		return false
+	}
+	if info.ChannelId != stored.ChannelId {
+		return false
+	}
+	var meta storedResponseMeta
+	if err := common.Unmarshal(stored.Response, &meta); err != nil {
+		return false
+	}
+	return meta.Store == nil || *meta.Store
+}
+
+// ExpandStoredResponseHistory 当请求引用了网关保存的 previous_response_id 且当前渠道无法读取该响应时，
+// 将历史会话展开为 input items 并清除 previous_response_id。响应不在网关存储中时保持请求不变，交由上游处理
+func ExpandStoredResponseHistory(c *gin.Context, info *relaycommon.RelayInfo, request *dto.OpenAIResponsesRequest) error {
+	setting := operation_setting.GetResponseStoreSetting()
+	if !setting.Enabled || request.PreviousResponseID == "" {
+		return nil
+	}
+	stored, err := model.GetUserStoredResponse(info.UserId, request.PreviousResponseID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if canUpstreamResolve(info, stored) {
+		return nil
+	}
+
+	// 从最近一轮向前回溯，chain[0] 为最早的一轮
+	chain := []*model.StoredResponse{stored}
+	visited := map[string]bool{stored.ResponseId: true}
+	for prev := stored.PreviousResponseId; prev != ""; {
+		if setting.MaxChainDepth > 0 && len(chain) >= setting.MaxChainDepth {
+			logger.LogWarn(c, fmt.Sprintf("response chain of %s exceeds max depth %d, older history truncated", request.PreviousResponseID, setting.MaxChainDepth))
+			break
+		}
+		if visited[prev] {
+			break
+		}
+		record, err := model.GetUserStoredResponse(info.UserId, prev)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("previous response %s not found", prev)
+			}
+			return err
+		}
+		visited[prev] = true
+		chain = append([]*model.StoredResponse{record}, chain...)
+		prev = record.PreviousResponseId
+	}
+
+	items := make([]json.RawMessage, 0)
+	for _, record := range chain {
+		var input []json.RawMessage
+		if len(record.Input) > 0 {
+			if err := common.Unmarshal(record.Input, &input); err != nil {
+				return fmt.Errorf("invalid stored input of response %s: %w", record.ResponseId, err)
+			}
+		}
+		items = append(items, input...)
+		var meta storedResponseMeta
+		if err := common.Unmarshal(record.Response, &meta); err != nil {
+			return fmt.Errorf("invalid stored response %s: %w", record.ResponseId, err)
+		}
+		items = append(items, storedOutputItems(meta.Output)...)
+	}
+	current, err := normalizeResponsesInput(request.Input)
+	if err != nil {
+		return err
+	}
+	items = append(items, current...)
+
+	expanded, err := common.Marshal(items)
+	if err != nil {
+		return err
+	}
+	request.Input = expanded
+	request.PreviousResponseID = ""
+	logger.LogDebug(c, fmt.Sprintf("expanded %d stored responses into %d input items", len(chain), len(items)))
+	return nil
+}
+
+// SaveStoredResponse 保存本次请求的输入与上游返回的 response 对象，request 为展开历史之前的原始请求。
+// 请求显式指定 store: false 时不保存
+func SaveStoredResponse(c *gin.Context, info *relaycommon.RelayInfo, request *dto.OpenAIResponsesRequest) {
+	if !operation_setting.GetResponseStoreSetting().Enabled || string(request.Store) == "false" {
+		return
+	}
+	result, ok := common.GetContextKeyType[json.RawMessage](c, constant.ContextKeyResponsesResult)
+	if !ok || len(result) == 0 {
+		return
+	}
+	var meta storedResponseMeta
+	if err := common.Unmarshal(result, &meta); err != nil || meta.ID == "" {
+		return
+	}
+	input, err := normalizeResponsesInput(request.Input)
+	if err != nil {
+		logger.LogWarn(c, fmt.Sprintf("failed to store response %s: %s", meta.ID, err.Error()))
+		return
+	}
+	inputData, err := common.Marshal(input)
+	if err != nil {
+		return
+	}
+	record := &model.StoredResponse{
+		ResponseId:         meta.ID,
+		UserId:             info.UserId,
+		TokenId:            info.TokenId,
+		ChannelId:          info.ChannelId,
+		Model:              info.OriginModelName,
+		PreviousResponseId: request.PreviousResponseID,
+		Input:              inputData,
+		Response:           result,
+		CreatedAt:          time.Now().Unix(),
+	}
+	// 同步写入，保证客户端收到响应后立即发起的下一轮请求能读取到本轮记录
+	if err := record.Insert(); err != nil {
+		logger.LogError(c, fmt.Sprintf("failed to store response %s: %s", record.ResponseId, err.Error()))
+	}
+}
+
+// StartResponseStoreCleanup 按保留天数定期清理网关保存的响应
+func StartResponseStoreCleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !common.IsMasterNode {
+			continue
+		}
+		CleanupExpiredStoredResponses()
+	}
+}
+
+// CleanupExpiredStoredResponses 删除超过保留期的响应，返回删除数量
+func CleanupExpiredStoredResponses() int64 {
+	retentionDays := operation_setting.GetResponseStoreSetting().RetentionDays
+	if retentionDays <= 0 {
+		return 0
+	}
+	before := time.Now().Unix() - int64(retentionDays)*86400
+	var deleted int64
+	for {
+		n, err := model.DeleteStoredResponsesBefore(before, 500)
+		if err != nil {
+			common.SysLog("failed to cleanup stored responses: " + err.Error())
+			return deleted
+		}
+		deleted += n
+		if n == 0 {
+			return deleted
+		}
+	}
+}
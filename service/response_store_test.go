@@ -0,0 +1,121 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+const responseStoreTestUserId = 1
+
+func setupResponseStoreTest(t *testing.T) *gin.Context {
+	common.RedisEnabled = false
+	common.BatchUpdateEnabled = false
+	common.IsMasterNode = true
+	common.SQLitePath = filepath.Join(t.TempDir(), "response_store.db")
+	require.NoError(t, model.InitDB())
+
+	setting := operation_setting.GetResponseStoreSetting()
+	origin := *setting
+	t.Cleanup(func() { *setting = origin })
+	setting.Enabled = true
+	setting.MaxChainDepth = 100
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+	return c
+}
+
+func insertTestStoredResponse(t *testing.T, id string, previous string, channelId int, input string, response string) {
+	record := &model.StoredResponse{ResponseId: id, UserId: responseStoreTestUserId, ChannelId: channelId,
+		PreviousResponseId: previous, Input: json.RawMessage(input), Response: json.RawMessage(response)}
+	require.NoError(t, record.Insert())
+}
+
+func newResponseStoreRelayInfo(channelType int, channelId int, baseURL string) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		UserId: responseStoreTestUserId,
+		ChannelMeta: &relaycommon.ChannelMeta{
+			ChannelType:    channelType,
+			ChannelId:      channelId,
+			ChannelBaseUrl: baseURL,
+		},
+	}
+}
+
+func TestCanUpstreamResolve(t *testing.T) {
+	stored := &model.StoredResponse{ChannelId: 1, Response: json.RawMessage(`{"id":"resp_1"}`)}
+	notStored := &model.StoredResponse{ChannelId: 1, Response: json.RawMessage(`{"id":"resp_1","store":false}`)}
+	tests := []struct {
+		name   string
+		info   *relaycommon.RelayInfo
+		stored *model.StoredResponse
+		want   bool
+	}{
+		{name: "official openai", info: newResponseStoreRelayInfo(constant.ChannelTypeOpenAI, 1, "https://api.openai.com/"), stored: stored, want: true},
+		{name: "openai default base url", info: newResponseStoreRelayInfo(constant.ChannelTypeOpenAI, 1, ""), stored: stored, want: true},
+		{name: "azure", info: newResponseStoreRelayInfo(constant.ChannelTypeAzure, 1, "https://example.openai.azure.com"), stored: stored, want: true},
+		{name: "openai compatible relay", info: newResponseStoreRelayInfo(constant.ChannelTypeOpenAI, 1, "https://relay.example.com"), stored: stored},
+		{name: "other channel type", info: newResponseStoreRelayInfo(constant.ChannelTypeAnthropic, 1, ""), stored: stored},
+		{name: "different channel", info: newResponseStoreRelayInfo(constant.ChannelTypeOpenAI, 2, ""), stored: stored},
+		{name: "upstream did not store", info: newResponseStoreRelayInfo(constant.ChannelTypeOpenAI, 1, ""), stored: notStored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, canUpstreamResolve(tt.info, tt.stored))
+		})
+	}
+}
+
+func TestExpandStoredResponseHistory(t *testing.T) {
+	c := setupResponseStoreTest(t)
+	insertTestStoredResponse(t, "resp_1", "", 1, `[{"role":"user","content":"hi"}]`,
+		`{"id":"resp_1","output":[{"type":"reasoning","id":"rs_1","encrypted_content":"x"},{"type":"message","id":"msg_1","role":"assistant","content":"hello"}]}`)
+	insertTestStoredResponse(t, "resp_2", "resp_1", 1, `[{"role":"user","content":"how are you"}]`,
+		`{"id":"resp_2","output":[{"type":"message","id":"msg_2","role":"assistant","content":"fine"}]}`)
+
+	// 同一官方 OpenAI 渠道可直接读取，请求保持不变
+	request := &dto.OpenAIResponsesRequest{PreviousResponseID: "resp_2", Input: json.RawMessage(`"bye"`)}
+	require.NoError(t, ExpandStoredResponseHistory(c, newResponseStoreRelayInfo(constant.ChannelTypeOpenAI, 1, ""), request))
+	require.Equal(t, "resp_2", request.PreviousResponseID)
+	require.JSONEq(t, `"bye"`, string(request.Input))
+
+	// 其它渠道按时间顺序展开历史，丢弃 reasoning 与条目 id
+	request = &dto.OpenAIResponsesRequest{PreviousResponseID: "resp_2", Input: json.RawMessage(`"bye"`)}
+	require.NoError(t, ExpandStoredResponseHistory(c, newResponseStoreRelayInfo(constant.ChannelTypeOpenAI, 1, "https://relay.example.com"), request))
+	require.Empty(t, request.PreviousResponseID)
+	require.JSONEq(t, `[
+		{"role":"user","content":"hi"},
+		{"type":"message","role":"assistant","content":"hello"},
+		{"role":"user","content":"how are you"},
+		{"type":"message","role":"assistant","content":"fine"},
+		{"role":"user","content":"bye"}
+	]`, string(request.Input))
+
+	// 超过最大回溯深度时截断较早的历史
+	operation_setting.GetResponseStoreSetting().MaxChainDepth = 1
+	request = &dto.OpenAIResponsesRequest{PreviousResponseID: "resp_2", Input: json.RawMessage(`"bye"`)}
+	require.NoError(t, ExpandStoredResponseHistory(c, newResponseStoreRelayInfo(constant.ChannelTypeAnthropic, 3, ""), request))
+	require.JSONEq(t, `[
+		{"role":"user","content":"how are you"},
+		{"type":"message","role":"assistant","content":"fine"},
+		{"role":"user","content":"bye"}
+	]`, string(request.Input))
+
+	// 不在网关存储中的响应交由上游处理
+	request = &dto.OpenAIResponsesRequest{PreviousResponseID: "resp_unknown", Input: json.RawMessage(`"bye"`)}
+	require.NoError(t, ExpandStoredResponseHistory(c, newResponseStoreRelayInfo(constant.ChannelTypeAnthropic, 3, ""), request))
+	require.Equal(t, "resp_unknown", request.PreviousResponseID)
+}
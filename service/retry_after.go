@@ -0,0 +1,125 @@
+package service
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 各上游的限流重置响应头：剩余额度头 -> 重置时间头
+var rateLimitResetHeaders = [][2]string{
+	// OpenAI / Azure，重置时间为时长，如 "1s"、"6m0s"、"20ms"
+	{"x-ratelimit-remaining-requests", "x-ratelimit-reset-requests"},
+	{"x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens"},
+	// Anthropic，重置时间为 RFC 3339 时间
+	{"anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-reset"},
+	{"anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-tokens-reset"},
+	{"anthropic-ratelimit-input-tokens-remaining", "anthropic-ratelimit-input-tokens-reset"},
+	{"anthropic-ratelimit-output-tokens-remaining", "anthropic-ratelimit-output-tokens-reset"},
+}
+
+// ParseUpstreamRetryAfter 从上游的错误响应中解析需要等待的秒数，无法解析时返回 0。依次尝试：
+// Retry-After / retry-after-ms（OpenAI、Azure、Anthropic），已耗尽额度对应的限流重置头（OpenAI、Azure、Anthropic），
+// 以及响应体中 google.rpc.RetryInfo 的 retryDelay（Gemini、Vertex AI）
+func ParseUpstreamRetryAfter(header http.Header, body []byte) int64 {
+	now := time.Now()
+	if v := header.Get("retry-after-ms"); v != "" {
+		if ms, err := strconv.ParseFloat(v, 64); err == nil && ms > 0 {
+			return ceilSeconds(time.Duration(ms * float64(time.Millisecond)))
+		}
+	}
+	if v := header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+			if seconds > 0 {
+				return ceilSeconds(time.Duration(seconds * float64(time.Second)))
+			}
+		} else if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			return ceilSeconds(t.Sub(now))
+		}
+	}
+
+	// 优先使用已耗尽（remaining 为 0）的限流项的重置时间，取最晚的一个；都未耗尽时取最早的一个
+	var exhausted, earliest time.Duration
+	for _, pair := range rateLimitResetHeaders {
+		reset, ok := parseRateLimitReset(header.Get(pair[1]), now)
+		if !ok {
+			continue
+		}
+		if header.Get(pair[0]) == "0" {
+			exhausted = max(exhausted, reset)
+		} else if earliest == 0 || reset < earliest {
+			earliest = reset
+		}
+	}
+	if exhausted > 0 {
+		return ceilSeconds(exhausted)
+	}
+	if earliest > 0 {
+		return ceilSeconds(earliest)
+	}
+
+	if delay := parseGoogleRetryDelay(body); delay > 0 {
+		return ceilSeconds(delay)
+	}
+	return 0
+}
+
+func parseRateLimitReset(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, d > 0
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Sub(now), t.After(now)
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), seconds > 0
+	}
+	return 0, false
+}
+
+// parseGoogleRetryDelay 解析 Google API 错误详情中的 RetryInfo，响应体可能是对象或只含一个对象的数组
+func parseGoogleRetryDelay(body []byte) time.Duration {
+	type googleError struct {
+		Error struct {
+			Details []struct {
+				Type       string `json:"@type"`
+				RetryDelay string `json:"retryDelay"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	var errs []googleError
+	trimmed := strings.TrimSpace(string(body))
+	if strings.HasPrefix(trimmed, "[") {
+		if err := common.UnmarshalJsonStr(trimmed, &errs); err != nil {
+			return 0
+		}
+	} else {
+		var e googleError
+		if err := common.UnmarshalJsonStr(trimmed, &e); err != nil {
+			return 0
+		}
+		errs = append(errs, e)
+	}
+	for _, e := range errs {
+		for _, detail := range e.Error.Details {
+			if !strings.HasSuffix(detail.Type, "google.rpc.RetryInfo") {
+				continue
+			}
+			if d, err := time.ParseDuration(detail.RetryDelay); err == nil && d > 0 {
+				return d
+			}
+		}
+	}
+	return 0
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
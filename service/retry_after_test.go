@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUpstreamRetryAfter(t *testing.T) {
+	now := time.Now()
+	httpDate := func(d time.Duration) string {
+		return now.Add(d).UTC().Format(http.TimeFormat)
+	}
+	tests := []struct {
+		name   string
+		header map[string]string
+		body   string
+		want   int64
+		delta  int64 // HTTP-date 精确到秒，允许的误差
+	}{
+		{name: "delta seconds", header: map[string]string{"Retry-After": "120"}, want: 120},
+		{name: "fractional seconds round up", header: map[string]string{"Retry-After": "1.2"}, want: 2},
+		{name: "milliseconds take precedence", header: map[string]string{"retry-after-ms": "1500", "Retry-After": "30"}, want: 2},
+		{name: "http date", header: map[string]string{"Retry-After": httpDate(90 * time.Second)}, want: 90, delta: 2},
+		{name: "past http date", header: map[string]string{"Retry-After": httpDate(-time.Hour)}},
+		{name: "zero seconds", header: map[string]string{"Retry-After": "0"}},
+		{name: "negative seconds", header: map[string]string{"Retry-After": "-5"}},
+		{name: "garbage", header: map[string]string{"Retry-After": "soon", "retry-after-ms": "later"}},
+		{name: "garbage falls through to reset headers", header: map[string]string{"Retry-After": "soon",
+			"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "6m0s"}, want: 360},
+		{name: "exhausted reset wins over earlier one", header: map[string]string{
+			"x-ratelimit-remaining-requests": "5", "x-ratelimit-reset-requests": "1s",
+			"x-ratelimit-remaining-tokens": "0", "x-ratelimit-reset-tokens": "20s"}, want: 20},
+		{name: "earliest reset when none exhausted", header: map[string]string{
+			"x-ratelimit-remaining-requests": "5", "x-ratelimit-reset-requests": "20ms",
+			"x-ratelimit-remaining-tokens": "100", "x-ratelimit-reset-tokens": "20s"}, want: 1},
+		{name: "anthropic rfc3339 reset", header: map[string]string{
+			"anthropic-ratelimit-requests-remaining": "0",
+			"anthropic-ratelimit-requests-reset":     now.Add(45 * time.Second).UTC().Format(time.RFC3339)}, want: 45, delta: 2},
+		{name: "anthropic past reset", header: map[string]string{
+			"anthropic-ratelimit-requests-remaining": "0",
+			"anthropic-ratelimit-requests-reset":     now.Add(-time.Minute).UTC().Format(time.RFC3339)}},
+		{name: "google retry info", body: `{"error":{"code":429,"details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"37s"}]}}`, want: 37},
+		{name: "google retry info in array", body: `[{"error":{"details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"2.5s"}]}}]`, want: 3},
+		{name: "google other details", body: `{"error":{"details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","retryDelay":"37s"}]}}`},
+		{name: "garbage body", body: `<html>Too Many Requests</html>`},
+		{name: "no hint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tt.header {
+				header.Set(k, v)
+			}
+			got := ParseUpstreamRetryAfter(header, []byte(tt.body))
+			require.InDelta(t, tt.want, got, float64(tt.delta))
+		})
+	}
+}
+
+func TestCoolDownChannel_CapsUpstreamRetryAfter(t *testing.T) {
+	common.RedisEnabled = false
+	setting := operation_setting.GetKeyPoolSetting()
+	origin := *setting
+	t.Cleanup(func() { *setting = origin })
+	setting.MaxCooldownSeconds = 600
+	setting.CooldownSeconds = 30
+
+	// 上游给出的等待时间超过上限时按上限冷却
+	header := http.Header{}
+	header.Set("Retry-After", time.Now().Add(48*time.Hour).UTC().Format(http.TimeFormat))
+	retryAfter := ParseUpstreamRetryAfter(header, nil)
+	require.Greater(t, retryAfter, int64(600))
+	err := types.NewErrorWithStatusCode(errors.New("rate limited"), types.ErrorCodeBadResponseStatusCode, http.StatusTooManyRequests, types.ErrOptionWithRetryAfter(retryAfter))
+	CoolDownChannel(990001, -1, err)
+	require.InDelta(t, time.Now().Unix()+600, model.GetChannelCooldown(990001), 1)
+
+	// 未给出等待时间时使用默认冷却时长
+	CoolDownChannel(990002, -1, types.NewErrorWithStatusCode(errors.New("rate limited"), types.ErrorCodeBadResponseStatusCode, http.StatusTooManyRequests))
+	require.InDelta(t, time.Now().Unix()+30, model.GetChannelCooldown(990002), 1)
+}
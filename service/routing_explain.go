@@ -0,0 +1,338 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/relay/helper"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoutingExplainRequest 路由诊断：按令牌或用户模拟一次请求的鉴权、分组与渠道选择，不会向上游发送请求
+type RoutingExplainRequest struct {
+	TokenId      int    `json:"token_id"`
+	TokenKey     string `json:"token_key"`
+	UserId       int    `json:"user_id"`       // 未指定令牌时按用户诊断
+	Group        string `json:"group"`         // 指定分组（同 playground），为空时使用令牌或用户的分组
+	Model        string `json:"model"`         // 请求的模型
+	PromptTokens int    `json:"prompt_tokens"` // 用于匹配阶梯计费及估算预扣额度
+}
+
+// RoutingCheck 一项检查的结果，顺序与实际请求的处理顺序一致
+type RoutingCheck struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// RoutingChannel 候选渠道及该渠道的模型映射结果
+type RoutingChannel struct {
+	*model.ChannelRouteInfo
+	UpstreamModel string `json:"upstream_model"`
+	MappingError  string `json:"mapping_error,omitempty"`
+}
+
+// RoutingTier 同一优先级的候选渠道
+type RoutingTier struct {
+	Priority int64             `json:"priority"`
+	Strategy string            `json:"strategy"`
+	Channels []*RoutingChannel `json:"channels"`
+}
+
+// RoutingGroup 一个分组下的候选渠道，Selected 为首次请求会使用的优先级
+type RoutingGroup struct {
+	Group    string         `json:"group"`
+	Model    string         `json:"model"` // 实际匹配到 abilities 的模型名（可能是标准化后的名称）
+	Tiers    []*RoutingTier `json:"tiers"`
+	Selected *int64         `json:"selected_priority"`
+}
+
+// RoutingFallback 回退链中的一个模型
+type RoutingFallback struct {
+	Model  string          `json:"model"`
+	Groups []*RoutingGroup `json:"groups"`
+}
+
+// RoutingPrice 将按此计费的价格与倍率
+type RoutingPrice struct {
+	Model             string                 `json:"model"`
+	Group             string                 `json:"group"`
+	UsePrice          bool                   `json:"use_price"`
+	ModelPrice        float64                `json:"model_price"`
+	ModelRatio        float64                `json:"model_ratio"`
+	CompletionRatio   float64                `json:"completion_ratio"`
+	CacheRatio        float64                `json:"cache_ratio"`
+	GroupRatio        float64                `json:"group_ratio"`
+	HasSpecialRatio   bool                   `json:"has_special_ratio"`
+	PricingTier       *types.PricingTierInfo `json:"pricing_tier,omitempty"`
+	Configured        bool                   `json:"configured"`
+	QuotaToPreConsume int                    `json:"quota_to_pre_consume"`
+}
+
+type RoutingExplainResult struct {
+	UserId         int                `json:"user_id"`
+	UserGroup      string             `json:"user_group"`
+	TokenId        int                `json:"token_id,omitempty"`
+	TokenName      string             `json:"token_name,omitempty"`
+	UsingGroup     string             `json:"using_group"`
+	RequestedModel string             `json:"requested_model"`
+	Model          string             `json:"model"` // 解析虚拟模型后的模型
+	Checks         []RoutingCheck     `json:"checks"`
+	RejectedBy     string             `json:"rejected_by,omitempty"` // 第一项未通过的检查，为空表示请求会被接受
+	Groups         []*RoutingGroup    `json:"groups"`                // auto 分组时为解析出的分组链
+	Fallbacks      []*RoutingFallback `json:"fallbacks,omitempty"`
+	SelectedGroup  string             `json:"selected_group,omitempty"`
+	SelectedModel  string             `json:"selected_model,omitempty"`
+	Price          *RoutingPrice      `json:"price,omitempty"`
+}
+
+// check 记录一项检查，failMessage 只在未通过时记录
+func (r *RoutingExplainResult) check(name string, passed bool, failMessage string) {
+	check := RoutingCheck{Name: name, Passed: passed}
+	if !passed {
+		check.Message = failMessage
+		if r.RejectedBy == "" {
+			r.RejectedBy = name
+		}
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// ExplainRouting 按 Distribute 等中间件的顺序复现一次请求的路由决策。诊断不会改变选择状态（如轮询位置），
+// 因此只列出各优先级的候选渠道，不模拟具体选中哪一个
+func ExplainRouting(req *RoutingExplainRequest) (*RoutingExplainResult, error) {
+	if req.Model == "" {
+		return nil, errors.New("未指定模型名称")
+	}
+	result := &RoutingExplainResult{
+		RequestedModel: req.Model,
+		Model:          req.Model,
+		Checks:         make([]RoutingCheck, 0),
+		Groups:         make([]*RoutingGroup, 0),
+	}
+
+	// 令牌
+	var token *model.Token
+	var err error
+	if req.TokenId > 0 {
+		token, err = model.GetTokenById(req.TokenId)
+	} else if req.TokenKey != "" {
+		token, err = model.GetTokenByKey(strings.TrimPrefix(strings.TrimPrefix(req.TokenKey, "Bearer "), "sk-"), true)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("令牌不存在：%w", err)
+	}
+	userId := req.UserId
+	if token != nil {
+		userId = token.UserId
+		result.TokenId = token.Id
+		result.TokenName = token.Name
+		if _, statusErr := token.CheckStatus(); statusErr != nil {
+			result.check("token", false, statusErr.Error())
+		} else {
+			result.check("token", true, "")
+		}
+	}
+	if userId == 0 {
+		return nil, errors.New("请指定令牌或用户")
+	}
+
+	// 用户
+	user, err := model.GetUserCache(userId)
+	if err != nil {
+		return nil, fmt.Errorf("用户不存在：%w", err)
+	}
+	result.UserId = userId
+	result.UserGroup = user.Group
+	result.check("user", user.Status == common.UserStatusEnabled, "用户已被封禁")
+
+	// 分组
+	usingGroup := user.Group
+	if token != nil && token.Group != "" {
+		if _, ok := GetUserUsableGroups(user.Group)[token.Group]; !ok {
+			result.check("token_group", false, fmt.Sprintf("无权访问 %s 分组", token.Group))
+		} else {
+			deprecated := !ratio_setting.ContainsGroupRatio(token.Group) && token.Group != "auto"
+			result.check("token_group", !deprecated, fmt.Sprintf("分组 %s 已被弃用", token.Group))
+		}
+		usingGroup = token.Group
+	}
+	if req.Group != "" && req.Group != usingGroup {
+		result.check("group", GroupInUserUsableGroups(usingGroup, req.Group), "无权访问该分组")
+		usingGroup = req.Group
+	}
+	result.UsingGroup = usingGroup
+
+	// 令牌模型限制
+	c := newRoutingExplainContext(user, token, usingGroup)
+	if token != nil && token.ModelLimitsEnabled {
+		allowed := tokenAllowsModel(c, req.Model)
+		result.check("token_model_limit", allowed, "该令牌无权访问模型 "+req.Model)
+	}
+
+	// 虚拟模型
+	if targetModel, isVirtual := ResolveVirtualModel(c, req.Model, usingGroup); isVirtual {
+		result.Model = targetModel
+	}
+
+	// 分组链与候选渠道
+	groups := []string{usingGroup}
+	if usingGroup == "auto" {
+		groups = GetUserAutoGroup(user.Group)
+		if len(setting.GetAutoGroups()) == 0 {
+			result.check("auto_group", false, "auto groups is not enabled")
+		} else {
+			result.check("auto_group", len(groups) > 0, "用户没有可用的自动分组")
+		}
+	}
+	if result.Groups, result.SelectedGroup, err = explainRoutingGroups(groups, result.Model); err != nil {
+		return nil, err
+	}
+	if result.SelectedGroup != "" {
+		result.SelectedModel = result.Model
+	} else {
+		// 当前模型无可用渠道时，尝试模型回退链
+		for _, fallbackModel := range GetModelFallbackChain(c, usingGroup, result.Model) {
+			fallback := &RoutingFallback{Model: fallbackModel}
+			var selectedGroup string
+			if fallback.Groups, selectedGroup, err = explainRoutingGroups(groups, fallbackModel); err != nil {
+				return nil, err
+			}
+			result.Fallbacks = append(result.Fallbacks, fallback)
+			if selectedGroup != "" {
+				result.SelectedGroup, result.SelectedModel = selectedGroup, fallbackModel
+				break
+			}
+		}
+	}
+	result.check("channel", result.SelectedGroup != "", fmt.Sprintf("分组 %s 下模型 %s 无可用渠道", usingGroup, result.Model))
+
+	// 计费
+	priceModel := common.GetStringIfEmpty(result.SelectedModel, result.Model)
+	priceGroup := common.GetStringIfEmpty(result.SelectedGroup, usingGroup)
+	result.Price = explainRoutingPrice(user, priceModel, priceGroup, req.PromptTokens)
+	result.check("price", result.Price.Configured || user.GetSetting().AcceptUnsetRatioModel, fmt.Sprintf("模型 %s 倍率或价格未配置", priceModel))
+	// 有可用订阅时会优先使用订阅额度，这里只检查账户余额
+	quota := user.Quota
+	result.check("user_quota", quota > 0 && quota >= result.Price.QuotaToPreConsume,
+		fmt.Sprintf("用户额度不足，剩余 %s，需要预扣 %s（不含订阅额度）", logger.FormatQuota(quota), logger.FormatQuota(result.Price.QuotaToPreConsume)))
+	return result, nil
+}
+
+// newRoutingExplainContext 构造与 TokenAuth 写入相同上下文的 gin.Context，供虚拟模型、回退链等按上下文判断的逻辑使用
+func newRoutingExplainContext(user *model.UserBase, token *model.Token, usingGroup string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	c.Set("id", user.Id)
+	user.WriteContext(c)
+	common.SetContextKey(c, constant.ContextKeyUsingGroup, usingGroup)
+	if token != nil {
+		c.Set("token_id", token.Id)
+		c.Set("token_model_limit_enabled", token.ModelLimitsEnabled)
+		if token.ModelLimitsEnabled {
+			c.Set("token_model_limit", token.GetModelLimitsMap())
+		}
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, token.Group)
+		common.SetContextKey(c, constant.ContextKeyTokenCrossGroupRetry, token.CrossGroupRetry)
+	}
+	return c
+}
+
+// explainRoutingGroups 依次列出各分组的候选渠道，返回第一个有可用渠道的分组
+func explainRoutingGroups(groups []string, modelName string) ([]*RoutingGroup, string, error) {
+	result := make([]*RoutingGroup, 0, len(groups))
+	selectedGroup := ""
+	for _, group := range groups {
+		routingGroup, err := explainRoutingGroup(group, modelName)
+		if err != nil {
+			return nil, "", err
+		}
+		result = append(result, routingGroup)
+		if selectedGroup == "" && routingGroup.Selected != nil {
+			selectedGroup = group
+		}
+	}
+	return result, selectedGroup, nil
+}
+
+func explainRoutingGroup(group string, modelName string) (*RoutingGroup, error) {
+	matchedModel, routes, err := model.GetChannelRouteInfos(group, modelName)
+	if err != nil {
+		return nil, err
+	}
+	routingGroup := &RoutingGroup{Group: group, Model: matchedModel, Tiers: make([]*RoutingTier, 0)}
+	var fallbackPriority *int64
+	for i := 0; i < len(routes); {
+		j := i
+		for j < len(routes) && routes[j].Priority == routes[i].Priority {
+			j++
+		}
+		tierRoutes := routes[i:j]
+		tier := &RoutingTier{Priority: routes[i].Priority, Channels: make([]*RoutingChannel, 0, len(tierRoutes))}
+		available := make([]*model.ChannelRouteInfo, 0, len(tierRoutes))
+		for _, route := range tierRoutes {
+			routingChannel := &RoutingChannel{ChannelRouteInfo: route, UpstreamModel: modelName}
+			if route.Channel != nil {
+				mapping := route.Channel.GetModelMapping()
+				if route.Pool != nil {
+					mapping = route.Pool.MergeModelMapping(mapping)
+				}
+				upstream, _, err := helper.ResolveModelMapping(mapping, modelName)
+				if err != nil {
+					routingChannel.MappingError = err.Error()
+				}
+				routingChannel.UpstreamModel = upstream
+			}
+			tier.Channels = append(tier.Channels, routingChannel)
+			if route.Available {
+				available = append(available, route)
+			}
+		}
+		tier.Strategy = model.GetSelectStrategy(group, available)
+		routingGroup.Tiers = append(routingGroup.Tiers, tier)
+		// 余额不足的渠道仅在没有其他渠道时使用
+		for _, route := range available {
+			if !route.BalanceLow && routingGroup.Selected == nil {
+				routingGroup.Selected = common.GetPointer(tier.Priority)
+			} else if route.BalanceLow && fallbackPriority == nil {
+				fallbackPriority = common.GetPointer(tier.Priority)
+			}
+		}
+		i = j
+	}
+	if routingGroup.Selected == nil {
+		routingGroup.Selected = fallbackPriority
+	}
+	return routingGroup, nil
+}
+
+// explainRoutingPrice 使用与 ModelPriceHelper 相同的 CalculateModelPrice 计算价格与倍率
+func explainRoutingPrice(user *model.UserBase, modelName string, group string, promptTokens int) *RoutingPrice {
+	groupRatioInfo := helper.GetGroupRatioInfo(user.Group, group)
+	priceData, configured, _ := helper.CalculateModelPrice(modelName, groupRatioInfo, promptTokens, &types.TokenCountMeta{}, time.Now())
+	return &RoutingPrice{
+		Model:             modelName,
+		Group:             group,
+		UsePrice:          priceData.UsePrice,
+		ModelPrice:        priceData.ModelPrice,
+		ModelRatio:        priceData.ModelRatio,
+		CompletionRatio:   priceData.CompletionRatio,
+		CacheRatio:        priceData.CacheRatio,
+		GroupRatio:        groupRatioInfo.GroupRatio,
+		HasSpecialRatio:   groupRatioInfo.HasSpecialRatio,
+		PricingTier:       priceData.PricingTier,
+		Configured:        configured,
+		QuotaToPreConsume: priceData.QuotaToPreConsume,
+	}
+}
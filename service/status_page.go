@@ -0,0 +1,239 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
+)
+
+// 状态页的统计周期与对应的展示粒度
+var statusPeriods = map[string]struct {
+	duration int64
+	step     int64
+}{
+	"24h": {86400, 3600},
+	"7d":  {7 * 86400, 6 * 3600},
+	"90d": {90 * 86400, 86400},
+}
+
+type StatusCounts struct {
+	RelaySuccess int64 `json:"relay_success"`
+	RelayFailure int64 `json:"relay_failure"`
+	TestSuccess  int64 `json:"test_success"`
+	TestFailure  int64 `json:"test_failure"`
+}
+
+type StatusPoint struct {
+	Time         int64         `json:"time,omitempty"`
+	Availability *float64      `json:"availability"` // 可用率（%），无数据时为 null
+	AvgLatency   *int64        `json:"avg_latency"`  // 成功请求的平均耗时（毫秒），无数据时为 null
+	Counts       *StatusCounts `json:"counts,omitempty"`
+}
+
+type ModelStatus struct {
+	Model string `json:"model"`
+	StatusPoint
+	Series []StatusPoint `json:"series"`
+}
+
+type StatusIncident struct {
+	ChannelId  int      `json:"channel_id,omitempty"`
+	KeyIndex   *int     `json:"key_index,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+	Models     []string `json:"models"`
+	StartedAt  int64    `json:"started_at"`
+	ResolvedAt int64    `json:"resolved_at"`
+}
+
+type StatusReport struct {
+	Period    string           `json:"period"`
+	Step      int64            `json:"step"`
+	UpdatedAt int64            `json:"updated_at"`
+	Models    []*ModelStatus   `json:"models"`
+	Incidents []StatusIncident `json:"incidents"`
+}
+
+type statusAccumulator struct {
+	StatusCounts
+	latency int64
+}
+
+func (a *statusAccumulator) add(sum *model.ChannelStatSum) {
+	a.RelaySuccess += sum.RelaySuccess
+	a.RelayFailure += sum.RelayFailure
+	a.TestSuccess += sum.TestSuccess
+	a.TestFailure += sum.TestFailure
+	a.latency += sum.RelayLatency + sum.TestLatency
+}
+
+func (a *statusAccumulator) point(t int64, withCounts bool) StatusPoint {
+	point := StatusPoint{Time: t}
+	success := a.RelaySuccess + a.TestSuccess
+	total := success + a.RelayFailure + a.TestFailure
+	if total > 0 {
+		availability := float64(success) * 100 / float64(total)
+		point.Availability = &availability
+	}
+	if success > 0 {
+		latency := a.latency / success
+		point.AvgLatency = &latency
+	}
+	if withCounts {
+		counts := a.StatusCounts
+		point.Counts = &counts
+	}
+	return point
+}
+
+// buildStatusReport 汇总统计数据，channelId 为 0 时汇总所有渠道，models 不为空时只保留这些模型
+func buildStatusReport(period string, channelId int, models []string, withCounts bool) (*StatusReport, error) {
+	p, ok := statusPeriods[period]
+	if !ok {
+		return nil, fmt.Errorf("invalid period: %s", period)
+	}
+	now := time.Now().Unix()
+	since := now - p.duration
+	since -= since % p.step
+	sums, err := model.GetChannelStatSums(channelId, since)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]*statusAccumulator)
+	buckets := make(map[string]map[int64]*statusAccumulator)
+	for _, sum := range sums {
+		if len(models) > 0 && !slices.Contains(models, sum.ModelName) {
+			continue
+		}
+		if _, ok := totals[sum.ModelName]; !ok {
+			totals[sum.ModelName] = &statusAccumulator{}
+			buckets[sum.ModelName] = make(map[int64]*statusAccumulator)
+		}
+		totals[sum.ModelName].add(sum)
+		t := sum.BucketStart - sum.BucketStart%p.step
+		bucket, ok := buckets[sum.ModelName][t]
+		if !ok {
+			bucket = &statusAccumulator{}
+			buckets[sum.ModelName][t] = bucket
+		}
+		bucket.add(sum)
+	}
+
+	report := &StatusReport{
+		Period:    period,
+		Step:      p.step,
+		UpdatedAt: now,
+		Models:    make([]*ModelStatus, 0, len(totals)),
+	}
+	for modelName, total := range totals {
+		status := &ModelStatus{
+			Model:       modelName,
+			StatusPoint: total.point(0, withCounts),
+		}
+		for t := since; t <= now; t += p.step {
+			bucket, ok := buckets[modelName][t]
+			if !ok {
+				bucket = &statusAccumulator{}
+			}
+			status.Series = append(status.Series, bucket.point(t, withCounts))
+		}
+		report.Models = append(report.Models, status)
+	}
+	sort.Slice(report.Models, func(i, j int) bool {
+		return report.Models[i].Model < report.Models[j].Model
+	})
+	return report, nil
+}
+
+var (
+	publicStatusLock  sync.Mutex
+	publicStatusCache = make(map[string]*StatusReport)
+)
+
+// GetPublicStatus 公开状态页数据：按模型展示可用率与时延，故障只展示受影响的模型，不包含渠道信息。结果缓存 1 分钟
+func GetPublicStatus(period string) (*StatusReport, error) {
+	publicStatusLock.Lock()
+	defer publicStatusLock.Unlock()
+	if cached, ok := publicStatusCache[period]; ok && time.Now().Unix()-cached.UpdatedAt < 60 {
+		return cached, nil
+	}
+
+	allowedModels := operation_setting.GetStatusPageSetting().Models
+	report, err := buildStatusReport(period, 0, allowedModels, false)
+	if err != nil {
+		return nil, err
+	}
+	incidents, err := model.GetChannelIncidentsSince(0, report.UpdatedAt-statusPeriods[period].duration, true)
+	if err != nil {
+		return nil, err
+	}
+	channelIds := make([]int, 0, len(incidents))
+	for _, incident := range incidents {
+		channelIds = append(channelIds, incident.ChannelId)
+	}
+	channelModels := make(map[int][]string)
+	if len(channelIds) > 0 {
+		slices.Sort(channelIds)
+		channels, err := model.GetChannelsByIds(slices.Compact(channelIds))
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range channels {
+			channelModels[channel.Id] = channel.GetModels()
+		}
+	}
+	report.Incidents = make([]StatusIncident, 0, len(incidents))
+	for _, incident := range incidents {
+		affected := make([]string, 0)
+		for _, m := range channelModels[incident.ChannelId] {
+			m = strings.TrimSpace(m)
+			if m != "" && (len(allowedModels) == 0 || slices.Contains(allowedModels, m)) {
+				affected = append(affected, m)
+			}
+		}
+		if len(affected) == 0 {
+			continue
+		}
+		report.Incidents = append(report.Incidents, StatusIncident{
+			Models:     affected,
+			StartedAt:  incident.StartedAt,
+			ResolvedAt: incident.ResolvedAt,
+		})
+	}
+	publicStatusCache[period] = report
+	return report, nil
+}
+
+// GetChannelStatus 管理端渠道状态：按模型展示可用率、时延与请求计数，以及该渠道（含 key 级）的故障记录
+func GetChannelStatus(channelId int, period string) (*StatusReport, error) {
+	report, err := buildStatusReport(period, channelId, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	incidents, err := model.GetChannelIncidentsSince(channelId, report.UpdatedAt-statusPeriods[period].duration, false)
+	if err != nil {
+		return nil, err
+	}
+	report.Incidents = make([]StatusIncident, 0, len(incidents))
+	for _, incident := range incidents {
+		item := StatusIncident{
+			ChannelId:  incident.ChannelId,
+			Reason:     incident.Reason,
+			Models:     []string{},
+			StartedAt:  incident.StartedAt,
+			ResolvedAt: incident.ResolvedAt,
+		}
+		if incident.KeyIndex >= 0 {
+			keyIndex := incident.KeyIndex
+			item.KeyIndex = &keyIndex
+		}
+		report.Incidents = append(report.Incidents, item)
+	}
+	return report, nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// preConsumeSubscriptionQuota 尝试使用订阅额度预扣费
+// handled 为 true 表示已由订阅处理（成功或拒绝），为 false 时继续使用账户余额
+func preConsumeSubscriptionQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (bool, *types.NewAPIError) {
+	sub, err := model.GetActiveUserSubscriptionCache(relayInfo.UserId)
+	if err != nil {
+		return true, types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
+	}
+	if sub == nil {
+		return false, nil
+	}
+	plan := sub.Plan
+	fallbackToBalance := plan.OveragePolicy == model.SubscriptionOverageBalance
+
+	if !plan.AllowsModel(relayInfo.OriginModelName) {
+		if fallbackToBalance {
+			return false, nil
+		}
+		return true, types.NewErrorWithStatusCode(fmt.Errorf("当前订阅套餐不支持模型 %s", relayInfo.OriginModelName), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+
+	ok, err := model.PreConsumeUserSubscriptionQuota(sub.Id, preConsumedQuota)
+	if err != nil {
+		return true, types.NewError(err, types.ErrorCodeUpdateDataError, types.ErrOptionWithSkipRetry())
+	}
+	if !ok {
+		// 额度已用尽或订阅已失效，清除缓存以便下次读取最新状态
+		model.InvalidateUserSubscriptionCache(relayInfo.UserId)
+		if fallbackToBalance {
+			logger.LogInfo(c, fmt.Sprintf("用户 %d 订阅额度不足，使用账户余额", relayInfo.UserId))
+			return false, nil
+		}
+		return true, types.NewErrorWithStatusCode(fmt.Errorf("订阅额度已用尽, 剩余额度: %s", logger.FormatQuota(sub.QuotaRemaining)), types.ErrorCodeInsufficientUserQuota, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+
+	if preConsumedQuota > 0 {
+		if err := PreConsumeTokenQuota(relayInfo, preConsumedQuota); err != nil {
+			_ = model.DecreaseUserSubscriptionQuota(sub.Id, -preConsumedQuota)
+			return true, types.NewErrorWithStatusCode(err, types.ErrorCodePreConsumeTokenQuotaFailed, http.StatusForbidden, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+		}
+	}
+	relayInfo.SubscriptionId = sub.Id
+	relayInfo.FinalPreConsumedQuota = preConsumedQuota
+	logger.LogInfo(c, fmt.Sprintf("用户 %d 使用订阅 %d 预扣费 %s", relayInfo.UserId, sub.Id, logger.FormatQuota(preConsumedQuota)))
+	return true, nil
+}
+
+// UpdateUserSubscriptionsTask 定时处理到期的订阅
+func UpdateUserSubscriptionsTask() {
+	for {
+		count, err := model.ExpireUserSubscriptions()
+		if err != nil {
+			common.SysLog("failed to expire subscriptions: " + err.Error())
+		} else if count > 0 {
+			common.SysLog(fmt.Sprintf("已处理 %d 个到期订阅", count))
+		}
+		time.Sleep(time.Minute)
+	}
+}
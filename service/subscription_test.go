@@ -0,0 +1,186 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	subscriptionTestModel        = "sub-model"
+	subscriptionTestUserQuota    = 1000
+	subscriptionTestTokenQuota   = 10000
+	subscriptionTestIncludeQuota = 500
+)
+
+// setupSubscriptionTest 初始化数据库，返回账户余额低于信任额度（会实际预扣余额）的用户与有限额度令牌
+func setupSubscriptionTest(t *testing.T) (*model.User, *model.Token) {
+	common.RedisEnabled = false
+	common.BatchUpdateEnabled = false
+	common.IsMasterNode = true
+	common.SQLitePath = filepath.Join(t.TempDir(), "subscription.db")
+	require.NoError(t, model.InitDB())
+
+	user := &model.User{Username: "subscriber", Password: "password123", Group: "default", Quota: subscriptionTestUserQuota, Status: common.UserStatusEnabled}
+	require.NoError(t, model.DB.Create(user).Error)
+	token := &model.Token{UserId: user.Id, Key: common.GetRandomString(48), Name: "subscriber", Status: common.TokenStatusEnabled,
+		RemainQuota: subscriptionTestTokenQuota, ExpiredTime: -1}
+	require.NoError(t, model.DB.Create(token).Error)
+	return user, token
+}
+
+func activateTestSubscription(t *testing.T, userId int, plan *model.SubscriptionPlan) *model.UserSubscription {
+	require.NoError(t, plan.Insert())
+	sub := &model.UserSubscription{UserId: userId, PlanId: plan.Id, Status: model.UserSubscriptionStatusPending}
+	require.NoError(t, sub.Insert())
+	require.NoError(t, model.ActivateUserSubscription(sub, plan))
+	return sub
+}
+
+func newSubscriptionRelayInfo(user *model.User, token *model.Token, modelName string) *relaycommon.RelayInfo {
+	return &relaycommon.RelayInfo{
+		UserId:          user.Id,
+		TokenId:         token.Id,
+		TokenKey:        token.Key,
+		OriginModelName: modelName,
+	}
+}
+
+func newSubscriptionTestContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	return c
+}
+
+func requireSubscriptionQuota(t *testing.T, subId int, remaining int, used int) {
+	sub, err := model.GetUserSubscriptionById(subId)
+	require.NoError(t, err)
+	require.Equal(t, remaining, sub.QuotaRemaining)
+	require.Equal(t, used, sub.QuotaUsed)
+}
+
+func requireUserAndTokenQuota(t *testing.T, user *model.User, token *model.Token, userQuota int, tokenQuota int) {
+	quota, err := model.GetUserQuota(user.Id, true)
+	require.NoError(t, err)
+	require.Equal(t, userQuota, quota)
+	updated, err := model.GetTokenById(token.Id)
+	require.NoError(t, err)
+	require.Equal(t, tokenQuota, updated.RemainQuota)
+}
+
+func TestPreConsumeQuota_SubscriptionBeforeBalance(t *testing.T) {
+	user, token := setupSubscriptionTest(t)
+	plan := &model.SubscriptionPlan{Name: "pro", IncludedQuota: subscriptionTestIncludeQuota, AllowedModels: subscriptionTestModel,
+		OveragePolicy: model.SubscriptionOverageBalance, Status: 1}
+	sub := activateTestSubscription(t, user.Id, plan)
+	c := newSubscriptionTestContext()
+
+	// 订阅支持的模型优先使用订阅额度，账户余额不变
+	info := newSubscriptionRelayInfo(user, token, subscriptionTestModel)
+	require.Nil(t, PreConsumeQuota(c, 200, info))
+	require.Equal(t, sub.Id, info.SubscriptionId)
+	require.Equal(t, 200, info.FinalPreConsumedQuota)
+	requireSubscriptionQuota(t, sub.Id, 300, 200)
+	requireUserAndTokenQuota(t, user, token, subscriptionTestUserQuota, subscriptionTestTokenQuota-200)
+
+	// 订阅不支持的模型按 balance 策略使用账户余额
+	info = newSubscriptionRelayInfo(user, token, "other-model")
+	require.Nil(t, PreConsumeQuota(c, 100, info))
+	require.Zero(t, info.SubscriptionId)
+	requireSubscriptionQuota(t, sub.Id, 300, 200)
+	requireUserAndTokenQuota(t, user, token, subscriptionTestUserQuota-100, subscriptionTestTokenQuota-300)
+
+	// 订阅额度不足时同样使用账户余额
+	info = newSubscriptionRelayInfo(user, token, subscriptionTestModel)
+	require.Nil(t, PreConsumeQuota(c, 400, info))
+	require.Zero(t, info.SubscriptionId)
+	requireSubscriptionQuota(t, sub.Id, 300, 200)
+
+	// 重新读取订阅写入缓存
+	info = newSubscriptionRelayInfo(user, token, subscriptionTestModel)
+	require.Nil(t, PreConsumeQuota(c, 100, info))
+	require.Equal(t, sub.Id, info.SubscriptionId)
+	requireSubscriptionQuota(t, sub.Id, 200, 300)
+
+	// 套餐改为 block 后所有用户的订阅缓存失效，不再回退到账户余额
+	plan.OveragePolicy = model.SubscriptionOverageBlock
+	require.NoError(t, plan.Update())
+	apiErr := PreConsumeQuota(c, 100, newSubscriptionRelayInfo(user, token, "other-model"))
+	require.NotNil(t, apiErr)
+	require.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+	apiErr = PreConsumeQuota(c, 400, newSubscriptionRelayInfo(user, token, subscriptionTestModel))
+	require.NotNil(t, apiErr)
+	require.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+	requireSubscriptionQuota(t, sub.Id, 200, 300)
+}
+
+func TestExpiredSubscriptionFallsBackToBalance(t *testing.T) {
+	user, token := setupSubscriptionTest(t)
+	plan := &model.SubscriptionPlan{Name: "vip", IncludedQuota: subscriptionTestIncludeQuota, UpgradeGroup: "vip",
+		OveragePolicy: model.SubscriptionOverageBlock, Status: 1}
+	sub := activateTestSubscription(t, user.Id, plan)
+	group, err := model.GetUserGroup(user.Id, true)
+	require.NoError(t, err)
+	require.Equal(t, "vip", group)
+
+	c := newSubscriptionTestContext()
+	info := newSubscriptionRelayInfo(user, token, subscriptionTestModel)
+	require.Nil(t, PreConsumeQuota(c, 100, info))
+	require.Equal(t, sub.Id, info.SubscriptionId)
+
+	// 周期结束后订阅过期，分组还原，之后的请求使用账户余额
+	require.NoError(t, model.DB.Model(&model.UserSubscription{}).Where("id = ?", sub.Id).Update("period_end", time.Now().Unix()-10).Error)
+	count, err := model.ExpireUserSubscriptions()
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	expired, err := model.GetUserSubscriptionById(sub.Id)
+	require.NoError(t, err)
+	require.Equal(t, model.UserSubscriptionStatusExpired, expired.Status)
+	group, err = model.GetUserGroup(user.Id, true)
+	require.NoError(t, err)
+	require.Equal(t, "default", group)
+
+	info = newSubscriptionRelayInfo(user, token, subscriptionTestModel)
+	require.Nil(t, PreConsumeQuota(c, 100, info))
+	require.Zero(t, info.SubscriptionId)
+	requireSubscriptionQuota(t, sub.Id, 400, 100)
+	requireUserAndTokenQuota(t, user, token, subscriptionTestUserQuota-100, subscriptionTestTokenQuota-200)
+}
+
+func TestSubscriptionPreConsumeRefundAndSettle(t *testing.T) {
+	user, token := setupSubscriptionTest(t)
+	plan := &model.SubscriptionPlan{Name: "pro", IncludedQuota: subscriptionTestIncludeQuota, OveragePolicy: model.SubscriptionOverageBlock, Status: 1}
+	sub := activateTestSubscription(t, user.Id, plan)
+	c := newSubscriptionTestContext()
+
+	// 请求失败时预扣的订阅额度与令牌额度全部返还
+	info := newSubscriptionRelayInfo(user, token, subscriptionTestModel)
+	require.Nil(t, PreConsumeQuota(c, 200, info))
+	requireSubscriptionQuota(t, sub.Id, 300, 200)
+	ReturnPreConsumedQuota(c, info)
+	require.Eventually(t, func() bool {
+		refunded, err := model.GetUserSubscriptionById(sub.Id)
+		return err == nil && refunded.QuotaRemaining == subscriptionTestIncludeQuota
+	}, 5*time.Second, 10*time.Millisecond)
+	requireSubscriptionQuota(t, sub.Id, subscriptionTestIncludeQuota, 0)
+	require.Eventually(t, func() bool {
+		refunded, err := model.GetTokenById(token.Id)
+		return err == nil && refunded.RemainQuota == subscriptionTestTokenQuota
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// 请求成功后按实际用量补扣差额，账户余额不受影响
+	info = newSubscriptionRelayInfo(user, token, subscriptionTestModel)
+	require.Nil(t, PreConsumeQuota(c, 200, info))
+	require.NoError(t, PostConsumeQuota(info, 50, info.FinalPreConsumedQuota, true))
+	requireSubscriptionQuota(t, sub.Id, 250, 250)
+	requireUserAndTokenQuota(t, user, token, subscriptionTestUserQuota, subscriptionTestTokenQuota-250)
+}
@@ -3,9 +3,46 @@ package service
 import (
 	"strings"
 
+	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
 )
 
 func CoverTaskActionToModelName(platform constant.TaskPlatform, action string) string {
 	return strings.ToLower(string(platform)) + "_" + strings.ToLower(action)
 }
+
+// taskBillingRelayInfo 还原任务提交时的计费来源（订阅或余额、令牌），供异步结算使用
+func taskBillingRelayInfo(task *model.Task) *relaycommon.RelayInfo {
+	info := &relaycommon.RelayInfo{
+		UserId:         task.UserId,
+		TokenId:        task.TokenId,
+		SubscriptionId: task.SubscriptionId,
+		IsPlayground:   task.TokenId == 0,
+	}
+	if task.TokenId != 0 {
+		token, err := model.GetTokenById(task.TokenId)
+		if err != nil {
+			// 令牌已删除，只处理用户（或订阅）额度
+			common.SysLog("task billing token not found: " + err.Error())
+			info.IsPlayground = true
+		} else {
+			info.TokenKey = token.Key
+		}
+	}
+	return info
+}
+
+// AdjustTaskQuota 按任务提交时的计费路径调整额度，delta 为正表示补扣，为负表示退还，令牌额度同步调整
+func AdjustTaskQuota(task *model.Task, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	return PostConsumeQuota(taskBillingRelayInfo(task), delta, 0, false)
+}
+
+// RefundTaskQuota 任务失败时退还额度
+func RefundTaskQuota(task *model.Task, quota int) error {
+	return AdjustTaskQuota(task, -quota)
+}
@@ -1,19 +1,20 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 )
 
 const (
@@ -23,7 +24,7 @@ const (
 )
 
 // TriggerTaskCallback 触发任务回调
-// 该函数应该在goroutine中异步调用
+// 回调写入 webhook 投递队列，失败后按退避策略持久化重试，服务重启不会丢失
 func TriggerTaskCallback(task *model.Task) {
 	if task.CallBackUrl == "" {
 		return
@@ -37,48 +38,59 @@ func TriggerTaskCallback(task *model.Task) {
 		return
 	}
 
-	// 构建回调payload
-	payload := buildCallbackPayload(task)
-
-	// 执行回调，最多重试3次
-	maxRetries := 3
-	for i := 0; i < maxRetries; i++ {
-		err := sendCallback(ctx, task.CallBackUrl, payload)
-		if err == nil {
-			// 回调成功，更新状态
-			_ = model.DB.Model(&model.Task{}).
-				Where("id = ?", task.ID).
-				Updates(map[string]interface{}{
-					"callback_status":      CallbackStatusSuccess,
-					"callback_time":        time.Now().Unix(),
-					"callback_retry_count": i + 1,
-				}).Error
-			logger.LogInfo(ctx, fmt.Sprintf("Task callback success: %s, attempt: %d", task.TaskID, i+1))
-			return
-		}
-
-		logger.LogWarn(ctx, fmt.Sprintf("Task callback failed (attempt %d/%d): %s, error: %s",
-			i+1, maxRetries, task.TaskID, err.Error()))
+	// 构建回调payload，Midjourney 任务的 notifyHook 保持旧版 Midjourney 格式
+	var payload any = buildCallbackPayload(task)
+	if task.Platform == constant.TaskPlatformMidjourney {
+		payload = CoverMidjourneyTaskDto(task.ToMidjourney())
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		logger.LogError(ctx, fmt.Sprintf("Marshal task callback payload failed: %s, error: %s", task.TaskID, err.Error()))
+		return
+	}
 
-		// 更新重试次数
+	secret := operation_setting.GetWebhookDeliverySetting().CallbackSecret
+	_, err = EnqueueWebhook(task.UserId, task.CallBackUrl, secret, WebhookEventTaskCallback, strconv.FormatInt(task.ID, 10), data)
+	if err != nil {
 		_ = model.DB.Model(&model.Task{}).
 			Where("id = ?", task.ID).
-			Update("callback_retry_count", i+1).Error
-
-		// 如果不是最后一次重试，等待后再试（指数退避）
-		if i < maxRetries-1 {
-			time.Sleep(time.Second * time.Duration(1<<uint(i))) // 1s, 2s, 4s
-		}
+			Updates(map[string]interface{}{
+				"call_back_status": CallbackStatusFailed,
+				"call_back_time":   time.Now().Unix(),
+			}).Error
+		logger.LogError(ctx, fmt.Sprintf("Task callback enqueue failed: %s, error: %s", task.TaskID, err.Error()))
+		return
 	}
-
-	// 所有重试失败，标记为失败
 	_ = model.DB.Model(&model.Task{}).
 		Where("id = ?", task.ID).
+		Update("call_back_status", CallbackStatusPending).Error
+}
+
+// updateTaskCallbackStatus 根据投递结果同步任务回调状态
+func updateTaskCallbackStatus(delivery *model.WebhookDelivery) {
+	taskId, err := strconv.ParseInt(delivery.SourceId, 10, 64)
+	if err != nil {
+		return
+	}
+	status := CallbackStatusPending
+	switch delivery.Status {
+	case model.WebhookDeliveryStatusSuccess:
+		status = CallbackStatusSuccess
+	case model.WebhookDeliveryStatusFailed:
+		status = CallbackStatusFailed
+	}
+	_ = model.DB.Model(&model.Task{}).
+		Where("id = ?", taskId).
 		Updates(map[string]interface{}{
-			"callback_status": CallbackStatusFailed,
-			"callback_time":   time.Now().Unix(),
+			"call_back_status":      status,
+			"call_back_time":        time.Now().Unix(),
+			"call_back_retry_count": delivery.Attempts,
 		}).Error
-	logger.LogError(ctx, fmt.Sprintf("Task callback failed after all retries: %s", task.TaskID))
+	if status == CallbackStatusSuccess {
+		logger.LogInfo(context.Background(), fmt.Sprintf("Task callback success: %d, attempt: %d", taskId, delivery.Attempts))
+	} else {
+		logger.LogWarn(context.Background(), fmt.Sprintf("Task callback failed (attempt %d): %d, error: %s", delivery.Attempts, taskId, delivery.LastError))
+	}
 }
 
 func buildCallbackPayload(task *model.Task) *dto.TaskCallbackPayload {
@@ -141,37 +153,6 @@ func buildCallbackPayload(task *model.Task) *dto.TaskCallbackPayload {
 	return payload
 }
 
-func sendCallback(ctx context.Context, callbackURL string, payload *dto.TaskCallbackPayload) error {
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("marshal payload failed: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", callbackURL, bytes.NewReader(data))
-	if err != nil {
-		return fmt.Errorf("create request failed: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "new-api-callback/1.0")
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("send request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("callback returned status %d", resp.StatusCode)
-	}
-
-	return nil
-}
-
 func mapStatusToState(status model.TaskStatus) string {
 	switch status {
 	case model.TaskStatusSuccess:
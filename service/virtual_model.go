@@ -0,0 +1,72 @@
+package service
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResolveVirtualModel 若 modelName 为虚拟模型，则按权重选择一个真实目标模型。
+// 返回目标模型名和是否为虚拟模型；非虚拟模型原样返回。
+func ResolveVirtualModel(c *gin.Context, modelName string, group string) (string, bool) {
+	vm := model.CacheGetVirtualModel(modelName)
+	if vm == nil {
+		return modelName, false
+	}
+	targets := vm.GetTargetsForGroup(group)
+	var stickyKey string
+	switch vm.StickyBy {
+	case model.VirtualModelStickyUser:
+		stickyKey = fmt.Sprintf("%s:user:%d", vm.Name, common.GetContextKeyInt(c, constant.ContextKeyUserId))
+	case model.VirtualModelStickyToken:
+		stickyKey = fmt.Sprintf("%s:token:%d", vm.Name, common.GetContextKeyInt(c, constant.ContextKeyTokenId))
+	}
+	target := pickVirtualModelTarget(targets, stickyKey)
+	if target == "" {
+		return modelName, true
+	}
+	return target, true
+}
+
+// pickVirtualModelTarget 按权重选择目标；stickyKey 非空时使用其哈希值代替随机数，保证同一 key 总是落在同一目标
+func pickVirtualModelTarget(targets []model.VirtualModelTarget, stickyKey string) string {
+	totalWeight := 0
+	for _, t := range targets {
+		if t.Model != "" && t.Weight > 0 {
+			totalWeight += t.Weight
+		}
+	}
+	if totalWeight == 0 {
+		// 未配置权重时使用第一个目标
+		for _, t := range targets {
+			if t.Model != "" {
+				return t.Model
+			}
+		}
+		return ""
+	}
+	var point int
+	if stickyKey != "" {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(stickyKey))
+		point = int(h.Sum32() % uint32(totalWeight))
+	} else {
+		point = rand.Intn(totalWeight)
+	}
+	for _, t := range targets {
+		if t.Model == "" || t.Weight <= 0 {
+			continue
+		}
+		point -= t.Weight
+		if point < 0 {
+			return t.Model
+		}
+	}
+	return ""
+}
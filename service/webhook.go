@@ -2,17 +2,30 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	WebhookEventTaskCallback = "task.callback"
+	WebhookEventNotifyPrefix = "notify."
 )
 
 // WebhookPayload webhook 通知的负载数据
@@ -31,8 +44,17 @@ func generateSignature(secret string, payload []byte) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-// SendWebhookNotify 发送 webhook 通知
-func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error {
+// generateTimestampSignature 生成带时间戳的签名，签名内容为 "{timestamp}.{payload}"，接收方可据此拒绝重放请求
+func generateTimestampSignature(secret string, timestamp int64, payload []byte) string {
+	signed := make([]byte, 0, len(payload)+16)
+	signed = append(signed, strconv.FormatInt(timestamp, 10)...)
+	signed = append(signed, '.')
+	signed = append(signed, payload...)
+	return generateSignature(secret, signed)
+}
+
+// SendWebhookNotify 发送 webhook 通知，写入投递队列后异步投递并自动重试
+func SendWebhookNotify(userId int, webhookURL string, secret string, data dto.Notify) error {
 	// 处理占位符
 	content := data.Content
 	for _, value := range data.Values {
@@ -54,73 +76,226 @@ func SendWebhookNotify(webhookURL string, secret string, data dto.Notify) error
 		return fmt.Errorf("failed to marshal webhook payload: %v", err)
 	}
 
-	// 创建 HTTP 请求
-	var req *http.Request
-	var resp *http.Response
-
-	if system_setting.EnableWorker() {
-		// 构建worker请求数据
-		workerReq := &WorkerRequest{
-			URL:    webhookURL,
-			Key:    system_setting.WorkerValidKey,
-			Method: http.MethodPost,
-			Headers: map[string]string{
-				"Content-Type": "application/json",
-			},
-			Body: payloadBytes,
+	// SSRF防护：入队前先校验一次，尽早拒绝（非Worker模式，Worker模式由DoWorkerRequest校验）
+	if !system_setting.EnableWorker() {
+		if err := validateWebhookURL(webhookURL); err != nil {
+			return err
 		}
+	}
 
-		// 如果有secret，添加签名到headers
-		if secret != "" {
-			signature := generateSignature(secret, payloadBytes)
-			workerReq.Headers["X-Webhook-Signature"] = signature
-			workerReq.Headers["Authorization"] = "Bearer " + secret
-		}
+	_, err = EnqueueWebhook(userId, webhookURL, secret, WebhookEventNotifyPrefix+data.Type, "", payloadBytes)
+	return err
+}
 
-		resp, err = DoWorkerRequest(workerReq)
+// EnqueueWebhook 将 webhook 写入投递队列（outbox）并立即尝试一次投递，失败后由后台任务按退避策略重试
+func EnqueueWebhook(userId int, webhookURL string, secret string, eventType string, sourceId string, payload []byte) (*model.WebhookDelivery, error) {
+	endpoint, err := model.GetOrCreateWebhookEndpoint(userId, webhookURL, secret, eventType == WebhookEventTaskCallback)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook endpoint: %w", err)
+	}
+	if endpoint.Status != model.WebhookEndpointStatusEnabled {
+		return nil, fmt.Errorf("webhook endpoint %d is disabled", endpoint.Id)
+	}
+	delivery := &model.WebhookDelivery{
+		EndpointId:    endpoint.Id,
+		UserId:        userId,
+		EventType:     eventType,
+		SourceId:      sourceId,
+		Payload:       string(payload),
+		Status:        model.WebhookDeliveryStatusPending,
+		NextAttemptAt: time.Now().Unix(),
+	}
+	if err := delivery.Insert(); err != nil {
+		return nil, fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+	gopool.Go(func() {
+		processWebhookDelivery(delivery.Id)
+	})
+	return delivery, nil
+}
+
+// ReplayWebhookDelivery 重新投递一条记录，会生成一条新的投递记录并保留原记录
+func ReplayWebhookDelivery(id int) (*model.WebhookDelivery, error) {
+	origin, err := model.GetWebhookDeliveryById(id)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := model.GetWebhookEndpointById(origin.EndpointId)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Status != model.WebhookEndpointStatusEnabled {
+		return nil, errors.New("webhook 地址已禁用，请先启用后再重放")
+	}
+	delivery := &model.WebhookDelivery{
+		EndpointId:    origin.EndpointId,
+		UserId:        origin.UserId,
+		EventType:     origin.EventType,
+		SourceId:      origin.SourceId,
+		Payload:       origin.Payload,
+		Status:        model.WebhookDeliveryStatusPending,
+		NextAttemptAt: time.Now().Unix(),
+		ReplayOf:      origin.Id,
+	}
+	if err := delivery.Insert(); err != nil {
+		return nil, err
+	}
+	gopool.Go(func() {
+		processWebhookDelivery(delivery.Id)
+	})
+	return delivery, nil
+}
+
+// StartWebhookDeliveryTask 后台重试到期的 webhook 投递，所有节点均可运行
+func StartWebhookDeliveryTask() {
+	for {
+		time.Sleep(10 * time.Second)
+		ids, err := model.GetDueWebhookDeliveryIds(time.Now().Unix(), 100)
 		if err != nil {
-			return fmt.Errorf("failed to send webhook request through worker: %v", err)
+			common.SysLog(fmt.Sprintf("failed to get due webhook deliveries: %s", err.Error()))
+			continue
+		}
+		for _, id := range ids {
+			processWebhookDelivery(id)
 		}
-		defer resp.Body.Close()
+	}
+}
+
+func processWebhookDelivery(id int) {
+	deliverySetting := operation_setting.GetWebhookDeliverySetting()
+	leaseSeconds := int(deliverySetting.GetTimeout()/time.Second) + 30
+	delivery, ok, err := model.LeaseWebhookDelivery(id, time.Now().Unix(), leaseSeconds)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to lease webhook delivery %d: %s", id, err.Error()))
+		return
+	}
+	if !ok {
+		return
+	}
+	endpoint, err := model.GetWebhookEndpointById(delivery.EndpointId)
+	if err != nil {
+		delivery.Status = model.WebhookDeliveryStatusFailed
+		delivery.LastError = "webhook endpoint not found"
+		finishWebhookDelivery(delivery)
+		return
+	}
+	if endpoint.Status != model.WebhookEndpointStatusEnabled {
+		delivery.Status = model.WebhookDeliveryStatusFailed
+		delivery.LastError = "webhook endpoint disabled"
+		finishWebhookDelivery(delivery)
+		return
+	}
 
-		// 检查响应状态
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
+	statusCode, err := sendWebhookRequest(endpoint, delivery, deliverySetting.GetTimeout())
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	if err == nil {
+		delivery.Status = model.WebhookDeliveryStatusSuccess
+		delivery.LastError = ""
+		delivery.DeliveredAt = time.Now().Unix()
+		if err := model.RecordWebhookEndpointSuccess(endpoint.Id); err != nil {
+			common.SysLog(fmt.Sprintf("failed to update webhook endpoint %d: %s", endpoint.Id, err.Error()))
 		}
 	} else {
-		// SSRF防护：验证Webhook URL（非Worker模式）
-		fetchSetting := system_setting.GetFetchSetting()
-		if err := common.ValidateURLWithFetchSetting(webhookURL, fetchSetting.EnableSSRFProtection, fetchSetting.AllowPrivateIp, fetchSetting.DomainFilterMode, fetchSetting.IpFilterMode, fetchSetting.DomainList, fetchSetting.IpList, fetchSetting.AllowedPorts, fetchSetting.ApplyIPFilterForDomain); err != nil {
-			return fmt.Errorf("request reject: %v", err)
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= deliverySetting.GetMaxAttempts() {
+			delivery.Status = model.WebhookDeliveryStatusFailed
+		} else {
+			delivery.NextAttemptAt = time.Now().Add(deliverySetting.RetryDelay(delivery.Attempts)).Unix()
 		}
-
-		req, err = http.NewRequest(http.MethodPost, webhookURL, bytes.NewBuffer(payloadBytes))
-		if err != nil {
-			return fmt.Errorf("failed to create webhook request: %v", err)
+		disabled, updateErr := model.RecordWebhookEndpointFailure(endpoint.Id, err.Error(), deliverySetting.DisableAfterFailures)
+		if updateErr != nil {
+			common.SysLog(fmt.Sprintf("failed to update webhook endpoint %d: %s", endpoint.Id, updateErr.Error()))
 		}
+		if disabled {
+			common.SysLog(fmt.Sprintf("webhook endpoint %d (%s) auto disabled after %d consecutive failures", endpoint.Id, endpoint.Url, deliverySetting.DisableAfterFailures))
+		}
+	}
+	finishWebhookDelivery(delivery)
+}
 
-		// 设置请求头
-		req.Header.Set("Content-Type", "application/json")
+func finishWebhookDelivery(delivery *model.WebhookDelivery) {
+	if err := model.FinishWebhookDeliveryAttempt(delivery); err != nil {
+		common.SysLog(fmt.Sprintf("failed to save webhook delivery %d: %s", delivery.Id, err.Error()))
+	}
+	if delivery.EventType == WebhookEventTaskCallback {
+		updateTaskCallbackStatus(delivery)
+	}
+}
 
-		// 如果有 secret，生成签名
+// validateWebhookURL 按 SSRF 防护配置校验 webhook 地址
+func validateWebhookURL(webhookURL string) error {
+	fetchSetting := system_setting.GetFetchSetting()
+	if err := common.ValidateURLWithFetchSetting(webhookURL, fetchSetting.EnableSSRFProtection, fetchSetting.AllowPrivateIp, fetchSetting.DomainFilterMode, fetchSetting.IpFilterMode, fetchSetting.DomainList, fetchSetting.IpList, fetchSetting.AllowedPorts, fetchSetting.ApplyIPFilterForDomain); err != nil {
+		return fmt.Errorf("request reject: %v", err)
+	}
+	return nil
+}
+
+// sendWebhookRequest 发送一次 webhook 请求，返回上游状态码。
+// 每次发送（含重试与重放）都重新校验地址，SSRF 防护配置变更或域名解析变化后同样生效
+func sendWebhookRequest(endpoint *model.WebhookEndpoint, delivery *model.WebhookDelivery, timeout time.Duration) (int, error) {
+	payloadBytes := []byte(delivery.Payload)
+	headers := map[string]string{
+		"Content-Type":       "application/json",
+		"User-Agent":         "new-api-webhook/1.0",
+		"X-Webhook-Event":    delivery.EventType,
+		"X-Webhook-Delivery": strconv.Itoa(delivery.Id),
+	}
+	// 任务回调与用户通知使用各自的密钥签名
+	secret := endpoint.Secret
+	if delivery.EventType == WebhookEventTaskCallback {
+		secret = endpoint.CallbackSecret
+	}
+	if secret != "" {
+		timestamp := time.Now().Unix()
+		headers["X-Webhook-Timestamp"] = strconv.FormatInt(timestamp, 10)
+		// 兼容旧版本仅对 payload 签名的校验方式
+		headers["X-Webhook-Signature"] = generateSignature(secret, payloadBytes)
+		headers["X-Webhook-Signature-256"] = fmt.Sprintf("t=%d,v1=%s", timestamp, generateTimestampSignature(secret, timestamp, payloadBytes))
+	}
+
+	var resp *http.Response
+	var err error
+	// 任务回调沿用原有的直连方式，通知类 webhook 在启用 Worker 时通过 Worker 发送
+	if system_setting.EnableWorker() && strings.HasPrefix(delivery.EventType, WebhookEventNotifyPrefix) {
+		workerReq := &WorkerRequest{
+			URL:     endpoint.Url,
+			Key:     system_setting.WorkerValidKey,
+			Method:  http.MethodPost,
+			Headers: headers,
+			Body:    payloadBytes,
+		}
 		if secret != "" {
-			signature := generateSignature(secret, payloadBytes)
-			req.Header.Set("X-Webhook-Signature", signature)
+			workerReq.Headers["Authorization"] = "Bearer " + secret
 		}
-
-		// 发送请求
-		client := GetHttpClient()
-		resp, err = client.Do(req)
+		resp, err = DoWorkerRequest(workerReq)
 		if err != nil {
-			return fmt.Errorf("failed to send webhook request: %v", err)
+			return 0, fmt.Errorf("failed to send webhook request through worker: %v", err)
 		}
-		defer resp.Body.Close()
-
-		// 检查响应状态
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
+	} else {
+		if err := validateWebhookURL(endpoint.Url); err != nil {
+			return 0, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		req, reqErr := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.Url, bytes.NewReader(payloadBytes))
+		if reqErr != nil {
+			return 0, fmt.Errorf("failed to create webhook request: %v", reqErr)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err = GetHttpClient().Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("failed to send webhook request: %v", err)
 		}
 	}
+	defer resp.Body.Close()
 
-	return nil
+	// 检查响应状态
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
 }
@@ -0,0 +1,87 @@
+package model_setting
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// ModelFallbackSettings 跨模型回退链配置
+// 当某个模型的所有渠道都已耗尽，或命中配置的错误类型时，按顺序切换到下一个模型
+type ModelFallbackSettings struct {
+	Enabled bool `json:"enabled"`
+	// Chains 全局回退链，model -> [fallback1, fallback2, ...]
+	Chains map[string][]string `json:"chains"`
+	// GroupChains 分组回退链，group -> model -> [fallback1, ...]，优先于全局回退链
+	GroupChains map[string]map[string][]string `json:"group_chains"`
+	// TriggerStatusCodes 命中这些上游状态码时，不再尝试当前模型的其他渠道，直接切换到下一个模型
+	TriggerStatusCodes []int `json:"trigger_status_codes"`
+	// TriggerErrorCodes 命中这些错误码时，直接切换到下一个模型
+	TriggerErrorCodes []string `json:"trigger_error_codes"`
+}
+
+// 默认配置
+var defaultModelFallbackSettings = ModelFallbackSettings{
+	Enabled:            false,
+	Chains:             map[string][]string{},
+	GroupChains:        map[string]map[string][]string{},
+	TriggerStatusCodes: []int{},
+	TriggerErrorCodes:  []string{},
+}
+
+// 全局实例
+var modelFallbackSettings = defaultModelFallbackSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("model_fallback", &modelFallbackSettings)
+}
+
+func GetModelFallbackSettings() *ModelFallbackSettings {
+	return &modelFallbackSettings
+}
+
+// GetModelFallbackChain 返回指定分组下模型的回退链（不包含模型本身），未配置时返回 nil
+func GetModelFallbackChain(group string, modelName string) []string {
+	if !modelFallbackSettings.Enabled {
+		return nil
+	}
+	if group != "" {
+		if groupChains, ok := modelFallbackSettings.GroupChains[group]; ok {
+			if chain, ok := groupChains[modelName]; ok {
+				return normalizeFallbackChain(modelName, chain)
+			}
+		}
+	}
+	if chain, ok := modelFallbackSettings.Chains[modelName]; ok {
+		return normalizeFallbackChain(modelName, chain)
+	}
+	return nil
+}
+
+// IsModelFallbackTrigger 判断错误是否应立即触发模型回退
+func IsModelFallbackTrigger(statusCode int, errorCode string) bool {
+	if !modelFallbackSettings.Enabled {
+		return false
+	}
+	if statusCode != 0 && slices.Contains(modelFallbackSettings.TriggerStatusCodes, statusCode) {
+		return true
+	}
+	if errorCode != "" && slices.Contains(modelFallbackSettings.TriggerErrorCodes, errorCode) {
+		return true
+	}
+	return false
+}
+
+func normalizeFallbackChain(modelName string, chain []string) []string {
+	result := make([]string, 0, len(chain))
+	for _, m := range chain {
+		m = strings.TrimSpace(m)
+		if m == "" || m == modelName || slices.Contains(result, m) {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
@@ -0,0 +1,35 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// BalanceMonitorSetting 渠道余额监控配置
+// 余额与渠道的 Balance 字段同单位（各上游的计费币种），Codex 等只有用量窗口的渠道按已用比例判断
+type BalanceMonitorSetting struct {
+	Enabled          bool    `json:"enabled"`            // 是否定期查询渠道余额
+	IntervalMinutes  int     `json:"interval_minutes"`   // 查询间隔
+	MinBalance       float64 `json:"min_balance"`        // 余额低于该值的渠道降低优先级，仅在没有其他可用渠道时使用，0 表示不降级；渠道可单独配置
+	AlertBalance     float64 `json:"alert_balance"`      // 余额低于该值时通知管理员，0 表示不通知
+	AlertDays        int     `json:"alert_days"`         // 按近期消耗预计该天数内耗尽时通知管理员，0 表示不通知
+	AlertUsedPercent float64 `json:"alert_used_percent"` // 用量窗口已用比例达到该值时通知管理员，0 表示不通知
+	HistoryDays      int     `json:"history_days"`       // 余额历史的保留天数
+}
+
+// 默认配置
+var balanceMonitorSetting = BalanceMonitorSetting{
+	Enabled:          false,
+	IntervalMinutes:  60,
+	MinBalance:       0,
+	AlertBalance:     0,
+	AlertDays:        3,
+	AlertUsedPercent: 90,
+	HistoryDays:      30,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("balance_monitor_setting", &balanceMonitorSetting)
+}
+
+func GetBalanceMonitorSetting() *BalanceMonitorSetting {
+	return &balanceMonitorSetting
+}
@@ -0,0 +1,28 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ChannelScriptSetting 渠道请求/响应转换脚本的运行限制
+type ChannelScriptSetting struct {
+	Enabled         bool `json:"enabled"`           // 默认关闭，开启后渠道配置的脚本才会执行
+	TimeoutMs       int  `json:"timeout_ms"`        // 每次调用的最长执行时间，超时即中断
+	MaxCallStack    int  `json:"max_call_stack"`    // 最大调用栈深度
+	MaxPayloadBytes int  `json:"max_payload_bytes"` // 输入或输出超过该大小时脚本执行失败
+}
+
+// 默认配置
+var channelScriptSetting = ChannelScriptSetting{
+	Enabled:         false,
+	TimeoutMs:       100,
+	MaxCallStack:    256,
+	MaxPayloadBytes: 8 << 20,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_script_setting", &channelScriptSetting)
+}
+
+func GetChannelScriptSetting() *ChannelScriptSetting {
+	return &channelScriptSetting
+}
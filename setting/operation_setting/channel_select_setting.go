@@ -0,0 +1,35 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ChannelSelectSetting 同一优先级内的渠道选择策略：random、round_robin、least_in_flight、consistent_hash
+// 渠道池配置的策略优先于分组策略，分组策略优先于默认策略
+type ChannelSelectSetting struct {
+	DefaultStrategy string            `json:"default_strategy"` // 默认策略
+	GroupStrategies map[string]string `json:"group_strategies"` // 按用户分组配置的策略
+	InFlightTTL     int               `json:"in_flight_ttl"`    // 使用 Redis 计数时进行中请求数的过期秒数，防止节点异常退出后计数无法归零
+}
+
+// 默认配置
+var channelSelectSetting = ChannelSelectSetting{
+	DefaultStrategy: "random",
+	GroupStrategies: map[string]string{},
+	InFlightTTL:     600,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_select_setting", &channelSelectSetting)
+}
+
+func GetChannelSelectSetting() *ChannelSelectSetting {
+	return &channelSelectSetting
+}
+
+// GetGroupSelectStrategy 分组的渠道选择策略，未单独配置时使用默认策略
+func GetGroupSelectStrategy(group string) string {
+	if strategy, ok := channelSelectSetting.GroupStrategies[group]; ok && strategy != "" {
+		return strategy
+	}
+	return channelSelectSetting.DefaultStrategy
+}
@@ -0,0 +1,94 @@
+package operation_setting
+
+import (
+	"strings"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	EventSinkTypeHttp        = "http"
+	EventSinkTypeNats        = "nats"
+	EventSinkTypeRedisStream = "redis_stream"
+	EventSinkTypeJsonl       = "jsonl"
+)
+
+// EventSinkConfig 事件投递目标
+type EventSinkConfig struct {
+	Name    string   `json:"name"` // 唯一名称，投递记录按名称关联
+	Type    string   `json:"type"` // http / nats / redis_stream / jsonl
+	Enabled bool     `json:"enabled"`
+	Events  []string `json:"events"`  // 事件类型过滤，支持 "*" 与前缀通配（如 "usage.*"），为空表示全部
+	Url     string   `json:"url"`     // http: webhook 地址；nats: nats://[user:pass@]host:port；redis_stream: redis 连接串，为空时使用系统 Redis
+	Secret  string   `json:"secret"`  // http 签名密钥
+	Subject string   `json:"subject"` // nats subject 前缀或 redis stream key
+	Path    string   `json:"path"`    // jsonl 文件路径
+	MaxLen  int64    `json:"max_len"` // redis stream 近似最大长度，0 表示不限
+}
+
+// EventBusSetting 事件总线配置
+type EventBusSetting struct {
+	Enabled       bool              `json:"enabled"`
+	MaxAttempts   int               `json:"max_attempts"`   // 单个事件在单个 sink 上的最大投递次数
+	BatchSize     int               `json:"batch_size"`     // 每批投递的事件数
+	RetentionDays int               `json:"retention_days"` // 已投递事件的保留天数
+	Sinks         []EventSinkConfig `json:"sinks"`
+}
+
+// 默认配置
+var eventBusSetting = EventBusSetting{
+	Enabled:       false,
+	MaxAttempts:   20,
+	BatchSize:     100,
+	RetentionDays: 7,
+	Sinks:         []EventSinkConfig{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("event_bus_setting", &eventBusSetting)
+}
+
+func GetEventBusSetting() *EventBusSetting {
+	return &eventBusSetting
+}
+
+// GetSink 按名称查找 sink 配置
+func (s *EventBusSetting) GetSink(name string) (EventSinkConfig, bool) {
+	for _, sink := range s.Sinks {
+		if sink.Name == name {
+			return sink, true
+		}
+	}
+	return EventSinkConfig{}, false
+}
+
+// MatchingSinks 返回订阅了该事件类型的已启用 sink
+func (s *EventBusSetting) MatchingSinks(eventType string) []EventSinkConfig {
+	if !s.Enabled {
+		return nil
+	}
+	var sinks []EventSinkConfig
+	for _, sink := range s.Sinks {
+		if sink.Enabled && sink.Name != "" && sink.Match(eventType) {
+			sinks = append(sinks, sink)
+		}
+	}
+	return sinks
+}
+
+// Match 判断 sink 是否订阅该事件类型
+func (c EventSinkConfig) Match(eventType string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, pattern := range c.Events {
+		if pattern == "*" || pattern == eventType {
+			return true
+		}
+		if strings.HasSuffix(pattern, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
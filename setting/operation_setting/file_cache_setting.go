@@ -0,0 +1,45 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// FileCacheSetting 多模态请求输入文件缓存配置
+// 远程文件按内容哈希落盘，URL 索引（含 ETag / Last-Modified）优先存 Redis，未启用 Redis 时存内存
+type FileCacheSetting struct {
+	Enabled           bool   `json:"enabled"`
+	Dir               string `json:"dir"`                // 缓存文件目录
+	TTLSeconds        int    `json:"ttl_seconds"`        // 索引与缓存文件的保留时间（自最后一次命中起）
+	RevalidateSeconds int    `json:"revalidate_seconds"` // 超过该时间后使用 ETag / Last-Modified 向源站重新校验，0 表示总是校验
+	MaxFileMB         int    `json:"max_file_mb"`        // 单个文件大小上限，超过时不缓存
+	MaxTotalMB        int    `json:"max_total_mb"`       // 缓存目录总大小上限，超过时按最久未使用淘汰，0 表示不限
+	// 上游文件句柄复用（Gemini File API / Anthropic Files API）
+	ProviderFilesEnabled   bool `json:"provider_files_enabled"`
+	ProviderFileMinKB      int  `json:"provider_file_min_kb"`      // 仅对不小于该大小的文件上传到上游，小文件继续内联
+	ProviderFileTTLSeconds int  `json:"provider_file_ttl_seconds"` // 句柄复用时长，上游自带过期时间时取较小值
+}
+
+// 默认配置
+var fileCacheSetting = FileCacheSetting{
+	Enabled:                false,
+	Dir:                    "data/file_cache",
+	TTLSeconds:             86400,
+	RevalidateSeconds:      300,
+	MaxFileMB:              20,
+	MaxTotalMB:             1024,
+	ProviderFilesEnabled:   false,
+	ProviderFileMinKB:      256,
+	ProviderFileTTLSeconds: 86400,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("file_cache_setting", &fileCacheSetting)
+}
+
+func GetFileCacheSetting() *FileCacheSetting {
+	return &fileCacheSetting
+}
+
+// ShouldUseProviderFile 判断给定大小的文件是否应上传为上游文件句柄
+func (s *FileCacheSetting) ShouldUseProviderFile(size int64) bool {
+	return s.ProviderFilesEnabled && size >= int64(s.ProviderFileMinKB)<<10
+}
@@ -0,0 +1,36 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// KeyPoolSetting 多 Key 渠道的 key 生命周期及渠道冷却配置
+type KeyPoolSetting struct {
+	CooldownStatusCodes []int `json:"cooldown_status_codes"` // 返回这些状态码时 key（或带有 Retry-After 等响应头的渠道）进入冷却而不是被禁用
+	CooldownSeconds     int   `json:"cooldown_seconds"`      // 上游未给出重置时间时 key 的冷却时长，0 表示不冷却
+	MaxCooldownSeconds  int   `json:"max_cooldown_seconds"`  // 按上游响应头冷却时的最长冷却时长
+	AutoEnableMinutes   int   `json:"auto_enable_minutes"`   // 自动禁用的 key 经过该时长后自动重新启用，0 表示不自动启用
+}
+
+// 默认配置
+var keyPoolSetting = KeyPoolSetting{
+	CooldownStatusCodes: []int{429},
+	CooldownSeconds:     60,
+	MaxCooldownSeconds:  3600,
+	AutoEnableMinutes:   0,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("key_pool_setting", &keyPoolSetting)
+}
+
+func GetKeyPoolSetting() *KeyPoolSetting {
+	return &keyPoolSetting
+}
+
+func ShouldCoolDownByStatusCode(code int) bool {
+	return slices.Contains(keyPoolSetting.CooldownStatusCodes, code)
+}
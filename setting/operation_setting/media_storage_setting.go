@@ -0,0 +1,91 @@
+package operation_setting
+
+import (
+	"slices"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+const (
+	MediaStorageBackendLocal = "local"
+	MediaStorageBackendS3    = "s3"
+)
+
+const (
+	MediaSourceImage      = "image"
+	MediaSourceAudio      = "audio"
+	MediaSourceMidjourney = "midjourney"
+	MediaSourceSuno       = "suno"
+	MediaSourceVideo      = "video"
+)
+
+// MediaStorageSetting 生成媒体（图片、语音、视频等）的持久化存储配置
+type MediaStorageSetting struct {
+	Enabled          bool     `json:"enabled"`
+	Backend          string   `json:"backend"`            // local / s3
+	LocalPath        string   `json:"local_path"`         // local: 存储目录
+	S3Endpoint       string   `json:"s3_endpoint"`        // s3: 兼容 S3 的服务地址，如 https://s3.amazonaws.com 或 MinIO 地址
+	S3Region         string   `json:"s3_region"`          // s3: 区域，默认 us-east-1
+	S3Bucket         string   `json:"s3_bucket"`          // s3: 存储桶
+	S3AccessKey      string   `json:"s3_access_key"`      // s3: access key
+	S3SecretKey      string   `json:"s3_secret_key"`      // s3: secret key
+	S3PathStyle      bool     `json:"s3_path_style"`      // s3: 使用 path-style 访问（MinIO 通常需要开启）
+	S3Prefix         string   `json:"s3_prefix"`          // s3: 对象 key 前缀
+	Sources          []string `json:"sources"`            // 需要持久化的来源：image / audio / midjourney / suno / video，为空表示全部
+	UrlExpireSeconds int      `json:"url_expire_seconds"` // 签名链接有效期
+	RetentionDays    int      `json:"retention_days"`     // 默认保留天数，0 表示不过期
+	UserQuotaMB      int      `json:"user_quota_mb"`      // 默认每个用户的存储配额，0 表示不限
+	MaxObjectMB      int      `json:"max_object_mb"`      // 单个对象大小上限，超过时不持久化
+	// 按分组覆盖保留天数与存储配额
+	GroupRetentionDays map[string]int `json:"group_retention_days"`
+	GroupQuotaMB       map[string]int `json:"group_quota_mb"`
+}
+
+// 默认配置
+var mediaStorageSetting = MediaStorageSetting{
+	Enabled:            false,
+	Backend:            MediaStorageBackendLocal,
+	LocalPath:          "data/media",
+	S3Region:           "us-east-1",
+	Sources:            []string{},
+	UrlExpireSeconds:   3600,
+	RetentionDays:      7,
+	UserQuotaMB:        1024,
+	MaxObjectMB:        200,
+	GroupRetentionDays: map[string]int{},
+	GroupQuotaMB:       map[string]int{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("media_storage_setting", &mediaStorageSetting)
+}
+
+func GetMediaStorageSetting() *MediaStorageSetting {
+	return &mediaStorageSetting
+}
+
+// ShouldPersist 判断某个来源的生成结果是否需要持久化
+func (s *MediaStorageSetting) ShouldPersist(source string) bool {
+	if !s.Enabled {
+		return false
+	}
+	return len(s.Sources) == 0 || slices.Contains(s.Sources, source)
+}
+
+// GetRetentionDays 返回分组的保留天数
+func (s *MediaStorageSetting) GetRetentionDays(group string) int {
+	if days, ok := s.GroupRetentionDays[group]; ok {
+		return days
+	}
+	return s.RetentionDays
+}
+
+// GetQuotaBytes 返回分组的存储配额（字节），0 表示不限
+func (s *MediaStorageSetting) GetQuotaBytes(group string) int64 {
+	quota := s.UserQuotaMB
+	if mb, ok := s.GroupQuotaMB[group]; ok {
+		quota = mb
+	}
+	return int64(quota) << 20
+}
@@ -10,12 +10,23 @@ import (
 type MonitorSetting struct {
 	AutoTestChannelEnabled bool    `json:"auto_test_channel_enabled"`
 	AutoTestChannelMinutes float64 `json:"auto_test_channel_minutes"`
+	// 批量测试（含定时测试）的测试矩阵
+	TestConcurrency   int      `json:"test_concurrency"`    // 同时进行的测试请求数
+	TestAllModels     bool     `json:"test_all_models"`     // 测试渠道的全部模型，否则仅测试测试模型（未设置时为第一个模型）
+	TestAllKeys       bool     `json:"test_all_keys"`       // 多 Key 渠道逐个测试每个 key，否则按渠道的 key 选择策略测试一个
+	TestStream        bool     `json:"test_stream"`         // 额外测试流式请求
+	TestEndpointTypes []string `json:"test_endpoint_types"` // 测试的端点类型，为空时按模型自动检测
 }
 
 // 默认配置
 var monitorSetting = MonitorSetting{
 	AutoTestChannelEnabled: false,
 	AutoTestChannelMinutes: 10,
+	TestConcurrency:        4,
+	TestAllModels:          false,
+	TestAllKeys:            false,
+	TestStream:             false,
+	TestEndpointTypes:      []string{},
 }
 
 func init() {
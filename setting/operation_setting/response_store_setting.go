@@ -0,0 +1,28 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// ResponseStoreSetting 网关侧 Responses API 存储配置
+// 开启后网关按 response id 保存 /v1/responses 的输入与输出，请求引用 previous_response_id 且上游无法读取该响应
+// （非 OpenAI 渠道、禁用了 store 或切换到其他渠道）时，由网关将历史展开为 input items
+type ResponseStoreSetting struct {
+	Enabled       bool `json:"enabled"`
+	RetentionDays int  `json:"retention_days"`  // 保留天数，0 表示不过期
+	MaxChainDepth int  `json:"max_chain_depth"` // 展开 previous_response_id 时最多回溯的响应数
+}
+
+// 默认配置
+var responseStoreSetting = ResponseStoreSetting{
+	Enabled:       false,
+	RetentionDays: 30,
+	MaxChainDepth: 100,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_store_setting", &responseStoreSetting)
+}
+
+func GetResponseStoreSetting() *ResponseStoreSetting {
+	return &responseStoreSetting
+}
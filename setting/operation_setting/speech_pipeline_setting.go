@@ -0,0 +1,50 @@
+package operation_setting
+
+import (
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// SpeechPipeline 语音链路虚拟模型：STT → 对话 → TTS，三个环节分别按各自模型选择渠道并计费
+type SpeechPipeline struct {
+	SttModel     string `json:"stt_model"`
+	ChatModel    string `json:"chat_model"`
+	TtsModel     string `json:"tts_model"`
+	Voice        string `json:"voice"`        // 默认音色，请求未指定时使用
+	Instructions string `json:"instructions"` // 默认系统提示词
+	Language     string `json:"language"`     // STT 语言提示，可选
+}
+
+// SpeechPipelineSetting 语音链路配置
+type SpeechPipelineSetting struct {
+	Pipelines          map[string]SpeechPipeline `json:"pipelines"`            // key 为对外暴露的模型名
+	MinSegmentChars    int                       `json:"min_segment_chars"`    // 对话输出按句切分送入 TTS 时，每段的最少字符数
+	MaxHistoryMessages int                       `json:"max_history_messages"` // realtime 会话保留的历史消息条数
+}
+
+// 默认配置
+var speechPipelineSetting = SpeechPipelineSetting{
+	Pipelines:          map[string]SpeechPipeline{},
+	MinSegmentChars:    20,
+	MaxHistoryMessages: 20,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("speech_pipeline_setting", &speechPipelineSetting)
+}
+
+func GetSpeechPipelineSetting() *SpeechPipelineSetting {
+	return &speechPipelineSetting
+}
+
+// GetSpeechPipeline 按模型名查找语音链路，三个环节的模型都配置后才有效
+func GetSpeechPipeline(name string) (SpeechPipeline, bool) {
+	if name == "" {
+		return SpeechPipeline{}, false
+	}
+	pipeline, ok := speechPipelineSetting.Pipelines[name]
+	if !ok || pipeline.SttModel == "" || pipeline.ChatModel == "" || pipeline.TtsModel == "" {
+		return SpeechPipeline{}, false
+	}
+	return pipeline, true
+}
@@ -0,0 +1,29 @@
+package operation_setting
+
+import "github.com/QuantumNous/new-api/setting/config"
+
+// StatusPageSetting 内置状态页配置
+// 渠道请求与测试结果按 5 分钟分桶统计，超过 RawRetentionHours 的数据合并为小时桶，超过 RetentionDays 的数据删除
+type StatusPageSetting struct {
+	Enabled           bool     `json:"enabled"`             // 是否开放公开状态页接口
+	Models            []string `json:"models"`              // 公开状态页展示的模型，为空表示全部
+	RawRetentionHours int      `json:"raw_retention_hours"` // 5 分钟粒度数据的保留时长
+	RetentionDays     int      `json:"retention_days"`      // 统计与故障记录的保留天数
+}
+
+// 默认配置
+var statusPageSetting = StatusPageSetting{
+	Enabled:           false,
+	Models:            []string{},
+	RawRetentionHours: 48,
+	RetentionDays:     90,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("status_page_setting", &statusPageSetting)
+}
+
+func GetStatusPageSetting() *StatusPageSetting {
+	return &statusPageSetting
+}
@@ -0,0 +1,116 @@
+package operation_setting
+
+import (
+	"math"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// TaskPlatformPollSetting 单个平台的轮询配置，字段为 0 时使用全局默认值
+type TaskPlatformPollSetting struct {
+	IntervalSeconds    int     `json:"interval_seconds"`     // 初始轮询间隔
+	MaxIntervalSeconds int     `json:"max_interval_seconds"` // 退避后的最大轮询间隔
+	BackoffFactor      float64 `json:"backoff_factor"`       // 任务无进展时的退避倍数
+	TimeoutSeconds     int     `json:"timeout_seconds"`      // 超过该时长未完成则判定失败并退款
+}
+
+// TaskPollSetting 异步任务轮询调度配置
+type TaskPollSetting struct {
+	TickSeconds        int                                `json:"tick_seconds"`  // 调度器扫描间隔
+	BatchSize          int                                `json:"batch_size"`    // 每次领取的最大任务数，0 表示使用 TASK_QUERY_LIMIT
+	LeaseSeconds       int                                `json:"lease_seconds"` // 任务租约时长，节点宕机后租约到期可被其他节点领取
+	IntervalSeconds    int                                `json:"interval_seconds"`
+	MaxIntervalSeconds int                                `json:"max_interval_seconds"`
+	BackoffFactor      float64                            `json:"backoff_factor"`
+	TimeoutSeconds     int                                `json:"timeout_seconds"`
+	Platforms          map[string]TaskPlatformPollSetting `json:"platforms"`
+}
+
+// 默认配置
+var taskPollSetting = TaskPollSetting{
+	TickSeconds:        5,
+	BatchSize:          0,
+	LeaseSeconds:       120,
+	IntervalSeconds:    15,
+	MaxIntervalSeconds: 300,
+	BackoffFactor:      1.5,
+	TimeoutSeconds:     24 * 3600,
+	Platforms: map[string]TaskPlatformPollSetting{
+		"mj": {TimeoutSeconds: 3600},
+	},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("task_poll_setting", &taskPollSetting)
+}
+
+func GetTaskPollSetting() *TaskPollSetting {
+	return &taskPollSetting
+}
+
+func (s *TaskPollSetting) GetTickInterval() time.Duration {
+	if s.TickSeconds <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(s.TickSeconds) * time.Second
+}
+
+func (s *TaskPollSetting) GetLeaseSeconds() int {
+	if s.LeaseSeconds <= 0 {
+		return 120
+	}
+	return s.LeaseSeconds
+}
+
+// GetPlatformPollSetting 返回合并了全局默认值的平台配置
+func (s *TaskPollSetting) GetPlatformPollSetting(platform string) TaskPlatformPollSetting {
+	result := TaskPlatformPollSetting{
+		IntervalSeconds:    s.IntervalSeconds,
+		MaxIntervalSeconds: s.MaxIntervalSeconds,
+		BackoffFactor:      s.BackoffFactor,
+		TimeoutSeconds:     s.TimeoutSeconds,
+	}
+	if p, ok := s.Platforms[platform]; ok {
+		if p.IntervalSeconds > 0 {
+			result.IntervalSeconds = p.IntervalSeconds
+		}
+		if p.MaxIntervalSeconds > 0 {
+			result.MaxIntervalSeconds = p.MaxIntervalSeconds
+		}
+		if p.BackoffFactor > 0 {
+			result.BackoffFactor = p.BackoffFactor
+		}
+		if p.TimeoutSeconds > 0 {
+			result.TimeoutSeconds = p.TimeoutSeconds
+		}
+	}
+	if result.IntervalSeconds <= 0 {
+		result.IntervalSeconds = 15
+	}
+	if result.MaxIntervalSeconds < result.IntervalSeconds {
+		result.MaxIntervalSeconds = result.IntervalSeconds
+	}
+	if result.BackoffFactor < 1 {
+		result.BackoffFactor = 1
+	}
+	return result
+}
+
+// NextPollDelay 根据连续无进展的轮询次数计算下一次轮询的延迟
+func (p TaskPlatformPollSetting) NextPollDelay(attempts int) time.Duration {
+	delay := float64(p.IntervalSeconds) * math.Pow(p.BackoffFactor, float64(attempts))
+	if delay > float64(p.MaxIntervalSeconds) {
+		delay = float64(p.MaxIntervalSeconds)
+	}
+	return time.Duration(delay) * time.Second
+}
+
+// IsTimeout 判断提交于 submitTime（Unix 秒）的任务是否已超时
+func (p TaskPlatformPollSetting) IsTimeout(submitTime int64, now time.Time) bool {
+	if p.TimeoutSeconds <= 0 || submitTime <= 0 {
+		return false
+	}
+	return now.Unix()-submitTime > int64(p.TimeoutSeconds)
+}
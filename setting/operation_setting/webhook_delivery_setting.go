@@ -0,0 +1,63 @@
+package operation_setting
+
+import (
+	"math"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+)
+
+// WebhookDeliverySetting 出站 webhook 投递配置
+type WebhookDeliverySetting struct {
+	MaxAttempts           int    `json:"max_attempts"`            // 最大投递次数
+	InitialBackoffSeconds int    `json:"initial_backoff_seconds"` // 首次重试间隔，之后指数增长
+	MaxBackoffSeconds     int    `json:"max_backoff_seconds"`     // 最大重试间隔
+	DisableAfterFailures  int    `json:"disable_after_failures"`  // 连续失败多少次后自动禁用地址，0 表示不禁用
+	TimeoutSeconds        int    `json:"timeout_seconds"`         // 单次请求超时
+	CallbackSecret        string `json:"callback_secret"`         // 任务回调签名密钥，为空时不签名
+}
+
+// 默认配置：1 分钟起指数退避，最长间隔 2 小时，共 12 次，约覆盖 9 小时
+var webhookDeliverySetting = WebhookDeliverySetting{
+	MaxAttempts:           12,
+	InitialBackoffSeconds: 60,
+	MaxBackoffSeconds:     7200,
+	DisableAfterFailures:  50,
+	TimeoutSeconds:        10,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("webhook_delivery_setting", &webhookDeliverySetting)
+}
+
+func GetWebhookDeliverySetting() *WebhookDeliverySetting {
+	return &webhookDeliverySetting
+}
+
+// RetryDelay 第 attempts 次失败后的重试间隔
+func (s *WebhookDeliverySetting) RetryDelay(attempts int) time.Duration {
+	initial := s.InitialBackoffSeconds
+	if initial <= 0 {
+		initial = 60
+	}
+	delay := float64(initial) * math.Pow(2, float64(attempts-1))
+	if s.MaxBackoffSeconds > 0 && delay > float64(s.MaxBackoffSeconds) {
+		delay = float64(s.MaxBackoffSeconds)
+	}
+	return time.Duration(delay) * time.Second
+}
+
+func (s *WebhookDeliverySetting) GetMaxAttempts() int {
+	if s.MaxAttempts <= 0 {
+		return 1
+	}
+	return s.MaxAttempts
+}
+
+func (s *WebhookDeliverySetting) GetTimeout() time.Duration {
+	if s.TimeoutSeconds <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(s.TimeoutSeconds) * time.Second
+}
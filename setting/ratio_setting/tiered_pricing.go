@@ -0,0 +1,216 @@
+package ratio_setting
+
+import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting/config"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// ContextPricingTier 上下文长度阶梯，提示 tokens 达到 MinPromptTokens 时生效
+// InputMultiplier 作用于输入价格（模型倍率），OutputMultiplier 作用于输出价格，未设置（<=0）时视为 1
+type ContextPricingTier struct {
+	Name             string  `json:"name"`
+	MinPromptTokens  int     `json:"min_prompt_tokens"`
+	InputMultiplier  float64 `json:"input_multiplier"`
+	OutputMultiplier float64 `json:"output_multiplier"`
+}
+
+// TimePricingTier 时段阶梯，例如夜间折扣
+// Weekdays 为 0(周日)~6(周六)，为空表示每天；Start/End 为 HH:MM，Start > End 表示跨零点
+// Multiplier 同时作用于倍率计费和按次计费，未设置（<=0）时视为 1
+type TimePricingTier struct {
+	Name       string  `json:"name"`
+	Weekdays   []int   `json:"weekdays"`
+	Start      string  `json:"start"`
+	End        string  `json:"end"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+type TieredPricingRule struct {
+	ContextTiers []ContextPricingTier `json:"context_tiers,omitempty"`
+	TimeTiers    []TimePricingTier    `json:"time_tiers,omitempty"`
+}
+
+// TieredPricingSettings 阶梯计费配置
+// Rules 的 key 为模型名，"*" 为所有模型的默认规则
+type TieredPricingSettings struct {
+	Enabled  bool                         `json:"enabled"`
+	Timezone string                       `json:"timezone"`
+	Rules    map[string]TieredPricingRule `json:"rules"`
+}
+
+// 默认配置
+var defaultTieredPricingSettings = TieredPricingSettings{
+	Enabled:  false,
+	Timezone: "",
+	Rules:    map[string]TieredPricingRule{},
+}
+
+// 全局实例
+var tieredPricingSettings = defaultTieredPricingSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("tiered_pricing", &tieredPricingSettings)
+}
+
+func GetTieredPricingSettings() *TieredPricingSettings {
+	return &tieredPricingSettings
+}
+
+// GetTieredPricingRule 返回模型生效的阶梯规则，未启用或未配置时返回 nil
+func GetTieredPricingRule(modelName string) *TieredPricingRule {
+	if !tieredPricingSettings.Enabled {
+		return nil
+	}
+	if rule, ok := tieredPricingSettings.Rules[modelName]; ok {
+		return &rule
+	}
+	if rule, ok := tieredPricingSettings.Rules[FormatMatchingModelName(modelName)]; ok {
+		return &rule
+	}
+	if rule, ok := tieredPricingSettings.Rules["*"]; ok {
+		return &rule
+	}
+	return nil
+}
+
+// MatchPricingTier 根据提示 tokens 数与请求时间匹配阶梯，未命中任何阶梯时返回 nil
+// 上下文长度阶梯只对倍率计费生效
+func MatchPricingTier(modelName string, promptTokens int, usePrice bool, at time.Time) *types.PricingTierInfo {
+	rule := GetTieredPricingRule(modelName)
+	if rule == nil {
+		return nil
+	}
+	tier := &types.PricingTierInfo{
+		ModelRatioMultiplier:      1,
+		CompletionRatioMultiplier: 1,
+		PriceMultiplier:           1,
+	}
+	matched := false
+
+	if !usePrice {
+		if ctxTier := matchContextTier(rule.ContextTiers, promptTokens); ctxTier != nil {
+			matched = true
+			input := positiveOrOne(ctxTier.InputMultiplier)
+			output := positiveOrOne(ctxTier.OutputMultiplier)
+			tier.ContextTier = tierName(ctxTier.Name, fmt.Sprintf(">=%d", ctxTier.MinPromptTokens))
+			tier.ModelRatioMultiplier = input
+			// 输出价格 = 模型倍率 * 补全倍率，因此补全倍率只需补足输出与输入的差值
+			tier.CompletionRatioMultiplier = output / input
+		}
+	}
+
+	if timeTier := matchTimeTier(rule.TimeTiers, at.In(tieredPricingLocation())); timeTier != nil {
+		matched = true
+		multiplier := positiveOrOne(timeTier.Multiplier)
+		tier.TimeTier = tierName(timeTier.Name, timeTier.Start+"-"+timeTier.End)
+		if usePrice {
+			tier.PriceMultiplier = multiplier
+		} else {
+			tier.ModelRatioMultiplier *= multiplier
+		}
+	}
+
+	if !matched {
+		return nil
+	}
+	return tier
+}
+
+func matchContextTier(tiers []ContextPricingTier, promptTokens int) *ContextPricingTier {
+	var matched *ContextPricingTier
+	for i := range tiers {
+		t := &tiers[i]
+		if t.MinPromptTokens <= 0 || promptTokens < t.MinPromptTokens {
+			continue
+		}
+		if matched == nil || t.MinPromptTokens > matched.MinPromptTokens {
+			matched = t
+		}
+	}
+	return matched
+}
+
+func matchTimeTier(tiers []TimePricingTier, now time.Time) *TimePricingTier {
+	minute := now.Hour()*60 + now.Minute()
+	for i := range tiers {
+		t := &tiers[i]
+		start, ok1 := parseClock(t.Start)
+		end, ok2 := parseClock(t.End)
+		if !ok1 || !ok2 || start == end {
+			continue
+		}
+		weekday := int(now.Weekday())
+		var inWindow bool
+		if start < end {
+			inWindow = minute >= start && minute < end
+		} else {
+			// 跨零点的时段，零点之后的部分属于前一天的时段
+			if minute >= start {
+				inWindow = true
+			} else if minute < end {
+				inWindow = true
+				weekday = (weekday + 6) % 7
+			}
+		}
+		if !inWindow {
+			continue
+		}
+		if len(t.Weekdays) > 0 && !slices.Contains(t.Weekdays, weekday) {
+			continue
+		}
+		return t
+	}
+	return nil
+}
+
+type cachedLocation struct {
+	name string
+	loc  *time.Location
+}
+
+// 已加载的时区，时区配置变化时才重新加载
+var tieredPricingLoc atomic.Pointer[cachedLocation]
+
+func tieredPricingLocation() *time.Location {
+	name := tieredPricingSettings.Timezone
+	if name == "" {
+		return time.Local
+	}
+	if cached := tieredPricingLoc.Load(); cached != nil && cached.name == name {
+		return cached.loc
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = time.Local
+	}
+	tieredPricingLoc.Store(&cachedLocation{name: name, loc: loc})
+	return loc
+}
+
+func parseClock(s string) (int, bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+func positiveOrOne(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	return v
+}
+
+func tierName(name string, fallback string) string {
+	if name != "" {
+		return name
+	}
+	return fallback
+}
@@ -95,6 +95,7 @@ type NewAPIError struct {
 	errorCode      ErrorCode
 	StatusCode     int
 	Metadata       json.RawMessage
+	RetryAfter     int64 // 需要等待的秒数（来自上游的 Retry-After 等响应头或渠道冷却），0 表示未知
 }
 
 // Unwrap enables errors.Is / errors.As to work with NewAPIError by exposing the underlying error.
@@ -383,6 +384,12 @@ func ErrOptionWithSkipRetry() NewAPIErrorOptions {
 	}
 }
 
+func ErrOptionWithRetryAfter(seconds int64) NewAPIErrorOptions {
+	return func(e *NewAPIError) {
+		e.RetryAfter = seconds
+	}
+}
+
 func ErrOptionWithNoRecordErrorLog() NewAPIErrorOptions {
 	return func(e *NewAPIError) {
 		e.recordErrorLog = common.GetPointer(false)
@@ -5,4 +5,5 @@ type LocalFileData struct {
 	Base64Data string
 	Url        string
 	Size       int64
+	Sha256     string // 内容哈希，用于复用上游文件句柄
 }
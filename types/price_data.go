@@ -24,6 +24,7 @@ type PriceData struct {
 	UsePrice             bool
 	QuotaToPreConsume    int // 预消耗额度
 	GroupRatioInfo       GroupRatioInfo
+	PricingTier          *PricingTierInfo // 命中的阶梯计费，未命中时为 nil
 }
 
 func (p *PriceData) AddOtherRatio(key string, ratio float64) {
@@ -45,3 +46,39 @@ type PerCallPriceData struct {
 func (p *PriceData) ToSetting() string {
 	return fmt.Sprintf("ModelPrice: %f, ModelRatio: %f, CompletionRatio: %f, CacheRatio: %f, GroupRatio: %f, UsePrice: %t, CacheCreationRatio: %f, CacheCreation5mRatio: %f, CacheCreation1hRatio: %f, QuotaToPreConsume: %d, ImageRatio: %f, AudioRatio: %f, AudioCompletionRatio: %f", p.ModelPrice, p.ModelRatio, p.CompletionRatio, p.CacheRatio, p.GroupRatioInfo.GroupRatio, p.UsePrice, p.CacheCreationRatio, p.CacheCreation5mRatio, p.CacheCreation1hRatio, p.QuotaToPreConsume, p.ImageRatio, p.AudioRatio, p.AudioCompletionRatio)
 }
+
+// PricingTierInfo 命中的阶梯计费信息（上下文长度阶梯 / 时段阶梯）
+type PricingTierInfo struct {
+	ContextTier               string  `json:"context_tier,omitempty"`
+	TimeTier                  string  `json:"time_tier,omitempty"`
+	ModelRatioMultiplier      float64 `json:"model_ratio_multiplier"`
+	CompletionRatioMultiplier float64 `json:"completion_ratio_multiplier"`
+	PriceMultiplier           float64 `json:"price_multiplier"`
+
+	// 应用阶梯前的基础价格，用于按实际用量重新匹配阶梯
+	BaseModelRatio      float64 `json:"-"`
+	BaseCompletionRatio float64 `json:"-"`
+	BaseModelPrice      float64 `json:"-"`
+}
+
+// Apply 记录基础价格并返回应用阶梯后的价格
+func (t *PricingTierInfo) Apply(modelRatio, completionRatio, modelPrice float64) (float64, float64, float64) {
+	t.BaseModelRatio = modelRatio
+	t.BaseCompletionRatio = completionRatio
+	t.BaseModelPrice = modelPrice
+	return modelRatio * t.ModelRatioMultiplier, completionRatio * t.CompletionRatioMultiplier, modelPrice * t.PriceMultiplier
+}
+
+// ApplyPricingTier 先还原到基础价格，再应用新的阶梯；tier 为 nil 时仅还原
+func (p *PriceData) ApplyPricingTier(tier *PricingTierInfo) {
+	if p.PricingTier != nil {
+		p.ModelRatio = p.PricingTier.BaseModelRatio
+		p.CompletionRatio = p.PricingTier.BaseCompletionRatio
+		p.ModelPrice = p.PricingTier.BaseModelPrice
+	}
+	p.PricingTier = tier
+	if tier == nil {
+		return
+	}
+	p.ModelRatio, p.CompletionRatio, p.ModelPrice = tier.Apply(p.ModelRatio, p.CompletionRatio, p.ModelPrice)
+}